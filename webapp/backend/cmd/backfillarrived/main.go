@@ -0,0 +1,44 @@
+package main
+
+import (
+	"backend/internal/db"
+	"backend/internal/repository"
+	"context"
+	"flag"
+	"log"
+)
+
+// 配送完了なのに到着日時（arrived_at）が記録されていない注文を埋めるバックフィル用コマンド
+//
+// 到着日時は order_events の最後の配送完了イベントの日時とする。配送完了イベントのない注文は埋めない。
+// 何度実行しても結果は変わらないため、途中で止まっても再実行すればよい。
+// ORDER_SHARDS を設定して実行すると、シャード化した注文テーブルを埋める。
+func main() {
+	batchSize := flag.Int("batch", 1000, "number of orders per table per batch")
+	flag.Parse()
+	if *batchSize <= 0 {
+		log.Fatalf("-batch must be positive")
+	}
+
+	dbConn, err := db.InitDBConnection()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbConn.Close()
+
+	ctx := context.Background()
+	orderRepo := repository.NewStore(dbConn).OrderRepo
+	filled := 0
+	for {
+		n, err := orderRepo.BackfillArrivedAt(ctx, *batchSize)
+		if err != nil {
+			log.Fatalf("Failed to backfill arrived_at after %d orders: %v", filled, err)
+		}
+		if n == 0 {
+			break
+		}
+		filled += n
+		log.Printf("Backfilled arrived_at for %d orders", filled)
+	}
+	log.Printf("arrived_at backfill finished: %d orders updated", filled)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"backend/internal/db"
+	"backend/internal/fieldcrypt"
+	"backend/internal/repository"
+	"context"
+	"flag"
+	"log"
+)
+
+// ユーザー名の暗号化・鍵ローテーション用コマンド
+//
+// 未暗号化の行は現在の鍵で暗号化し、古い鍵で暗号化された行は現在の鍵で暗号化し直す。
+// 何度実行しても結果は変わらないため、途中で止まっても再実行すればよい。
+// すべての行が暗号化されたことを確認してから -scrub を付けて実行すると平文のユーザー名を消去する。
+func main() {
+	batchSize := flag.Int("batch", 500, "number of users per batch")
+	scrub := flag.Bool("scrub", false, "replace plaintext user_name after encrypting")
+	flag.Parse()
+
+	codec, err := fieldcrypt.NewCodecFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid field encryption config: %v", err)
+	}
+	if codec == nil {
+		log.Fatalf("FIELD_ENCRYPTION_KEYS is not set")
+	}
+	repository.SetUserFieldCodec(codec)
+
+	dbConn, err := db.InitDBConnection()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbConn.Close()
+
+	ctx := context.Background()
+	userRepo := repository.NewStore(dbConn).UserRepo
+	lastID, updated := 0, 0
+	for {
+		users, err := userRepo.ListForReencryption(ctx, lastID, *batchSize)
+		if err != nil {
+			log.Fatalf("Failed to list users after %d: %v", lastID, err)
+		}
+		if len(users) == 0 {
+			break
+		}
+		for _, user := range users {
+			lastID = user.UserID
+			needs, err := userRepo.NeedsReencryption(ctx, user.UserID)
+			if err != nil {
+				log.Fatalf("Failed to inspect user %d: %v", user.UserID, err)
+			}
+			if !needs && !*scrub {
+				continue
+			}
+			if err := userRepo.SaveEncryptedName(ctx, user.UserID, user.UserName, *scrub); err != nil {
+				log.Fatalf("Failed to encrypt user %d: %v", user.UserID, err)
+			}
+			updated++
+		}
+		log.Printf("Processed users up to %d (%d updated)", lastID, updated)
+	}
+	log.Printf("Re-encryption finished: %d users updated", updated)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"backend/internal/db"
+	"backend/internal/model"
+	"backend/internal/repository"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// orders テーブルをユーザーIDのハッシュ（user_id % N）でN個の物理テーブルに分割するバックフィル用コマンド
+//
+// シャードkの注文IDは k*OrderShardIDSpan 以上の範囲に振り直し、IDから格納先を特定できるようにする。
+// 注文IDを持つ表（orderIDReferences と delivery_plans.order_ids）と退避済みの注文も同じトランザクションで振り直す。
+// 元の orders テーブルは切り戻し用にそのまま残す。
+// 完了後、バックエンドを ORDER_SHARDS=N で起動するとシャード化したテーブルを使う。
+func main() {
+	shards := flag.Int("shards", 4, "number of order shards")
+	flag.Parse()
+	if *shards < 2 {
+		log.Fatalf("-shards must be at least 2")
+	}
+
+	dbConn, err := db.InitDBConnection()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbConn.Close()
+
+	if err := backfill(context.Background(), dbConn, *shards); err != nil {
+		log.Fatalf("Order shard backfill failed: %v", err)
+	}
+	log.Printf("Order shard backfill finished. Start the backend with ORDER_SHARDS=%d", *shards)
+}
+
+func backfill(ctx context.Context, dbConn *sqlx.DB, n int) error {
+	for k := 0; k < n; k++ {
+		table := repository.OrderShardTable(k)
+		if _, err := dbConn.ExecContext(ctx, fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				order_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+				user_id INT UNSIGNED NOT NULL,
+				product_id INT UNSIGNED NOT NULL,
+				shipped_status VARCHAR(50) NOT NULL,
+				priority TINYINT UNSIGNED NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				arrived_at DATETIME,
+				deliver_by DATETIME NULL,
+				cancelled_at DATETIME NULL,
+				retry_count INT UNSIGNED NOT NULL DEFAULT 0,
+				metadata JSON NULL,
+				ship_after DATETIME NULL,
+				assigned_robot_id VARCHAR(64) NULL,
+				value INT UNSIGNED NULL,
+				product_name VARCHAR(255) NULL,
+				product_weight INT UNSIGNED NULL,
+				product_volume INT UNSIGNED NULL,
+				INDEX idx_%s_user_id_created_at (user_id, created_at),
+				INDEX idx_%s_shipped_status_product (shipped_status, product_id),
+				INDEX idx_%s_user_id_status_created_at (user_id, shipped_status, created_at),
+				INDEX idx_%s_user_id_product (user_id, product_id),
+				INDEX idx_%s_status_ship_after (shipped_status, ship_after),
+				INDEX idx_%s_status_created_at (shipped_status, created_at),
+				FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+				FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+			) AUTO_INCREMENT = %d`, table, table, table, table, table, table, table, int64(k)*repository.OrderShardIDSpan+1)); err != nil {
+			return fmt.Errorf("create %s: %w", table, err)
+		}
+
+		var existing int
+		if err := dbConn.GetContext(ctx, &existing, "SELECT COUNT(*) FROM "+table); err != nil {
+			return err
+		}
+		if existing > 0 {
+			return fmt.Errorf("%s already contains %d rows; backfill has already run", table, existing)
+		}
+	}
+
+	// シャード化後の注文は orders テーブルに存在しないため、外部キーを外して注文IDを64bitに広げる
+	var fkNames []string
+	if err := dbConn.SelectContext(ctx, &fkNames, `
+		SELECT CONSTRAINT_NAME FROM information_schema.REFERENTIAL_CONSTRAINTS
+		WHERE CONSTRAINT_SCHEMA = DATABASE() AND TABLE_NAME = 'order_events' AND REFERENCED_TABLE_NAME = 'orders'`); err != nil {
+		return err
+	}
+	for _, name := range fkNames {
+		if _, err := dbConn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE order_events DROP FOREIGN KEY `%s`", name)); err != nil {
+			return fmt.Errorf("drop foreign key %s: %w", name, err)
+		}
+	}
+	if _, err := dbConn.ExecContext(ctx, "ALTER TABLE order_events MODIFY order_id BIGINT UNSIGNED NOT NULL"); err != nil {
+		return fmt.Errorf("widen order_events.order_id: %w", err)
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for k := 0; k < n; k++ {
+		table := repository.OrderShardTable(k)
+		offset := int64(k) * repository.OrderShardIDSpan
+		result, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %s (order_id, user_id, product_id, shipped_status, priority, created_at, arrived_at, deliver_by, cancelled_at, retry_count, metadata, ship_after, assigned_robot_id, value, product_name, product_weight, product_volume)
+			SELECT order_id + ?, user_id, product_id, shipped_status, priority, created_at, arrived_at, deliver_by, cancelled_at, retry_count, metadata, ship_after, assigned_robot_id, value, product_name, product_weight, product_volume
+			FROM orders WHERE MOD(user_id, ?) = ?`, table), offset, n, k)
+		if err != nil {
+			return fmt.Errorf("copy into %s: %w", table, err)
+		}
+		copied, _ := result.RowsAffected()
+		log.Printf("Copied %d orders into %s", copied, table)
+	}
+
+	if err := renumberReferences(ctx, tx, n); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// orderIDReferences は注文IDをorder_id列に持つ表。注文IDを持つ表を追加したらここにも加える
+// orders_archive は退避済みの注文そのもので、シャードの注文と同じ規則で振り直す
+var orderIDReferences = []string{"order_events", "delivery_proofs", "orders_archive"}
+
+// 配送計画の履歴を一度に振り直す件数
+const planBatchSize = 1000
+
+// renumberReferences は注文IDを参照する表をシャードの注文IDに振り直す
+// 振り直す前後の対応はordersと退避済みの注文から作る。シャード0の注文はIDが変わらないため対応に含めない
+func renumberReferences(ctx context.Context, tx *sqlx.Tx, n int) error {
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TEMPORARY TABLE order_id_map (
+			old_id BIGINT UNSIGNED PRIMARY KEY,
+			new_id BIGINT UNSIGNED NOT NULL
+		)`); err != nil {
+		return fmt.Errorf("create order_id_map: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO order_id_map (old_id, new_id)
+		SELECT order_id, order_id + MOD(user_id, ?) * ? FROM orders WHERE MOD(user_id, ?) > 0
+		UNION ALL
+		SELECT order_id, order_id + MOD(user_id, ?) * ? FROM orders_archive WHERE MOD(user_id, ?) > 0`,
+		n, repository.OrderShardIDSpan, n, n, repository.OrderShardIDSpan, n)
+	if err != nil {
+		return fmt.Errorf("build order_id_map: %w", err)
+	}
+	mapped, _ := result.RowsAffected()
+	log.Printf("Renumbering references to %d orders", mapped)
+
+	for _, table := range orderIDReferences {
+		result, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %s t
+			JOIN order_id_map m ON m.old_id = t.order_id
+			SET t.order_id = m.new_id`, table))
+		if err != nil {
+			return fmt.Errorf("renumber %s: %w", table, err)
+		}
+		updated, _ := result.RowsAffected()
+		log.Printf("Renumbered %d rows in %s", updated, table)
+	}
+	return renumberPlans(ctx, tx)
+}
+
+// renumberPlans は配送計画の履歴のorder_ids（JSONの配列）をorder_id_mapで振り直す
+func renumberPlans(ctx context.Context, tx *sqlx.Tx) error {
+	var lastID int64
+	updated := 0
+	for {
+		var plans []struct {
+			PlanID   int64             `db:"plan_id"`
+			OrderIDs model.OrderIDList `db:"order_ids"`
+		}
+		if err := tx.SelectContext(ctx, &plans, "SELECT plan_id, order_ids FROM delivery_plans WHERE plan_id > ? ORDER BY plan_id LIMIT ?", lastID, planBatchSize); err != nil {
+			return fmt.Errorf("read delivery_plans: %w", err)
+		}
+		if len(plans) == 0 {
+			log.Printf("Renumbered %d rows in delivery_plans", updated)
+			return nil
+		}
+		lastID = plans[len(plans)-1].PlanID
+
+		var ids []int64
+		for _, plan := range plans {
+			ids = append(ids, plan.OrderIDs...)
+		}
+		mapping, err := loadOrderIDMap(ctx, tx, ids)
+		if err != nil {
+			return err
+		}
+		for _, plan := range plans {
+			renumbered, changed := renumberOrderIDs(plan.OrderIDs, mapping)
+			if !changed {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE delivery_plans SET order_ids = ? WHERE plan_id = ?", renumbered, plan.PlanID); err != nil {
+				return fmt.Errorf("renumber delivery plan %d: %w", plan.PlanID, err)
+			}
+			updated++
+		}
+	}
+}
+
+// loadOrderIDMap はorder_id_mapからidsの振り直し先を読む
+func loadOrderIDMap(ctx context.Context, tx *sqlx.Tx, ids []int64) (map[int64]int64, error) {
+	mapping := make(map[int64]int64)
+	if len(ids) == 0 {
+		return mapping, nil
+	}
+	query, args, err := sqlx.In("SELECT old_id, new_id FROM order_id_map WHERE old_id IN (?)", ids)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		OldID int64 `db:"old_id"`
+		NewID int64 `db:"new_id"`
+	}
+	if err := tx.SelectContext(ctx, &rows, tx.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("read order_id_map: %w", err)
+	}
+	for _, row := range rows {
+		mapping[row.OldID] = row.NewID
+	}
+	return mapping, nil
+}
+
+// renumberOrderIDs はidsをmappingで振り直した並びと、1つでも変わったかを返す。mappingにない注文IDはそのまま残す
+func renumberOrderIDs(ids model.OrderIDList, mapping map[int64]int64) (model.OrderIDList, bool) {
+	renumbered := make(model.OrderIDList, len(ids))
+	changed := false
+	for i, id := range ids {
+		renumbered[i] = id
+		if newID, ok := mapping[id]; ok {
+			renumbered[i] = newID
+			changed = true
+		}
+	}
+	return renumbered, changed
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// 注文IDを持つ列を定義したスキーマ
+var schemaGlobs = []string{"../../../mysql/init/*.sql", "../../../mysql/migration/*.sql"}
+
+var (
+	sqlComment    = regexp.MustCompile(`(?m)--.*$`)
+	tableStmt     = regexp.MustCompile("(?is)^\\s*(?:CREATE TABLE(?: IF NOT EXISTS)?|ALTER TABLE)\\s+`?(\\w+)`?")
+	orderIDColumn = regexp.MustCompile(`(?im)^\s*(?:ADD\s+(?:COLUMN\s+)?|MODIFY\s+(?:COLUMN\s+)?)?order_ids?\s+(?:BIGINT|INT|JSON)`)
+)
+
+func TestOrderIDReferencesCoverSchema(t *testing.T) {
+	var files []string
+	for _, glob := range schemaGlobs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		t.Fatal("no schema files found")
+	}
+
+	// 注文IDを持つ表は、注文テーブルそのものか、バックフィルで振り直す表でなければならない
+	handled := append([]string{"orders", "delivery_plans"}, orderIDReferences...)
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stmt := range strings.Split(sqlComment.ReplaceAllString(string(body), ""), ";") {
+			m := tableStmt.FindStringSubmatch(stmt)
+			if m == nil || !orderIDColumn.MatchString(stmt) {
+				continue
+			}
+			if !slices.Contains(handled, m[1]) {
+				t.Errorf("%s: %s stores order IDs but is not renumbered by the shard backfill", filepath.Base(file), m[1])
+			}
+		}
+	}
+}
+
+func TestRenumberOrderIDsResolvesToShards(t *testing.T) {
+	const n = 4
+	// 注文ID -> ユーザーID
+	orders := map[int64]int{1: 4, 2: 5, 3: 6, 4: 7}
+	// order_id_mapと同じ規則で対応を作る（シャード0の注文はIDが変わらない）
+	mapping := make(map[int64]int64)
+	for id, userID := range orders {
+		if shard := userID % n; shard > 0 {
+			mapping[id] = id + int64(shard)*repository.OrderShardIDSpan
+		}
+	}
+
+	plan := model.OrderIDList{4, 1, 3, 2}
+	renumbered, changed := renumberOrderIDs(plan, mapping)
+	if !changed || len(renumbered) != len(plan) {
+		t.Fatalf("unexpected renumbering: %v, %v", renumbered, changed)
+	}
+	for i, id := range renumbered {
+		oldID := plan[i]
+		// 振り直したIDは注文の持ち主のシャードの範囲にあり、元のIDを保つ
+		if shard := int(id / repository.OrderShardIDSpan); shard != orders[oldID]%n || id%repository.OrderShardIDSpan != oldID {
+			t.Errorf("order %d renumbered to %d, which does not resolve to shard %d", oldID, id, orders[oldID]%n)
+		}
+	}
+
+	// シャード0の注文だけの計画は書き換えない
+	if _, changed := renumberOrderIDs(model.OrderIDList{1}, mapping); changed {
+		t.Fatal("expected a plan of shard 0 orders to be left unchanged")
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"backend/internal/service"
+	"context"
+	"log"
+)
+
+// 全商品画像のサムネイルを一括生成するバックフィル用コマンド
+func main() {
+	thumbnailService := service.NewThumbnailService()
+	log.Printf("Generating thumbnails for sizes %v", thumbnailService.Sizes())
+
+	generated, err := thumbnailService.Backfill(context.Background())
+	if err != nil {
+		log.Fatalf("Thumbnail backfill failed: %v", err)
+	}
+	log.Printf("Thumbnail backfill finished: %d generated", generated)
+}
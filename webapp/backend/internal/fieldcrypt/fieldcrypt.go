@@ -0,0 +1,185 @@
+// Package fieldcrypt はDBに保存する個人情報カラムをアプリケーション層で暗号化する
+//
+// エンベロープ暗号化を用い、値ごとに生成したデータ鍵(DEK)でAES-GCM暗号化し、
+// DEKは鍵暗号化鍵(KEK)でラップして暗号文と一緒に保存する。
+//
+// 暗号化した値は等価検索できないため、検索用にHMAC-SHA256のブラインドインデックスを併用する。
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const formatVersion = "v1"
+
+var (
+	ErrMalformed  = errors.New("fieldcrypt: malformed ciphertext")
+	ErrUnknownKey = errors.New("fieldcrypt: unknown key id")
+)
+
+// Codec はKEKとブラインドインデックス鍵を保持し、値の暗号化・復号を行う
+type Codec struct {
+	keys     map[string][]byte
+	activeID string
+	indexKey []byte
+}
+
+// NewCodec はKEKの一覧と、新規暗号化に使うKEKのIDからCodecを作る
+// KEKは32バイト(AES-256)であること
+func NewCodec(keys map[string][]byte, activeID string, indexKey []byte) (*Codec, error) {
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, activeID)
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("fieldcrypt: key %s must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	if len(indexKey) < 16 {
+		return nil, errors.New("fieldcrypt: index key must be at least 16 bytes")
+	}
+	return &Codec{keys: keys, activeID: activeID, indexKey: indexKey}, nil
+}
+
+// NewCodecFromEnv は環境変数からCodecを作る。設定がなければnilを返す
+//
+//	FIELD_ENCRYPTION_KEYS       "id1:base64鍵,id2:base64鍵"
+//	FIELD_ENCRYPTION_ACTIVE_KEY 新規暗号化に使う鍵ID
+//	FIELD_ENCRYPTION_INDEX_KEY  ブラインドインデックス用のbase64鍵
+func NewCodecFromEnv() (*Codec, error) {
+	raw := os.Getenv("FIELD_ENCRYPTION_KEYS")
+	if raw == "" {
+		return nil, nil
+	}
+	keys := make(map[string][]byte)
+	for _, entry := range strings.Split(raw, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("fieldcrypt: malformed key entry %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: key %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(os.Getenv("FIELD_ENCRYPTION_INDEX_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: index key: %w", err)
+	}
+	return NewCodec(keys, os.Getenv("FIELD_ENCRYPTION_ACTIVE_KEY"), indexKey)
+}
+
+// Encrypt は値を現在のKEKで暗号化する
+// 形式: v1:<KEK ID>:<ラップしたDEK>:<nonce+暗号文>
+func (c *Codec) Encrypt(plaintext string) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	sealed, err := seal(dek, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(c.keys[c.activeID], dek)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		formatVersion,
+		c.activeID,
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// Decrypt は暗号化された値を復号する
+func (c *Codec) Decrypt(ciphertext string) (string, error) {
+	keyID, wrapped, sealed, err := c.parse(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	dek, err := open(c.keys[keyID], wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dek, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation は値が現在のKEK以外でラップされているかを返す
+func (c *Codec) NeedsRotation(ciphertext string) bool {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	return len(parts) < 2 || parts[1] != c.activeID
+}
+
+// BlindIndex は等価検索用のインデックス値を返す
+func (c *Codec) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Codec) parse(ciphertext string) (string, []byte, []byte, error) {
+	parts := strings.Split(ciphertext, ":")
+	if len(parts) != 4 || parts[0] != formatVersion {
+		return "", nil, nil, ErrMalformed
+	}
+	if _, ok := c.keys[parts[1]]; !ok {
+		return "", nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, parts[1])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[1], wrapped, sealed, nil
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, body := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, body, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
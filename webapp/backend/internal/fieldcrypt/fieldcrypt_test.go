@@ -0,0 +1,72 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testCodec(t *testing.T, activeID string) *Codec {
+	t.Helper()
+	keys := map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}
+	c, err := NewCodec(keys, activeID, bytes.Repeat([]byte{9}, 32))
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	return c
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	c := testCodec(t, "k1")
+	enc, err := c.Encrypt("山田太郎")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	got, err := c.Decrypt(enc)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if got != "山田太郎" {
+		t.Fatalf("expected round trip, got %q", got)
+	}
+
+	again, _ := c.Encrypt("山田太郎")
+	if again == enc {
+		t.Fatalf("expected a fresh data key and nonce per value")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old := testCodec(t, "k1")
+	enc, err := old.Encrypt("user1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	current := testCodec(t, "k2")
+	if !current.NeedsRotation(enc) {
+		t.Fatalf("expected value wrapped with k1 to need rotation")
+	}
+	got, err := current.Decrypt(enc)
+	if err != nil || got != "user1" {
+		t.Fatalf("expected old values to stay readable, got %q, %v", got, err)
+	}
+	if old.BlindIndex("user1") != current.BlindIndex("user1") {
+		t.Fatalf("blind index must not depend on the active key")
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	c := testCodec(t, "k1")
+	enc, _ := c.Encrypt("user1")
+	tampered := enc[:len(enc)-2] + "AA"
+	if _, err := c.Decrypt(tampered); err == nil {
+		t.Fatalf("expected tampered ciphertext to fail")
+	}
+	if _, err := c.Decrypt("v1:k9:AAAA:AAAA"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+}
@@ -0,0 +1,545 @@
+package handler
+
+import (
+	"backend/internal/model"
+	"backend/internal/service"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AdminHandler struct {
+	MaintenanceSvc *service.MaintenanceService
+	DeadLetterSvc  *service.DeadLetterService
+	PlannerSvc     *service.PlannerProfileService
+	ReportSvc      *service.ReconciliationService
+	ProductSvc     *service.ProductService
+	APIKeySvc      *service.APIKeyService
+}
+
+func NewAdminHandler(maintenanceSvc *service.MaintenanceService, deadLetterSvc *service.DeadLetterService, plannerSvc *service.PlannerProfileService, reportSvc *service.ReconciliationService, productSvc *service.ProductService, apiKeySvc *service.APIKeyService) *AdminHandler {
+	return &AdminHandler{MaintenanceSvc: maintenanceSvc, DeadLetterSvc: deadLetterSvc, PlannerSvc: plannerSvc, ReportSvc: reportSvc, ProductSvc: productSvc, APIKeySvc: apiKeySvc}
+}
+
+// 主要テーブルの統計情報更新(ANALYZE TABLE)を開始
+func (h *AdminHandler) StartAnalyze(w http.ResponseWriter, r *http.Request) {
+	progress, err := h.MaintenanceSvc.StartAnalyze()
+	status := http.StatusAccepted
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrMaintenanceRunning):
+			status = http.StatusConflict
+		case errors.Is(err, service.ErrMaintenanceThrottled):
+			status = http.StatusTooManyRequests
+		default:
+			http.Error(w, "Failed to start analyze", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(progress)
+}
+
+// 統計情報更新の進捗を取得
+func (h *AdminHandler) AnalyzeStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.MaintenanceSvc.AnalyzeProgress())
+}
+
+// デッドレター一覧を取得
+func (h *AdminHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(q.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	letters, partial, err := h.DeadLetterSvc.List(r.Context(), q.Get("kind"), limit, offset)
+	if err != nil {
+		log.Printf("Failed to list dead letters: %v", err)
+		http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := map[string]interface{}{"data": letters}
+	if partial {
+		resp["partial"] = true
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 種別ごとのデッドレター滞留件数を取得
+func (h *AdminHandler) DeadLetterMetrics(w http.ResponseWriter, r *http.Request) {
+	depths, err := h.DeadLetterSvc.Depth(r.Context())
+	if err != nil {
+		log.Printf("Failed to count dead letters: %v", err)
+		http.Error(w, "Failed to count dead letters", http.StatusInternalServerError)
+		return
+	}
+	total := 0
+	for _, d := range depths {
+		total += d.Count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"total": total, "by_kind": depths})
+}
+
+// デッドレターを再実行
+func (h *AdminHandler) RetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid dead letter id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DeadLetterSvc.Retry(r.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, service.ErrDeadLetterNotFound):
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrNoRetryHandler):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, "Retry failed: "+err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// デッドレターを破棄
+func (h *AdminHandler) DiscardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid dead letter id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DeadLetterSvc.Discard(r.Context(), id); err != nil {
+		if errors.Is(err, service.ErrDeadLetterNotFound) {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to discard dead letter %d: %v", id, err)
+		http.Error(w, "Failed to discard dead letter", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// プランナープロファイルとロボットへの割り当てを取得
+func (h *AdminHandler) ListPlannerProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, assignments := h.PlannerSvc.Profiles()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"profiles": profiles, "assignments": assignments})
+}
+
+// プランナープロファイルを作成・更新
+func (h *AdminHandler) SavePlannerProfile(w http.ResponseWriter, r *http.Request) {
+	var profile model.PlannerProfile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	profile.Name = chi.URLParam(r, "name")
+
+	if err := h.PlannerSvc.SaveProfile(r.Context(), profile); err != nil {
+		if errors.Is(err, service.ErrInvalidPlannerProfile) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to save planner profile %s: %v", profile.Name, err)
+		http.Error(w, "Failed to save planner profile", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ロボットにプランナープロファイルを割り当てる（profileが空なら割り当てを外す）
+func (h *AdminHandler) AssignPlannerProfile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Profile string `json:"profile"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	robotID := chi.URLParam(r, "robotID")
+
+	if err := h.PlannerSvc.Assign(r.Context(), robotID, req.Profile); err != nil {
+		if errors.Is(err, service.ErrPlannerProfileMissing) {
+			http.Error(w, "Planner profile not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to assign planner profile to %s: %v", robotID, err)
+		http.Error(w, "Failed to assign planner profile", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// プロファイルごとの計画結果の集計を取得
+func (h *AdminHandler) PlannerMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": h.PlannerSvc.Metrics()})
+}
+
+// 突合レポートを作成（dateを省略した場合は前日分）
+func (h *AdminHandler) GenerateReconciliationReport(w http.ResponseWriter, r *http.Request) {
+	day := time.Now().AddDate(0, 0, -1)
+	if date := r.URL.Query().Get("date"); date != "" {
+		var err error
+		day, err = service.ParseReportDate(date)
+		if err != nil {
+			http.Error(w, "Query parameter 'date' must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := h.ReportSvc.Generate(r.Context(), day)
+	if err != nil {
+		log.Printf("Failed to generate reconciliation report: %v", err)
+		http.Error(w, "Failed to generate reconciliation report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// 保存済みの突合レポートを取得（format=json|csv、既定はjson）
+func (h *AdminHandler) GetReconciliationReport(w http.ResponseWriter, r *http.Request) {
+	date := chi.URLParam(r, "date")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	body, err := h.ReportSvc.Open(r.Context(), date, format)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidReportDate):
+			http.Error(w, "Report date must be YYYY-MM-DD", http.StatusBadRequest)
+		case errors.Is(err, service.ErrUnknownReportFormat):
+			http.Error(w, "Query parameter 'format' must be json or csv", http.StatusBadRequest)
+		case errors.Is(err, service.ErrReportNotFound):
+			http.Error(w, "Report not found", http.StatusNotFound)
+		default:
+			log.Printf("Failed to open reconciliation report %s: %v", date, err)
+			http.Error(w, "Failed to fetch reconciliation report", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer body.Close()
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"reconciliation-%s.csv\"", date))
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	io.Copy(w, body)
+}
+
+// 商品を追加する
+func (h *AdminHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
+	var product model.Product
+	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.ProductSvc.CreateProduct(r.Context(), product)
+	if err != nil {
+		if writeProductError(w, err) {
+			return
+		}
+		log.Printf("Failed to create product: %v", err)
+		http.Error(w, "Failed to create product", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// 商品のすべての項目を置き換える
+func (h *AdminHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+	var product model.Product
+	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	product.ProductID = productID
+
+	updated, err := h.ProductSvc.UpdateProduct(r.Context(), product)
+	if err != nil {
+		if writeProductError(w, err) {
+			return
+		}
+		log.Printf("Failed to update product %d: %v", productID, err)
+		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// 商品を論理削除する。削除した商品を参照する注文はそのまま残る
+func (h *AdminHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ProductSvc.DeleteProduct(r.Context(), productID); err != nil {
+		if writeProductError(w, err) {
+			return
+		}
+		log.Printf("Failed to delete product %d: %v", productID, err)
+		http.Error(w, "Failed to delete product", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 削除した商品を元に戻す
+func (h *AdminHandler) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.ProductSvc.RestoreProduct(r.Context(), productID)
+	if err != nil {
+		if writeProductError(w, err) {
+			return
+		}
+		log.Printf("Failed to restore product %d: %v", productID, err)
+		http.Error(w, "Failed to restore product", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
+
+// 商品のタグを置き換える。リクエストボディは{"tags": [...]}で、空の配列ならすべてのタグを外す
+func (h *AdminHandler) SetProductTags(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := h.ProductSvc.SetProductTags(r.Context(), productID, body.Tags)
+	if err != nil {
+		if writeProductError(w, err) {
+			return
+		}
+		log.Printf("Failed to set tags of product %d: %v", productID, err)
+		http.Error(w, "Failed to set product tags", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"product_id": productID, "tags": tags})
+}
+
+// 商品の価値の履歴を返す
+func (h *AdminHandler) ProductValueHistory(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.ProductSvc.ValueHistory(r.Context(), productID)
+	if err != nil {
+		if writeProductError(w, err) {
+			return
+		}
+		log.Printf("Failed to get value history of product %d: %v", productID, err)
+		http.Error(w, "Failed to get value history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": history})
+}
+
+// CSVで商品を一括登録する
+// multipart/form-dataのfileフィールドか、リクエストボディのCSVそのものを受け付ける
+func (h *AdminHandler) ImportProducts(w http.ResponseWriter, r *http.Request) {
+	maxBytes := h.ProductSvc.ImportMaxBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeImportReadError(w, err)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		writeImportReadError(w, err)
+		return
+	}
+
+	result, err := h.ProductSvc.ImportProducts(r.Context(), bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidProductImport) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to import products: %v", err)
+		http.Error(w, "Failed to import products", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func writeImportReadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "CSV file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Failed to read CSV file", http.StatusBadRequest)
+}
+
+// writeProductError は商品の管理APIのエラーをステータスコードにして書き込む。書き込んだらtrueを返す
+// 項目の検証エラーは、不正な項目ごとの理由をJSONで返す
+func writeProductError(w http.ResponseWriter, err error) bool {
+	var invalid *service.ProductValidationError
+	switch {
+	case errors.As(err, &invalid):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": invalid.Error(), "fields": invalid.Fields})
+	case errors.Is(err, service.ErrInvalidProduct):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrProductNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	default:
+		return false
+	}
+	return true
+}
+
+// ロボット向けのAPIキーの一覧。キーそのものは返さない
+func (h *AdminHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.APIKeySvc.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to list api keys: %v", err)
+		http.Error(w, "Failed to list api keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": keys})
+}
+
+// ロボット向けのAPIキーを発行する。キーそのものはこのレスポンスでしか返さない
+func (h *AdminHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req model.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.APIKeySvc.Create(r.Context(), req.Name, req.RobotID)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAPIKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to create api key: %v", err)
+		http.Error(w, "Failed to create api key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// APIキーを発行し直す。古いキーは猶予期間が過ぎると使えなくなる
+func (h *AdminHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	apiKeyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || apiKeyID <= 0 {
+		http.Error(w, "Invalid api key id", http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.APIKeySvc.Rotate(r.Context(), apiKeyID)
+	if err != nil {
+		if errors.Is(err, service.ErrAPIKeyNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to rotate api key %d: %v", apiKeyID, err)
+		http.Error(w, "Failed to rotate api key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// APIキーを取り消す
+func (h *AdminHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	apiKeyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || apiKeyID <= 0 {
+		http.Error(w, "Invalid api key id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.APIKeySvc.Revoke(r.Context(), apiKeyID); err != nil {
+		log.Printf("Failed to revoke api key %d: %v", apiKeyID, err)
+		http.Error(w, "Failed to revoke api key", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,18 +3,63 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/go-chi/chi/v5"
+
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
 )
 
 type AuthHandler struct {
 	AuthSvc *service.AuthService
+	// X-Real-IPを信用する接続元（nginxなどのリバースプロキシ）
+	trustedProxies []*net.IPNet
 }
 
+// プロキシの設定がないときに信用する接続元。nginxとはループバックかプライベートネットワークでつながる
+const defaultTrustedProxies = "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
 func NewAuthHandler(authSvc *service.AuthService) *AuthHandler {
-	return &AuthHandler{AuthSvc: authSvc}
+	proxies := os.Getenv("TRUSTED_PROXIES")
+	if proxies == "" {
+		proxies = defaultTrustedProxies
+	}
+	return &AuthHandler{AuthSvc: authSvc, trustedProxies: parseTrustedProxies(proxies)}
+}
+
+// parseTrustedProxies はカンマ区切りのCIDRかIPアドレスを読む。読めないものは無視する
+func parseTrustedProxies(raw string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, ipNet)
+		} else {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+		}
+	}
+	return nets
 }
 
 // ログイン時にセッションを発行し、Cookieにセットする
@@ -25,11 +70,13 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password)
+	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password, model.ClientInfo{IP: h.clientIP(r), UserAgent: r.UserAgent()})
 	if err != nil {
-		if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword) {
+		switch {
+		case writeThrottled(w, err):
+		case errors.Is(err, service.ErrInvalidCredentials):
 			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
-		} else {
+		default:
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 		return
@@ -48,6 +95,252 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
 }
 
+// writeThrottled は試行が多すぎて断ったエラーなら、Retry-Afterを付けて429を返す
+func writeThrottled(w http.ResponseWriter, err error) bool {
+	var throttled *service.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+	return true
+}
+
+// clearSessionCookie はセッションのCookieを消す
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/",
+	})
+}
+
+// clientIP はリクエストの接続元IPを返す
+// 信用するプロキシからのリクエストなら、プロキシが付けたX-Real-IPを使う
+func (h *AuthHandler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil {
+		return host
+	}
+	for _, proxy := range h.trustedProxies {
+		if proxy.Contains(remote) {
+			if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+				return realIP.String()
+			}
+			break
+		}
+	}
+	return remote.String()
+}
+
+// ユーザー登録 - ユーザーを作成する。ユーザー名が使われていれば409を返す
+func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var req model.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.AuthSvc.Register(r.Context(), req.UserName, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRegistration):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, service.ErrUserNameTaken):
+			http.Error(w, "User name is already taken", http.StatusConflict)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(model.LoginResponse{UserID: user.UserID, UserName: user.UserName})
+}
+
+// ログアウト - セッションを削除し、Cookieを消す。?all=true ならすべての端末からログアウトする
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		everywhere := r.URL.Query().Get("all") == "true"
+		if err := h.AuthSvc.Logout(r.Context(), cookie.Value, everywhere); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	clearSessionCookie(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logout successful"})
+}
+
+// セッション延長 - 有効なセッションの有効期限を延ばし、Cookieを付け直す
+func (h *AuthHandler) RefreshSession(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("session_id")
+	if err != nil {
+		http.Error(w, "Unauthorized: No session cookie", http.StatusUnauthorized)
+		return
+	}
+
+	expiresAt, err := h.AuthSvc.RefreshSession(r.Context(), cookie.Value)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    cookie.Value,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Path:     "/",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"expires_at": expiresAt})
+}
+
+// パスワード変更 - 今のパスワードを確かめてから変える。すべてのセッションが無効になるのでCookieも消す
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req model.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.ChangePassword(r.Context(), userID, req.OldPassword, req.NewPassword); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRegistration):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, service.ErrInvalidPassword), errors.Is(err, service.ErrUserNotFound):
+			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	clearSessionCookie(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed"})
+}
+
+// セッション一覧 - ログイン中のユーザーの有効なセッションを返す
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var current string
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		current = cookie.Value
+	}
+
+	sessions, err := h.AuthSvc.ListSessions(r.Context(), userID, current)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": sessions})
+}
+
+// セッション削除 - ログイン中のユーザーのセッションを1つ無効にする
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.RevokeSession(r.Context(), userID, id); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// パスワード再設定の申請 - トークンを発行して利用者に届ける
+// ユーザーがいるかどうかを知られないよう、ユーザー名によらず202を返す
+func (h *AuthHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req model.PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	client := model.ClientInfo{IP: h.clientIP(r), UserAgent: r.UserAgent()}
+	if err := h.AuthSvc.RequestPasswordReset(r.Context(), req.UserName, client); err != nil {
+		switch {
+		case writeThrottled(w, err):
+		case errors.Is(err, service.ErrPasswordResetUnavailable):
+			http.Error(w, "Password reset is not available", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "If the user exists, a password reset token has been sent"})
+}
+
+// パスワード再設定 - トークンを確かめてからパスワードを変える
+func (h *AuthHandler) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req model.PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.ConfirmPasswordReset(r.Context(), req.Token, req.NewPassword); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRegistration), errors.Is(err, service.ErrInvalidResetToken):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset"})
+}
+
 // 認証情報確認 - セッションが有効か確認
 func (h *AuthHandler) Verify(w http.ResponseWriter, r *http.Request) {
 	// パフォーマンス向上のためログを削除
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	h := &AuthHandler{trustedProxies: parseTrustedProxies("172.16.0.0/12, 127.0.0.1, bogus")}
+	tests := []struct {
+		remote, realIP, want string
+	}{
+		// nginxからのリクエストはX-Real-IPの接続元を使う
+		{"172.18.0.5:41234", "203.0.113.7", "203.0.113.7"},
+		{"127.0.0.1:41234", "203.0.113.7", "203.0.113.7"},
+		// 信用しない接続元が付けたX-Real-IPは使わない
+		{"198.51.100.9:41234", "203.0.113.7", "198.51.100.9"},
+		// X-Real-IPが読めなければプロキシのアドレスを使う
+		{"172.18.0.5:41234", "", "172.18.0.5"},
+		{"172.18.0.5:41234", "not-an-ip", "172.18.0.5"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/login", nil)
+		r.RemoteAddr = tt.remote
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := h.clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %q, want %q", tt.remote, tt.realIP, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"backend/internal/telemetry"
+	"encoding/json"
+	"net/http"
+)
+
+type DebugHandler struct {
+	Registry *telemetry.InflightRegistry
+}
+
+func NewDebugHandler(inflight *telemetry.InflightRegistry) *DebugHandler {
+	return &DebugHandler{Registry: inflight}
+}
+
+type inflightResponse struct {
+	Count    int                          `json:"count"`
+	Requests []telemetry.InflightSnapshot `json:"requests"`
+}
+
+// 処理中のリクエストの一覧（経過時間の長い順）
+func (h *DebugHandler) Inflight(w http.ResponseWriter, r *http.Request) {
+	requests := h.Registry.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(inflightResponse{Count: len(requests), Requests: requests})
+}
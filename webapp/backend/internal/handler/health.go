@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"backend/internal/service"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type HealthHandler struct {
+	HealthSvc *service.HealthService
+}
+
+func NewHealthHandler(healthSvc *service.HealthService) *HealthHandler {
+	return &HealthHandler{HealthSvc: healthSvc}
+}
+
+// オートスケーラー向けの0〜100のヘルススコア（高いほど余裕がある）
+func (h *HealthHandler) Score(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.HealthSvc.Score(ctx))
+}
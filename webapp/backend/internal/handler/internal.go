@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"backend/internal/service"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 指定がない場合に1ページで返す配送待ちの注文の件数
+const defaultShippingFeedPageSize = 100
+
+// InternalHandler は社内の他システム向けのエンドポイントを扱う
+type InternalHandler struct {
+	OrderSvc   *service.OrderService
+	ProductSvc *service.ProductService
+}
+
+func NewInternalHandler(orderSvc *service.OrderService, productSvc *service.ProductService) *InternalHandler {
+	return &InternalHandler{OrderSvc: orderSvc, ProductSvc: productSvc}
+}
+
+// 倉庫管理システム向けに配送待ちの注文を作成日時の順に返す
+// sinceはRFC3339の日時で、それより前に作成された注文を含めない。続きはレスポンスのnext_cursorをcursorに渡して読む
+func (h *InternalHandler) ShippingOrders(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := defaultShippingFeedPageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "Invalid since: must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	page, err := h.OrderSvc.ShippingFeed(r.Context(), since, q.Get("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidFeedRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to list shipping orders for the feed: %v", err)
+		http.Error(w, "Failed to list shipping orders", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// 負荷試験のツール向けに、注文数に比例した確率で商品をn件（重複あり）抽出して返す
+func (h *InternalHandler) SampleProducts(w http.ResponseWriter, r *http.Request) {
+	n := 0
+	if v := r.URL.Query().Get("n"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid n", http.StatusBadRequest)
+			return
+		}
+		n = parsed
+	}
+
+	products, err := h.ProductSvc.SampleProducts(r.Context(), n)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidSampleRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to sample products: %v", err)
+		http.Error(w, "Failed to sample products", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": products})
+}
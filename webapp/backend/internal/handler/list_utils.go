@@ -1,13 +1,499 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"backend/internal/model"
 )
 
+// 一覧取得リクエストの上限値
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxSearchLength = 100
+	maxSearchTerms  = 10
+	maxTagFilters   = 10
+	maxListOffset   = 10000
+	maxSortKeys     = 3
+)
+
+// ListValidationError は一覧取得リクエストの検証エラー
+type ListValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ListValidationError) Error() string {
+	return "invalid " + e.Field + ": " + e.Reason
+}
+
+// listSpec は一覧エンドポイントごとのソート・絞り込みの設定
+type listSpec struct {
+	sortFields       map[string]string
+	defaultSortField string
+	defaultSortOrder string
+	// 絞り込みに指定できるステータス。nilならステータスと作成日時での絞り込みを受け付けない
+	statuses map[string]bool
+	// fieldsで選べる列（JSONの名前→SELECTする式）と、常に返す識別子の列
+	fields  map[string]string
+	idField string
+	// 検索の種類にfulltext（全文検索）を指定できる
+	fulltext bool
+	// キーセットのカーソル（並び替えの列と識別子）で続きを読める並び替えの列（SQLの式）
+	// インデックス（列, 識別子）のある列だけを入れる。nilならオフセットのカーソルだけを使う
+	keyset map[string]bool
+}
+
+// keysetSortable は並び順がキーセットのカーソルで続きを読める1列か判定する
+func (spec listSpec) keysetSortable(req model.ListRequest) bool {
+	return spec.keyset[req.SortField] && len(req.Sort) <= 1
+}
+
+var productListSpec = listSpec{
+	sortFields: map[string]string{
+		"product_id":  "product_id",
+		"name":        "name",
+		"value":       "value",
+		"weight":      "weight",
+		"image":       "image",
+		"description": "description",
+		"popularity":  "popularity",
+	},
+	defaultSortField: "product_id",
+	defaultSortOrder: "asc",
+	fields: map[string]string{
+		"product_id":  "product_id",
+		"name":        "name",
+		"value":       "value",
+		"weight":      "weight",
+		"volume":      "volume",
+		"image":       "image",
+		"description": "description",
+		"stock":       "stock",
+		"popularity":  "popularity",
+		"is_favorite": "f.favorite_product_id IS NOT NULL AS is_favorite",
+	},
+	idField:  "product_id",
+	fulltext: true,
+	keyset: map[string]bool{
+		"product_id": true,
+		"name":       true,
+		"value":      true,
+		"weight":     true,
+		"popularity": true,
+	},
+}
+
+var orderListSpec = listSpec{
+	sortFields: map[string]string{
+		"order_id":       "o.order_id",
+		"product_name":   "COALESCE(o.product_name, p.name)",
+		"created_at":     "o.created_at",
+		"shipped_status": "o.shipped_status",
+		"arrived_at":     "o.arrived_at",
+	},
+	defaultSortField: "o.order_id",
+	defaultSortOrder: "desc",
+	statuses: map[string]bool{
+		"scheduled":  true,
+		"shipping":   true,
+		"delivering": true,
+		"completed":  true,
+		"cancelled":  true,
+		"failed":     true,
+	},
+	fields: map[string]string{
+		"order_id":       "o.order_id",
+		"user_id":        "o.user_id",
+		"product_id":     "o.product_id",
+		"product_name":   "COALESCE(o.product_name, p.name, '') AS product_name",
+		"shipped_status": "o.shipped_status",
+		"priority":       "o.priority",
+		"created_at":     "o.created_at",
+		"arrived_at":     "o.arrived_at",
+		"deliver_by":     "o.deliver_by",
+		"cancelled_at":   "o.cancelled_at",
+		"retry_count":    "o.retry_count",
+		"metadata":       "o.metadata",
+		"ship_after":     "o.ship_after",
+		"weight":         "COALESCE(o.product_weight, p.weight, 0) AS weight",
+		"value":          "COALESCE(o.value, p.value, 0) AS value",
+		"volume":         "COALESCE(o.product_volume, p.volume, 0) AS volume",
+	},
+	idField: "order_id",
+}
+
+// normalizeListRequest は一覧取得リクエストに既定値を補い、上限を検証し、Offsetを確定させる
+// カーソルが指定されていればページ番号より優先する
+func normalizeListRequest(req *model.ListRequest, spec listSpec) error {
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = defaultPageSize
+	}
+	if req.PageSize > maxPageSize {
+		return &ListValidationError{Field: "page_size", Reason: "must be at most " + strconv.Itoa(maxPageSize)}
+	}
+
+	search, err := normalizeSearch(req.Search)
+	if err != nil {
+		return err
+	}
+	req.Search = search
+	if req.Tags, err = normalizeTagFilter(req.Tags); err != nil {
+		return err
+	}
+
+	switch t := strings.ToLower(req.Type); t {
+	case "partial", "prefix":
+		req.Type = t
+	case "fulltext":
+		if spec.fulltext {
+			req.Type = t
+		} else {
+			req.Type = "partial"
+		}
+	default:
+		req.Type = "partial"
+	}
+
+	if err := sanitizeListRequest(req, spec.sortFields, spec.defaultSortField, spec.defaultSortOrder); err != nil {
+		return err
+	}
+
+	if err := normalizeListFilters(req, spec.statuses); err != nil {
+		return err
+	}
+
+	if err := normalizeListFields(req, spec); err != nil {
+		return err
+	}
+	// 続きのカーソルを作るため、列を絞っていても並び替えの列は読む
+	if spec.keysetSortable(*req) && len(req.Columns) > 0 && !slices.Contains(req.Columns, req.SortField) {
+		req.Columns = append(req.Columns, req.SortField)
+	}
+
+	if isKeysetCursor(req.Cursor) {
+		field, order, after, err := decodeKeysetCursor(req.Cursor)
+		if err != nil {
+			return &ListValidationError{Field: "cursor", Reason: "malformed"}
+		}
+		if !spec.keysetSortable(*req) || field != req.SortField || order != req.SortOrder {
+			return &ListValidationError{Field: "cursor", Reason: "does not match the sort order"}
+		}
+		req.After = &after
+		return nil
+	}
+	if req.Cursor != "" {
+		offset, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return &ListValidationError{Field: "cursor", Reason: "malformed"}
+		}
+		req.Offset = offset
+	} else {
+		if req.Page > maxListOffset/req.PageSize+1 {
+			return &ListValidationError{Field: "page", Reason: "exceeds the maximum offset " + strconv.Itoa(maxListOffset)}
+		}
+		req.Offset = (req.Page - 1) * req.PageSize
+	}
+	if req.Offset > maxListOffset {
+		return &ListValidationError{Field: "cursor", Reason: "exceeds the maximum offset " + strconv.Itoa(maxListOffset)}
+	}
+	return nil
+}
+
+// normalizeSearch は検索文字列の前後の空白を除き、語の間の空白（全角を含む）を半角1文字にそろえる
+// 同じ検索が空白の違いで別のキャッシュ・クエリにならないようにする
+func normalizeSearch(search string) (string, error) {
+	terms := strings.Fields(search)
+	if len(terms) > maxSearchTerms {
+		return "", &ListValidationError{Field: "search", Reason: "must have at most " + strconv.Itoa(maxSearchTerms) + " terms"}
+	}
+	search = strings.Join(terms, " ")
+	if utf8.RuneCountInString(search) > maxSearchLength {
+		return "", &ListValidationError{Field: "search", Reason: "must be at most " + strconv.Itoa(maxSearchLength) + " characters"}
+	}
+	return search, nil
+}
+
+// normalizeTagFilter は絞り込むタグを小文字にそろえて空と重複を除き、名前の順に並べる
+func normalizeTagFilter(tags []string) ([]string, error) {
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTagFilters {
+		return nil, &ListValidationError{Field: "tags", Reason: "must be at most " + strconv.Itoa(maxTagFilters) + " tags"}
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
+
+// listRequestFromQuery はクエリ文字列の検索・並び順・絞り込みの指定を一覧取得リクエストにする
+// ページングは扱わない。statusはカンマ区切りか繰り返しで、created_from・created_toはRFC3339で、archivedは真偽値で指定する
+// sortは「列:asc」「列:desc」のカンマ区切りか繰り返しで指定し、sort_field・sort_orderより優先する
+// value_min・value_max・weight_min・weight_max・category_idは整数で指定する
+func listRequestFromQuery(q url.Values, spec listSpec) (model.ListRequest, error) {
+	req := model.ListRequest{
+		Type:      q.Get("type"),
+		SortField: q.Get("sort_field"),
+		SortOrder: q.Get("sort_order"),
+	}
+	search, err := normalizeSearch(q.Get("search"))
+	if err != nil {
+		return req, err
+	}
+	req.Search = search
+	switch t := strings.ToLower(req.Type); t {
+	case "partial", "prefix":
+		req.Type = t
+	default:
+		req.Type = "partial"
+	}
+	for _, v := range q["sort"] {
+		for _, key := range strings.Split(v, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				field, order, _ := strings.Cut(key, ":")
+				req.Sort = append(req.Sort, model.SortKey{Field: field, Order: order})
+			}
+		}
+	}
+	if err := sanitizeListRequest(&req, spec.sortFields, spec.defaultSortField, spec.defaultSortOrder); err != nil {
+		return req, err
+	}
+
+	if v := q.Get("archived"); v != "" {
+		archived, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, &ListValidationError{Field: "archived", Reason: "must be a boolean"}
+		}
+		req.Archived = archived
+	}
+	for _, v := range q["status"] {
+		for _, status := range strings.Split(v, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				req.Status = append(req.Status, status)
+			}
+		}
+	}
+	for _, f := range []struct {
+		name string
+		dest *time.Time
+	}{{"created_from", &req.CreatedFrom}, {"created_to", &req.CreatedTo}} {
+		if v := q.Get(f.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return req, &ListValidationError{Field: f.name, Reason: "must be an RFC3339 timestamp"}
+			}
+			*f.dest = t
+		}
+	}
+	for _, f := range []struct {
+		name string
+		set  func(n int)
+	}{
+		{"value_min", func(n int) { v := model.Points(n); req.ValueMin = &v }},
+		{"value_max", func(n int) { v := model.Points(n); req.ValueMax = &v }},
+		{"weight_min", func(n int) { v := model.Grams(n); req.WeightMin = &v }},
+		{"weight_max", func(n int) { v := model.Grams(n); req.WeightMax = &v }},
+		{"category_id", func(n int) { req.CategoryID = n }},
+	} {
+		if v := q.Get(f.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return req, &ListValidationError{Field: f.name, Reason: "must be an integer"}
+			}
+			f.set(n)
+		}
+	}
+	return req, normalizeListFilters(&req, spec.statuses)
+}
+
+// normalizeListFields は返す列の名前を小文字にして重複を除き、SELECTする式をreq.Columnsに設定する
+// 識別子の列は指定がなくても先頭に含める
+func normalizeListFields(req *model.ListRequest, spec listSpec) error {
+	req.Columns = nil
+	if len(req.Fields) == 0 {
+		req.Fields = nil
+		return nil
+	}
+	fields := []string{spec.idField}
+	columns := []string{spec.fields[spec.idField]}
+	seen := map[string]bool{spec.idField: true}
+	for _, f := range req.Fields {
+		name := strings.ToLower(strings.TrimSpace(f))
+		column, ok := spec.fields[name]
+		if !ok {
+			return &ListValidationError{Field: "fields", Reason: "unknown field " + strconv.Quote(f)}
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		fields = append(fields, name)
+		columns = append(columns, column)
+	}
+	req.Fields, req.Columns = fields, columns
+	return nil
+}
+
+// shapeListRows は一覧の各行を、fieldsの列だけを指定の順に持つJSONオブジェクトにする
+func shapeListRows[T any](rows []T, fields []string) ([]json.RawMessage, error) {
+	shaped := make([]json.RawMessage, len(rows))
+	for i, row := range rows {
+		b, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(b, &all); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for j, f := range fields {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(strconv.Quote(f))
+			buf.WriteByte(':')
+			if v, ok := all[f]; ok {
+				buf.Write(v)
+			} else {
+				buf.WriteString("null")
+			}
+		}
+		buf.WriteByte('}')
+		shaped[i] = buf.Bytes()
+	}
+	return shaped, nil
+}
+
+// budgetListRows はレスポンスのバイト数上限に収まるよう一覧の行を切り詰め、fieldsが指定されていればその列だけにする
+// 返す行と件数、続きのカーソルを返す。上限は列を絞った後の大きさで判定する
+func budgetListRows[T any](w http.ResponseWriter, r *http.Request, rows []T, fields []string, offset, total int) (interface{}, int, string, error) {
+	if len(fields) == 0 {
+		returned, nextCursor := applyResponseBudget(w, r, rows, offset, total)
+		return returned, len(returned), nextCursor, nil
+	}
+	shaped, err := shapeListRows(rows, fields)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	returned, nextCursor := applyResponseBudget(w, r, shaped, offset, total)
+	return returned, len(returned), nextCursor, nil
+}
+
+// normalizeListFilters はステータスを小文字にして重複を除き、指定できる値か検証する
+func normalizeListFilters(req *model.ListRequest, statuses map[string]bool) error {
+	if req.CategoryID < 0 {
+		return &ListValidationError{Field: "category_id", Reason: "must not be negative"}
+	}
+	if statuses == nil {
+		if len(req.Status) > 0 {
+			return &ListValidationError{Field: "status", Reason: "not supported"}
+		}
+		if !req.CreatedFrom.IsZero() || !req.CreatedTo.IsZero() {
+			return &ListValidationError{Field: "created_from", Reason: "not supported"}
+		}
+		if req.Archived {
+			return &ListValidationError{Field: "archived", Reason: "not supported"}
+		}
+		if req.EstimateTotal {
+			return &ListValidationError{Field: "estimate_total", Reason: "not supported"}
+		}
+		if req.ValueMin != nil || req.ValueMax != nil || req.WeightMin != nil || req.WeightMax != nil {
+			return &ListValidationError{Field: "value_min", Reason: "not supported"}
+		}
+		return nil
+	}
+
+	if len(req.Status) > 0 {
+		normalized := make([]string, 0, len(req.Status))
+		seen := make(map[string]bool, len(req.Status))
+		for _, status := range req.Status {
+			status = strings.ToLower(strings.TrimSpace(status))
+			if !statuses[status] {
+				return &ListValidationError{Field: "status", Reason: "unknown status " + strconv.Quote(status)}
+			}
+			if !seen[status] {
+				seen[status] = true
+				normalized = append(normalized, status)
+			}
+		}
+		req.Status = normalized
+	}
+	if !req.CreatedFrom.IsZero() && !req.CreatedTo.IsZero() && !req.CreatedFrom.Before(req.CreatedTo) {
+		return &ListValidationError{Field: "created_to", Reason: "must be after created_from"}
+	}
+	if err := validateRange("value", req.ValueMin, req.ValueMax); err != nil {
+		return err
+	}
+	return validateRange("weight", req.WeightMin, req.WeightMax)
+}
+
+// validateRange は商品の価値・重さの範囲が0以上で、下限が上限以下か検証する
+func validateRange[T ~int](name string, min, max *T) error {
+	if min != nil && *min < 0 {
+		return &ListValidationError{Field: name + "_min", Reason: "must not be negative"}
+	}
+	if max != nil && *max < 0 {
+		return &ListValidationError{Field: name + "_max", Reason: "must not be negative"}
+	}
+	if min != nil && max != nil && *min > *max {
+		return &ListValidationError{Field: name + "_max", Reason: "must be at least " + name + "_min"}
+	}
+	return nil
+}
+
 // sanitizeListRequest applies allowlists for sort field/order and defaults.
-func sanitizeListRequest(req *model.ListRequest, allowedFields map[string]string, defaultField, defaultOrder string) {
+// Unknown single sort fields fall back to the default, while every key in a multi-column sort must be allowed.
+func sanitizeListRequest(req *model.ListRequest, allowedFields map[string]string, defaultField, defaultOrder string) error {
+	if len(req.Sort) > 0 {
+		if len(req.Sort) > maxSortKeys {
+			return &ListValidationError{Field: "sort", Reason: "must have at most " + strconv.Itoa(maxSortKeys) + " keys"}
+		}
+		keys := make([]model.SortKey, len(req.Sort))
+		seen := make(map[string]bool, len(req.Sort))
+		for i, key := range req.Sort {
+			column, ok := allowedFields[strings.ToLower(strings.TrimSpace(key.Field))]
+			if !ok {
+				return &ListValidationError{Field: "sort", Reason: "unknown field " + strconv.Quote(key.Field)}
+			}
+			if seen[column] {
+				return &ListValidationError{Field: "sort", Reason: "duplicate field " + strconv.Quote(key.Field)}
+			}
+			seen[column] = true
+			order := strings.ToLower(strings.TrimSpace(key.Order))
+			switch order {
+			case "asc", "desc":
+			case "":
+				order = defaultOrder
+			default:
+				return &ListValidationError{Field: "sort", Reason: "unknown order " + strconv.Quote(key.Order)}
+			}
+			keys[i] = model.SortKey{Field: column, Order: strings.ToUpper(order)}
+		}
+		req.Sort = keys
+		req.SortField, req.SortOrder = keys[0].Field, keys[0].Order
+		return nil
+	}
+
 	fieldKey := strings.ToLower(req.SortField)
 	if fieldKey == "" {
 		req.SortField = defaultField
@@ -24,4 +510,113 @@ func sanitizeListRequest(req *model.ListRequest, allowedFields map[string]string
 	default:
 		req.SortOrder = strings.ToUpper(defaultOrder)
 	}
+	return nil
+}
+
+// クライアントはこのヘッダーでレスポンスのバイト数上限を指定できる
+// 値はバイト数、または"auto"（サーバー既定値を使用）
+const responseBudgetHeader = "X-Response-Budget"
+
+var (
+	defaultResponseBudget = envInt("RESPONSE_BYTE_BUDGET", 64<<10)
+	maxResponseBudget     = envInt("RESPONSE_BYTE_BUDGET_MAX", 1<<20)
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// responseBudget はリクエストで指定されたバイト数上限を返す。指定がなければ0
+func responseBudget(r *http.Request) int {
+	raw := strings.TrimSpace(r.Header.Get(responseBudgetHeader))
+	if raw == "" {
+		return 0
+	}
+	budget := defaultResponseBudget
+	if !strings.EqualFold(raw, "auto") {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return 0
+		}
+		budget = n
+	}
+	if budget > maxResponseBudget {
+		budget = maxResponseBudget
+	}
+	return budget
+}
+
+// applyResponseBudget はバイト数上限に収まるだけの行に切り詰め、続きがあれば継続カーソルを返す
+// 上限が小さすぎても最低1行は返す
+func applyResponseBudget[T any](w http.ResponseWriter, r *http.Request, rows []T, offset, total int) ([]T, string) {
+	budget := responseBudget(r)
+	if budget <= 0 {
+		return rows, ""
+	}
+	w.Header().Set(responseBudgetHeader+"-Applied", strconv.Itoa(budget))
+
+	size := 0
+	n := 0
+	for ; n < len(rows); n++ {
+		b, err := json.Marshal(rows[n])
+		if err != nil {
+			break
+		}
+		size += len(b) + 1
+		if size > budget && n > 0 {
+			break
+		}
+	}
+	if offset+n < total {
+		return rows[:n], encodeCursor(offset + n)
+	}
+	return rows[:n], ""
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), "o:"))
+	if err != nil || offset < 0 || !strings.HasPrefix(string(raw), "o:") {
+		return 0, errInvalidCursor
+	}
+	return offset, nil
+}
+
+// キーセットのカーソルは「k:並び順:列:識別子:値」。値は区切りを含みうるため最後に置く
+func encodeKeysetCursor(field, order string, after model.ListKeyset) string {
+	raw := "k:" + order + ":" + field + ":" + strconv.FormatInt(after.ID, 10) + ":" + after.Value
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func isKeysetCursor(cursor string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	return err == nil && strings.HasPrefix(string(raw), "k:")
+}
+
+func decodeKeysetCursor(cursor string) (field, order string, after model.ListKeyset, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", after, errInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 5)
+	if len(parts) != 5 || parts[0] != "k" {
+		return "", "", after, errInvalidCursor
+	}
+	id, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return "", "", after, errInvalidCursor
+	}
+	return parts[2], parts[1], model.ListKeyset{Value: parts[4], ID: id}, nil
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
 }
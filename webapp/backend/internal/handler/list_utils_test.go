@@ -0,0 +1,320 @@
+package handler
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestNormalizeListRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       model.ListRequest
+		spec      listSpec
+		want      model.ListRequest
+		wantField string
+	}{
+		{
+			name: "defaults",
+			req:  model.ListRequest{},
+			spec: productListSpec,
+			want: model.ListRequest{Type: "partial", Page: 1, PageSize: 20, SortField: "product_id", SortOrder: "ASC"},
+		},
+		{
+			name: "offset follows page",
+			req:  model.ListRequest{Page: 3, PageSize: 20, SortField: "created_at", SortOrder: "asc"},
+			spec: orderListSpec,
+			want: model.ListRequest{Type: "partial", Page: 3, PageSize: 20, SortField: "o.created_at", SortOrder: "ASC", Offset: 40},
+		},
+		{
+			name: "unknown sort and type fall back",
+			req:  model.ListRequest{Type: "regex", SortField: "password", SortOrder: "sideways"},
+			spec: orderListSpec,
+			want: model.ListRequest{Type: "partial", Page: 1, PageSize: 20, SortField: "o.order_id", SortOrder: "DESC"},
+		},
+		{
+			name: "type and search normalized",
+			req:  model.ListRequest{Type: "PREFIX", Search: "  chello  "},
+			spec: productListSpec,
+			want: model.ListRequest{Type: "prefix", Search: "chello", Page: 1, PageSize: 20, SortField: "product_id", SortOrder: "ASC"},
+		},
+		{
+			name: "fulltext search on products",
+			req:  model.ListRequest{Type: "FullText", Search: "ergonomic\u3000 chair"},
+			spec: productListSpec,
+			want: model.ListRequest{Type: "fulltext", Search: "ergonomic chair", Page: 1, PageSize: 20, SortField: "product_id", SortOrder: "ASC"},
+		},
+		{
+			name: "fulltext search falls back on orders",
+			req:  model.ListRequest{Type: "fulltext", Search: "chair"},
+			spec: orderListSpec,
+			want: model.ListRequest{Type: "partial", Search: "chair", Page: 1, PageSize: 20, SortField: "o.order_id", SortOrder: "DESC"},
+		},
+		{
+			name: "keyset cursor",
+			req:  model.ListRequest{SortField: "value", SortOrder: "desc", Cursor: encodeKeysetCursor("value", "DESC", model.ListKeyset{Value: "100", ID: 7})},
+			spec: productListSpec,
+			want: model.ListRequest{
+				Type: "partial", Page: 1, PageSize: 20, SortField: "value", SortOrder: "DESC",
+				Cursor: encodeKeysetCursor("value", "DESC", model.ListKeyset{Value: "100", ID: 7}),
+				After:  &model.ListKeyset{Value: "100", ID: 7},
+			},
+		},
+		{
+			name: "keyset sort column is read with selected fields",
+			req:  model.ListRequest{SortField: "weight", Fields: []string{"name"}},
+			spec: productListSpec,
+			want: model.ListRequest{
+				Type: "partial", Page: 1, PageSize: 20, SortField: "weight", SortOrder: "ASC",
+				Fields: []string{"product_id", "name"}, Columns: []string{"product_id", "name", "weight"},
+			},
+		},
+		{
+			name: "cursor overrides page",
+			req:  model.ListRequest{Page: 5, PageSize: 10, Cursor: encodeCursor(7)},
+			spec: productListSpec,
+			want: model.ListRequest{Type: "partial", Page: 5, PageSize: 10, SortField: "product_id", SortOrder: "ASC", Cursor: encodeCursor(7), Offset: 7},
+		},
+		{
+			name: "status filter normalized",
+			req:  model.ListRequest{Status: []string{" Shipping", "cancelled", "shipping"}},
+			spec: orderListSpec,
+			want: model.ListRequest{Type: "partial", Page: 1, PageSize: 20, SortField: "o.order_id", SortOrder: "DESC", Status: []string{"shipping", "cancelled"}},
+		},
+		{
+			name: "multi-column sort normalized",
+			req:  model.ListRequest{Sort: []model.SortKey{{Field: "Shipped_Status", Order: "asc"}, {Field: "created_at"}}},
+			spec: orderListSpec,
+			want: model.ListRequest{
+				Type: "partial", Page: 1, PageSize: 20, SortField: "o.shipped_status", SortOrder: "ASC",
+				Sort: []model.SortKey{{Field: "o.shipped_status", Order: "ASC"}, {Field: "o.created_at", Order: "DESC"}},
+			},
+		},
+		{
+			name: "fields normalized with the id first",
+			req:  model.ListRequest{Fields: []string{" Shipped_Status", "created_at", "shipped_status"}},
+			spec: orderListSpec,
+			want: model.ListRequest{
+				Type: "partial", Page: 1, PageSize: 20, SortField: "o.order_id", SortOrder: "DESC",
+				Fields:  []string{"order_id", "shipped_status", "created_at"},
+				Columns: []string{"o.order_id", "o.shipped_status", "o.created_at"},
+			},
+		},
+		{
+			name:      "unknown field",
+			req:       model.ListRequest{Fields: []string{"name", "password"}},
+			spec:      productListSpec,
+			wantField: "fields",
+		},
+		{
+			name:      "unknown multi-column sort field",
+			req:       model.ListRequest{Sort: []model.SortKey{{Field: "created_at"}, {Field: "password"}}},
+			spec:      orderListSpec,
+			wantField: "sort",
+		},
+		{
+			name:      "duplicate multi-column sort field",
+			req:       model.ListRequest{Sort: []model.SortKey{{Field: "name", Order: "asc"}, {Field: "NAME", Order: "desc"}}},
+			spec:      productListSpec,
+			wantField: "sort",
+		},
+		{
+			name:      "too many sort keys",
+			req:       model.ListRequest{Sort: []model.SortKey{{Field: "name"}, {Field: "value"}, {Field: "weight"}, {Field: "image"}}},
+			spec:      productListSpec,
+			wantField: "sort",
+		},
+		{
+			name:      "negative value range",
+			req:       model.ListRequest{ValueMin: pointsPtr(-1)},
+			spec:      orderListSpec,
+			wantField: "value_min",
+		},
+		{
+			name:      "inverted weight range",
+			req:       model.ListRequest{WeightMin: gramsPtr(500), WeightMax: gramsPtr(100)},
+			spec:      orderListSpec,
+			wantField: "weight_max",
+		},
+		{
+			name:      "value range on products",
+			req:       model.ListRequest{ValueMax: pointsPtr(100)},
+			spec:      productListSpec,
+			wantField: "value_min",
+		},
+		{
+			name:      "negative category",
+			req:       model.ListRequest{CategoryID: -1},
+			spec:      productListSpec,
+			wantField: "category_id",
+		},
+		{
+			name:      "unknown status",
+			req:       model.ListRequest{Status: []string{"lost"}},
+			spec:      orderListSpec,
+			wantField: "status",
+		},
+		{
+			name:      "status filter on products",
+			req:       model.ListRequest{Status: []string{"shipping"}},
+			spec:      productListSpec,
+			wantField: "status",
+		},
+		{
+			name:      "empty date range",
+			req:       model.ListRequest{CreatedFrom: time.Date(2025, 9, 2, 0, 0, 0, 0, time.UTC), CreatedTo: time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)},
+			spec:      orderListSpec,
+			wantField: "created_to",
+		},
+		{
+			name:      "page size over cap",
+			req:       model.ListRequest{PageSize: maxPageSize + 1},
+			spec:      productListSpec,
+			wantField: "page_size",
+		},
+		{
+			name: "tag filters are normalized",
+			req:  model.ListRequest{Tags: []string{" Heavy", "fragile", "heavy", ""}},
+			spec: productListSpec,
+			want: model.ListRequest{Type: "partial", Page: 1, PageSize: 20, SortField: "product_id", SortOrder: "ASC", Tags: []string{"fragile", "heavy"}},
+		},
+		{
+			name:      "too many tag filters",
+			req:       model.ListRequest{Tags: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}},
+			spec:      productListSpec,
+			wantField: "tags",
+		},
+		{
+			name:      "too many search terms",
+			req:       model.ListRequest{Search: strings.Repeat("a ", maxSearchTerms+1)},
+			spec:      productListSpec,
+			wantField: "search",
+		},
+		{
+			name:      "search too long",
+			req:       model.ListRequest{Search: strings.Repeat("あ", maxSearchLength+1)},
+			spec:      productListSpec,
+			wantField: "search",
+		},
+		{
+			name:      "page beyond max offset",
+			req:       model.ListRequest{Page: maxListOffset, PageSize: 20},
+			spec:      orderListSpec,
+			wantField: "page",
+		},
+		{
+			name:      "keyset cursor for another sort",
+			req:       model.ListRequest{SortField: "name", Cursor: encodeKeysetCursor("value", "ASC", model.ListKeyset{Value: "100", ID: 7})},
+			spec:      productListSpec,
+			wantField: "cursor",
+		},
+		{
+			name:      "keyset cursor on orders",
+			req:       model.ListRequest{Cursor: encodeKeysetCursor("o.order_id", "DESC", model.ListKeyset{ID: 7})},
+			spec:      orderListSpec,
+			wantField: "cursor",
+		},
+		{
+			name:      "malformed cursor",
+			req:       model.ListRequest{Cursor: "!!!"},
+			spec:      orderListSpec,
+			wantField: "cursor",
+		},
+		{
+			name:      "cursor beyond max offset",
+			req:       model.ListRequest{Cursor: encodeCursor(maxListOffset + 1)},
+			spec:      orderListSpec,
+			wantField: "cursor",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := normalizeListRequest(&req, tt.spec)
+			if tt.wantField != "" {
+				var verr *ListValidationError
+				if !errors.As(err, &verr) || verr.Field != tt.wantField {
+					t.Fatalf("expected validation error on %q, got %v", tt.wantField, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(req, tt.want) {
+				t.Fatalf("unexpected result:\n got %+v\nwant %+v", req, tt.want)
+			}
+		})
+	}
+}
+
+func TestListRequestFromQuery(t *testing.T) {
+	q := url.Values{
+		"search":       {" chello "},
+		"sort_field":   {"order_id"},
+		"sort":         {"created_at:desc,shipped_status"},
+		"status":       {"shipping,Completed", "shipping"},
+		"created_from": {"2025-09-01T00:00:00+09:00"},
+		"value_min":    {"100"},
+		"weight_max":   {"2000"},
+		"category_id":  {"3"},
+	}
+	req, err := listRequestFromQuery(q, orderListSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := model.ListRequest{
+		Search:      "chello",
+		Type:        "partial",
+		SortField:   "o.created_at",
+		SortOrder:   "DESC",
+		Sort:        []model.SortKey{{Field: "o.created_at", Order: "DESC"}, {Field: "o.shipped_status", Order: "DESC"}},
+		Status:      []string{"shipping", "completed"},
+		CreatedFrom: time.Date(2025, 9, 1, 0, 0, 0, 0, time.FixedZone("", 9*60*60)),
+		ValueMin:    pointsPtr(100),
+		WeightMax:   gramsPtr(2000),
+		CategoryID:  3,
+	}
+	if !reflect.DeepEqual(req, want) {
+		t.Fatalf("unexpected result:\n got %+v\nwant %+v", req, want)
+	}
+
+	for field, q := range map[string]url.Values{
+		"created_to": {"created_to": {"2025-09-01"}},
+		"status":     {"status": {"lost"}},
+		"sort":       {"sort": {"created_at:sideways"}},
+		"value_max":  {"value_max": {"1.5"}},
+	} {
+		var verr *ListValidationError
+		if _, err := listRequestFromQuery(q, orderListSpec); !errors.As(err, &verr) || verr.Field != field {
+			t.Fatalf("expected validation error on %q, got %v", field, err)
+		}
+	}
+}
+
+func pointsPtr(n int) *model.Points {
+	p := model.Points(n)
+	return &p
+}
+
+func gramsPtr(n int) *model.Grams {
+	g := model.Grams(n)
+	return &g
+}
+
+func TestShapeListRows(t *testing.T) {
+	products := []model.Product{{ProductID: 1, Name: "chair", Description: "a long description", Image: "/images/chair.png"}}
+	shaped, err := shapeListRows(products, []string{"product_id", "name"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := string(shaped[0]); got != `{"product_id":1,"name":"chair"}` {
+		t.Fatalf("expected only the selected fields, got %s", got)
+	}
+}
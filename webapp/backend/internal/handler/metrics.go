@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"backend/internal/telemetry"
+	"log"
+	"net/http"
+)
+
+type MetricsHandler struct {
+	SolverStats    *telemetry.SolverStats
+	SessionGCStats *telemetry.SessionGCStats
+}
+
+func NewMetricsHandler(solverStats *telemetry.SolverStats, sessionGCStats *telemetry.SessionGCStats) *MetricsHandler {
+	return &MetricsHandler{SolverStats: solverStats, SessionGCStats: sessionGCStats}
+}
+
+// 配送計画の選定に使った解法ごとの集計と、期限切れセッションの削除の集計（Prometheusのテキスト形式）
+func (h *MetricsHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := h.SolverStats.WritePrometheus(w); err != nil {
+		log.Printf("Failed to write metrics: %v", err)
+		return
+	}
+	if err := h.SessionGCStats.WritePrometheus(w); err != nil {
+		log.Printf("Failed to write metrics: %v", err)
+	}
+}
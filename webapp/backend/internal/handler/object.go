@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"backend/internal/objectstore"
+	"backend/internal/service"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ObjectHandler struct {
+	ProofSvc *service.DeliveryProofService
+}
+
+func NewObjectHandler(proofSvc *service.DeliveryProofService) *ObjectHandler {
+	return &ObjectHandler{ProofSvc: proofSvc}
+}
+
+// 署名付きURLでオブジェクトをダウンロード
+// 署名が認証を兼ねるため、セッションやAPIキーは要求しない
+func (h *ObjectHandler) Download(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	q := r.URL.Query()
+
+	body, contentType, err := h.ProofSvc.OpenSigned(r.Context(), key, q.Get("expires"), q.Get("signature"))
+	if err != nil {
+		switch {
+		case errors.Is(err, objectstore.ErrInvalidSignature):
+			http.Error(w, "Invalid or expired signature", http.StatusForbidden)
+		case errors.Is(err, objectstore.ErrNotFound), errors.Is(err, objectstore.ErrInvalidKey):
+			http.Error(w, "Object not found", http.StatusNotFound)
+		default:
+			log.Printf("Failed to open object %s: %v", key, err)
+			http.Error(w, "Failed to fetch object", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer body.Close()
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+	io.Copy(w, body)
+}
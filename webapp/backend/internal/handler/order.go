@@ -4,17 +4,34 @@ import (
 	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// ロングポーリングで待機できる最大時間
+const maxStatusWait = 60 * time.Second
+
+// SSEの接続を保つためにコメント行を送る間隔
+const streamKeepAlive = 15 * time.Second
+
 type OrderHandler struct {
 	OrderSvc *service.OrderService
+	ProofSvc *service.DeliveryProofService
 }
 
-func NewOrderHandler(svc *service.OrderService) *OrderHandler {
-	return &OrderHandler{OrderSvc: svc}
+func NewOrderHandler(svc *service.OrderService, proofSvc *service.DeliveryProofService) *OrderHandler {
+	return &OrderHandler{OrderSvc: svc, ProofSvc: proofSvc}
 }
 
 // 注文履歴一覧を取得
@@ -31,41 +48,497 @@ func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// デフォルト値の設定
-	if req.Page <= 0 {
-		req.Page = 1
+	if err := normalizeListRequest(&req, orderListSpec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	orders, total, hasNext, err := h.OrderSvc.FetchOrders(r.Context(), userID, req)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownCategory) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to fetch orders for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
+		return
+	}
+
+	// 総件数を数えていない場合は、続きがあれば1件多い件数として継続カーソルを決める
+	known := total
+	if known < 0 {
+		known = req.Offset + len(orders)
+		if hasNext {
+			known++
+		}
+	}
+	data, n, nextCursor, err := budgetListRows(w, r, orders, req.Fields, req.Offset, known)
+	if err != nil {
+		log.Printf("Failed to shape orders for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
+		return
+	}
+
+	var facets []model.CategoryFacet
+	if req.WithFacets {
+		if facets, err = h.OrderSvc.CategoryFacets(r.Context(), userID, req); err != nil {
+			log.Printf("Failed to count order categories for user %d: %v", userID, err)
+			http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := struct {
+		Data       interface{}           `json:"data"`
+		Total      int                   `json:"total"`
+		HasNext    bool                  `json:"has_next"`
+		NextCursor string                `json:"next_cursor,omitempty"`
+		Facets     []model.CategoryFacet `json:"facets,omitempty"`
+	}{
+		Data:       data,
+		Total:      total,
+		HasNext:    hasNext || n < len(orders),
+		NextCursor: nextCursor,
+		Facets:     facets,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CSVの書き出しで、この件数ごとにクライアントへ送る
+const exportFlushRows = 500
+
+// 注文履歴をCSVで書き出す。一覧と同じ検索・並び順・絞り込みをクエリ文字列で指定でき、ページングせず全件を返す
+// 履歴が大きくてもメモリに溜めないよう、読みながら一定件数ごとに送る。Accept-Encodingにgzipがあれば圧縮する
+func (h *OrderHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
 	}
-	if req.PageSize <= 0 {
-		req.PageSize = 20
+
+	if format := r.URL.Query().Get("format"); format != "" && format != "csv" {
+		http.Error(w, "Query parameter 'format' must be csv", http.StatusBadRequest)
+		return
 	}
-	allowedSortFields := map[string]string{
-		"order_id":       "o.order_id",
-		"product_name":   "p.name",
-		"created_at":     "o.created_at",
-		"shipped_status": "o.shipped_status",
-		"arrived_at":     "o.arrived_at",
+	req, err := listRequestFromQuery(r.URL.Query(), orderListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	sanitizeListRequest(&req, allowedSortFields, "o.order_id", "desc")
-	if req.Type != "" && req.Type != "partial" && req.Type != "prefix" {
-		req.Type = "partial"
+
+	// 最初の行を読むまではヘッダーを送らず、読み込みの失敗をエラー応答として返せるようにする
+	var (
+		out     io.Writer
+		gz      *gzip.Writer
+		cw      *csv.Writer
+		rows    int
+		control = http.NewResponseController(w)
+	)
+	start := func() error {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="orders.csv"`)
+		w.Header().Add("Vary", "Accept-Encoding")
+		out = w
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz = gzip.NewWriter(w)
+			out = gz
+		}
+		w.WriteHeader(http.StatusOK)
+		cw = csv.NewWriter(out)
+		return cw.Write(orderCSVHeader)
 	}
-	if req.Type == "" {
-		req.Type = "partial"
+	flush := func() error {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		if gz != nil {
+			if err := gz.Flush(); err != nil {
+				return err
+			}
+		}
+		// 途中で送れなくても、最後にまとめて送られるだけなので続ける
+		if err := control.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
 	}
 
-	orders, total, err := h.OrderSvc.FetchOrders(r.Context(), userID, req)
+	err = h.OrderSvc.ExportOrders(r.Context(), userID, req, func(order model.Order) error {
+		if cw == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := cw.Write(orderCSVRecord(order)); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			return flush()
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Failed to fetch orders for user %d: %v", userID, err)
-		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
+		if cw == nil && errors.Is(err, service.ErrUnknownCategory) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to export orders for user %d after %d rows: %v", userID, rows, err)
+		if cw == nil {
+			http.Error(w, "Failed to export orders", http.StatusInternalServerError)
+			return
+		}
+		// 送り始めた後は応答を変えられないため、途中で打ち切る
+		if gz != nil {
+			gz.Close()
+		}
+		return
+	}
+	if cw == nil {
+		if err := start(); err != nil {
+			log.Printf("Failed to export orders for user %d: %v", userID, err)
+			return
+		}
+	}
+	if err := flush(); err != nil {
+		log.Printf("Failed to export orders for user %d: %v", userID, err)
+		return
+	}
+	if gz != nil {
+		gz.Close()
+	}
+}
+
+var orderCSVHeader = []string{
+	"order_id", "product_id", "product_name", "shipped_status", "priority", "weight", "value",
+	"created_at", "arrived_at", "deliver_by", "cancelled_at",
+}
+
+func orderCSVRecord(o model.Order) []string {
+	nullTime := func(t sql.NullTime) string {
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Format(time.RFC3339)
+	}
+	return []string{
+		strconv.FormatInt(o.OrderID, 10),
+		strconv.Itoa(o.ProductID),
+		o.ProductName,
+		o.ShippedStatus,
+		strconv.Itoa(o.Priority),
+		strconv.Itoa(int(o.Weight)),
+		strconv.Itoa(int(o.Value)),
+		o.CreatedAt.Format(time.RFC3339),
+		nullTime(o.ArrivedAt),
+		nullTime(o.DeliverBy),
+		nullTime(o.CancelledAt),
+	}
+}
+
+// acceptsGzip はクライアントがgzipで圧縮した応答を受け付けるか判定する
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+			if strings.EqualFold(strings.TrimSpace(coding), "gzip") && strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 注文詳細とイベント履歴、配達証明を取得。他のユーザーの注文は403を返す
+func (h *OrderHandler) Detail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || orderID <= 0 {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	order, events, err := h.OrderSvc.GetOrderDetail(r.Context(), userID, orderID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrOrderForbidden):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			log.Printf("Failed to fetch order %d: %v", orderID, err)
+			http.Error(w, "Failed to fetch order", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	proof, err := h.ProofSvc.ForOrder(r.Context(), orderID)
+	if err != nil {
+		log.Printf("Failed to fetch delivery proof for order %d: %v", orderID, err)
+		http.Error(w, "Failed to fetch order", http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		*model.Order
+		Events        []model.OrderEvent   `json:"events"`
+		DeliveryProof *model.DeliveryProof `json:"delivery_proof,omitempty"`
+	}{
+		Order:         order,
+		Events:        events,
+		DeliveryProof: proof,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 注文のステータス変更の履歴を取得。配送が進まない注文の調査に使う
+func (h *OrderHandler) Events(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || orderID <= 0 {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
 		return
 	}
 
+	events, err := h.OrderSvc.OrderEvents(r.Context(), userID, orderID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrOrderForbidden):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			log.Printf("Failed to fetch events for order %d: %v", orderID, err)
+			http.Error(w, "Failed to fetch order events", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := struct {
+		OrderID int64              `json:"order_id"`
+		Events  []model.OrderEvent `json:"events"`
+	}{
+		OrderID: orderID,
+		Events:  events,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ユーザーの注文のステータス変更をServer-Sent Eventsで配信する
+// 変更ごとにstatusイベントを送る。接続が切れた場合は一覧を取り直してから再接続すること
+func (h *OrderHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	events, cancel, err := h.OrderSvc.StreamStatuses(userID)
+	if err != nil {
+		if errors.Is(err, service.ErrTooManyStreams) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many order streams", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Failed to open order stream: %v", err)
+		http.Error(w, "Failed to open order stream", http.StatusInternalServerError)
+		return
+	}
+	defer cancel()
+
+	control := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, "retry: 3000\n\n")
+	if err := control.Flush(); err != nil {
+		log.Printf("Order stream is not supported by the response writer: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("Failed to encode order stream event: %v", err)
+				return
+			}
+			if _, err := io.WriteString(w, "event: status\ndata: "+string(data)+"\n\n"); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		if err := control.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// 注文ステータスを取得（wait指定時はステータス変更までロングポーリング）
+func (h *OrderHandler) Status(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || orderID <= 0 {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	var wait time.Duration
+	if waitStr := r.URL.Query().Get("wait"); waitStr != "" {
+		wait, err = time.ParseDuration(waitStr)
+		if err != nil || wait < 0 {
+			http.Error(w, "Query parameter 'wait' must be a duration such as 30s", http.StatusBadRequest)
+			return
+		}
+		if wait > maxStatusWait {
+			wait = maxStatusWait
+		}
+	}
+
+	status, changed, err := h.OrderSvc.WaitForStatus(r.Context(), userID, orderID, r.URL.Query().Get("status"), wait)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrTooManyWaiters):
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many status waiters", http.StatusServiceUnavailable)
+		default:
+			log.Printf("Failed to fetch status for order %d: %v", orderID, err)
+			http.Error(w, "Failed to fetch order status", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := struct {
+		OrderID       int64  `json:"order_id"`
+		ShippedStatus string `json:"shipped_status"`
+		Changed       bool   `json:"changed"`
+	}{
+		OrderID:       orderID,
+		ShippedStatus: status,
+		Changed:       changed,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 配送待ちの注文を取り消す。ロボットが引き受けた後や配送完了後の注文は409を返す
+func (h *OrderHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || orderID <= 0 {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	cancelledAt, err := h.OrderSvc.CancelOrder(r.Context(), userID, orderID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrOrderNotCancellable):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Failed to cancel order %d: %v", orderID, err)
+			http.Error(w, "Failed to cancel order", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := struct {
+		OrderID       int64     `json:"order_id"`
+		ShippedStatus string    `json:"shipped_status"`
+		CancelledAt   time.Time `json:"cancelled_at"`
+	}{
+		OrderID:       orderID,
+		ShippedStatus: "cancelled",
+		CancelledAt:   cancelledAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 複数の注文をまとめて取り消す。取り消せない注文は理由を返し、残りの注文だけを取り消す
+func (h *OrderHandler) CancelMany(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.CancelOrdersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	results, cancelledAt, err := h.OrderSvc.CancelOrders(r.Context(), userID, req.OrderIDs)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCancelRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to cancel %d orders for user %d: %v", len(req.OrderIDs), userID, err)
+		http.Error(w, "Failed to cancel orders", http.StatusInternalServerError)
+		return
+	}
+
+	cancelled := 0
+	for _, result := range results {
+		if result.Result == model.CancelResultCancelled {
+			cancelled++
+		}
+	}
 	resp := struct {
-		Data  []model.Order `json:"data"`
-		Total int           `json:"total"`
+		Cancelled   int                       `json:"cancelled"`
+		CancelledAt time.Time                 `json:"cancelled_at"`
+		Results     []model.CancelOrderResult `json:"results"`
 	}{
-		Data:  orders,
-		Total: total,
+		Cancelled:   cancelled,
+		CancelledAt: cancelledAt,
+		Results:     results,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
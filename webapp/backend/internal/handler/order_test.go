@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestOrderCSVRecord(t *testing.T) {
+	created := time.Date(2025, 9, 1, 10, 0, 0, 0, time.UTC)
+	order := model.Order{
+		OrderID: 7, ProductID: 3, ProductName: "chello, large", ShippedStatus: "completed",
+		Weight: 1200, Value: 40, CreatedAt: created,
+		ArrivedAt: sql.NullTime{Time: created.Add(time.Hour), Valid: true},
+	}
+	want := []string{"7", "3", "chello, large", "completed", "0", "1200", "40", "2025-09-01T10:00:00Z", "2025-09-01T11:00:00Z", "", ""}
+	if got := orderCSVRecord(order); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected record:\n got %q\nwant %q", got, want)
+	}
+	if len(orderCSVHeader) != len(want) {
+		t.Fatalf("header has %d columns, records have %d", len(orderCSVHeader), len(want))
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, GZIP;q=1": true,
+		"br, gzip;q=0":      false,
+		"identity":          false,
+	} {
+		r := httptest.NewRequest("GET", "/api/orders/export", nil)
+		if header != "" {
+			r.Header.Set("Accept-Encoding", header)
+		}
+		if got := acceptsGzip(r); got != want {
+			t.Fatalf("Accept-Encoding %q: expected %v, got %v", header, want, got)
+		}
+	}
+}
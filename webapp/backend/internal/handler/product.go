@@ -5,22 +5,34 @@ import (
 	"backend/internal/model"
 	"backend/internal/service"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type ProductHandler struct {
-	ProductSvc *service.ProductService
+	ProductSvc   *service.ProductService
+	ThumbnailSvc *service.ThumbnailService
+	ImageSvc     *service.ProductImageService
 }
 
-func NewProductHandler(svc *service.ProductService) *ProductHandler {
-	return &ProductHandler{ProductSvc: svc}
+func NewProductHandler(svc *service.ProductService, thumbnailSvc *service.ThumbnailService, imageSvc *service.ProductImageService) *ProductHandler {
+	return &ProductHandler{ProductSvc: svc, ThumbnailSvc: thumbnailSvc, ImageSvc: imageSvc}
 }
 
+// 画像はパスごとに内容が変わらないため、ブラウザ・中間キャッシュに長めに保持させる
+const imageCacheControl = "public, max-age=86400"
+
+// 商品IDごとの画像は商品の編集で差し替わるため短めに保持させ、以降はETagで再検証させる
+const productImageCacheControl = "public, max-age=3600"
+
 // 商品一覧を取得
 func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.GetUserFromContext(r.Context())
@@ -35,42 +47,187 @@ func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Page <= 0 {
-		req.Page = 1
-	}
-	if req.PageSize <= 0 {
-		req.PageSize = 20
-	}
-	allowedSortFields := map[string]string{
-		"product_id":  "product_id",
-		"name":        "name",
-		"value":       "value",
-		"weight":      "weight",
-		"image":       "image",
-		"description": "description",
+	if err := normalizeListRequest(&req, productListSpec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	sanitizeListRequest(&req, allowedSortFields, "product_id", "asc")
-	req.Offset = (req.Page - 1) * req.PageSize
 
-	products, total, err := h.ProductSvc.FetchProducts(r.Context(), userID, req)
+	products, total, partial, err := h.ProductSvc.FetchProducts(r.Context(), userID, req)
 	if err != nil {
+		if errors.Is(err, service.ErrUnknownCategory) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		log.Printf("Failed to fetch products for user %d: %v", userID, err)
 		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
 		return
 	}
 
+	data, n, nextCursor, err := budgetListRows(w, r, products, req.Fields, req.Offset, total)
+	if err != nil {
+		log.Printf("Failed to shape products for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		return
+	}
+	// キーセットで読める並び順では、続きがあれば常に最後に返した商品の位置をカーソルにする
+	// 総件数はカーソルより前の商品も数えるため、ページが埋まったかで続きの有無を判定する
+	if productListSpec.keysetSortable(req) {
+		nextCursor = ""
+		if n > 0 && (n < len(products) || len(products) == req.PageSize) {
+			nextCursor = encodeKeysetCursor(req.SortField, req.SortOrder, productKeyset(products[n-1], req.SortField))
+		}
+	}
+
+	var facets []model.CategoryFacet
+	if req.WithFacets {
+		if facets, err = h.ProductSvc.CategoryFacets(r.Context(), userID, req); err != nil {
+			log.Printf("Failed to count product categories for user %d: %v", userID, err)
+			http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	resp := struct {
-		Data  []model.Product `json:"data"`
-		Total int             `json:"total"`
+		Data       interface{}           `json:"data"`
+		Total      int                   `json:"total"`
+		NextCursor string                `json:"next_cursor,omitempty"`
+		Partial    bool                  `json:"partial,omitempty"`
+		Facets     []model.CategoryFacet `json:"facets,omitempty"`
 	}{
-		Data:  products,
-		Total: total,
+		Data:       data,
+		Facets:     facets,
+		Total:      total,
+		NextCursor: nextCursor,
+		Partial:    partial,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// カテゴリの一覧を取得。サイドバーの階層はparent_idでたどる
+func (h *ProductHandler) Categories(w http.ResponseWriter, r *http.Request) {
+	categories, err := h.ProductSvc.ListCategories(r.Context())
+	if err != nil {
+		log.Printf("Failed to list categories: %v", err)
+		http.Error(w, "Failed to list categories", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": categories})
+}
+
+// productKeyset は商品の並び替えの列の値と商品IDを、キーセットのカーソルの位置にする
+func productKeyset(p model.Product, field string) model.ListKeyset {
+	value := strconv.Itoa(p.ProductID)
+	switch field {
+	case "name":
+		value = p.Name
+	case "value":
+		value = strconv.Itoa(int(p.Value))
+	case "weight":
+		value = strconv.Itoa(int(p.Weight))
+	case "popularity":
+		value = strconv.Itoa(p.Popularity)
+	}
+	return model.ListKeyset{Value: value, ID: int64(p.ProductID)}
+}
+
+// 商品と、その商品の注文の集計を返す
+func (h *ProductHandler) Detail(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	detail, err := h.ProductSvc.GetProductDetail(r.Context(), productID)
+	if err != nil {
+		if errors.Is(err, service.ErrProductNotFound) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get product %d: %v", productID, err)
+		http.Error(w, "Failed to get product", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// 商品をお気に入りに加える
+func (h *ProductHandler) AddFavorite(w http.ResponseWriter, r *http.Request) {
+	h.setFavorite(w, r, true)
+}
+
+// 商品をお気に入りから外す
+func (h *ProductHandler) RemoveFavorite(w http.ResponseWriter, r *http.Request) {
+	h.setFavorite(w, r, false)
+}
+
+func (h *ProductHandler) setFavorite(w http.ResponseWriter, r *http.Request, favorite bool) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	if favorite {
+		err = h.ProductSvc.AddFavorite(r.Context(), userID, productID)
+	} else {
+		err = h.ProductSvc.RemoveFavorite(r.Context(), userID, productID)
+	}
+	if err != nil {
+		if errors.Is(err, service.ErrProductNotFound) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to update favorite product %d for user %d: %v", productID, userID, err)
+		http.Error(w, "Failed to update favorite", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 最近注文した商品と一緒に注文されることの多い商品を返す
+func (h *ProductHandler) Recommended(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "limit must be an integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	products, err := h.ProductSvc.Recommend(r.Context(), userID, limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRecommendationRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to recommend products: %v", err)
+		http.Error(w, "Failed to recommend products", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": products})
+}
+
 // 注文を作成
 func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.GetUserFromContext(r.Context())
@@ -87,6 +244,14 @@ func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 
 	insertedOrderIDs, err := h.ProductSvc.CreateOrders(r.Context(), userID, req.Items)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidOrderPriority) || errors.Is(err, service.ErrInvalidOrderDeliverBy) || errors.Is(err, service.ErrInvalidOrderProduct) || errors.Is(err, service.ErrInvalidOrderMetadata) || errors.Is(err, service.ErrInvalidOrderShipAfter) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, service.ErrInsufficientStock) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		log.Printf("Failed to create orders: %v", err)
 		http.Error(w, "Failed to process order request", http.StatusInternalServerError)
 		return
@@ -117,6 +282,21 @@ func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// サイズ指定があれば事前生成済みのサムネイルを返す（未生成なら原寸にフォールバック）
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" && h.ThumbnailSvc != nil {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 {
+			http.Error(w, "無効なサイズです", http.StatusBadRequest)
+			return
+		}
+		if data, contentType, err := h.ThumbnailSvc.Get(imagePath, size); err == nil {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Cache-Control", imageCacheControl)
+			w.Write(data)
+			return
+		}
+	}
+
 	baseImageDir := "/app/images"
 	fullPath := filepath.Join(baseImageDir, imagePath)
 
@@ -141,6 +321,7 @@ func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 		contentType = "application/octet-stream"
 	}
 	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", imageCacheControl)
 
 	data, err := os.ReadFile(fullPath)
 	if err != nil {
@@ -151,3 +332,46 @@ func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(data)
 }
+
+// 商品画像を指定サイズに縮小して返す
+func (h *ProductHandler) ProductImage(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+	var size [2]int
+	for i, key := range []string{"w", "h"} {
+		raw := r.URL.Query().Get(key)
+		if raw == "" {
+			continue
+		}
+		if size[i], err = strconv.Atoi(raw); err != nil {
+			http.Error(w, "無効なサイズです", http.StatusBadRequest)
+			return
+		}
+	}
+
+	img, err := h.ImageSvc.Get(r.Context(), productID, size[0], size[1])
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidImageSize):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, service.ErrProductImageNotFound):
+			http.Error(w, "画像が見つかりません", http.StatusNotFound)
+		default:
+			log.Printf("Failed to serve image for product %d: %v", productID, err)
+			http.Error(w, "画像の読み込みに失敗しました", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", productImageCacheControl)
+	w.Header().Set("ETag", img.ETag)
+	if r.Header.Get("If-None-Match") == img.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Write(img.Data)
+}
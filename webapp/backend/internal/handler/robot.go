@@ -1,41 +1,284 @@
 package handler
 
 import (
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+
+	"github.com/go-chi/chi/v5"
 )
 
+const maxPlanChunkSize = 1000
+
 type RobotHandler struct {
 	RobotSvc *service.RobotService
+	ProofSvc *service.DeliveryProofService
+}
+
+func NewRobotHandler(robotSvc *service.RobotService, proofSvc *service.DeliveryProofService) *RobotHandler {
+	return &RobotHandler{RobotSvc: robotSvc, ProofSvc: proofSvc}
 }
 
-func NewRobotHandler(robotSvc *service.RobotService) *RobotHandler {
-	return &RobotHandler{RobotSvc: robotSvc}
+// requireRobotID はリクエストを送ったロボットのIDを返す
+// ロボットIDは認証で確かめたもの（ロボットに紐づいたAPIキー）だけを使い、クライアントが送るヘッダーは信用しない
+// ロボットとして認証されていなければ403を書き出してfalseを返す
+func requireRobotID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	robotID, ok := middleware.GetRobotFromContext(r.Context())
+	if !ok || robotID == "" {
+		http.Error(w, "Forbidden: Request is not authenticated as a robot", http.StatusForbidden)
+		return "", false
+	}
+	return robotID, true
 }
 
 // 配送計画を取得
 func (h *RobotHandler) GetDeliveryPlan(w http.ResponseWriter, r *http.Request) {
-	robotID := "robot-001"
+	robotID, ok := requireRobotID(w, r)
+	if !ok {
+		return
+	}
+	spec, err := parseRobotSpec(r, robotID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// chunk_size指定時は計画を分割し、先頭のチャンクのみ返す
+	chunkSize, err := parseChunkSize(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var plan *model.DeliveryPlan
+	if chunkSize > 0 {
+		plan, err = h.RobotSvc.GenerateChunkedDeliveryPlan(r.Context(), spec, chunkSize)
+	} else {
+		plan, err = h.RobotSvc.GenerateDeliveryPlan(r.Context(), spec)
+	}
+	if err != nil {
+		if writeRobotError(w, err) {
+			return
+		}
+		log.Printf("Failed to generate delivery plan: %v", err)
+		http.Error(w, "Failed to create delivery plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// 配送計画の候補を取得（注文の引き当ては行わない）
+// ロボットのシミュレーターが計画を確定する前に確認するために使う
+func (h *RobotHandler) PreviewDeliveryPlan(w http.ResponseWriter, r *http.Request) {
+	robotID, ok := requireRobotID(w, r)
+	if !ok {
+		return
+	}
+	spec, err := parseRobotSpec(r, robotID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plan, err := h.RobotSvc.PreviewDeliveryPlan(r.Context(), spec)
+	if err != nil {
+		if writeRobotError(w, err) {
+			return
+		}
+		log.Printf("Failed to preview delivery plan: %v", err)
+		http.Error(w, "Failed to preview delivery plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// 配送中のロボットの残りの積載量に、前回の計画の後に届いた注文を追加で引き当てる
+// capacityには残りの積載量を指定する
+func (h *RobotHandler) AmendDeliveryPlan(w http.ResponseWriter, r *http.Request) {
+	robotID, ok := requireRobotID(w, r)
+	if !ok {
+		return
+	}
+	spec, err := parseRobotSpec(r, robotID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	plan, err := h.RobotSvc.AmendPlan(r.Context(), spec)
+	if err != nil {
+		if writeRobotError(w, err) {
+			return
+		}
+		log.Printf("Failed to amend delivery plan: %v", err)
+		http.Error(w, "Failed to amend delivery plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// writeRobotError は未登録・停止中のロボットのエラーを応答する。該当しなければfalseを返す
+func writeRobotError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrRobotNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrRobotInactive), errors.Is(err, service.ErrRobotSilent):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		return false
+	}
+	return true
+}
+
+// parseRobotSpec はクエリパラメータからrobotIDのロボットの計画の指定を読み取る
+func parseRobotSpec(r *http.Request, robotID string) (model.RobotSpec, error) {
 	capacityStr := r.URL.Query().Get("capacity")
 	if capacityStr == "" {
-		http.Error(w, "Query parameter 'capacity' is required", http.StatusBadRequest)
+		return model.RobotSpec{}, errors.New("Query parameter 'capacity' is required")
+	}
+	// 積載量はグラム単位
+	capacity, err := model.ParseGrams(capacityStr)
+	if err != nil {
+		return model.RobotSpec{}, errors.New("Query parameter 'capacity' must be an integer")
+	}
+
+	// 容積の上限（立方センチメートル）。未指定なら重量のみで選ぶ
+	spec := model.RobotSpec{RobotID: robotID, Capacity: capacity}
+	if raw := r.URL.Query().Get("volume_capacity"); raw != "" {
+		spec.VolumeCapacity, err = model.ParseCubicCentimeters(raw)
+		if err != nil || spec.VolumeCapacity < 0 {
+			return model.RobotSpec{}, errors.New("Query parameter 'volume_capacity' must be a non-negative integer")
+		}
+	}
+	return spec, nil
+}
+
+// 複数のロボットの配送計画をまとめて取得
+// 同じ注文が複数のロボットに割り当てられないよう、1つのトランザクションで注文を振り分ける
+func (h *RobotHandler) GetDeliveryPlans(w http.ResponseWriter, r *http.Request) {
+	var req model.BatchDeliveryPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	capacity, err := strconv.Atoi(capacityStr)
+
+	plans, err := h.RobotSvc.GenerateDeliveryPlans(r.Context(), req.Robots)
 	if err != nil {
-		http.Error(w, "Query parameter 'capacity' must be an integer", http.StatusBadRequest)
+		if errors.Is(err, service.ErrInvalidRobotBatch) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if writeRobotError(w, err) {
+			return
+		}
+		log.Printf("Failed to generate delivery plans: %v", err)
+		http.Error(w, "Failed to create delivery plans", http.StatusInternalServerError)
 		return
 	}
 
-	plan, err := h.RobotSvc.GenerateDeliveryPlan(r.Context(), robotID, capacity)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.BatchDeliveryPlanResponse{Plans: plans})
+}
+
+// 配送計画の履歴を取得（どの解法がどの注文を割り当てたかの監査用）
+func (h *RobotHandler) ListPlans(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(q.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	records, err := h.RobotSvc.ListPlans(r.Context(), q.Get("robot_id"), limit, offset)
 	if err != nil {
-		log.Printf("Failed to generate delivery plan: %v", err)
-		http.Error(w, "Failed to create delivery plan", http.StatusInternalServerError)
+		log.Printf("Failed to list delivery plans: %v", err)
+		http.Error(w, "Failed to list delivery plans", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": records})
+}
+
+// ロボットの生存を通知する。一度通知したロボットは、通知が途絶えると計画を生成せず、引き受けた注文を配送待ちに戻す
+func (h *RobotHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	robotID, ok := requireRobotID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.RobotSvc.Heartbeat(r.Context(), robotID); err != nil {
+		if writeRobotError(w, err) {
+			return
+		}
+		log.Printf("Failed to record heartbeat for %s: %v", robotID, err)
+		http.Error(w, "Failed to record heartbeat", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ロボットが引き受けたまま配送中の注文を配送待ちに戻す
+// ロボットが故障して計画を続けられなくなった場合に使う
+func (h *RobotHandler) ReleasePlan(w http.ResponseWriter, r *http.Request) {
+	robotID, ok := requireRobotID(w, r)
+	if !ok {
+		return
+	}
+
+	released, err := h.RobotSvc.ReleasePlan(r.Context(), robotID)
+	if err != nil {
+		log.Printf("Failed to release delivery plan for %s: %v", robotID, err)
+		http.Error(w, "Failed to release delivery plan", http.StatusInternalServerError)
+		return
+	}
+	if released == nil {
+		released = []int64{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.ReleasePlanResponse{RobotID: robotID, OrderIDs: released})
+}
+
+// 分割された配送計画の続きを取得
+func (h *RobotHandler) GetDeliveryPlanChunk(w http.ResponseWriter, r *http.Request) {
+	chunkSize, err := parseChunkSize(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if chunkSize == 0 {
+		http.Error(w, "Query parameter 'chunk_size' is required", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := h.RobotSvc.PlanChunk(chi.URLParam(r, "planID"), r.URL.Query().Get("cursor"), chunkSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPlanNotFound):
+			http.Error(w, "Plan not found or expired", http.StatusNotFound)
+		case errors.Is(err, service.ErrInvalidCursor):
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to fetch delivery plan", http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -43,16 +286,47 @@ func (h *RobotHandler) GetDeliveryPlan(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(plan)
 }
 
+// ロボットのメモリに収まる件数で計画を分割するためのチャンクサイズ。未指定なら0
+func parseChunkSize(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("chunk_size")
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 || n > maxPlanChunkSize {
+		return 0, fmt.Errorf("Query parameter 'chunk_size' must be an integer between 1 and %d", maxPlanChunkSize)
+	}
+	return n, nil
+}
+
 // 配送完了時に注文ステータスを更新
+// 本文が配列なら、すべての注文を1つのトランザクションでまとめて更新する
 func (h *RobotHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	robotID, ok := requireRobotID(w, r)
+	if !ok {
+		return
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		h.updateOrderStatuses(w, r, robotID, trimmed)
+		return
+	}
+
 	var req model.UpdateOrderStatusRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	err := h.RobotSvc.UpdateOrderStatus(r.Context(), req.OrderID, req.NewStatus)
+	err := h.RobotSvc.UpdateOrderStatus(r.Context(), robotID, req.OrderID, req.NewStatus)
 	if err != nil {
+		if writeStatusUpdateError(w, err) {
+			return
+		}
 		log.Printf("Failed to update order status for order %d: %v", req.OrderID, err)
 		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
 		return
@@ -61,3 +335,122 @@ func (h *RobotHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Order status updated"))
 }
+
+// writeStatusUpdateError はステータス更新の失敗のうちクライアントに原因があるものを書き出し、書き出したかどうかを返す
+func writeStatusUpdateError(w http.ResponseWriter, err error) bool {
+	var transition *service.StatusTransitionError
+	switch {
+	case errors.Is(err, service.ErrInvalidStatusUpdate):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrOrderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrOrderNotAssigned):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.As(err, &transition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *RobotHandler) updateOrderStatuses(w http.ResponseWriter, r *http.Request, robotID string, body []byte) {
+	var updates []model.UpdateOrderStatusRequest
+	if err := json.Unmarshal(body, &updates); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.RobotSvc.UpdateOrderStatuses(r.Context(), robotID, updates); err != nil {
+		if writeStatusUpdateError(w, err) {
+			return
+		}
+		log.Printf("Failed to update order statuses for %d orders: %v", len(updates), err)
+		http.Error(w, "Failed to update order statuses", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Updated int `json:"updated"`
+	}{Updated: len(updates)})
+}
+
+// 配送中の注文の配送失敗を報告する。注文は配送待ちに戻り、失敗が上限を超えた注文は配送失敗になる
+func (h *RobotHandler) FailDelivery(w http.ResponseWriter, r *http.Request) {
+	robotID, ok := requireRobotID(w, r)
+	if !ok {
+		return
+	}
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || orderID <= 0 {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	var req model.DeliveryFailureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.RobotSvc.FailDelivery(r.Context(), robotID, orderID, req.Reason)
+	if err != nil {
+		if writeStatusUpdateError(w, err) {
+			return
+		}
+		log.Printf("Failed to record delivery failure for order %d: %v", orderID, err)
+		http.Error(w, "Failed to record delivery failure", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 配送完了した注文に配達証明（写真・署名の画像）を添付
+// リクエストボディは画像のバイナリそのもの
+func (h *RobotHandler) AttachDeliveryProof(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || orderID <= 0 {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.ProofSvc.MaxBytes()))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Delivery proof is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 {
+		http.Error(w, "Request body is empty", http.StatusBadRequest)
+		return
+	}
+
+	proof, err := h.ProofSvc.Attach(r.Context(), orderID, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, service.ErrOrderNotCompleted):
+			http.Error(w, "Order is not completed", http.StatusConflict)
+		case errors.Is(err, service.ErrProofTooLarge):
+			http.Error(w, "Delivery proof is too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, service.ErrUnsupportedProofType):
+			http.Error(w, "Delivery proof must be a JPEG, PNG or WebP image", http.StatusUnsupportedMediaType)
+		default:
+			log.Printf("Failed to attach delivery proof for order %d: %v", orderID, err)
+			http.Error(w, "Failed to attach delivery proof", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(proof)
+}
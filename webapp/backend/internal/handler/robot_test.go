@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/internal/middleware"
+)
+
+func TestRequireRobotIDIgnoresClientHeader(t *testing.T) {
+	tests := []struct {
+		legacyRobotID string
+		wantStatus    int
+		wantRobotID   string
+	}{
+		// 共通のキーをロボットに紐づけていなければ、X-ROBOT-IDを送ってもロボットとして扱わない
+		{"", http.StatusForbidden, ""},
+		// 紐づけていれば、X-ROBOT-IDによらず紐づけたロボットとする
+		{"robot-001", http.StatusOK, "robot-001"},
+	}
+	for _, tt := range tests {
+		var got string
+		h := middleware.APIKeyAuthMiddleware(nil, "legacy-key", tt.legacyRobotID)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if robotID, ok := requireRobotID(w, r); ok {
+				got = robotID
+			}
+		}))
+		r := httptest.NewRequest("POST", "/api/robot/orders/status", nil)
+		r.Header.Set("X-API-Key", "legacy-key")
+		r.Header.Set("X-ROBOT-ID", "robot-002")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus || got != tt.wantRobotID {
+			t.Errorf("legacy robot %q: got status %d robot %q, want %d %q", tt.legacyRobotID, w.Code, got, tt.wantStatus, tt.wantRobotID)
+		}
+	}
+}
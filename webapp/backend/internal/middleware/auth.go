@@ -2,15 +2,25 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"log"
 	"net/http"
+	"slices"
 
+	"backend/internal/model"
 	"backend/internal/repository"
+	"backend/internal/service"
+	"backend/internal/telemetry"
 )
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey  contextKey = "user"
+	roleContextKey  contextKey = "role"
+	robotContextKey contextKey = "robot"
+)
 
 func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -23,28 +33,151 @@ func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Han
 			}
 			sessionID := cookie.Value
 
-			userID, err := sessionRepo.FindUserBySessionID(r.Context(), sessionID)
+			telemetry.SetPhase(r.Context(), telemetry.PhaseAuth)
+			user, err := sessionRepo.FindSession(r.Context(), sessionID)
 			if err != nil {
 				log.Printf("Error finding user by session ID: %v", err)
 				http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), userContextKey, userID)
+			// 有効期限を延長するセッションはCookieの有効期限も延ばす
+			if expiresAt, ok := sessionRepo.PendingExpiry(sessionID); ok {
+				http.SetCookie(w, &http.Cookie{
+					Name:     "session_id",
+					Value:    sessionID,
+					Expires:  expiresAt,
+					HttpOnly: true,
+					Path:     "/",
+				})
+			}
+
+			telemetry.SetUser(r.Context(), user.UserID)
+			telemetry.SetPhase(r.Context(), telemetry.PhaseHandler)
+			ctx := context.WithValue(r.Context(), userContextKey, user.UserID)
+			ctx = context.WithValue(ctx, roleContextKey, user.Role)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func RobotAuthMiddleware(validAPIKey string) func(http.Handler) http.Handler {
+// RequireRole はセッションのユーザーの役割がrolesのいずれかでなければ403を返す
+// UserAuthMiddlewareの後に使うこと
+func RequireRole(roles ...model.Role) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			apiKey := r.Header.Get("X-API-KEY")
+			role, _ := GetRoleFromContext(r.Context())
+			if !slices.Contains(roles, role) {
+				http.Error(w, "Forbidden: Insufficient role", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
 
-			if apiKey == "" || apiKey != validAPIKey {
+// RequirePermission はセッションのユーザーの役割にpermissionがなければ403を返す
+// UserAuthMiddlewareの後に使うこと
+func RequirePermission(permission model.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := GetRoleFromContext(r.Context())
+			if !role.Can(permission) {
+				http.Error(w, "Forbidden: Insufficient permission", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// KeyOrSessionAuth はkeyHeaderのヘッダーがあればkeyAuthで、なければセッションのCookieで認証する
+// セッションで認証したときは、続けてrequireで役割を確かめる。どちらもなければkeyAuthのエラーを返す
+func KeyOrSessionAuth(keyHeader string, keyAuth, sessionAuth, require func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		byKey := keyAuth(next)
+		bySession := sessionAuth(require(next))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(keyHeader) == "" {
+				if _, err := r.Cookie("session_id"); err == nil {
+					bySession.ServeHTTP(w, r)
+					return
+				}
+			}
+			byKey.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIKeyAuthenticator はAPIキーを検証する
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*model.APIKey, error)
+}
+
+// APIKeyAuthMiddleware はX-API-KeyのAPIキーでロボットを認証する
+// ロボットに紐づいたキーなら、そのロボットIDをコンテキストに入れる
+// legacyKeyはAPIキーを発行する前から使っている共通のキーで、空なら受け付けない
+// legacyRobotIDは共通のキーを紐づけるロボットのIDで、空なら共通のキーのリクエストはどのロボットでもない
+func APIKeyAuthMiddleware(keys APIKeyAuthenticator, legacyKey, legacyRobotID string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := r.Header.Get("X-API-Key")
+			if apiKey == "" {
+				http.Error(w, "Forbidden: Invalid or missing API key", http.StatusForbidden)
+				return
+			}
+			if legacyKey != "" && subtle.ConstantTimeCompare([]byte(apiKey), []byte(legacyKey)) == 1 {
+				ctx := r.Context()
+				if legacyRobotID != "" {
+					ctx = context.WithValue(ctx, robotContextKey, legacyRobotID)
+				}
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			key, err := keys.Authenticate(r.Context(), apiKey)
+			if err != nil {
+				if !errors.Is(err, service.ErrInvalidAPIKey) {
+					log.Printf("Error authenticating API key: %v", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
 				http.Error(w, "Forbidden: Invalid or missing API key", http.StatusForbidden)
 				return
 			}
+			ctx := r.Context()
+			if key.RobotID != nil {
+				ctx = context.WithValue(ctx, robotContextKey, *key.RobotID)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func AdminAuthMiddleware(validAPIKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := r.Header.Get("X-ADMIN-KEY")
+
+			if apiKey == "" || apiKey != validAPIKey {
+				http.Error(w, "Forbidden: Invalid or missing admin key", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// InternalAuthMiddleware は倉庫管理システムなど社内の他システム向けのAPIキーを検証する
+func InternalAuthMiddleware(validAPIKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := r.Header.Get("X-INTERNAL-KEY")
+
+			if apiKey == "" || apiKey != validAPIKey {
+				http.Error(w, "Forbidden: Invalid or missing internal key", http.StatusForbidden)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -56,3 +189,15 @@ func GetUserFromContext(ctx context.Context) (int, bool) {
 	userID, ok := ctx.Value(userContextKey).(int)
 	return userID, ok
 }
+
+// コンテキストからユーザーの役割を取得
+func GetRoleFromContext(ctx context.Context) (model.Role, bool) {
+	role, ok := ctx.Value(roleContextKey).(model.Role)
+	return role, ok
+}
+
+// コンテキストからAPIキーに紐づいたロボットIDを取得
+func GetRobotFromContext(ctx context.Context) (string, bool) {
+	robotID, ok := ctx.Value(robotContextKey).(string)
+	return robotID, ok
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"backend/internal/telemetry"
+	"net/http"
+)
+
+// InflightMiddleware は処理中のリクエストをregistryに登録し、処理が終わったら外す
+func InflightMiddleware(registry *telemetry.InflightRegistry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := registry.Begin(r.Method, r.URL.Path)
+			defer registry.End(req)
+			next.ServeHTTP(w, r.WithContext(telemetry.WithInflight(r.Context(), req)))
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"backend/internal/telemetry"
+	"context"
+	"net/http"
+	"time"
+)
+
+type requestMetricsKey struct{}
+
+// リクエスト単位の記録設定。内側のミドルウェアから書き換えられるようポインタでcontextに入れる
+type requestMetrics struct {
+	skipLatency bool
+}
+
+// RequestMetricsMiddleware はリクエストの処理時間と5xxの発生をstatsに記録する
+func RequestMetricsMiddleware(stats *telemetry.RequestStats) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			m := &requestMetrics{}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestMetricsKey{}, m)))
+			stats.Record(time.Since(start), rec.status >= http.StatusInternalServerError, m.skipLatency)
+		})
+	}
+}
+
+// ExcludeFromLatency はロングポーリングなど待つことが仕様のエンドポイントをレイテンシの集計から除く
+func ExcludeFromLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m, ok := r.Context().Value(requestMetricsKey{}).(*requestMetrics); ok {
+			m.skipLatency = true
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap はhttp.ResponseControllerから元のResponseWriterのFlushなどを使えるようにする
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"backend/internal/repository"
+	"context"
+	"net/http"
+	"time"
+)
+
+// PartialResultsMiddleware はリクエストにtimeoutの期限を設け、期限が迫った一覧取得では
+// エラーにせず読み込み済みの行だけを返させる（レスポンスには partial: true が付く）
+// 一部だけでも役に立つ一覧のルートにのみ適用すること
+func PartialResultsMiddleware(timeout, reserve time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(repository.WithPartialResults(ctx, reserve)))
+		})
+	}
+}
@@ -2,6 +2,9 @@ package model
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -9,34 +12,379 @@ type User struct {
 	UserID       int    `db:"user_id"`
 	PasswordHash string `db:"password_hash"`
 	UserName     string `db:"user_name"`
+	Role         Role   `db:"role"`
+}
+
+// SessionUser はセッションのユーザーIDと、ログイン時点のユーザーの役割
+type SessionUser struct {
+	UserID int  `db:"user_id"`
+	Role   Role `db:"role"`
+}
+
+// ClientInfo はセッションを作ったクライアントの情報
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// SessionInfo はユーザーに見せるセッションの情報。セッションIDそのものは返さない
+type SessionInfo struct {
+	ID         int64      `db:"id"           json:"id"`
+	SessionID  string     `db:"session_uuid" json:"-"`
+	UserAgent  *string    `db:"user_agent"   json:"user_agent,omitempty"`
+	IP         *string    `db:"ip"           json:"ip,omitempty"`
+	CreatedAt  *time.Time `db:"created_at"   json:"created_at,omitempty"`
+	LastSeenAt *time.Time `db:"last_seen_at" json:"last_seen_at,omitempty"`
+	ExpiresAt  time.Time  `db:"expires_at"   json:"expires_at"`
+	// リクエストを送ったセッションかどうか
+	Current bool `db:"-" json:"current"`
+}
+
+// Role はユーザーの役割
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+	RoleRobot Role = "robot"
+)
+
+// Permission は役割に与える操作の権限
+type Permission string
+
+const (
+	// 商品の閲覧・注文など購入者向けの操作
+	PermissionShop Permission = "shop"
+	// 配送計画の取得・配送状況の更新などロボット向けの操作
+	PermissionDeliver Permission = "deliver"
+	// 商品やプランナーの設定など管理者向けの操作
+	PermissionManage Permission = "manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser:  {PermissionShop},
+	RoleRobot: {PermissionDeliver},
+	RoleAdmin: {PermissionShop, PermissionDeliver, PermissionManage},
+}
+
+// Can は役割に権限があるかを返す。未知の役割には何も許さない
+func (r Role) Can(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
 }
 
 type Product struct {
-	ProductID   int    `db:"product_id"   json:"product_id"`
-	Name        string `db:"name"         json:"name"`
-	Value       int    `db:"value"        json:"value"`
-	Weight      int    `db:"weight"       json:"weight"`
-	Image       string `db:"image"        json:"image"`
-	Description string `db:"description"  json:"description"`
+	ProductID   int              `db:"product_id"   json:"product_id"`
+	Name        string           `db:"name"         json:"name"`
+	Value       Points           `db:"value"        json:"value"`
+	Weight      Grams            `db:"weight"       json:"weight"`
+	Volume      CubicCentimeters `db:"volume"       json:"volume"`
+	Image       string           `db:"image"        json:"image"`
+	Description string           `db:"description"  json:"description"`
+	// 在庫数。在庫を数えない設定（INVENTORY_UNLIMITED）では使わない
+	Stock int `db:"stock" json:"stock"`
+	// 在庫がこれを下回ったら通知する。0なら通知しない。管理APIでだけ読み書きする
+	LowStockThreshold int `db:"low_stock_threshold" json:"low_stock_threshold,omitempty"`
+	// 取り消しを除いた注文数。定期的に集計し直すため、直近の注文は反映されていないことがある
+	Popularity int `db:"popularity" json:"popularity"`
+	// 論理削除した日時。削除していなければnil
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+	// 一覧を取得した利用者のお気に入りか。商品一覧でだけ設定する
+	IsFavorite bool `db:"is_favorite" json:"is_favorite"`
+	// 商品に付けたタグ。商品の詳細でだけ設定する
+	Tags []string `db:"-" json:"tags,omitempty"`
+}
+
+// ProductDetail は商品と、その商品の注文の集計
+type ProductDetail struct {
+	Product
+	// 退避したものを含め、取り消しを除いた注文数
+	TimesOrdered int `json:"times_ordered"`
+	// 配送完了した注文の、注文から到着までの平均の秒数。配送完了した注文がなければnull
+	AverageDeliverySeconds *float64 `json:"average_delivery_seconds"`
+}
+
+// ProductOrderStats は商品の注文の集計。平均は呼び出し側で求める
+type ProductOrderStats struct {
+	Orders          int   `db:"orders"`
+	Delivered       int   `db:"delivered"`
+	DeliverySeconds int64 `db:"delivery_seconds"`
+}
+
+// ProductRecommendation は商品と、それと一緒に注文されることの多い商品の組
+type ProductRecommendation struct {
+	ProductID            int `db:"product_id"`
+	RecommendedProductID int `db:"recommended_product_id"`
+	Score                int `db:"score"`
+}
+
+// ProductValueChange は商品の価値の履歴の1件。EffectiveToがnullなら現在の価値
+type ProductValueChange struct {
+	Value         Points     `db:"value"          json:"value"`
+	EffectiveFrom time.Time  `db:"effective_from" json:"effective_from"`
+	EffectiveTo   *time.Time `db:"effective_to"   json:"effective_to"`
 }
 
 type Order struct {
-	OrderID       int64        `db:"order_id"        json:"order_id"`
-	UserID        int          `db:"user_id"         json:"user_id"`
-	ProductID     int          `db:"product_id"      json:"product_id"`
-	ProductName   string       `db:"product_name"    json:"product_name"`
-	ShippedStatus string       `db:"shipped_status"  json:"shipped_status"`
-	Weight        int          `db:"weight"          json:"weight"`
-	Value         int          `db:"value"           json:"value"`
-	CreatedAt     time.Time    `db:"created_at"      json:"created_at"`
-	ArrivedAt     sql.NullTime `db:"arrived_at"      json:"arrived_at"`
+	OrderID       int64            `db:"order_id"        json:"order_id"`
+	UserID        int              `db:"user_id"         json:"user_id"`
+	ProductID     int              `db:"product_id"      json:"product_id"`
+	ProductName   string           `db:"product_name"    json:"product_name"`
+	ShippedStatus string           `db:"shipped_status"  json:"shipped_status"`
+	Priority      int              `db:"priority"        json:"priority"`
+	Weight        Grams            `db:"weight"          json:"weight"`
+	Value         Points           `db:"value"           json:"value"`
+	Volume        CubicCentimeters `db:"volume"          json:"volume"`
+	CreatedAt     time.Time        `db:"created_at"      json:"created_at"`
+	ArrivedAt     sql.NullTime     `db:"arrived_at"      json:"arrived_at"`
+	// 配送期限。未指定ならNULL
+	DeliverBy sql.NullTime `db:"deliver_by" json:"deliver_by"`
+	// 利用者が取り消した日時。取り消していなければNULL
+	CancelledAt sql.NullTime `db:"cancelled_at" json:"cancelled_at"`
+	// 配送に失敗して配送待ちへ戻した回数
+	RetryCount int `db:"retry_count" json:"retry_count"`
+	// 注文作成時に付けた任意のJSONオブジェクト。未指定ならnull
+	Metadata OrderMetadata `db:"metadata" json:"metadata"`
+	// 配送を始めてよい日時。それまでは予約中（scheduled）で配送計画の対象にならない。未指定ならNULL
+	ShipAfter sql.NullTime `db:"ship_after" json:"ship_after"`
+	// 配送計画で注文を引き当てたロボット。配送待ちの間はNULL。利用者には返さない
+	AssignedRobotID sql.NullString `db:"assigned_robot_id" json:"-"`
+}
+
+// OrderMetadata は注文に付ける任意のJSONオブジェクト。DBにはJSON列として保存し、空ならNULLにする
+type OrderMetadata json.RawMessage
+
+func (m OrderMetadata) MarshalJSON() ([]byte, error) {
+	if len(m) == 0 {
+		return []byte("null"), nil
+	}
+	return m, nil
+}
+
+func (m *OrderMetadata) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*m = nil
+		return nil
+	}
+	*m = append((*m)[:0], b...)
+	return nil
+}
+
+func (m OrderMetadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return string(m), nil
+}
+
+func (m *OrderMetadata) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+	case []byte:
+		*m = append(OrderMetadata(nil), v...)
+	case string:
+		*m = OrderMetadata(v)
+	default:
+		return fmt.Errorf("cannot scan %T into OrderMetadata", src)
+	}
+	return nil
+}
+
+// 注文イベントの種別
+const (
+	OrderEventCreated       = "created"
+	OrderEventStatusChanged = "status_changed"
+)
+
+// 注文ステータス変更イベント（order_eventsテーブルの1行）
+type OrderEvent struct {
+	EventID    int64     `db:"event_id"    json:"event_id"`
+	OrderID    int64     `db:"order_id"    json:"order_id"`
+	EventType  string    `db:"event_type"  json:"event_type"`
+	Status     string    `db:"status"      json:"status"`
+	OccurredAt time.Time `db:"occurred_at" json:"occurred_at"`
+	Actor      string    `db:"actor"       json:"actor,omitempty"`
+	// 変更前のステータス。作成のイベントはNULL
+	PreviousStatus sql.NullString `db:"previous_status" json:"previous_status"`
+	// 配送に失敗した理由のコード。配送失敗のイベント以外はNULL
+	Reason sql.NullString `db:"reason" json:"reason"`
+	// 主体の種別（user/robot/system）。Actorから導く
+	ActorType string `db:"-" json:"actor_type"`
+}
+
+// 在庫補充のために完了済みの注文を複製したときのイベントの主体
+const OrderEventActorSupplyClone = "supply-clone"
+
+// 引き受けたまま一定時間完了しない注文を配送待ちに戻したときのイベントの主体
+const OrderEventActorPlanReaper = "plan-reaper"
+
+// 配送開始日時になった予約中の注文を配送待ちにしたときのイベントの主体
+const OrderEventActorScheduler = "scheduler"
+
+// 利用者が注文を作成・取り消したときのイベントの主体
+const OrderEventActorUser = "user"
+
+// 注文イベントの主体の種別
+const (
+	OrderEventActorTypeUser   = "user"
+	OrderEventActorTypeRobot  = "robot"
+	OrderEventActorTypeSystem = "system"
+)
+
+// OrderEventActorType はイベントの主体の種別を返す。利用者とシステムの処理以外はロボットID
+// 主体を記録していない過去のイベントはsystemとする
+func OrderEventActorType(actor string) string {
+	switch actor {
+	case OrderEventActorUser:
+		return OrderEventActorTypeUser
+	case "", OrderEventActorSupplyClone, OrderEventActorPlanReaper, OrderEventActorScheduler:
+		return OrderEventActorTypeSystem
+	}
+	return OrderEventActorTypeRobot
+}
+
+// 失敗した非同期処理（dead_lettersテーブルの1行）
+type DeadLetter struct {
+	DeadLetterID int64     `db:"dead_letter_id" json:"dead_letter_id"`
+	Kind         string    `db:"kind"           json:"kind"`
+	Payload      string    `db:"payload"        json:"payload"`
+	Reason       string    `db:"reason"         json:"reason"`
+	Attempts     int       `db:"attempts"       json:"attempts"`
+	CreatedAt    time.Time `db:"created_at"     json:"created_at"`
+	LastFailedAt time.Time `db:"last_failed_at" json:"last_failed_at"`
 }
 
 type DeliveryPlan struct {
-	RobotID     string  `json:"robot_id"`
-	TotalWeight int     `json:"total_weight"`
-	TotalValue  int     `json:"total_value"`
-	Orders      []Order `json:"orders"`
+	RobotID     string `json:"robot_id"`
+	TotalWeight Grams  `json:"total_weight"`
+	TotalValue  Points `json:"total_value"`
+	// 容積の上限を指定した計画のみ設定される
+	TotalVolume CubicCentimeters `json:"total_volume,omitempty"`
+	Orders      []Order          `json:"orders"`
+	Explanation *PlanExplanation `json:"explanation,omitempty"`
+	// 計画の選定に使ったプランナープロファイル
+	Profile string `json:"profile,omitempty"`
+	// 計画の注文をすべて配り終えるまでの見積もりの所要時間（ミリ秒）
+	EstimatedDuration int64 `json:"estimated_duration_ms"`
+
+	// 計画を分割して返す場合のみ設定される
+	PlanID      string `json:"plan_id,omitempty"`
+	TotalOrders int    `json:"total_orders,omitempty"`
+	NextCursor  string `json:"next_cursor,omitempty"`
+}
+
+// 配送計画の選定過程の説明
+type PlanExplanation struct {
+	ZeroWeightCandidates int      `json:"zero_weight_candidates"`
+	ZeroWeightIncluded   int      `json:"zero_weight_included"`
+	ZeroWeightDeferred   int      `json:"zero_weight_deferred"`
+	Notes                []string `json:"notes,omitempty"`
+	// 優先度の層ごとに使った解法（重複なし、使った順）
+	Strategies []string `json:"strategies,omitempty"`
+}
+
+// 生成した配送計画の履歴（delivery_plansテーブルの1行）
+type DeliveryPlanRecord struct {
+	PlanID      int64            `db:"plan_id"      json:"plan_id"`
+	RobotID     string           `db:"robot_id"     json:"robot_id"`
+	Profile     string           `db:"profile"      json:"profile"`
+	Algorithm   string           `db:"algorithm"    json:"algorithm"`
+	OrderIDs    OrderIDList      `db:"order_ids"    json:"order_ids"`
+	TotalWeight Grams            `db:"total_weight" json:"total_weight"`
+	TotalValue  Points           `db:"total_value"  json:"total_value"`
+	TotalVolume CubicCentimeters `db:"total_volume" json:"total_volume"`
+	DurationUs  int64            `db:"duration_us"  json:"duration_us"`
+	CreatedAt   time.Time        `db:"created_at"   json:"created_at"`
+}
+
+// OrderIDList は注文IDの並び。DBにはJSONの配列として保存する
+type OrderIDList []int64
+
+func (l OrderIDList) Value() (driver.Value, error) {
+	if l == nil {
+		l = OrderIDList{}
+	}
+	b, err := json.Marshal([]int64(l))
+	return string(b), err
+}
+
+func (l *OrderIDList) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into OrderIDList", src)
+	}
+	return json.Unmarshal(b, (*[]int64)(l))
+}
+
+// ロボットの稼働状態
+const (
+	RobotStatusActive   = "active"
+	RobotStatusInactive = "inactive"
+)
+
+// 登録済みの配送ロボット（robotsテーブルの1行）
+type Robot struct {
+	RobotID     string `db:"robot_id"     json:"robot_id"`
+	MaxCapacity Grams  `db:"max_capacity" json:"max_capacity"`
+	Status      string `db:"status"       json:"status"`
+	// 最後に生存を通知した時刻。一度も通知していなければNULL
+	LastHeartbeatAt sql.NullTime `db:"last_heartbeat_at" json:"last_heartbeat_at"`
+}
+
+// 配送計画の選定設定（planner_profilesテーブルの1行）
+type PlannerProfile struct {
+	Name             string    `db:"name"               json:"name"`
+	Algorithm        string    `db:"algorithm"          json:"algorithm"`
+	Epsilon          float64   `db:"epsilon"            json:"epsilon"`
+	FairnessMode     string    `db:"fairness_mode"      json:"fairness_mode"`
+	AgingBoost       Points    `db:"aging_boost"        json:"aging_boost"`
+	ZeroWeightCap    int       `db:"zero_weight_cap"    json:"zero_weight_cap"`
+	ZeroWeightPolicy string    `db:"zero_weight_policy" json:"zero_weight_policy"`
+	RolloutPercent   int       `db:"rollout_percent"    json:"rollout_percent"`
+	UpdatedAt        time.Time `db:"updated_at"         json:"updated_at"`
+}
+
+// ロボットへのプランナープロファイルの割り当て
+type PlannerAssignment struct {
+	RobotID     string    `db:"robot_id"     json:"robot_id"`
+	ProfileName string    `db:"profile_name" json:"profile"`
+	UpdatedAt   time.Time `db:"updated_at"   json:"updated_at"`
+}
+
+// 日次の突合レポート
+type ReconciliationReport struct {
+	Date        string    `json:"date"`
+	GeneratedAt time.Time `json:"generated_at"`
+
+	OrdersCreated   int `json:"orders_created"`
+	CloneTopUps     int `json:"clone_top_ups"`
+	OrdersCompleted int `json:"orders_completed"`
+	// レポート生成時点で、配送中のまま閾値を超えて完了していない注文
+	StuckOrders    int    `json:"stuck_orders"`
+	StuckThreshold string `json:"stuck_threshold"`
+
+	SLA               string  `json:"sla"`
+	SLABreaches       int     `json:"sla_breaches"`
+	SLABreachOrderIDs []int64 `json:"sla_breach_order_ids"`
+
+	Robots []RobotDeliverySummary `json:"robots"`
+}
+
+// ロボットごとの配送完了件数と価値
+type RobotDeliverySummary struct {
+	RobotID string `json:"robot_id"`
+	Orders  int    `json:"orders"`
+	Value   Points `json:"value"`
 }
 
 type LoginRequest struct {
@@ -44,6 +392,47 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type RegisterRequest struct {
+	UserName string `json:"user_name"`
+	Password string `json:"password"`
+}
+
+// APIKey はロボット向けのAPIキー。キーそのものは作成・ローテーションのときにだけ返す
+type APIKey struct {
+	APIKeyID  int64      `db:"api_key_id" json:"api_key_id"`
+	Name      string     `db:"name"       json:"name"`
+	RobotID   *string    `db:"robot_id"   json:"robot_id,omitempty"`
+	KeyPrefix string     `db:"key_prefix" json:"key_prefix"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// IssuedAPIKey は作成・ローテーションしたAPIキーと、そのキーそのもの
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name    string `json:"name"`
+	RobotID string `json:"robot_id"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	UserName string `json:"user_name"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
 type CreateOrderRequest struct {
 	Items []RequestItem `json:"items"`
 }
@@ -51,6 +440,43 @@ type CreateOrderRequest struct {
 type RequestItem struct {
 	ProductID int `json:"product_id"`
 	Quantity  int `json:"quantity"`
+	// 配送の優先度（OrderPriorityNormal〜OrderPriorityUrgent）。未指定なら通常
+	Priority int `json:"priority"`
+	// 配送期限。未指定なら期限なし
+	DeliverBy *time.Time `json:"deliver_by,omitempty"`
+	// 作成する注文に付ける任意のJSONオブジェクト。未指定なら付けない
+	Metadata OrderMetadata `json:"metadata,omitempty"`
+	// 配送を始めてよい日時。未来の日時を指定するとそれまで配送しない
+	ShipAfter *time.Time `json:"ship_after,omitempty"`
+}
+
+// 注文の優先度。配送計画では優先度の高い注文から積む
+const (
+	OrderPriorityNormal = 0
+	OrderPriorityHigh   = 1
+	OrderPriorityUrgent = 2
+)
+
+// 複数ロボットの配送計画をまとめて生成するときの1台分の指定
+type RobotSpec struct {
+	RobotID  string `json:"robot_id"`
+	Capacity Grams  `json:"capacity"`
+	// 0なら容積は制約しない
+	VolumeCapacity CubicCentimeters `json:"volume_capacity,omitempty"`
+}
+
+type BatchDeliveryPlanRequest struct {
+	Robots []RobotSpec `json:"robots"`
+}
+
+type BatchDeliveryPlanResponse struct {
+	Plans []DeliveryPlan `json:"plans"`
+}
+
+// 配送待ちに戻した注文
+type ReleasePlanResponse struct {
+	RobotID  string  `json:"robot_id"`
+	OrderIDs []int64 `json:"order_ids"`
 }
 
 type UpdateOrderStatusRequest struct {
@@ -58,6 +484,86 @@ type UpdateOrderStatusRequest struct {
 	NewStatus string `json:"new_status"`
 }
 
+type CancelOrdersRequest struct {
+	OrderIDs []int64 `json:"order_ids"`
+}
+
+// まとめて取り消した注文ごとの結果
+const (
+	CancelResultCancelled = "cancelled"
+	CancelResultSkipped   = "skipped"
+)
+
+// 注文を取り消さなかった理由
+const (
+	CancelSkipNotFound       = "not_found"
+	CancelSkipForbidden      = "forbidden"
+	CancelSkipNotCancellable = "not_cancellable"
+	CancelSkipDuplicate      = "duplicate"
+)
+
+// ProductImportResult は商品のCSV一括登録の結果
+// 不正な行が1つでもあれば何も登録せず、Errorsにその行を返す
+type ProductImportResult struct {
+	Imported int                     `json:"imported"`
+	Errors   []ProductImportRowError `json:"errors"`
+}
+
+type ProductImportRowError struct {
+	// CSVの行番号（ヘッダーが1行目）
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+	// 商品の項目の検証エラー。CSVの値を読めなかった行では空
+	Fields []ProductFieldError `json:"fields,omitempty"`
+}
+
+// LowStockProduct は在庫が閾値を下回った商品
+type LowStockProduct struct {
+	ProductID int    `db:"product_id"          json:"product_id"`
+	Name      string `db:"name"                json:"name"`
+	Stock     int    `db:"stock"               json:"stock"`
+	Threshold int    `db:"low_stock_threshold" json:"threshold"`
+}
+
+// ProductFieldError は商品の項目ごとの検証エラー
+type ProductFieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+type CancelOrderResult struct {
+	OrderID int64  `json:"order_id"`
+	Result  string `json:"result"`
+	// 取り消さなかった理由。取り消した場合は空
+	Reason string `json:"reason,omitempty"`
+	// 取り消さなかった注文の現在のステータス。存在しない・他のユーザーの注文は空
+	ShippedStatus string `json:"shipped_status,omitempty"`
+}
+
+// 配送待ちの注文のフィードでの位置。作成日時・注文IDの順に並べ、この注文より後を返す
+type ShippingFeedPosition struct {
+	CreatedAt time.Time
+	OrderID   int64
+}
+
+// 外部の倉庫管理システム向けの配送待ちの注文のフィードの1ページ
+type ShippingFeedPage struct {
+	Orders []Order `json:"orders"`
+	// 続きを読むためのカーソル。最後のページなら空
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ロボットが配送に失敗したときの報告
+type DeliveryFailureRequest struct {
+	Reason string `json:"reason"`
+}
+
+type DeliveryFailureResponse struct {
+	OrderID       int64  `json:"order_id"`
+	ShippedStatus string `json:"shipped_status"`
+	RetryCount    int    `json:"retry_count"`
+}
+
 type LoginResponse struct {
 	UserID   int    `json:"user_id"`
 	UserName string `json:"user_name"`
@@ -70,5 +576,78 @@ type ListRequest struct {
 	PageSize  int    `json:"page_size"`
 	SortField string `json:"sort_field"`
 	SortOrder string `json:"sort_order"`
-	Offset    int    `json:"-"`
+	// 複数列での並び順。指定した順に優先し、先頭はSortField・SortOrderにも反映する
+	// 空ならSortField・SortOrderの1列で並べる
+	Sort   []SortKey `json:"sort"`
+	Cursor string    `json:"cursor"`
+	Offset int       `json:"-"`
+
+	// 注文一覧の絞り込み。Statusはいずれかのステータスに一致する注文、作成日時はCreatedFrom以上CreatedTo未満
+	// ゼロ値・空なら絞り込まない
+	Status      []string  `json:"status"`
+	CreatedFrom time.Time `json:"created_from"`
+	CreatedTo   time.Time `json:"created_to"`
+	// 商品の価値・重さの範囲での絞り込み。両端を含み、nilなら絞り込まない
+	ValueMin  *Points `json:"value_min"`
+	ValueMax  *Points `json:"value_max"`
+	WeightMin *Grams  `json:"weight_min"`
+	WeightMax *Grams  `json:"weight_max"`
+	// 配送完了から一定日数が過ぎて退避した注文を検索する
+	Archived bool `json:"archived"`
+	// 2ページ目以降で総件数を数えない。総件数の代わりに-1を返し、続きの有無だけを返す
+	EstimateTotal bool `json:"estimate_total"`
+	// 商品のカテゴリでの絞り込み。子孫のカテゴリの商品も含み、0なら絞り込まない
+	CategoryID int `json:"category_id"`
+	// CategoryIDとその子孫のカテゴリID。サービスで展開して設定する
+	CategoryIDs []int `json:"-"`
+	// カテゴリごとの件数（facets）もあわせて返す
+	WithFacets bool `json:"with_facets"`
+	// 利用者のお気に入りの商品だけに絞り込む
+	FavoritesOnly bool `json:"favorites_only"`
+	// 商品のタグでの絞り込み。すべてのタグが付いた商品に絞り、空なら絞り込まない
+	Tags []string `json:"tags"`
+	// 一覧で返す列（レスポンスのJSONの名前）。空ならすべての列を返す
+	Fields []string `json:"fields"`
+	// Fieldsに対応するSELECTする式。ハンドラで検証して設定する
+	Columns []string `json:"-"`
+	// キーセットのカーソルで指定した続きの位置。設定されていればOffsetの代わりに、この位置より後の行を返す
+	After *ListKeyset `json:"-"`
+}
+
+// ListKeyset は一覧の最後の行の、並び替えの列の値と識別子
+type ListKeyset struct {
+	Value string
+	ID    int64
+}
+
+// 商品のカテゴリ。ParentIDがnilなら最上位
+type Category struct {
+	CategoryID int    `db:"category_id" json:"category_id"`
+	ParentID   *int   `db:"parent_id"   json:"parent_id"`
+	Name       string `db:"name"        json:"name"`
+}
+
+// 一覧の絞り込みに一致した行の、カテゴリごとの件数
+// カテゴリでの絞り込みは除いて数え、複数のカテゴリに入っている商品はそれぞれで数える。子孫のカテゴリの件数は含まない
+type CategoryFacet struct {
+	Category
+	Count int `db:"count" json:"count"`
+}
+
+// 一覧の並び順の1列
+type SortKey struct {
+	Field string `json:"field"`
+	Order string `json:"order"`
+}
+
+// 配達証明（delivery_proofsテーブルの1行）
+type DeliveryProof struct {
+	OrderID     int64     `db:"order_id"     json:"order_id"`
+	ObjectKey   string    `db:"object_key"   json:"-"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	SizeBytes   int       `db:"size_bytes"   json:"size_bytes"`
+	UploadedAt  time.Time `db:"uploaded_at"  json:"uploaded_at"`
+	// 署名付きのダウンロードURLとその有効期限（レスポンス時に付与）
+	URL       string    `db:"-" json:"url"`
+	ExpiresAt time.Time `db:"-" json:"expires_at"`
 }
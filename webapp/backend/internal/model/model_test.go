@@ -0,0 +1,85 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOrderIDListRoundTrip(t *testing.T) {
+	v, err := OrderIDList{3, 1, 2}.Value()
+	if err != nil || v != "[3,1,2]" {
+		t.Fatalf("Value: %v, %v", v, err)
+	}
+	if v, _ := OrderIDList(nil).Value(); v != "[]" {
+		t.Fatalf("nil list must be stored as an empty array, got %v", v)
+	}
+	var ids OrderIDList
+	if err := ids.Scan([]byte("[5,6]")); err != nil || len(ids) != 2 || ids[0] != 5 || ids[1] != 6 {
+		t.Fatalf("Scan: %v, %v", ids, err)
+	}
+	if err := ids.Scan(nil); err == nil {
+		t.Fatalf("NULL must be rejected")
+	}
+}
+
+func TestOrderMetadataRoundTrip(t *testing.T) {
+	if v, err := OrderMetadata(nil).Value(); err != nil || v != nil {
+		t.Fatalf("empty metadata must be stored as NULL, got %v, %v", v, err)
+	}
+	var m OrderMetadata
+	if err := m.Scan([]byte(`{"gift":true}`)); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	b, err := json.Marshal(Order{Metadata: m})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var decoded struct {
+		Metadata map[string]bool `json:"metadata"`
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil || !decoded.Metadata["gift"] {
+		t.Fatalf("metadata must be returned as an object, got %s", b)
+	}
+	if err := m.Scan(nil); err != nil || m != nil {
+		t.Fatalf("NULL must scan to empty metadata, got %s, %v", m, err)
+	}
+	if b, _ := json.Marshal(m); string(b) != "null" {
+		t.Fatalf("empty metadata must be returned as null, got %s", b)
+	}
+}
+
+func TestOrderEventActorType(t *testing.T) {
+	for actor, want := range map[string]string{
+		OrderEventActorUser:        OrderEventActorTypeUser,
+		OrderEventActorSupplyClone: OrderEventActorTypeSystem,
+		OrderEventActorPlanReaper:  OrderEventActorTypeSystem,
+		"":                         OrderEventActorTypeSystem,
+		"robot-001":                OrderEventActorTypeRobot,
+	} {
+		if got := OrderEventActorType(actor); got != want {
+			t.Fatalf("actor %q: expected %s, got %s", actor, want, got)
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	cases := []struct {
+		role       Role
+		permission Permission
+		want       bool
+	}{
+		{RoleUser, PermissionShop, true},
+		{RoleUser, PermissionDeliver, false},
+		{RoleUser, PermissionManage, false},
+		{RoleRobot, PermissionDeliver, true},
+		{RoleRobot, PermissionShop, false},
+		{RoleAdmin, PermissionShop, true},
+		{RoleAdmin, PermissionManage, true},
+		{Role(""), PermissionShop, false},
+	}
+	for _, tc := range cases {
+		if got := tc.role.Can(tc.permission); got != tc.want {
+			t.Errorf("%q.Can(%q) = %v, want %v", tc.role, tc.permission, got, tc.want)
+		}
+	}
+}
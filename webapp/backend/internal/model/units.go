@@ -0,0 +1,173 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// 重量（グラム）。商品・注文の重量とロボットの積載量はすべてこの単位で扱う
+type Grams int
+
+// 価値（ポイント）。商品・注文の価値と計画の合計価値はすべてこの単位で扱う
+type Points int
+
+// 容積（立方センチメートル）。商品・注文の容積とロボットの積載容積はこの単位で扱う
+// 0は容積が未登録であることを表し、容積の制約を受けない
+type CubicCentimeters int
+
+// Kilograms は重量をキログラムで返す
+func (g Grams) Kilograms() float64 {
+	return float64(g) / 1000
+}
+
+// GramsFromKilograms はキログラムをグラムに変換する（端数は四捨五入）
+func GramsFromKilograms(kg float64) Grams {
+	return Grams(math.Round(kg * 1000))
+}
+
+// ParseGrams はグラム単位の整数表記を読み取る
+func ParseGrams(s string) (Grams, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return Grams(n), nil
+}
+
+func (g Grams) String() string {
+	return strconv.Itoa(int(g)) + "g"
+}
+
+func (g Grams) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(g), 10), nil
+}
+
+func (g *Grams) UnmarshalJSON(data []byte) error {
+	n, err := unmarshalUnit(data, "weight")
+	if err != nil {
+		return err
+	}
+	*g = Grams(n)
+	return nil
+}
+
+func (g *Grams) Scan(src any) error {
+	n, err := scanUnit(src, "weight")
+	if err != nil {
+		return err
+	}
+	*g = Grams(n)
+	return nil
+}
+
+func (g Grams) Value() (driver.Value, error) {
+	return int64(g), nil
+}
+
+func (p Points) String() string {
+	return strconv.Itoa(int(p)) + "pt"
+}
+
+func (p Points) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(p), 10), nil
+}
+
+func (p *Points) UnmarshalJSON(data []byte) error {
+	n, err := unmarshalUnit(data, "value")
+	if err != nil {
+		return err
+	}
+	*p = Points(n)
+	return nil
+}
+
+func (p *Points) Scan(src any) error {
+	n, err := scanUnit(src, "value")
+	if err != nil {
+		return err
+	}
+	*p = Points(n)
+	return nil
+}
+
+func (p Points) Value() (driver.Value, error) {
+	return int64(p), nil
+}
+
+// Liters は容積をリットルで返す
+func (c CubicCentimeters) Liters() float64 {
+	return float64(c) / 1000
+}
+
+// ParseCubicCentimeters は立方センチメートル単位の整数表記を読み取る
+func ParseCubicCentimeters(s string) (CubicCentimeters, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return CubicCentimeters(n), nil
+}
+
+func (c CubicCentimeters) String() string {
+	return strconv.Itoa(int(c)) + "cm3"
+}
+
+func (c CubicCentimeters) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(c), 10), nil
+}
+
+func (c *CubicCentimeters) UnmarshalJSON(data []byte) error {
+	n, err := unmarshalUnit(data, "volume")
+	if err != nil {
+		return err
+	}
+	*c = CubicCentimeters(n)
+	return nil
+}
+
+func (c *CubicCentimeters) Scan(src any) error {
+	n, err := scanUnit(src, "volume")
+	if err != nil {
+		return err
+	}
+	*c = CubicCentimeters(n)
+	return nil
+}
+
+func (c CubicCentimeters) Value() (driver.Value, error) {
+	return int64(c), nil
+}
+
+// JSONでは単位を付けない整数として表す。小数や文字列は単位の取り違えとみなして拒否する
+func unmarshalUnit(data []byte, name string) (int, error) {
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", name, err)
+	}
+	return n, nil
+}
+
+func scanUnit(src any, name string) (int, error) {
+	switch v := src.(type) {
+	case int64:
+		return int(v), nil
+	case []byte:
+		n, err := strconv.Atoi(string(v))
+		if err != nil {
+			return 0, fmt.Errorf("scan %s: %w", name, err)
+		}
+		return n, nil
+	case string:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("scan %s: %w", name, err)
+		}
+		return n, nil
+	case nil:
+		return 0, fmt.Errorf("scan %s: unexpected NULL", name)
+	}
+	return 0, fmt.Errorf("scan %s: unsupported type %T", name, src)
+}
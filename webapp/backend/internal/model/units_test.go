@@ -0,0 +1,55 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUnitsJSONRoundTrip(t *testing.T) {
+	in := Product{ProductID: 1, Weight: 1500, Value: 300}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var out Product
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Weight != 1500 || out.Value != 300 {
+		t.Fatalf("unexpected round trip: %s -> %+v", data, out)
+	}
+}
+
+func TestUnitsRejectNonInteger(t *testing.T) {
+	var g Grams
+	if err := json.Unmarshal([]byte(`1.5`), &g); err == nil {
+		t.Fatalf("fractional weight must be rejected, got %d", g)
+	}
+	var p Points
+	if err := json.Unmarshal([]byte(`"10"`), &p); err == nil {
+		t.Fatalf("string value must be rejected, got %d", p)
+	}
+}
+
+func TestUnitsScan(t *testing.T) {
+	var g Grams
+	if err := g.Scan([]byte("250")); err != nil || g != 250 {
+		t.Fatalf("Scan([]byte): %d, %v", g, err)
+	}
+	var p Points
+	if err := p.Scan(int64(42)); err != nil || p != 42 {
+		t.Fatalf("Scan(int64): %d, %v", p, err)
+	}
+	if err := p.Scan(nil); err == nil {
+		t.Fatalf("NULL value must be rejected")
+	}
+}
+
+func TestGramsKilograms(t *testing.T) {
+	if got := Grams(1250).Kilograms(); got != 1.25 {
+		t.Fatalf("Kilograms: %v", got)
+	}
+	if got := GramsFromKilograms(0.0015); got != 2 {
+		t.Fatalf("GramsFromKilograms: %d", got)
+	}
+}
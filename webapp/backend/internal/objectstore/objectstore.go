@@ -0,0 +1,151 @@
+// Package objectstore は添付ファイルなどのバイナリを保存するオブジェクトストレージの抽象
+//
+// 現状はローカルディスクに保存する実装のみを持つ。
+// ダウンロードは署名付きURLで行い、URLを知っていれば有効期限内だけ認証なしで取得できる。
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("objectstore: object not found")
+	ErrInvalidKey       = errors.New("objectstore: invalid object key")
+	ErrInvalidSignature = errors.New("objectstore: invalid or expired signature")
+)
+
+// Store はキーを指定してオブジェクトを保存・取得する
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// LocalStore はディレクトリ配下にオブジェクトを保存する
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+// NewLocalStoreFromEnv はOBJECT_STORE_DIR（未指定なら一時ディレクトリ配下）に保存するLocalStoreを作る
+func NewLocalStoreFromEnv() *LocalStore {
+	dir := os.Getenv("OBJECT_STORE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "objects")
+	}
+	return NewLocalStore(dir)
+}
+
+// Put は一時ファイルに書き込んでからリネームし、書きかけのオブジェクトが読まれないようにする
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// キーは "/" 区切りの相対パスのみ許可し、保存先ディレクトリの外を指せないようにする
+func (s *LocalStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// URLSigner はオブジェクトのダウンロード用に期限付きの署名付きURLを発行・検証する
+type URLSigner struct {
+	baseURL string
+	secret  []byte
+	now     func() time.Time
+}
+
+// NewURLSigner はbaseURL配下のダウンロードURLに署名するSignerを作る
+// secretが空の場合は起動ごとにランダムな鍵を使う（再起動で発行済みURLは無効になる）
+func NewURLSigner(baseURL string, secret []byte) (*URLSigner, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &URLSigner{baseURL: strings.TrimRight(baseURL, "/"), secret: secret, now: time.Now}, nil
+}
+
+// Sign はkeyをttlの間だけダウンロードできるURLとその有効期限を返す
+func (s *URLSigner) Sign(key string, ttl time.Duration) (string, time.Time) {
+	expires := s.now().Add(ttl).Truncate(time.Second)
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("signature", s.signature(key, expires.Unix()))
+	return fmt.Sprintf("%s/%s?%s", s.baseURL, key, q.Encode()), expires
+}
+
+// Verify は署名付きURLのクエリを検証する
+func (s *URLSigner) Verify(key, expiresParam, signature string) error {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil || s.now().Unix() > expires {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(key, expires))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *URLSigner) signature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%d", key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
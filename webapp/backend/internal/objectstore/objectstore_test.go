@@ -0,0 +1,59 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreRejectsKeysOutsideDir(t *testing.T) {
+	s := NewLocalStore(t.TempDir())
+	for _, key := range []string{"", "/etc/passwd", "../x", "a/../../x", "a//b", `a\b`} {
+		if err := s.Put(context.Background(), key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+
+	if err := s.Put(context.Background(), "a/b.png", strings.NewReader("data")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	rc, err := s.Open(context.Background(), "a/b.png")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rc.Close()
+	if got, _ := io.ReadAll(rc); string(got) != "data" {
+		t.Fatalf("expected stored data, got %q", got)
+	}
+	if _, err := s.Open(context.Background(), "a/missing.png"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestURLSigner(t *testing.T) {
+	signer, _ := NewURLSigner("/api/objects", []byte("secret"))
+	now := time.Unix(1_700_000_000, 0)
+	signer.now = func() time.Time { return now }
+
+	raw, _ := signer.Sign("a/b.png", time.Minute)
+	u, err := url.Parse(raw)
+	if err != nil || u.Path != "/api/objects/a/b.png" {
+		t.Fatalf("unexpected url %q", raw)
+	}
+	q := u.Query()
+	if err := signer.Verify("a/b.png", q.Get("expires"), q.Get("signature")); err != nil {
+		t.Fatalf("expected valid signature, got %v", err)
+	}
+	if err := signer.Verify("a/c.png", q.Get("expires"), q.Get("signature")); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected signature bound to key, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := signer.Verify("a/b.png", q.Get("expires"), q.Get("signature")); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected expired url to be rejected, got %v", err)
+	}
+}
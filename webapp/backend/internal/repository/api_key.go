@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"backend/internal/model"
+)
+
+const apiKeyColumns = "api_key_id, name, robot_id, key_prefix, created_at, expires_at, revoked_at"
+
+type APIKeyRepository struct {
+	db DBTX
+}
+
+func NewAPIKeyRepository(db DBTX) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// Create はキーのハッシュkeyHashでAPIキーを作成し、IDを返す
+func (r *APIKeyRepository) Create(ctx context.Context, key model.APIKey, keyHash string) (int64, error) {
+	query := "INSERT INTO api_keys (name, robot_id, key_hash, key_prefix, created_at) VALUES (?, ?, ?, ?, ?)"
+	result, err := r.db.ExecContext(ctx, query, key.Name, key.RobotID, keyHash, key.KeyPrefix, key.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// FindActiveByHash はハッシュがkeyHashで、取り消しも期限切れもしていないAPIキーを返す
+func (r *APIKeyRepository) FindActiveByHash(ctx context.Context, keyHash string, now time.Time) (*model.APIKey, error) {
+	var key model.APIKey
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)"
+	if err := r.db.GetContext(ctx, &key, query, keyHash, now); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetByID はAPIキーを返す。行ロックを取るので、ローテーションなどの更新はトランザクション内で呼ぶこと
+func (r *APIKeyRepository) GetByID(ctx context.Context, apiKeyID int64) (*model.APIKey, error) {
+	var key model.APIKey
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE api_key_id = ? FOR UPDATE"
+	if err := r.db.GetContext(ctx, &key, query, apiKeyID); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// List は取り消していないAPIキーを作成順に返す
+func (r *APIKeyRepository) List(ctx context.Context) ([]model.APIKey, error) {
+	keys := []model.APIKey{}
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE revoked_at IS NULL ORDER BY api_key_id"
+	err := r.db.SelectContext(ctx, &keys, query)
+	return keys, err
+}
+
+// ExpireAt はAPIキーをatまで使えるようにする。既にそれより早く切れるキーは変えない
+func (r *APIKeyRepository) ExpireAt(ctx context.Context, apiKeyID int64, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_keys SET expires_at = ? WHERE api_key_id = ? AND (expires_at IS NULL OR expires_at > ?)", at, apiKeyID, at)
+	return err
+}
+
+// Revoke はAPIキーを取り消す。既に取り消したキーは変えない
+func (r *APIKeyRepository) Revoke(ctx context.Context, apiKeyID int64, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = ? WHERE api_key_id = ? AND revoked_at IS NULL", at, apiKeyID)
+	return err
+}
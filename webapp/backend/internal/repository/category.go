@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"strings"
+)
+
+type CategoryRepository struct {
+	db DBTX
+}
+
+func NewCategoryRepository(db DBTX) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+// List はすべてのカテゴリをカテゴリIDの順に返す
+func (r *CategoryRepository) List(ctx context.Context) ([]model.Category, error) {
+	categories := []model.Category{}
+	err := r.db.SelectContext(ctx, &categories, "SELECT category_id, parent_id, name FROM categories ORDER BY category_id")
+	return categories, err
+}
+
+// categoryFilter はproductColumnの商品がcategoryIDsのいずれかのカテゴリに入っているという条件と、その引数を返す
+func categoryFilter(productColumn string, categoryIDs []int) (string, []interface{}) {
+	args := make([]interface{}, len(categoryIDs))
+	for i, id := range categoryIDs {
+		args[i] = id
+	}
+	return productColumn + " IN (SELECT product_id FROM product_categories WHERE category_id IN (?" + strings.Repeat(", ?", len(categoryIDs)-1) + "))", args
+}
+
+// categoryFacetQuery はfromとwhereで絞り込んだ行を、商品のカテゴリごとに数えるSELECT文を返す
+// productColumnはfromの中の商品IDの列。複数のカテゴリに入っている商品はそれぞれのカテゴリで数える
+func categoryFacetQuery(from, where, productColumn string) string {
+	return `
+		SELECT c.category_id, c.parent_id, c.name, f.count
+		FROM (
+			SELECT pc.category_id, COUNT(*) AS count
+			FROM ` + from + `
+			JOIN product_categories pc ON pc.product_id = ` + productColumn + where + `
+			GROUP BY pc.category_id
+		) f
+		JOIN categories c ON c.category_id = f.category_id
+		ORDER BY c.category_id`
+}
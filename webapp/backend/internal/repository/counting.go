@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"backend/internal/telemetry"
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// countingDB は発行したクエリを処理中のリクエストの記録に数える
+type countingDB struct {
+	DBTX
+}
+
+// WithQueryCounting はクエリの発行を処理中のリクエストの記録に数えるDBTXを返す
+// トランザクション内のクエリも数えるよう、ExecTxはこのラッパーを引き継ぐ
+func WithQueryCounting(db DBTX) DBTX {
+	return &countingDB{DBTX: db}
+}
+
+func (c *countingDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	defer telemetry.StartQuery(ctx)()
+	return c.DBTX.GetContext(ctx, dest, query, args...)
+}
+
+func (c *countingDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	defer telemetry.StartQuery(ctx)()
+	return c.DBTX.SelectContext(ctx, dest, query, args...)
+}
+
+func (c *countingDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	defer telemetry.StartQuery(ctx)()
+	return c.DBTX.QueryxContext(ctx, query, args...)
+}
+
+func (c *countingDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer telemetry.StartQuery(ctx)()
+	return c.DBTX.ExecContext(ctx, query, args...)
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+)
+
+type DeadLetterRepository struct {
+	db DBTX
+}
+
+func NewDeadLetterRepository(db DBTX) *DeadLetterRepository {
+	return &DeadLetterRepository{db: db}
+}
+
+// 失敗した処理をデッドレターとして保存する
+func (r *DeadLetterRepository) Create(ctx context.Context, kind, payload, reason string) (int64, error) {
+	query := "INSERT INTO dead_letters (kind, payload, reason, attempts, created_at, last_failed_at) VALUES (?, ?, ?, 1, NOW(), NOW())"
+	result, err := r.db.ExecContext(ctx, query, kind, payload, reason)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r *DeadLetterRepository) FindByID(ctx context.Context, id int64) (*model.DeadLetter, error) {
+	var dl model.DeadLetter
+	query := "SELECT dead_letter_id, kind, payload, reason, attempts, created_at, last_failed_at FROM dead_letters WHERE dead_letter_id = ?"
+	if err := r.db.GetContext(ctx, &dl, query, id); err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+// デッドレターを新しい順に取得。kindが空なら全種別
+// 部分結果が許可されていて期限が迫った場合は、読み込み済みの分だけを返しtrueを返す
+func (r *DeadLetterRepository) List(ctx context.Context, kind string, limit, offset int) ([]model.DeadLetter, bool, error) {
+	letters := []model.DeadLetter{}
+	query := "SELECT dead_letter_id, kind, payload, reason, attempts, created_at, last_failed_at FROM dead_letters"
+	args := []interface{}{}
+	if kind != "" {
+		query += " WHERE kind = ?"
+		args = append(args, kind)
+	}
+	query += " ORDER BY dead_letter_id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+	partial, err := selectPartial(ctx, r.db, &letters, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	return letters, partial, nil
+}
+
+// 再試行に失敗したデッドレターの試行回数と理由を更新する
+func (r *DeadLetterRepository) MarkFailed(ctx context.Context, id int64, reason string) error {
+	query := "UPDATE dead_letters SET attempts = attempts + 1, reason = ?, last_failed_at = NOW() WHERE dead_letter_id = ?"
+	_, err := r.db.ExecContext(ctx, query, reason, id)
+	return err
+}
+
+func (r *DeadLetterRepository) Delete(ctx context.Context, id int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM dead_letters WHERE dead_letter_id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeadLetterDepth は種別ごとのデッドレター件数
+type DeadLetterDepth struct {
+	Kind  string `db:"kind"  json:"kind"`
+	Count int    `db:"count" json:"count"`
+}
+
+// 種別ごとの件数を取得
+func (r *DeadLetterRepository) CountByKind(ctx context.Context) ([]DeadLetterDepth, error) {
+	depths := []DeadLetterDepth{}
+	query := "SELECT kind, COUNT(*) AS count FROM dead_letters GROUP BY kind ORDER BY kind"
+	if err := r.db.SelectContext(ctx, &depths, query); err != nil {
+		return nil, err
+	}
+	return depths, nil
+}
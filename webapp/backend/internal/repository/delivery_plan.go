@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+)
+
+type PlanRepository struct {
+	db DBTX
+}
+
+func NewPlanRepository(db DBTX) *PlanRepository {
+	return &PlanRepository{db: db}
+}
+
+// 生成した配送計画を履歴に追加する
+func (r *PlanRepository) Insert(ctx context.Context, rec *model.DeliveryPlanRecord) error {
+	query := `
+		INSERT INTO delivery_plans (robot_id, profile, algorithm, order_ids, total_weight, total_value, total_volume, duration_us, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := r.db.ExecContext(ctx, query, rec.RobotID, rec.Profile, rec.Algorithm, rec.OrderIDs,
+		rec.TotalWeight, rec.TotalValue, rec.TotalVolume, rec.DurationUs, rec.CreatedAt)
+	if err != nil {
+		return err
+	}
+	rec.PlanID, err = result.LastInsertId()
+	return err
+}
+
+// 配送計画の履歴を新しい順に取得する。robotIDが空なら全ロボット分
+func (r *PlanRepository) List(ctx context.Context, robotID string, limit, offset int) ([]model.DeliveryPlanRecord, error) {
+	records := []model.DeliveryPlanRecord{}
+	query := "SELECT plan_id, robot_id, profile, algorithm, order_ids, total_weight, total_value, total_volume, duration_us, created_at FROM delivery_plans"
+	args := []interface{}{}
+	if robotID != "" {
+		query += " WHERE robot_id = ?"
+		args = append(args, robotID)
+	}
+	query += " ORDER BY plan_id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+	if err := r.db.SelectContext(ctx, &records, query, args...); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
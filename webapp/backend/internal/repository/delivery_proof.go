@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+)
+
+type DeliveryProofRepository struct {
+	db DBTX
+}
+
+func NewDeliveryProofRepository(db DBTX) *DeliveryProofRepository {
+	return &DeliveryProofRepository{db: db}
+}
+
+// 配達証明を保存する。既にあれば差し替える
+func (r *DeliveryProofRepository) Upsert(ctx context.Context, proof *model.DeliveryProof) error {
+	query := `
+		INSERT INTO delivery_proofs (order_id, object_key, content_type, size_bytes, uploaded_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE object_key = VALUES(object_key), content_type = VALUES(content_type),
+			size_bytes = VALUES(size_bytes), uploaded_at = VALUES(uploaded_at)`
+	_, err := r.db.ExecContext(ctx, query, proof.OrderID, proof.ObjectKey, proof.ContentType, proof.SizeBytes, proof.UploadedAt)
+	return err
+}
+
+func (r *DeliveryProofRepository) FindByOrderID(ctx context.Context, orderID int64) (*model.DeliveryProof, error) {
+	var proof model.DeliveryProof
+	query := "SELECT order_id, object_key, content_type, size_bytes, uploaded_at FROM delivery_proofs WHERE order_id = ?"
+	if err := r.db.GetContext(ctx, &proof, query, orderID); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
@@ -0,0 +1,30 @@
+package repository
+
+import "context"
+
+type FavoriteRepository struct {
+	db DBTX
+}
+
+func NewFavoriteRepository(db DBTX) *FavoriteRepository {
+	return &FavoriteRepository{db: db}
+}
+
+// Add は商品を利用者のお気に入りに加える。すでにお気に入りなら何もしない
+func (r *FavoriteRepository) Add(ctx context.Context, userID, productID int) error {
+	_, err := r.db.ExecContext(ctx, "INSERT IGNORE INTO favorites (user_id, product_id) VALUES (?, ?)", userID, productID)
+	return err
+}
+
+// Remove は商品を利用者のお気に入りから外す。お気に入りでなければ何もしない
+func (r *FavoriteRepository) Remove(ctx context.Context, userID, productID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM favorites WHERE user_id = ? AND product_id = ?", userID, productID)
+	return err
+}
+
+// favoritesJoin は商品一覧に利用者のお気に入りをつなぐLEFT JOIN。引数に利用者IDを1つとる
+// 商品の列を修飾せずに参照できるよう、お気に入りの商品IDは別名で返す
+const favoritesJoin = " LEFT JOIN (SELECT product_id AS favorite_product_id FROM favorites WHERE user_id = ?) f ON f.favorite_product_id = products.product_id"
+
+// favoriteColumn は商品がお気に入りかどうかの列。favoritesJoinとあわせて使う
+const favoriteColumn = "f.favorite_product_id IS NOT NULL AS is_favorite"
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"strings"
+)
+
+// listOrderBy はreqの並び順をORDER BY句にする。列とその向きはハンドラで許可リストに照らして検証済みであること
+// 同じ値の行の順序が実行ごとに変わらないよう、tieBreakerを並び順に含めていなければ最後に昇順で加える
+func listOrderBy(req model.ListRequest, tieBreaker string) string {
+	keys := req.Sort
+	if len(keys) == 0 {
+		keys = []model.SortKey{{Field: req.SortField, Order: req.SortOrder}}
+	}
+	terms := make([]string, 0, len(keys)+1)
+	unique := false
+	for _, key := range keys {
+		terms = append(terms, key.Field+" "+key.Order)
+		if key.Field == tieBreaker {
+			unique = true
+			break
+		}
+	}
+	if !unique {
+		terms = append(terms, tieBreaker+" ASC")
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+type MaintenanceRepository struct {
+	db DBTX
+}
+
+func NewMaintenanceRepository(db DBTX) *MaintenanceRepository {
+	return &MaintenanceRepository{db: db}
+}
+
+// ANALYZE TABLEの結果行
+type AnalyzeResult struct {
+	Table   string `db:"Table"    json:"table"`
+	Op      string `db:"Op"       json:"op"`
+	MsgType string `db:"Msg_type" json:"msg_type"`
+	MsgText string `db:"Msg_text" json:"msg_text"`
+}
+
+// テーブルの統計情報を更新する
+// テーブル名はプレースホルダで渡せないため、呼び出し側で許可済みの名前のみを渡すこと
+func (r *MaintenanceRepository) AnalyzeTable(ctx context.Context, table string) ([]AnalyzeResult, error) {
+	var results []AnalyzeResult
+	query := fmt.Sprintf("ANALYZE TABLE `%s`", table)
+	if err := r.db.SelectContext(ctx, &results, query); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -3,28 +3,46 @@ package repository
 import (
 	"backend/internal/model"
 	"context"
+	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type OrderRepository struct {
-	db DBTX
+	db     DBTX
+	shards orderShards
 }
 
 func NewOrderRepository(db DBTX) *OrderRepository {
-	return &OrderRepository{db: db}
+	return &OrderRepository{db: db, shards: defaultOrderShards}
 }
 
 // 注文を作成し、生成された注文IDを返す
+// ステータスを指定しなければ配送待ち（shipping）で作成する
+// 商品の価値・名前・重さ・容積は作成時点のものを注文に記録し、後から商品を変えても注文の記録は変わらない
+// 商品が存在しなければsql.ErrNoRowsを返す
 func (r *OrderRepository) Create(ctx context.Context, order *model.Order) (string, error) {
-	query := `INSERT INTO orders (user_id, product_id, shipped_status, created_at) VALUES (?, ?, 'shipping', NOW())`
-	result, err := r.db.ExecContext(ctx, query, order.UserID, order.ProductID)
+	status := order.ShippedStatus
+	if status == "" {
+		status = "shipping"
+	}
+	query := "INSERT INTO " + r.shards.forUser(order.UserID) + " (user_id, product_id, priority, deliver_by, ship_after, metadata, shipped_status, value, product_name, product_weight, product_volume, created_at) " +
+		"SELECT ?, ?, ?, ?, ?, ?, ?, value, name, weight, volume, NOW() FROM products WHERE product_id = ?"
+	result, err := r.db.ExecContext(ctx, query, order.UserID, order.ProductID, order.Priority, order.DeliverBy, order.ShipAfter, order.Metadata, status, order.ProductID)
 	if err != nil {
 		return "", err
 	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return "", err
+	}
 	id, err := result.LastInsertId()
 	if err != nil {
 		return "", err
@@ -34,99 +52,562 @@ func (r *OrderRepository) Create(ctx context.Context, order *model.Order) (strin
 
 // 複数の注文IDのステータスを一括で更新
 // 主に配送ロボットが注文を引き受けた際に一括更新をするために使用
+// 配送完了にした注文には、まだ記録がなければ現在日時を到着日時として記録する
 func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64, newStatus string) error {
-	if len(orderIDs) == 0 {
-		return nil
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In(`
+			UPDATE `+group.table+`
+			SET shipped_status = ?, arrived_at = IF(? = 'completed', COALESCE(arrived_at, NOW()), arrived_at)
+			WHERE order_id IN (?)`, newStatus, newStatus, group.orderIDs)
+		if err != nil {
+			return err
+		}
+		query = r.db.Rebind(query)
+		if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
 	}
-	query, args, err := sqlx.In("UPDATE orders SET shipped_status = ? WHERE order_id IN (?)", newStatus, orderIDs)
-	if err != nil {
-		return err
+	return nil
+}
+
+// BackfillArrivedAt は配送完了なのに到着日時がない注文に、最後の配送完了イベントの日時を記録する
+// 各注文テーブルから最大limit件ずつ埋め、埋めた件数を返す。配送完了イベントのない注文は埋めない
+func (r *OrderRepository) BackfillArrivedAt(ctx context.Context, limit int) (int, error) {
+	filled := 0
+	for _, table := range r.shards.all() {
+		var ids []int64
+		query := `
+			SELECT o.order_id FROM ` + table + ` o
+			WHERE o.shipped_status = 'completed' AND o.arrived_at IS NULL
+			  AND EXISTS (SELECT 1 FROM order_events e WHERE e.order_id = o.order_id AND e.status = 'completed')
+			ORDER BY o.order_id
+			LIMIT ?`
+		if err := r.db.SelectContext(ctx, &ids, query, limit); err != nil {
+			return 0, err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		update, args, err := sqlx.In(`
+			UPDATE `+table+` o
+			SET o.arrived_at = (SELECT MAX(e.occurred_at) FROM order_events e WHERE e.order_id = o.order_id AND e.status = 'completed')
+			WHERE o.order_id IN (?) AND o.arrived_at IS NULL`, ids)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := r.db.ExecContext(ctx, r.db.Rebind(update), args...); err != nil {
+			return 0, err
+		}
+		filled += len(ids)
+	}
+	return filled, nil
+}
+
+// LockDelivering は配送中の注文に行ロックを取り、ロックできた（まだ配送中の）注文IDを返す。トランザクション内で使う
+func (r *OrderRepository) LockDelivering(ctx context.Context, orderIDs []int64) ([]int64, error) {
+	locked := []int64{}
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In("SELECT order_id FROM "+group.table+" WHERE order_id IN (?) AND shipped_status = 'delivering' FOR UPDATE", group.orderIDs)
+		if err != nil {
+			return nil, err
+		}
+		var ids []int64
+		if err := r.db.SelectContext(ctx, &ids, r.db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		locked = append(locked, ids...)
 	}
-	query = r.db.Rebind(query)
-	_, err = r.db.ExecContext(ctx, query, args...)
+	return locked, nil
+}
+
+// LockStatuses は注文に行ロックを取り、現在のステータスと引き当てたロボットを注文IDごとに返す
+// 返す注文はOrderID・ShippedStatus・AssignedRobotIDのみ埋める。存在しない注文は含まない。トランザクション内で使う
+func (r *OrderRepository) LockStatuses(ctx context.Context, orderIDs []int64) (map[int64]model.Order, error) {
+	current := make(map[int64]model.Order, len(orderIDs))
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In("SELECT order_id, shipped_status, assigned_robot_id FROM "+group.table+" WHERE order_id IN (?) FOR UPDATE", group.orderIDs)
+		if err != nil {
+			return nil, err
+		}
+		var orders []model.Order
+		if err := r.db.SelectContext(ctx, &orders, r.db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		for _, o := range orders {
+			current[o.OrderID] = o
+		}
+	}
+	return current, nil
+}
+
+// LockRetryCount は注文に行ロックを取り、現在のステータスと配送に失敗した回数、引き当てたロボットを返す
+// 返す注文はOrderID・ShippedStatus・RetryCount・AssignedRobotIDのみ埋める。トランザクション内で使う
+func (r *OrderRepository) LockRetryCount(ctx context.Context, orderID int64) (model.Order, error) {
+	var order model.Order
+	query := "SELECT order_id, shipped_status, retry_count, assigned_robot_id FROM " + r.shards.forOrder(orderID) + " WHERE order_id = ? FOR UPDATE"
+	err := r.db.GetContext(ctx, &order, query, orderID)
+	return order, err
+}
+
+// AssignRobot は配送計画で引き当てた注文にロボットを記録する
+func (r *OrderRepository) AssignRobot(ctx context.Context, orderIDs []int64, robotID string) error {
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In("UPDATE "+group.table+" SET assigned_robot_id = ? WHERE order_id IN (?)", robotID, group.orderIDs)
+		if err != nil {
+			return err
+		}
+		if _, err := r.db.ExecContext(ctx, r.db.Rebind(query), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IncrementRetryCount は注文の配送に失敗した回数を1増やす
+func (r *OrderRepository) IncrementRetryCount(ctx context.Context, orderID int64) error {
+	query := "UPDATE " + r.shards.forOrder(orderID) + " SET retry_count = retry_count + 1 WHERE order_id = ?"
+	_, err := r.db.ExecContext(ctx, query, orderID)
 	return err
 }
 
+// LockDueScheduled は配送開始日時がdue以前になった予約中（scheduled）の注文に行ロックを取り、
+// 各注文テーブルから配送開始日時の早い順に最大limit件ずつ注文IDを返す。トランザクション内で使う
+func (r *OrderRepository) LockDueScheduled(ctx context.Context, due time.Time, limit int) ([]int64, error) {
+	var ids []int64
+	for _, table := range r.shards.all() {
+		var found []int64
+		query := "SELECT order_id FROM " + table + " WHERE shipped_status = 'scheduled' AND ship_after <= ? ORDER BY ship_after LIMIT ? FOR UPDATE"
+		if err := r.db.SelectContext(ctx, &found, query, due, limit); err != nil {
+			return nil, err
+		}
+		ids = append(ids, found...)
+	}
+	return ids, nil
+}
+
+// CountByProduct は注文の数を商品IDごとに返す。存在しない注文は数えない
+func (r *OrderRepository) CountByProduct(ctx context.Context, orderIDs []int64) (map[int]int, error) {
+	counts := make(map[int]int)
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In("SELECT product_id, COUNT(*) AS count FROM "+group.table+" WHERE order_id IN (?) GROUP BY product_id", group.orderIDs)
+		if err != nil {
+			return nil, err
+		}
+		var rows []struct {
+			ProductID int `db:"product_id"`
+			Count     int `db:"count"`
+		}
+		if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			counts[row.ProductID] += row.Count
+		}
+	}
+	return counts, nil
+}
+
+// CountAllByProduct は退避したものを含め、取り消しを除いた注文の数を商品IDごとに返す
+func (r *OrderRepository) CountAllByProduct(ctx context.Context) (map[int]int, error) {
+	counts := make(map[int]int)
+	for _, table := range append(r.shards.all(), orderArchiveTable) {
+		var rows []struct {
+			ProductID int `db:"product_id"`
+			Count     int `db:"count"`
+		}
+		query := "SELECT product_id, COUNT(*) AS count FROM " + table + " WHERE shipped_status <> 'cancelled' GROUP BY product_id"
+		if err := r.db.SelectContext(ctx, &rows, query); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			counts[row.ProductID] += row.Count
+		}
+	}
+	return counts, nil
+}
+
+// RecentProductIDs は利用者が最近注文した商品を、最後に注文した日時の新しい順にlimit件返す
+// 取り消した注文と退避した注文は含めない
+func (r *OrderRepository) RecentProductIDs(ctx context.Context, userID, limit int) ([]int, error) {
+	var productIDs []int
+	query := "SELECT product_id FROM " + r.shards.forUser(userID) + " WHERE user_id = ? AND shipped_status <> 'cancelled'" +
+		" GROUP BY product_id ORDER BY MAX(created_at) DESC, product_id LIMIT ?"
+	err := r.db.SelectContext(ctx, &productIDs, query, userID, limit)
+	return productIDs, err
+}
+
+// ProductsByUser は退避したものを含め、利用者ごとに注文したことのある商品を返す。取り消した注文は含めない
+func (r *OrderRepository) ProductsByUser(ctx context.Context) (map[int][]int, error) {
+	products := make(map[int][]int)
+	for _, table := range append(r.shards.all(), orderArchiveTable) {
+		var rows []struct {
+			UserID    int `db:"user_id"`
+			ProductID int `db:"product_id"`
+		}
+		query := "SELECT DISTINCT user_id, product_id FROM " + table + " WHERE shipped_status <> 'cancelled'"
+		if err := r.db.SelectContext(ctx, &rows, query); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			products[row.UserID] = append(products[row.UserID], row.ProductID)
+		}
+	}
+	return products, nil
+}
+
+// ProductStats は退避したものを含め、商品の取り消しを除いた注文を集計する
+// 到着日時のある注文（配送完了）について、注文から到着までの秒数を合計する
+func (r *OrderRepository) ProductStats(ctx context.Context, productID int) (model.ProductOrderStats, error) {
+	var stats model.ProductOrderStats
+	for _, table := range append(r.shards.all(), orderArchiveTable) {
+		var part model.ProductOrderStats
+		query := `
+			SELECT COUNT(*) AS orders, COUNT(arrived_at) AS delivered,
+				COALESCE(SUM(TIMESTAMPDIFF(SECOND, created_at, arrived_at)), 0) AS delivery_seconds
+			FROM ` + table + `
+			WHERE product_id = ? AND shipped_status <> 'cancelled'`
+		if err := r.db.GetContext(ctx, &part, query, productID); err != nil {
+			return stats, err
+		}
+		stats.Orders += part.Orders
+		stats.Delivered += part.Delivered
+		stats.DeliverySeconds += part.DeliverySeconds
+	}
+	return stats, nil
+}
+
 // CountShipping returns the current number of shipping orders.
 func (r *OrderRepository) CountShipping(ctx context.Context) (int, error) {
-	const query = "SELECT COUNT(*) FROM orders WHERE shipped_status = 'shipping'"
-	var total int
-	if err := r.db.GetContext(ctx, &total, query); err != nil {
-		return 0, err
+	total := 0
+	for _, table := range r.shards.all() {
+		var n int
+		if err := r.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM "+table+" WHERE shipped_status = 'shipping'"); err != nil {
+			return 0, err
+		}
+		total += n
 	}
 	return total, nil
 }
 
 // CloneAsShipping duplicates specified orders as new shipping entries to keep supply available.
-func (r *OrderRepository) CloneAsShipping(ctx context.Context, orderIDs []int64) error {
+// It returns the IDs of the newly created orders.
+func (r *OrderRepository) CloneAsShipping(ctx context.Context, orderIDs []int64) ([]int64, error) {
 	if len(orderIDs) == 0 {
-		return nil
+		return nil, nil
 	}
-	query, args, err := sqlx.In(
-		"INSERT INTO orders (user_id, product_id, shipped_status, created_at) "+
-			"SELECT user_id, product_id, 'shipping', NOW() FROM orders WHERE order_id IN (?)",
-		orderIDs,
-	)
+	clonedIDs := make([]int64, 0, len(orderIDs))
+	for _, orderID := range orderIDs {
+		// 複製元と同じユーザーの注文なので、同じテーブルに複製する
+		table := r.shards.forOrder(orderID)
+		query := "INSERT INTO " + table + " (user_id, product_id, priority, value, product_name, product_weight, product_volume, shipped_status, created_at) " +
+			"SELECT user_id, product_id, priority, value, product_name, product_weight, product_volume, 'shipping', NOW() FROM " + table + " WHERE order_id = ?"
+		result, err := r.db.ExecContext(ctx, query, orderID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		clonedIDs = append(clonedIDs, id)
+	}
+	return clonedIDs, nil
+}
+
+// GetByID は注文を注文した時点の商品情報付きで1件取得する。持ち主の確認は呼び出し側で行う
+func (r *OrderRepository) GetByID(ctx context.Context, orderID int64) (*model.Order, error) {
+	var order model.Order
+	query := `
+		SELECT o.order_id, o.user_id, o.product_id, COALESCE(o.product_name, p.name) AS product_name, o.shipped_status, o.priority, o.created_at, o.arrived_at, o.deliver_by, o.cancelled_at, o.retry_count, o.metadata, o.ship_after,
+			COALESCE(o.product_weight, p.weight) AS weight, COALESCE(o.value, p.value) AS value, COALESCE(o.product_volume, p.volume) AS volume
+		FROM ` + r.shards.forOrder(orderID) + ` o
+		JOIN products p ON o.product_id = p.product_id
+		WHERE o.order_id = ?`
+	if err := r.db.GetContext(ctx, &order, query, orderID); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// ユーザーの注文の現在のステータスを取得
+func (r *OrderRepository) GetStatus(ctx context.Context, orderID int64, userID int) (string, error) {
+	var status string
+	query := "SELECT shipped_status FROM " + r.shards.forUser(userID) + " WHERE order_id = ? AND user_id = ?"
+	if err := r.db.GetContext(ctx, &status, query, orderID, userID); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// LockStatus はユーザーの注文に行ロックを取り、現在のステータスを返す。トランザクション内で使う
+func (r *OrderRepository) LockStatus(ctx context.Context, orderID int64, userID int) (string, error) {
+	var status string
+	query := "SELECT shipped_status FROM " + r.shards.forUser(userID) + " WHERE order_id = ? AND user_id = ? FOR UPDATE"
+	if err := r.db.GetContext(ctx, &status, query, orderID, userID); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// MarkCancelled は注文の取り消し日時を記録する
+func (r *OrderRepository) MarkCancelled(ctx context.Context, orderID int64, userID int, at time.Time) error {
+	query := "UPDATE " + r.shards.forUser(userID) + " SET cancelled_at = ? WHERE order_id = ? AND user_id = ?"
+	_, err := r.db.ExecContext(ctx, query, at, orderID, userID)
+	return err
+}
+
+// LockOwnedStatuses は注文に行ロックを取り、持ち主と現在のステータスを注文IDごとに返す。存在しない注文は含まない。トランザクション内で使う
+func (r *OrderRepository) LockOwnedStatuses(ctx context.Context, orderIDs []int64) (map[int64]model.Order, error) {
+	orders := make(map[int64]model.Order, len(orderIDs))
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In("SELECT order_id, user_id, shipped_status FROM "+group.table+" WHERE order_id IN (?) FOR UPDATE", group.orderIDs)
+		if err != nil {
+			return nil, err
+		}
+		var locked []model.Order
+		if err := r.db.SelectContext(ctx, &locked, r.db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		for _, o := range locked {
+			orders[o.OrderID] = o
+		}
+	}
+	return orders, nil
+}
+
+// MarkCancelledMany はユーザーの複数の注文に取り消し日時を記録する
+func (r *OrderRepository) MarkCancelledMany(ctx context.Context, orderIDs []int64, userID int, at time.Time) error {
+	query, args, err := sqlx.In("UPDATE "+r.shards.forUser(userID)+" SET cancelled_at = ? WHERE user_id = ? AND order_id IN (?)", at, userID, orderIDs)
 	if err != nil {
 		return err
 	}
-	query = r.db.Rebind(query)
-	_, err = r.db.ExecContext(ctx, query, args...)
+	_, err = r.db.ExecContext(ctx, r.db.Rebind(query), args...)
 	return err
 }
 
+// 注文IDから現在のステータスを取得（ロボットなどユーザーを介さない操作用）
+func (r *OrderRepository) GetStatusByID(ctx context.Context, orderID int64) (string, error) {
+	var status string
+	query := "SELECT shipped_status FROM " + r.shards.forOrder(orderID) + " WHERE order_id = ?"
+	if err := r.db.GetContext(ctx, &status, query, orderID); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// 注文の持ち主のユーザーIDを取得
+func (r *OrderRepository) GetUserID(ctx context.Context, orderID int64) (int, error) {
+	var userID int
+	query := "SELECT user_id FROM " + r.shards.forOrder(orderID) + " WHERE order_id = ?"
+	if err := r.db.GetContext(ctx, &userID, query, orderID); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// 注文IDごとの持ち主のユーザーIDを取得する。存在しない注文は含まない
+func (r *OrderRepository) UserIDsByID(ctx context.Context, orderIDs []int64) (map[int64]int, error) {
+	owners := make(map[int64]int, len(orderIDs))
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In("SELECT order_id, user_id FROM "+group.table+" WHERE order_id IN (?)", group.orderIDs)
+		if err != nil {
+			return nil, err
+		}
+		var orders []model.Order
+		if err := r.db.SelectContext(ctx, &orders, r.db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		for _, o := range orders {
+			owners[o.OrderID] = o.UserID
+		}
+	}
+	return owners, nil
+}
+
+// 注文IDごとの商品の価値を取得
+func (r *OrderRepository) ValuesByID(ctx context.Context, orderIDs []int64) (map[int64]model.Points, error) {
+	values := make(map[int64]model.Points, len(orderIDs))
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In(`
+			SELECT o.order_id, COALESCE(o.value, p.value) AS value FROM `+group.table+` o
+			JOIN products p ON o.product_id = p.product_id
+			WHERE o.order_id IN (?)`, group.orderIDs)
+		if err != nil {
+			return nil, err
+		}
+		var rows []struct {
+			OrderID int64        `db:"order_id"`
+			Value   model.Points `db:"value"`
+		}
+		if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			values[row.OrderID] = row.Value
+		}
+	}
+	return values, nil
+}
+
 // 配送中(shipped_status:shipping)の注文一覧を取得
 func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order, error) {
 	var orders []model.Order
-	query := `
-        SELECT
-            o.order_id,
-            p.weight,
-            p.value
-        FROM orders o
-        JOIN products p ON o.product_id = p.product_id
-        WHERE o.shipped_status = 'shipping'
-    `
-	err := r.db.SelectContext(ctx, &orders, query)
+	err := r.db.SelectContext(ctx, &orders, r.shippingOrdersQuery(""))
 	return orders, err
 }
 
-// 注文履歴一覧を取得
-func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
-	var (
-		orders []model.Order
-		total  int
-	)
+// GetShippingOrdersCreatedAfter はafterより後に作成された配送待ちの注文を取得する
+func (r *OrderRepository) GetShippingOrdersCreatedAfter(ctx context.Context, after time.Time) ([]model.Order, error) {
+	var orders []model.Order
+	tables := r.shards.all()
+	args := make([]interface{}, len(tables))
+	for i := range tables {
+		args[i] = after
+	}
+	err := r.db.SelectContext(ctx, &orders, r.shippingOrdersQuery("AND o.created_at > ?"), args...)
+	return orders, err
+}
 
-	filters := []string{"o.user_id = ?"}
-	args := []interface{}{userID}
-	if req.Search != "" {
-		pattern := "%" + req.Search + "%"
-		if req.Type == "prefix" {
-			pattern = req.Search + "%"
+// LockShippingOrders はorderIDsのうちまだ配送待ちの注文に行ロックを取り、ロックできた注文IDを返す。トランザクション内で使う
+// 他のトランザクションがロック中の注文は待たずに読み飛ばすため、並行して引き当てるロボット同士で同じ注文を取らない
+func (r *OrderRepository) LockShippingOrders(ctx context.Context, orderIDs []int64) ([]int64, error) {
+	var locked []int64
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In("SELECT order_id FROM "+group.table+" WHERE order_id IN (?) AND shipped_status = 'shipping' FOR UPDATE SKIP LOCKED", group.orderIDs)
+		if err != nil {
+			return nil, err
 		}
-		filters = append(filters, "p.name LIKE ?")
-		args = append(args, pattern)
+		var ids []int64
+		if err := r.db.SelectContext(ctx, &ids, r.db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		locked = append(locked, ids...)
 	}
+	return locked, nil
+}
 
-	whereClause := ""
-	if len(filters) > 0 {
-		whereClause = " WHERE " + strings.Join(filters, " AND ")
+// GetShippingOrderWindow は配送待ちの注文のうち、計画に選ばれやすい順にlimit件だけ取得する
+// 優先度の高い順、urgentBeforeより前に配送期限がある注文、重量0の注文、価値密度（価値/重量）の高い順に並べる
+// 配送待ちの注文が多すぎて全件を読めない場合に、候補をSQLで絞り込むために使う
+func (r *OrderRepository) GetShippingOrderWindow(ctx context.Context, limit int, urgentBefore time.Time) ([]model.Order, error) {
+	var orders []model.Order
+	for _, table := range r.shards.all() {
+		query := shippingOrdersSelect(table) + `
+        ORDER BY o.priority DESC, (o.deliver_by IS NOT NULL AND o.deliver_by < ?) DESC,
+            COALESCE(o.product_weight, p.weight) = 0 DESC, COALESCE(o.value, p.value) / COALESCE(o.product_weight, p.weight) DESC, o.order_id
+        LIMIT ?`
+		var part []model.Order
+		if err := r.db.SelectContext(ctx, &part, query, urgentBefore, limit); err != nil {
+			return nil, err
+		}
+		orders = append(orders, part...)
 	}
+	if len(r.shards.all()) > 1 {
+		urgent := func(o model.Order) bool { return o.DeliverBy.Valid && o.DeliverBy.Time.Before(urgentBefore) }
+		sort.SliceStable(orders, func(i, j int) bool {
+			x, y := orders[i], orders[j]
+			switch {
+			case x.Priority != y.Priority:
+				return x.Priority > y.Priority
+			case urgent(x) != urgent(y):
+				return urgent(x)
+			case (x.Weight == 0) != (y.Weight == 0):
+				return x.Weight == 0
+			}
+			if dx, dy := int(x.Value)*int(y.Weight), int(y.Value)*int(x.Weight); dx != dy {
+				return dx > dy
+			}
+			return x.OrderID < y.OrderID
+		})
+	}
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
 
-	orderClause := fmt.Sprintf(" ORDER BY %s %s", req.SortField, req.SortOrder)
-	if req.SortField != "o.order_id" {
-		orderClause += ", o.order_id ASC"
+// shippingOrdersQuery は全シャードの配送待ちの注文を読むクエリを組み立てる
+// filterはUNIONの各SELECTの条件に追加する（プレースホルダの引数はシャードの数だけ繰り返して渡す）
+func (r *OrderRepository) shippingOrdersQuery(filter string) string {
+	parts := make([]string, 0, len(r.shards.all()))
+	for _, table := range r.shards.all() {
+		part := shippingOrdersSelect(table)
+		if filter != "" {
+			part += " " + filter
+		}
+		parts = append(parts, part)
 	}
+	return strings.Join(parts, "\n        UNION ALL")
+}
 
-	countQuery := "SELECT COUNT(*) FROM orders o JOIN products p ON o.product_id = p.product_id" + whereClause
-	query := fmt.Sprintf(`
-		SELECT o.order_id, o.user_id, o.product_id, p.name AS product_name, o.shipped_status, o.created_at, o.arrived_at, p.weight, p.value
-		FROM orders o
-		JOIN products p ON o.product_id = p.product_id%s%s
-		LIMIT ? OFFSET ?`, whereClause, orderClause)
-	listArgs := append([]interface{}{}, args...)
-	listArgs = append(listArgs, req.PageSize, req.Offset)
+// ListShippingFeed は配送待ちの注文を作成日時・注文IDの順に、afterより後のものからlimit件返す
+// afterがゼロ値なら先頭から、sinceより前に作成された注文は含めない。シャードごとにlimit件読んで並べ直す
+// 注文IDはシャードごとに範囲が分かれ作成順にならないため、作成日時を先に比べる
+func (r *OrderRepository) ListShippingFeed(ctx context.Context, since time.Time, after model.ShippingFeedPosition, limit int) ([]model.Order, error) {
+	from := since
+	if after.CreatedAt.After(from) {
+		from = after.CreatedAt
+	}
+	var orders []model.Order
+	for _, table := range r.shards.all() {
+		query := `
+			SELECT o.order_id, o.user_id, o.product_id, COALESCE(o.product_name, p.name) AS product_name, o.shipped_status, o.priority,
+				COALESCE(o.product_weight, p.weight) AS weight, COALESCE(o.value, p.value) AS value,
+				COALESCE(o.product_volume, p.volume) AS volume, o.created_at, o.deliver_by, o.metadata
+			FROM ` + table + ` o
+			JOIN products p ON o.product_id = p.product_id
+			WHERE o.shipped_status = 'shipping' AND o.created_at >= ?
+				AND (o.created_at > ? OR o.order_id > ?)
+			ORDER BY o.created_at, o.order_id
+			LIMIT ?`
+		var part []model.Order
+		if err := r.db.SelectContext(ctx, &part, query, from, after.CreatedAt, after.OrderID, limit); err != nil {
+			return nil, err
+		}
+		orders = append(orders, part...)
+	}
+	if len(r.shards.all()) > 1 {
+		sort.Slice(orders, func(i, j int) bool {
+			if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+				return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+			}
+			return orders[i].OrderID < orders[j].OrderID
+		})
+		if len(orders) > limit {
+			orders = orders[:limit]
+		}
+	}
+	return orders, nil
+}
+
+// shippingOrdersSelect はtableの配送待ちの注文を商品の重さ・価値とあわせて読むSELECT文
+// 重さ・容積は注文した時点の値を使い、記録のない古い注文だけ今の商品の値で補う
+func shippingOrdersSelect(table string) string {
+	return `
+        SELECT
+            o.order_id,
+            o.user_id,
+            o.priority,
+            o.created_at,
+            o.deliver_by,
+            COALESCE(o.product_weight, p.weight) AS weight,
+            COALESCE(o.value, p.value) AS value,
+            COALESCE(o.product_volume, p.volume) AS volume
+        FROM ` + table + ` o
+        JOIN products p ON o.product_id = p.product_id
+        WHERE o.shipped_status = 'shipping'`
+}
+
+// 注文履歴一覧を取得
+// 1ページ分の注文と絞り込みに一致する総件数を並行して読む
+func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
+	var (
+		orders []model.Order
+		total  int
+	)
 
 	errCh := make(chan error, 2)
 	ctx, cancel := context.WithCancel(ctx)
@@ -137,7 +618,8 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 
 	go func() {
 		defer wg.Done()
-		if err := r.db.GetContext(ctx, &total, countQuery, args...); err != nil {
+		var err error
+		if total, err = r.CountOrders(ctx, userID, req); err != nil {
 			errCh <- err
 			cancel()
 		}
@@ -145,7 +627,8 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 
 	go func() {
 		defer wg.Done()
-		if err := r.db.SelectContext(ctx, &orders, query, listArgs...); err != nil {
+		var err error
+		if orders, err = r.FindOrders(ctx, userID, req, req.PageSize); err != nil {
 			errCh <- err
 			cancel()
 		}
@@ -165,3 +648,146 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 
 	return orders, total, nil
 }
+
+// CountOrders はreqの絞り込みに一致するユーザーの注文数を返す
+func (r *OrderRepository) CountOrders(ctx context.Context, userID int, req model.ListRequest) (int, error) {
+	whereClause, args := orderListFilters(userID, req)
+	var total int
+	query := "SELECT COUNT(*) FROM " + r.orderListFrom(userID, req) + whereClause
+	if err := r.db.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// FindOrders はreqの絞り込みと並び順で、req.Offset件目からlimit件の注文を返す
+// req.Columnsが指定されていればその列だけを読み、他の項目はゼロ値にする
+func (r *OrderRepository) FindOrders(ctx context.Context, userID int, req model.ListRequest, limit int) ([]model.Order, error) {
+	whereClause, args := orderListFilters(userID, req)
+	columns := orderListColumns
+	if len(req.Columns) > 0 {
+		columns = strings.Join(req.Columns, ", ")
+	}
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s%s%s
+		LIMIT ? OFFSET ?`, columns, r.orderListFrom(userID, req), whereClause, orderListOrder(req))
+	args = append(args, limit, req.Offset)
+	orders := []model.Order{}
+	if err := r.db.SelectContext(ctx, &orders, query, args...); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// EachOrder はユーザーの注文履歴をreqの絞り込みと並び順で1件ずつ読み、fnに渡す
+// 全件をメモリに載せずに済むよう、ページングせず読みながら返す。fnがエラーを返すと読み込みを打ち切る
+func (r *OrderRepository) EachOrder(ctx context.Context, userID int, req model.ListRequest, fn func(model.Order) error) error {
+	whereClause, args := orderListFilters(userID, req)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s%s%s`, orderListColumns, r.orderListFrom(userID, req), whereClause, orderListOrder(req))
+	rows, err := r.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var order model.Order
+		if err := rows.StructScan(&order); err != nil {
+			return err
+		}
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// orderListTable は注文履歴を読むテーブルを返す
+// ユーザーの注文はすべて同じテーブルにあるため、シャーディング時も1テーブルの検索で済む
+func (r *OrderRepository) orderListTable(userID int, req model.ListRequest) string {
+	if req.Archived {
+		return orderArchiveTable
+	}
+	return r.shards.forUser(userID)
+}
+
+// 注文履歴として返す列
+// 商品名・重さ・価値・容積は注文時点の商品の値。記録していない古い注文だけ、orderListFromでつないだ現在の商品の値で補う
+// 列を増やした場合は、ハンドラのorderListSpecのfieldsにも加えること
+const orderListColumns = "o.order_id, o.user_id, o.product_id, COALESCE(o.product_name, p.name, '') AS product_name, o.shipped_status, o.priority, o.created_at, o.arrived_at, o.deliver_by, o.cancelled_at, o.retry_count, o.metadata, o.ship_after, COALESCE(o.product_weight, p.weight, 0) AS weight, COALESCE(o.value, p.value, 0) AS value, COALESCE(o.product_volume, p.volume, 0) AS volume"
+
+// orderListFrom は注文履歴を読むFROM句を返す
+// 商品の値を記録していない注文を補うため商品をつなぐ。主キーでつなぐので1件あたりの読み込みは増えない
+func (r *OrderRepository) orderListFrom(userID int, req model.ListRequest) string {
+	return r.orderListTable(userID, req) + " o LEFT JOIN products p ON p.product_id = o.product_id"
+}
+
+// orderListFilters はreqの絞り込みをWHERE句とその引数にする
+func orderListFilters(userID int, req model.ListRequest) (string, []interface{}) {
+	filters := []string{"o.user_id = ?"}
+	args := []interface{}{userID}
+	// 空白で区切った語をすべて含む注文に絞る。前方一致では最初の語だけ商品名の先頭に合わせる
+	for i, term := range strings.Fields(req.Search) {
+		pattern := "%" + escapeLike(term) + "%"
+		if req.Type == "prefix" && i == 0 {
+			pattern = escapeLike(term) + "%"
+		}
+		filters = append(filters, "COALESCE(o.product_name, p.name) LIKE ?")
+		args = append(args, pattern)
+	}
+	if len(req.Status) > 0 {
+		filters = append(filters, "o.shipped_status IN (?"+strings.Repeat(", ?", len(req.Status)-1)+")")
+		for _, status := range req.Status {
+			args = append(args, status)
+		}
+	}
+	// 作成日時は列を加工せずに比較し、(user_id, created_at)のインデックスで範囲を絞る
+	if !req.CreatedFrom.IsZero() {
+		filters = append(filters, "o.created_at >= ?")
+		args = append(args, req.CreatedFrom)
+	}
+	if !req.CreatedTo.IsZero() {
+		filters = append(filters, "o.created_at < ?")
+		args = append(args, req.CreatedTo)
+	}
+	if req.ValueMin != nil {
+		filters = append(filters, "COALESCE(o.value, p.value) >= ?")
+		args = append(args, *req.ValueMin)
+	}
+	if req.ValueMax != nil {
+		filters = append(filters, "COALESCE(o.value, p.value) <= ?")
+		args = append(args, *req.ValueMax)
+	}
+	if req.WeightMin != nil {
+		filters = append(filters, "COALESCE(o.product_weight, p.weight) >= ?")
+		args = append(args, *req.WeightMin)
+	}
+	if req.WeightMax != nil {
+		filters = append(filters, "COALESCE(o.product_weight, p.weight) <= ?")
+		args = append(args, *req.WeightMax)
+	}
+	if len(req.CategoryIDs) > 0 {
+		filter, categoryArgs := categoryFilter("o.product_id", req.CategoryIDs)
+		filters = append(filters, filter)
+		args = append(args, categoryArgs...)
+	}
+	return " WHERE " + strings.Join(filters, " AND "), args
+}
+
+// CategoryFacets はreqの絞り込みに一致するユーザーの注文の数を、商品のカテゴリごとに返す
+// カテゴリでの絞り込みは除いて数える
+func (r *OrderRepository) CategoryFacets(ctx context.Context, userID int, req model.ListRequest) ([]model.CategoryFacet, error) {
+	req.CategoryIDs = nil
+	whereClause, args := orderListFilters(userID, req)
+	from := r.orderListFrom(userID, req)
+	facets := []model.CategoryFacet{}
+	err := r.db.SelectContext(ctx, &facets, categoryFacetQuery(from, whereClause, "o.product_id"), args...)
+	return facets, err
+}
+
+// orderListOrder はreqの並び順をORDER BY句にする。同順位は注文IDの昇順で並べる
+func orderListOrder(req model.ListRequest) string {
+	return listOrderBy(req, "o.order_id")
+}
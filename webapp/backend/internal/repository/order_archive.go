@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 配送完了から一定日数が過ぎた注文の退避先
+const orderArchiveTable = "orders_archive"
+
+// ArchiveCompleted はbefore以前に配送完了した注文を、各注文テーブルから注文IDの小さい順に最大limit件ずつ退避先へ移し、移した件数を返す
+// 配送完了の日時は最後の配送完了イベントの日時とする。トランザクション内で使う
+func (r *OrderRepository) ArchiveCompleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	archived := 0
+	now := time.Now()
+	for _, table := range r.shards.all() {
+		var ids []int64
+		query := `
+			SELECT o.order_id FROM ` + table + ` o
+			WHERE o.shipped_status = 'completed'
+			  AND (SELECT MAX(e.occurred_at) FROM order_events e WHERE e.order_id = o.order_id AND e.status = 'completed') < ?
+			ORDER BY o.order_id
+			LIMIT ?`
+		if err := r.db.SelectContext(ctx, &ids, query, before, limit); err != nil {
+			return 0, err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		// 配送完了は最後の状態なので、選んでから移すまでの間にステータスは変わらない
+		insert, args, err := sqlx.In(`
+			INSERT INTO `+orderArchiveTable+` (order_id, user_id, product_id, shipped_status, priority, created_at, arrived_at, deliver_by, cancelled_at, retry_count, metadata, ship_after, assigned_robot_id, value, product_name, product_weight, product_volume, completed_at, archived_at)
+			SELECT o.order_id, o.user_id, o.product_id, o.shipped_status, o.priority, o.created_at, o.arrived_at, o.deliver_by, o.cancelled_at, o.retry_count, o.metadata, o.ship_after, o.assigned_robot_id, o.value, o.product_name, o.product_weight, o.product_volume,
+				(SELECT MAX(e.occurred_at) FROM order_events e WHERE e.order_id = o.order_id AND e.status = 'completed'), ?
+			FROM `+table+` o
+			WHERE o.order_id IN (?)`, now, ids)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := r.db.ExecContext(ctx, r.db.Rebind(insert), args...); err != nil {
+			return 0, err
+		}
+		del, args, err := sqlx.In("DELETE FROM "+table+" WHERE order_id IN (?)", ids)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := r.db.ExecContext(ctx, r.db.Rebind(del), args...); err != nil {
+			return 0, err
+		}
+		archived += len(ids)
+	}
+	return archived, nil
+}
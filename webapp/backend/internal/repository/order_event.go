@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type OrderEventRepository struct {
+	db     DBTX
+	shards orderShards
+}
+
+func NewOrderEventRepository(db DBTX) *OrderEventRepository {
+	return &OrderEventRepository{db: db, shards: defaultOrderShards}
+}
+
+// 複数の注文に同じイベントを追記する。actorはイベントを発生させた主体（不明なら空）
+// ステータスの変更では、変更前のステータスもあわせて記録する
+func (r *OrderEventRepository) Append(ctx context.Context, orderIDs []int64, eventType, status, actor string) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if eventType == model.OrderEventStatusChanged {
+		// 変更前のステータスは、射影で書き換える前の注文テーブルから読む
+		for _, group := range r.shards.groupByTable(orderIDs) {
+			query, args, err := sqlx.In(`
+			INSERT INTO order_events (order_id, event_type, status, occurred_at, actor, previous_status)
+			SELECT order_id, ?, ?, ?, ?, shipped_status FROM `+group.table+` WHERE order_id IN (?)`,
+				eventType, status, now, actor, group.orderIDs)
+			if err != nil {
+				return err
+			}
+			if _, err := r.db.ExecContext(ctx, r.db.Rebind(query), args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	placeholders := make([]string, len(orderIDs))
+	args := make([]interface{}, 0, len(orderIDs)*5)
+	for i, id := range orderIDs {
+		placeholders[i] = "(?, ?, ?, ?, ?)"
+		args = append(args, id, eventType, status, now, actor)
+	}
+	query := "INSERT INTO order_events (order_id, event_type, status, occurred_at, actor) VALUES " + strings.Join(placeholders, ", ")
+	_, err := r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// AppendDeliveryFailure は配送に失敗した注文のステータス変更を、失敗の理由とあわせて追記する
+func (r *OrderEventRepository) AppendDeliveryFailure(ctx context.Context, orderID int64, status, actor, reason string) error {
+	query := `
+		INSERT INTO order_events (order_id, event_type, status, occurred_at, actor, previous_status, reason)
+		SELECT order_id, ?, ?, ?, ?, shipped_status, ? FROM ` + r.shards.forOrder(orderID) + ` WHERE order_id = ?`
+	_, err := r.db.ExecContext(ctx, query, model.OrderEventStatusChanged, status, time.Now(), actor, reason, orderID)
+	return err
+}
+
+// 注文のイベントを発生順に取得
+func (r *OrderEventRepository) ListByOrder(ctx context.Context, orderID int64) ([]model.OrderEvent, error) {
+	events := []model.OrderEvent{}
+	query := `
+		SELECT event_id, order_id, event_type, status, occurred_at, actor, previous_status, reason
+		FROM order_events
+		WHERE order_id = ?
+		ORDER BY event_id ASC`
+	if err := r.db.SelectContext(ctx, &events, query, orderID); err != nil {
+		return nil, err
+	}
+	for i := range events {
+		events[i].ActorType = model.OrderEventActorType(events[i].Actor)
+	}
+	return events, nil
+}
+
+// 各注文の最新イベントのステータスをordersテーブルへ射影する
+// 配送完了になった注文には、まだ記録がなければ到着日時としてイベントの日時を記録する
+func (r *OrderEventRepository) Project(ctx context.Context, orderIDs []int64) error {
+	for _, group := range r.shards.groupByTable(orderIDs) {
+		query, args, err := sqlx.In(`
+		UPDATE `+group.table+` o
+		JOIN order_events e ON e.order_id = o.order_id
+		JOIN (
+			SELECT order_id, MAX(event_id) AS event_id
+			FROM order_events
+			WHERE order_id IN (?)
+			GROUP BY order_id
+		) latest ON latest.event_id = e.event_id
+		SET o.shipped_status = e.status,
+			o.arrived_at = IF(e.status = 'completed', COALESCE(o.arrived_at, e.occurred_at), o.arrived_at),
+			o.assigned_robot_id = IF(e.status = 'shipping', NULL, o.assigned_robot_id)`, group.orderIDs)
+		if err != nil {
+			return err
+		}
+		query = r.db.Rebind(query)
+		if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountCreatedBetween は期間内に作成された注文数を、作成の主体ごとに返す
+func (r *OrderEventRepository) CountCreatedBetween(ctx context.Context, from, to time.Time) (map[string]int, error) {
+	var rows []struct {
+		Actor string `db:"actor"`
+		Count int    `db:"count"`
+	}
+	query := `
+		SELECT actor, COUNT(*) AS count FROM order_events
+		WHERE status = 'shipping' AND event_type = ? AND occurred_at >= ? AND occurred_at < ?
+		GROUP BY actor`
+	if err := r.db.SelectContext(ctx, &rows, query, model.OrderEventCreated, from, to); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Actor] = row.Count
+	}
+	return counts, nil
+}
+
+// CompletedOrder は期間内に配送完了した注文と、それを運んだロボット
+type CompletedOrder struct {
+	OrderID     int64     `db:"order_id"`
+	RobotID     string    `db:"robot_id"`
+	CreatedAt   time.Time `db:"created_at"`
+	CompletedAt time.Time `db:"completed_at"`
+}
+
+// CompletedBetween は期間内に配送完了した注文を返す
+// ロボットは完了直前に配送を引き受けた(delivering)イベントの主体とする
+func (r *OrderEventRepository) CompletedBetween(ctx context.Context, from, to time.Time) ([]CompletedOrder, error) {
+	orders := []CompletedOrder{}
+	query := `
+		SELECT c.order_id,
+			COALESCE((
+				SELECT d.actor FROM order_events d
+				WHERE d.order_id = c.order_id AND d.status = 'delivering' AND d.event_id < c.event_id
+				ORDER BY d.event_id DESC LIMIT 1
+			), '') AS robot_id,
+			(SELECT MIN(x.occurred_at) FROM order_events x WHERE x.order_id = c.order_id) AS created_at,
+			c.occurred_at AS completed_at
+		FROM order_events c
+		WHERE c.status = 'completed' AND c.event_type = ? AND c.occurred_at >= ? AND c.occurred_at < ?`
+	if err := r.db.SelectContext(ctx, &orders, query, model.OrderEventStatusChanged, from, to); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// CountStuckDelivering はbefore以前に配送中になったまま完了していない注文数を返す
+func (r *OrderEventRepository) CountStuckDelivering(ctx context.Context, before time.Time) (int, error) {
+	var count int
+	query := `
+		SELECT COUNT(*) FROM order_events e
+		JOIN (
+			SELECT order_id, MAX(event_id) AS event_id FROM order_events GROUP BY order_id
+		) latest ON latest.event_id = e.event_id
+		WHERE e.status = 'delivering' AND e.occurred_at < ?`
+	if err := r.db.GetContext(ctx, &count, query, before); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeliveringOrderIDs は最新のイベントが配送中(delivering)の注文IDを返す
+// robotIDを指定するとそのロボットが引き受けた注文に、beforeを指定するとbefore以前に引き受けた注文に絞る
+func (r *OrderEventRepository) DeliveringOrderIDs(ctx context.Context, robotID string, before time.Time) ([]int64, error) {
+	ids := []int64{}
+	query := `
+		SELECT e.order_id FROM order_events e
+		JOIN (
+			SELECT order_id, MAX(event_id) AS event_id FROM order_events GROUP BY order_id
+		) latest ON latest.event_id = e.event_id
+		WHERE e.status = 'delivering'`
+	var args []interface{}
+	if robotID != "" {
+		query += " AND e.actor = ?"
+		args = append(args, robotID)
+	}
+	if !before.IsZero() {
+		query += " AND e.occurred_at < ?"
+		args = append(args, before)
+	}
+	query += " ORDER BY e.order_id"
+	if err := r.db.SelectContext(ctx, &ids, query, args...); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// RecentlyCompleted は直近に配送完了した注文IDを新しい順にlimit件返す
+func (r *OrderEventRepository) RecentlyCompleted(ctx context.Context, limit int) ([]int64, error) {
+	ids := []int64{}
+	query := `
+		SELECT order_id FROM order_events
+		WHERE status = 'completed' AND event_type = ?
+		ORDER BY event_id DESC LIMIT ?`
+	if err := r.db.SelectContext(ctx, &ids, query, model.OrderEventStatusChanged, limit); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
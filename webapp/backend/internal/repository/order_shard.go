@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// シャーディング時、各シャードの注文IDはこの幅の範囲に割り当てる
+// シャードkの注文IDは [k*OrderShardIDSpan, (k+1)*OrderShardIDSpan) となり、IDからシャードを特定できる
+const OrderShardIDSpan int64 = 1_000_000_000_000
+
+// orderShards は注文テーブルの物理テーブルへの振り分けを決める
+// シャード数が1以下のときは従来どおり orders テーブルのみを使う
+type orderShards struct {
+	n int
+}
+
+// ORDER_SHARDS で指定したシャード数。起動時に一度だけ読み込む
+var defaultOrderShards = loadOrderShards()
+
+func loadOrderShards() orderShards {
+	n, err := strconv.Atoi(os.Getenv("ORDER_SHARDS"))
+	if err != nil || n < 1 {
+		n = 1
+	}
+	return orderShards{n: n}
+}
+
+func (s orderShards) enabled() bool {
+	return s.n > 1
+}
+
+// OrderShardTable はシャード番号に対応する物理テーブル名を返す
+func OrderShardTable(shard int) string {
+	return fmt.Sprintf("orders_%d", shard)
+}
+
+// ユーザーの注文が格納されるテーブル
+func (s orderShards) forUser(userID int) string {
+	if !s.enabled() {
+		return "orders"
+	}
+	return OrderShardTable(userID % s.n)
+}
+
+// 注文IDからその注文が格納されるテーブルを求める
+func (s orderShards) forOrder(orderID int64) string {
+	if !s.enabled() {
+		return "orders"
+	}
+	shard := int(orderID / OrderShardIDSpan)
+	if shard >= s.n {
+		shard = s.n - 1
+	}
+	return OrderShardTable(shard)
+}
+
+// すべての注文テーブル
+func (s orderShards) all() []string {
+	if !s.enabled() {
+		return []string{"orders"}
+	}
+	tables := make([]string, s.n)
+	for i := range tables {
+		tables[i] = OrderShardTable(i)
+	}
+	return tables
+}
+
+// orderShardGroup は同じテーブルに格納される注文IDのまとまり
+type orderShardGroup struct {
+	table    string
+	orderIDs []int64
+}
+
+// 注文IDを格納テーブルごとにまとめる
+// ロック順を一定にしてデッドロックを避けるため、シャード番号順に返す
+func (s orderShards) groupByTable(orderIDs []int64) []orderShardGroup {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	if !s.enabled() {
+		return []orderShardGroup{{table: "orders", orderIDs: orderIDs}}
+	}
+	byTable := make(map[string][]int64)
+	for _, id := range orderIDs {
+		table := s.forOrder(id)
+		byTable[table] = append(byTable[table], id)
+	}
+	groups := make([]orderShardGroup, 0, len(byTable))
+	for _, table := range s.all() {
+		if ids, ok := byTable[table]; ok {
+			groups = append(groups, orderShardGroup{table: table, orderIDs: ids})
+		}
+	}
+	return groups
+}
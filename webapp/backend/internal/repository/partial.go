@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type partialResultsKey struct{}
+
+// WithPartialResults は期限が迫った一覧取得で、それまでに読み込んだ行だけを返すことを許可する
+// 読み込みは期限のreserve前に打ち切り、応答を組み立てて返す時間を残す
+func WithPartialResults(ctx context.Context, reserve time.Duration) context.Context {
+	return context.WithValue(ctx, partialResultsKey{}, reserve)
+}
+
+// partialContext は部分結果が許可されていれば、読み込みを打ち切る期限を設けたコンテキストを返す
+func partialContext(ctx context.Context) (context.Context, context.CancelFunc, bool) {
+	reserve, ok := ctx.Value(partialResultsKey{}).(time.Duration)
+	deadline, hasDeadline := ctx.Deadline()
+	if !ok || !hasDeadline {
+		return ctx, func() {}, false
+	}
+	scanCtx, cancel := context.WithDeadline(ctx, deadline.Add(-reserve))
+	return scanCtx, cancel, true
+}
+
+// selectPartial はSelectContextと同様に結果をdestに読み込む
+// 部分結果が許可されていて打ち切りの期限に達した場合は、読み込み済みの行を残してtrueを返す
+func selectPartial[T any](ctx context.Context, db DBTX, dest *[]T, query string, args ...interface{}) (bool, error) {
+	scanCtx, cancel, allowed := partialContext(ctx)
+	defer cancel()
+	if !allowed {
+		return false, db.SelectContext(ctx, dest, query, args...)
+	}
+
+	rows, err := db.QueryxContext(scanCtx, query, args...)
+	if err != nil {
+		if scanCtx.Err() != nil && ctx.Err() == nil {
+			return true, nil
+		}
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v T
+		if err := rows.StructScan(&v); err != nil {
+			return false, err
+		}
+		*dest = append(*dest, v)
+	}
+	if err := rows.Err(); err != nil {
+		if scanCtx.Err() != nil && ctx.Err() == nil {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type PasswordResetRepository struct {
+	db DBTX
+}
+
+func NewPasswordResetRepository(db DBTX) *PasswordResetRepository {
+	return &PasswordResetRepository{db: db}
+}
+
+// Create はハッシュがtokenHashのトークンを、expiresAtまで使えるように保存する
+func (r *PasswordResetRepository) Create(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, ?)", userID, tokenHash, expiresAt)
+	return err
+}
+
+// LockValid はハッシュがtokenHashで、使っておらず期限も切れていないトークンのユーザーIDを返す
+// 同じトークンを同時に使えないよう行ロックを取るので、トランザクション内で呼ぶこと
+func (r *PasswordResetRepository) LockValid(ctx context.Context, tokenHash string, now time.Time) (int, error) {
+	var userID int
+	query := "SELECT user_id FROM password_reset_tokens WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? FOR UPDATE"
+	err := r.db.GetContext(ctx, &userID, query, tokenHash, now)
+	return userID, err
+}
+
+// UseAllForUser はユーザーの使っていないトークンをすべて使用済みにする
+func (r *PasswordResetRepository) UseAllForUser(ctx context.Context, userID int, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at = ? WHERE user_id = ? AND used_at IS NULL", at, userID)
+	return err
+}
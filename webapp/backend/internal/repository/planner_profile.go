@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+)
+
+type PlannerProfileRepository struct {
+	db DBTX
+}
+
+func NewPlannerProfileRepository(db DBTX) *PlannerProfileRepository {
+	return &PlannerProfileRepository{db: db}
+}
+
+func (r *PlannerProfileRepository) ListProfiles(ctx context.Context) ([]model.PlannerProfile, error) {
+	profiles := []model.PlannerProfile{}
+	query := `
+		SELECT name, algorithm, epsilon, fairness_mode, aging_boost, zero_weight_cap, zero_weight_policy, rollout_percent, updated_at
+		FROM planner_profiles ORDER BY name`
+	if err := r.db.SelectContext(ctx, &profiles, query); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// プロファイルを保存する。同名のプロファイルがあれば置き換える
+func (r *PlannerProfileRepository) UpsertProfile(ctx context.Context, p model.PlannerProfile) error {
+	query := `
+		INSERT INTO planner_profiles (name, algorithm, epsilon, fairness_mode, aging_boost, zero_weight_cap, zero_weight_policy, rollout_percent, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE algorithm = VALUES(algorithm), epsilon = VALUES(epsilon), fairness_mode = VALUES(fairness_mode),
+			aging_boost = VALUES(aging_boost), zero_weight_cap = VALUES(zero_weight_cap), zero_weight_policy = VALUES(zero_weight_policy),
+			rollout_percent = VALUES(rollout_percent), updated_at = VALUES(updated_at)`
+	_, err := r.db.ExecContext(ctx, query, p.Name, p.Algorithm, p.Epsilon, p.FairnessMode, p.AgingBoost, p.ZeroWeightCap, p.ZeroWeightPolicy, p.RolloutPercent)
+	return err
+}
+
+func (r *PlannerProfileRepository) ListAssignments(ctx context.Context) ([]model.PlannerAssignment, error) {
+	assignments := []model.PlannerAssignment{}
+	query := "SELECT robot_id, profile_name, updated_at FROM planner_assignments ORDER BY robot_id"
+	if err := r.db.SelectContext(ctx, &assignments, query); err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// ロボットにプロファイルを割り当てる。profileNameが空なら割り当てを外す
+func (r *PlannerProfileRepository) SetAssignment(ctx context.Context, robotID, profileName string) error {
+	if profileName == "" {
+		_, err := r.db.ExecContext(ctx, "DELETE FROM planner_assignments WHERE robot_id = ?", robotID)
+		return err
+	}
+	query := `
+		INSERT INTO planner_assignments (robot_id, profile_name, updated_at) VALUES (?, ?, NOW())
+		ON DUPLICATE KEY UPDATE profile_name = VALUES(profile_name), updated_at = VALUES(updated_at)`
+	_, err := r.db.ExecContext(ctx, query, robotID, profileName)
+	return err
+}
@@ -3,8 +3,12 @@ package repository
 import (
 	"backend/internal/model"
 	"context"
-	"fmt"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
 )
 
 type ProductRepository struct {
@@ -15,25 +19,62 @@ func NewProductRepository(db DBTX) *ProductRepository {
 	return &ProductRepository{db: db}
 }
 
+// WeightsByID は商品IDごとの重さを返す。存在しない商品と削除した商品は含まない
+func (r *ProductRepository) WeightsByID(ctx context.Context, productIDs []int) (map[int]model.Grams, error) {
+	weights := make(map[int]model.Grams, len(productIDs))
+	if len(productIDs) == 0 {
+		return weights, nil
+	}
+	query, args, err := sqlx.In("SELECT product_id, weight FROM products WHERE product_id IN (?) AND deleted_at IS NULL", productIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ProductID int         `db:"product_id"`
+		Weight    model.Grams `db:"weight"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		weights[row.ProductID] = row.Weight
+	}
+	return weights, nil
+}
+
+// 全文検索できる語の最短の文字数。conf.d/my.cnfのngram_token_sizeと合わせる
+// これより短い語はngramの索引に載らないため、LIKEでの検索に切り替える
+const fulltextMinTermLength = 5
+
+// 商品一覧などで返す商品の列
+const productColumns = "product_id, name, value, weight, volume, image, description, stock, popularity"
+
 // 商品一覧を取得（検索・ソート・ページングはDB側で実施）
-func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req model.ListRequest) ([]model.Product, int, error) {
+// 部分結果が許可されていて期限が迫った場合は、読み込み済みの商品だけを返しpartialをtrueにする
+func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req model.ListRequest) (products []model.Product, total int, partial bool, err error) {
 	var (
-		products []model.Product
-		total    int
+		listPartial  bool
+		countPartial bool
 	)
 
-	filters := ""
-	args := []interface{}{}
-	if req.Search != "" {
-		filters = " WHERE (name LIKE ? OR description LIKE ?)"
-		searchPattern := "%" + req.Search + "%"
-		args = append(args, searchPattern, searchPattern)
-	}
+	filters, args := productListFilters(req)
 
-	orderClause := fmt.Sprintf(" ORDER BY %s %s, product_id ASC", req.SortField, req.SortOrder)
-	query := "SELECT product_id, name, value, weight, image, description FROM products" + filters + orderClause + " LIMIT ? OFFSET ?"
-	listArgs := append([]interface{}{}, args...)
+	orderClause := listOrderBy(req, "product_id")
+	columns := productColumns + ", " + favoriteColumn
+	if len(req.Columns) > 0 {
+		columns = strings.Join(req.Columns, ", ")
+	}
+	// 一覧では利用者のお気に入りかどうかも返すため、常にお気に入りをつなぐ
+	listFilters, listArgs := filters, append([]interface{}{userID}, args...)
+	if req.After != nil {
+		condition, keysetArgs := productKeysetCondition(req)
+		listFilters += " AND " + condition
+		listArgs = append(listArgs, keysetArgs...)
+	}
+	query := "SELECT " + columns + " FROM products" + favoritesJoin + listFilters + orderClause + " LIMIT ? OFFSET ?"
 	listArgs = append(listArgs, req.PageSize, req.Offset)
+	from, fromArgs := productListFrom(userID, req)
+	countArgs := append(fromArgs, args...)
 
 	errCh := make(chan error, 2)
 	ctx, cancel := context.WithCancel(ctx)
@@ -44,8 +85,15 @@ func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req mo
 
 	go func() {
 		defer wg.Done()
-		countQuery := "SELECT COUNT(*) FROM products" + filters
-		if err := r.db.GetContext(ctx, &total, countQuery, args...); err != nil {
+		countQuery := "SELECT COUNT(*) FROM " + from + filters
+		countCtx, countCancel, allowed := partialContext(ctx)
+		defer countCancel()
+		if err := r.db.GetContext(countCtx, &total, countQuery, countArgs...); err != nil {
+			// 件数が取れなくても、読み込めた商品は返す
+			if allowed && countCtx.Err() != nil && ctx.Err() == nil {
+				countPartial = true
+				return
+			}
 			errCh <- err
 			cancel()
 		}
@@ -53,7 +101,9 @@ func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req mo
 
 	go func() {
 		defer wg.Done()
-		if err := r.db.SelectContext(ctx, &products, query, listArgs...); err != nil {
+		var err error
+		listPartial, err = selectPartial(ctx, r.db, &products, query, listArgs...)
+		if err != nil {
 			errCh <- err
 			cancel()
 		}
@@ -63,13 +113,309 @@ func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req mo
 	close(errCh)
 	for err := range errCh {
 		if err != nil {
-			return nil, 0, err
+			return nil, 0, false, err
+		}
+	}
+
+	if countPartial {
+		total = req.Offset + len(products)
+	}
+	partial = listPartial || countPartial
+	if products == nil {
+		products = []model.Product{}
+	}
+	if total == 0 && !partial {
+		return []model.Product{}, 0, false, nil
+	}
+
+	return products, total, partial, nil
+}
+
+// Create は商品を追加し、採番した商品IDをproductに設定する
+func (r *ProductRepository) Create(ctx context.Context, product *model.Product) error {
+	query := "INSERT INTO products (name, value, weight, volume, image, description, stock, low_stock_threshold) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	result, err := r.db.ExecContext(ctx, query, product.Name, product.Value, product.Weight, product.Volume, product.Image, product.Description, product.Stock, product.LowStockThreshold)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	product.ProductID = int(id)
+	return nil
+}
+
+// LockByID は削除した商品を含め、商品に行ロックを取って読む。存在しなければsql.ErrNoRowsを返す。トランザクション内で使う
+func (r *ProductRepository) LockByID(ctx context.Context, productID int) (model.Product, error) {
+	var product model.Product
+	query := "SELECT product_id, name, value, weight, volume, image, description, stock, low_stock_threshold, deleted_at FROM products WHERE product_id = ? FOR UPDATE"
+	err := r.db.GetContext(ctx, &product, query, productID)
+	return product, err
+}
+
+// Upsert は商品をまとめて追加する。商品IDを指定した商品は、同じIDの商品があればすべての項目を置き換える
+// 商品IDが0の商品は新しいIDを採番する（採番したIDはproductsに設定しない）
+func (r *ProductRepository) Upsert(ctx context.Context, products []model.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(products))
+	args := make([]interface{}, 0, len(products)*9)
+	for i, p := range products {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		var productID interface{}
+		if p.ProductID != 0 {
+			productID = p.ProductID
+		}
+		args = append(args, productID, p.Name, p.Value, p.Weight, p.Volume, p.Image, p.Description, p.Stock, p.LowStockThreshold)
+	}
+	query := "INSERT INTO products (product_id, name, value, weight, volume, image, description, stock, low_stock_threshold) VALUES " +
+		strings.Join(placeholders, ", ") +
+		" ON DUPLICATE KEY UPDATE name = VALUES(name), value = VALUES(value), weight = VALUES(weight), volume = VALUES(volume)," +
+		" image = VALUES(image), description = VALUES(description), stock = VALUES(stock), low_stock_threshold = VALUES(low_stock_threshold)"
+	_, err := r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RecordValueChanges は商品の現在の価値を価値の履歴に反映する。productIDsが空なら全商品を対象にする
+// 価値が変わった商品は現在の履歴をatで閉じ、現在の履歴がない商品にatからの履歴を加える
+func (r *ProductRepository) RecordValueChanges(ctx context.Context, productIDs []int, at time.Time) error {
+	filter, args := "", []interface{}{}
+	if len(productIDs) > 0 {
+		var err error
+		filter, args, err = sqlx.In(" AND p.product_id IN (?)", productIDs)
+		if err != nil {
+			return err
+		}
+	}
+	closeQuery := `
+		UPDATE product_value_history h
+		JOIN products p ON h.product_id = p.product_id
+		SET h.effective_to = ?
+		WHERE h.effective_to IS NULL AND h.value <> p.value` + filter
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(closeQuery), append([]interface{}{at}, args...)...); err != nil {
+		return err
+	}
+	openQuery := `
+		INSERT INTO product_value_history (product_id, value, effective_from)
+		SELECT p.product_id, p.value, ? FROM products p
+		LEFT JOIN product_value_history h ON h.product_id = p.product_id AND h.effective_to IS NULL
+		WHERE h.history_id IS NULL` + filter
+	_, err := r.db.ExecContext(ctx, r.db.Rebind(openQuery), append([]interface{}{at}, args...)...)
+	return err
+}
+
+// ValueHistory は商品の価値の履歴を古い順に返す
+func (r *ProductRepository) ValueHistory(ctx context.Context, productID int) ([]model.ProductValueChange, error) {
+	history := []model.ProductValueChange{}
+	query := "SELECT value, effective_from, effective_to FROM product_value_history WHERE product_id = ? ORDER BY effective_from, history_id"
+	if err := r.db.SelectContext(ctx, &history, query, productID); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// GetByID は商品を1件返す。存在しないか削除した商品ならsql.ErrNoRowsを返す
+func (r *ProductRepository) GetByID(ctx context.Context, productID int) (model.Product, error) {
+	var product model.Product
+	err := r.db.GetContext(ctx, &product, "SELECT "+productColumns+" FROM products WHERE product_id = ? AND deleted_at IS NULL", productID)
+	return product, err
+}
+
+// GetByIDs は商品IDの商品を返す。存在しない商品と削除した商品は含まず、順序は問わない
+func (r *ProductRepository) GetByIDs(ctx context.Context, productIDs []int) ([]model.Product, error) {
+	products := []model.Product{}
+	if len(productIDs) == 0 {
+		return products, nil
+	}
+	query, args, err := sqlx.In("SELECT "+productColumns+" FROM products WHERE product_id IN (?) AND deleted_at IS NULL", productIDs)
+	if err != nil {
+		return nil, err
+	}
+	err = r.db.SelectContext(ctx, &products, r.db.Rebind(query), args...)
+	return products, err
+}
+
+// TopSellers は注文数の多い順にlimit件の商品を返す。excludeの商品と削除した商品は含めない
+func (r *ProductRepository) TopSellers(ctx context.Context, exclude []int, limit int) ([]model.Product, error) {
+	products := []model.Product{}
+	query, args := "SELECT "+productColumns+" FROM products WHERE deleted_at IS NULL", []interface{}{}
+	if len(exclude) > 0 {
+		var err error
+		query, args, err = sqlx.In(query+" AND product_id NOT IN (?)", exclude)
+		if err != nil {
+			return nil, err
 		}
 	}
+	query += " ORDER BY popularity DESC, product_id LIMIT ?"
+	err := r.db.SelectContext(ctx, &products, r.db.Rebind(query), append(args, limit)...)
+	return products, err
+}
+
+// ImageByID は商品の画像パスを返す。商品が存在しなければsql.ErrNoRowsを返す
+// 削除した商品も過去の注文に表示するため、画像は返す
+func (r *ProductRepository) ImageByID(ctx context.Context, productID int) (string, error) {
+	var image string
+	err := r.db.GetContext(ctx, &image, "SELECT image FROM products WHERE product_id = ?", productID)
+	return image, err
+}
+
+// Update は商品のすべての項目をproductの内容で置き換える
+func (r *ProductRepository) Update(ctx context.Context, product model.Product) error {
+	query := "UPDATE products SET name = ?, value = ?, weight = ?, volume = ?, image = ?, description = ?, stock = ?, low_stock_threshold = ? WHERE product_id = ?"
+	_, err := r.db.ExecContext(ctx, query, product.Name, product.Value, product.Weight, product.Volume, product.Image, product.Description, product.Stock, product.LowStockThreshold, product.ProductID)
+	return err
+}
+
+// SoftDelete は商品を削除した日時を記録する。注文から参照できるよう行は残す
+func (r *ProductRepository) SoftDelete(ctx context.Context, productID int, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE products SET deleted_at = ? WHERE product_id = ?", at, productID)
+	return err
+}
+
+// Restore は削除した商品を元に戻す
+func (r *ProductRepository) Restore(ctx context.Context, productID int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE products SET deleted_at = NULL WHERE product_id = ?", productID)
+	return err
+}
+
+// productListFilters はreqの検索とカテゴリでの絞り込みをWHERE句とその引数にする。削除した商品は常に除く
+func productListFilters(req model.ListRequest) (string, []interface{}) {
+	filters := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	// 空白で区切った語をすべて含む商品に絞る。語ごとに商品名か説明のどちらかに含まれればよい
+	for _, term := range strings.Fields(req.Search) {
+		if req.Type == "fulltext" && utf8.RuneCountInString(term) >= fulltextMinTermLength {
+			filters = append(filters, "MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE)")
+			args = append(args, term)
+		} else {
+			filters = append(filters, "(name LIKE ? OR description LIKE ?)")
+			searchPattern := "%" + escapeLike(term) + "%"
+			args = append(args, searchPattern, searchPattern)
+		}
+	}
+	if len(req.CategoryIDs) > 0 {
+		filter, categoryArgs := categoryFilter("product_id", req.CategoryIDs)
+		filters = append(filters, filter)
+		args = append(args, categoryArgs...)
+	}
+	// タグでの絞り込み。カテゴリごとの件数を数えるときはproduct_categoriesもつなぐため、商品IDの列を修飾する
+	if len(req.Tags) > 0 {
+		filter, tagArgs := tagFilter("products.product_id", req.Tags)
+		filters = append(filters, filter)
+		args = append(args, tagArgs...)
+	}
+	// お気に入りでの絞り込みは、productListFromでお気に入りをつないでいること
+	if req.FavoritesOnly {
+		filters = append(filters, "f.favorite_product_id IS NOT NULL")
+	}
+	return " WHERE " + strings.Join(filters, " AND "), args
+}
+
+// productListFrom は商品一覧の件数を数えるFROM句とその引数を返す
+// お気に入りで絞り込む場合だけ、利用者のお気に入りをつなぐ
+func productListFrom(userID int, req model.ListRequest) (string, []interface{}) {
+	if !req.FavoritesOnly {
+		return "products", nil
+	}
+	return "products" + favoritesJoin, []interface{}{userID}
+}
+
+// productKeysetCondition はreq.Afterの位置より後の商品に絞る条件と、その引数を返す
+// 並び替えの列が同じ商品は、listOrderByが補う商品IDの昇順で並ぶ
+func productKeysetCondition(req model.ListRequest) (string, []interface{}) {
+	op := ">"
+	if req.SortOrder == "DESC" {
+		op = "<"
+	}
+	if req.SortField == "product_id" {
+		return "product_id " + op + " ?", []interface{}{req.After.ID}
+	}
+	return "(" + req.SortField + " " + op + " ? OR (" + req.SortField + " = ? AND product_id > ?))",
+		[]interface{}{req.After.Value, req.After.Value, req.After.ID}
+}
+
+// CategoryFacets はreqの検索に一致する商品の数をカテゴリごとに返す。カテゴリでの絞り込みは除いて数える
+func (r *ProductRepository) CategoryFacets(ctx context.Context, userID int, req model.ListRequest) ([]model.CategoryFacet, error) {
+	req.CategoryIDs = nil
+	filters, args := productListFilters(req)
+	from, fromArgs := productListFrom(userID, req)
+	facets := []model.CategoryFacet{}
+	err := r.db.SelectContext(ctx, &facets, categoryFacetQuery(from, filters, "products.product_id"), append(fromArgs, args...)...)
+	return facets, err
+}
 
-	if total == 0 {
-		return []model.Product{}, 0, nil
+// DecrementStock は在庫がn以上あればnだけ減らしてtrueを返す。足りなければ減らさずfalseを返す
+func (r *ProductRepository) DecrementStock(ctx context.Context, productID, n int) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE products SET stock = stock - ? WHERE product_id = ? AND stock >= ?", n, productID, n)
+	if err != nil {
+		return false, err
 	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// IncrementStock は在庫をnだけ増やす
+func (r *ProductRepository) IncrementStock(ctx context.Context, productID, n int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE products SET stock = stock + ? WHERE product_id = ?", n, productID)
+	return err
+}
 
-	return products, total, nil
+// LowStock は削除していない商品のうち、在庫が閾値を下回った商品を商品IDの順に返す
+func (r *ProductRepository) LowStock(ctx context.Context) ([]model.LowStockProduct, error) {
+	products := []model.LowStockProduct{}
+	query := "SELECT product_id, name, stock, low_stock_threshold FROM products WHERE stock < low_stock_threshold AND deleted_at IS NULL ORDER BY product_id"
+	err := r.db.SelectContext(ctx, &products, query)
+	return products, err
+}
+
+// Popularities は全商品の現在の注文数を返す
+func (r *ProductRepository) Popularities(ctx context.Context) (map[int]int, error) {
+	var rows []struct {
+		ProductID  int `db:"product_id"`
+		Popularity int `db:"popularity"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, "SELECT product_id, popularity FROM products"); err != nil {
+		return nil, err
+	}
+	popularities := make(map[int]int, len(rows))
+	for _, row := range rows {
+		popularities[row.ProductID] = row.Popularity
+	}
+	return popularities, nil
+}
+
+// ActivePopularities は削除していない商品の現在の注文数を返す
+func (r *ProductRepository) ActivePopularities(ctx context.Context) (map[int]int, error) {
+	var rows []struct {
+		ProductID  int `db:"product_id"`
+		Popularity int `db:"popularity"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, "SELECT product_id, popularity FROM products WHERE deleted_at IS NULL"); err != nil {
+		return nil, err
+	}
+	popularities := make(map[int]int, len(rows))
+	for _, row := range rows {
+		popularities[row.ProductID] = row.Popularity
+	}
+	return popularities, nil
+}
+
+// SetPopularity は商品IDごとの注文数を1回のUPDATEで書き込む
+func (r *ProductRepository) SetPopularity(ctx context.Context, popularities map[int]int) error {
+	if len(popularities) == 0 {
+		return nil
+	}
+	var cases strings.Builder
+	args := make([]interface{}, 0, len(popularities)*3)
+	ids := make([]interface{}, 0, len(popularities))
+	for productID, popularity := range popularities {
+		cases.WriteString(" WHEN ? THEN ?")
+		args = append(args, productID, popularity)
+		ids = append(ids, productID)
+	}
+	query := "UPDATE products SET popularity = CASE product_id" + cases.String() + " END WHERE product_id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	_, err := r.db.ExecContext(ctx, query, append(args, ids...)...)
+	return err
 }
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type RecommendationRepository struct {
+	db DBTX
+}
+
+func NewRecommendationRepository(db DBTX) *RecommendationRepository {
+	return &RecommendationRepository{db: db}
+}
+
+// Replace はすべてのおすすめをrecsで置き換える。batchSize件ずつINSERTする。トランザクション内で使う
+func (r *RecommendationRepository) Replace(ctx context.Context, recs []model.ProductRecommendation, batchSize int) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM product_recommendations"); err != nil {
+		return err
+	}
+	for start := 0; start < len(recs); start += batchSize {
+		batch := recs[start:min(start+batchSize, len(recs))]
+		args := make([]interface{}, 0, len(batch)*3)
+		for _, rec := range batch {
+			args = append(args, rec.ProductID, rec.RecommendedProductID, rec.Score)
+		}
+		query := "INSERT INTO product_recommendations (product_id, recommended_product_id, score) VALUES (?, ?, ?)" +
+			strings.Repeat(", (?, ?, ?)", len(batch)-1)
+		if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForProducts はproductIDsの商品と一緒に注文されることの多い商品を、スコアの合計の高い順にlimit件返す
+// productIDsの商品自体と削除した商品は含めない
+func (r *RecommendationRepository) ForProducts(ctx context.Context, productIDs []int, limit int) ([]model.Product, error) {
+	products := []model.Product{}
+	if len(productIDs) == 0 {
+		return products, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT `+productColumns+`
+		FROM (
+			SELECT recommended_product_id, SUM(score) AS score
+			FROM product_recommendations
+			WHERE product_id IN (?) AND recommended_product_id NOT IN (?)
+			GROUP BY recommended_product_id
+			ORDER BY score DESC, recommended_product_id
+			LIMIT ?
+		) r
+		JOIN products p ON p.product_id = r.recommended_product_id AND p.deleted_at IS NULL
+		ORDER BY r.score DESC, p.product_id`, productIDs, productIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+	err = r.db.SelectContext(ctx, &products, r.db.Rebind(query), args...)
+	return products, err
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"time"
+)
+
+type RobotRepository struct {
+	db DBTX
+}
+
+func NewRobotRepository(db DBTX) *RobotRepository {
+	return &RobotRepository{db: db}
+}
+
+// 登録済みのロボットを取得する。登録がなければsql.ErrNoRowsを返す
+func (r *RobotRepository) FindByID(ctx context.Context, robotID string) (*model.Robot, error) {
+	var robot model.Robot
+	query := "SELECT robot_id, max_capacity, status, last_heartbeat_at FROM robots WHERE robot_id = ?"
+	if err := r.db.GetContext(ctx, &robot, query, robotID); err != nil {
+		return nil, err
+	}
+	return &robot, nil
+}
+
+// Heartbeat はロボットの生存の通知を記録する。登録がなければsql.ErrNoRowsを返す
+func (r *RobotRepository) Heartbeat(ctx context.Context, robotID string, at time.Time) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE robots SET last_heartbeat_at = ? WHERE robot_id = ?", at, robotID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		// 同じ時刻で更新した場合も0件になるため、登録の有無は改めて確かめる
+		if _, err := r.FindByID(ctx, robotID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSilent はbefore以降に生存を通知していないロボットのIDを返す。一度も通知していないロボットは含まない
+func (r *RobotRepository) ListSilent(ctx context.Context, before time.Time) ([]string, error) {
+	ids := []string{}
+	query := "SELECT robot_id FROM robots WHERE last_heartbeat_at < ? ORDER BY robot_id"
+	if err := r.db.SelectContext(ctx, &ids, query, before); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
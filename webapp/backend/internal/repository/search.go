@@ -0,0 +1,12 @@
+package repository
+
+import "strings"
+
+// likeEscaper はLIKEのパターンで特別な意味を持つ文字を、既定のエスケープ文字（\）でエスケープする
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike は検索語をLIKEのパターンにそのまま埋め込めるようにする
+// 利用者の入力した%や_をワイルドカードとして扱わない
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -2,17 +2,35 @@ package repository
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
+	"backend/internal/model"
+
 	"github.com/google/uuid"
 )
 
 type SessionRepository struct {
 	db    DBTX
 	cache *sessionCache
+	// 有効期限の残りがthresholdを切ったセッションは、今からdurationまで延長する
+	// thresholdが0なら延長しない。SetSlidingで設定する
+	duration  time.Duration
+	threshold time.Duration
+	// 延長待ちのセッションIDと、前回から使われたセッションID
+	// リクエストごとに書き込まないようFlushExtensionsでまとめて書き込む
+	pendingMx sync.Mutex
+	pending   map[string]struct{}
+	seen      map[string]struct{}
 }
 
+// 1回のUPDATEで書き換えるセッションの数の上限
+const sessionExtendBatch = 500
+
+// user_sessions.user_agentの長さの上限
+const maxUserAgentLength = 255
+
 type sessionCache struct {
 	mx         sync.RWMutex
 	entries    map[string]cachedSession
@@ -21,13 +39,13 @@ type sessionCache struct {
 }
 
 type cachedSession struct {
-	userID    int
+	user      model.SessionUser
 	expiresAt time.Time
 }
 
 func NewSessionRepository(db DBTX) *SessionRepository {
 	cache := newSessionCache(300*time.Millisecond, 1000)
-	return &SessionRepository{db: db, cache: cache}
+	return &SessionRepository{db: db, cache: cache, pending: make(map[string]struct{}), seen: make(map[string]struct{})}
 }
 
 func newSessionCache(ttl time.Duration, maxEntries int) *sessionCache {
@@ -39,7 +57,8 @@ func newSessionCache(ttl time.Duration, maxEntries int) *sessionCache {
 }
 
 // セッションを作成し、セッションIDと有効期限を返す
-func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, duration time.Duration) (string, time.Time, error) {
+// ユーザーの役割はセッションに複製しておき、認証のたびにusersを引かないようにする
+func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, role model.Role, duration time.Duration, client model.ClientInfo) (string, time.Time, error) {
 	sessionUUID, err := uuid.NewRandom()
 	if err != nil {
 		return "", time.Time{}, err
@@ -47,8 +66,13 @@ func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, dura
 	expiresAt := time.Now().Add(duration)
 	sessionIDStr := sessionUUID.String()
 
-	query := "INSERT INTO user_sessions (session_uuid, user_id, expires_at) VALUES (?, ?, ?)"
-	_, err = r.db.ExecContext(ctx, query, sessionIDStr, userBusinessID, expiresAt)
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	now := time.Now()
+	query := "INSERT INTO user_sessions (session_uuid, user_id, role, expires_at, user_agent, ip, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = r.db.ExecContext(ctx, query, sessionIDStr, userBusinessID, role, expiresAt, userAgent, client.IP, now, now)
 	if err != nil {
 		return "", time.Time{}, err
 	}
@@ -57,29 +81,204 @@ func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, dura
 
 // セッションIDからユーザーIDを取得
 func (r *SessionRepository) FindUserBySessionID(ctx context.Context, sessionID string) (int, error) {
+	user, err := r.FindSession(ctx, sessionID)
+	return user.UserID, err
+}
+
+// セッションIDからユーザーIDと役割を取得
+func (r *SessionRepository) FindSession(ctx context.Context, sessionID string) (model.SessionUser, error) {
 	// キャッシュから確認
-	if cachedUserID := r.cache.get(sessionID); cachedUserID != 0 {
-		return cachedUserID, nil
+	if cached, ok := r.cache.get(sessionID); ok {
+		r.markSeen(sessionID)
+		return cached, nil
 	}
 
-	var userID int
+	var session struct {
+		model.SessionUser
+		ExpiresAt time.Time `db:"expires_at"`
+	}
 	// JOINを避けて直接セッションテーブルから検索（パフォーマンス最適化）
 	query := `
-		SELECT user_id
+		SELECT user_id, role, expires_at
 		FROM user_sessions
 		WHERE session_uuid = ? AND expires_at > ?`
-	err := r.db.GetContext(ctx, &userID, query, sessionID, time.Now())
+	err := r.db.GetContext(ctx, &session, query, sessionID, time.Now())
 	if err != nil {
-		return 0, err
+		return model.SessionUser{}, err
+	}
+	if r.threshold > 0 && time.Until(session.ExpiresAt) < r.threshold {
+		r.pendingMx.Lock()
+		r.pending[sessionID] = struct{}{}
+		r.pendingMx.Unlock()
 	}
 
 	// キャッシュに保存
-	r.cache.set(sessionID, userID)
+	r.cache.set(sessionID, session.SessionUser)
+	r.markSeen(sessionID)
+
+	return session.SessionUser, nil
+}
+
+func (r *SessionRepository) markSeen(sessionID string) {
+	r.pendingMx.Lock()
+	r.seen[sessionID] = struct{}{}
+	r.pendingMx.Unlock()
+}
+
+// SetSliding はセッションの有効期限の延長を設定する。起動時に一度だけ呼び出すこと
+func (r *SessionRepository) SetSliding(duration, threshold time.Duration) {
+	r.duration = duration
+	r.threshold = threshold
+}
+
+// PendingExpiry は延長待ちのセッションなら延長後の有効期限を返す
+func (r *SessionRepository) PendingExpiry(sessionID string) (time.Time, bool) {
+	r.pendingMx.Lock()
+	_, ok := r.pending[sessionID]
+	r.pendingMx.Unlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Now().Add(r.duration), true
+}
 
-	return userID, nil
+// FlushExtensions は延長待ちのセッションの有効期限を今からdurationまで延ばし、
+// 前回から使われたセッションの最終利用時刻を書き込む。延長した数を返す
+// 既に切れたセッションは書き換えない。書き込めなかったセッションは次に書き込むよう戻しておく
+func (r *SessionRepository) FlushExtensions(ctx context.Context) (int64, error) {
+	r.pendingMx.Lock()
+	extend := make([]string, 0, len(r.pending))
+	for id := range r.pending {
+		extend = append(extend, id)
+	}
+	seen := make([]string, 0, len(r.seen))
+	for id := range r.seen {
+		if _, ok := r.pending[id]; !ok {
+			seen = append(seen, id)
+		}
+	}
+	r.pending = make(map[string]struct{})
+	r.seen = make(map[string]struct{})
+	r.pendingMx.Unlock()
+
+	now := time.Now()
+	extended, written, err := r.updateActive(ctx, extend, "expires_at = ?, last_seen_at = ?", []interface{}{now.Add(r.duration), now}, now)
+	if err != nil {
+		r.requeue(extend[written:], seen)
+		return extended, err
+	}
+	_, written, err = r.updateActive(ctx, seen, "last_seen_at = ?", []interface{}{now}, now)
+	if err != nil {
+		r.requeue(nil, seen[written:])
+	}
+	return extended, err
+}
+
+// requeue は書き込めなかったセッションを延長待ち・利用済みに戻す
+func (r *SessionRepository) requeue(extend, seen []string) {
+	r.pendingMx.Lock()
+	defer r.pendingMx.Unlock()
+	for _, id := range extend {
+		r.pending[id] = struct{}{}
+		r.seen[id] = struct{}{}
+	}
+	for _, id := range seen {
+		r.seen[id] = struct{}{}
+	}
+}
+
+// updateActive はidsのうちnowに切れていないセッションをsetで書き換え、書き換えた数と、書き込みを終えたidsの数を返す
+// 1回のUPDATEはsessionExtendBatch件までにする
+func (r *SessionRepository) updateActive(ctx context.Context, ids []string, set string, setArgs []interface{}, now time.Time) (int64, int, error) {
+	var updated int64
+	for start := 0; start < len(ids); start += sessionExtendBatch {
+		batch := ids[start:min(start+sessionExtendBatch, len(ids))]
+		query := "UPDATE user_sessions SET " + set + " WHERE session_uuid IN (?" + strings.Repeat(", ?", len(batch)-1) + ") AND expires_at > ?"
+		args := make([]interface{}, 0, len(setArgs)+len(batch)+1)
+		args = append(args, setArgs...)
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		args = append(args, now)
+		result, err := r.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return updated, start, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return updated, start + len(batch), err
+		}
+		updated += n
+	}
+	return updated, len(ids), nil
 }
 
-func (c *sessionCache) get(sessionID string) int {
+// Extend は有効なセッションの有効期限をexpiresAtにする。有効なセッションがなければsql.ErrNoRowsを返す
+func (r *SessionRepository) Extend(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE user_sessions SET expires_at = ? WHERE session_uuid = ? AND expires_at > ?", expiresAt, sessionID, time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// 同じ値に更新した行は数えられないため、セッションが有効かを確かめ直す
+		var exists int
+		return r.db.GetContext(ctx, &exists, "SELECT 1 FROM user_sessions WHERE session_uuid = ? AND expires_at > ?", sessionID, time.Now())
+	}
+	return nil
+}
+
+// ListActiveForUser はユーザーの有効なセッションを最後に使った順に返す
+func (r *SessionRepository) ListActiveForUser(ctx context.Context, userID int) ([]model.SessionInfo, error) {
+	sessions := []model.SessionInfo{}
+	query := `
+		SELECT id, session_uuid, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM user_sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY last_seen_at DESC, id DESC`
+	err := r.db.SelectContext(ctx, &sessions, query, userID, time.Now())
+	return sessions, err
+}
+
+// DeleteForUser はユーザーのセッションをIDで削除する。ユーザーのセッションでなければsql.ErrNoRowsを返す
+func (r *SessionRepository) DeleteForUser(ctx context.Context, userID int, id int64) error {
+	var sessionID string
+	if err := r.db.GetContext(ctx, &sessionID, "SELECT session_uuid FROM user_sessions WHERE id = ? AND user_id = ?", id, userID); err != nil {
+		return err
+	}
+	return r.DeleteByUUID(ctx, sessionID)
+}
+
+// セッションを削除し、キャッシュからも消す。存在しないセッションでもエラーにしない
+func (r *SessionRepository) DeleteByUUID(ctx context.Context, sessionID string) error {
+	r.cache.delete(sessionID)
+	_, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE session_uuid = ?", sessionID)
+	return err
+}
+
+// ユーザーのすべてのセッションを削除し、削除した数を返す（すべての端末からのログアウト）
+func (r *SessionRepository) DeleteAllForUser(ctx context.Context, userID int) (int64, error) {
+	r.cache.deleteUser(userID)
+	result, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 有効期限がbefore以前のセッションを最大limit件削除し、削除した数を返す
+func (r *SessionRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE expires_at <= ? ORDER BY expires_at LIMIT ?", before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (c *sessionCache) get(sessionID string) (model.SessionUser, bool) {
 	c.mx.RLock()
 	entry, ok := c.entries[sessionID]
 	c.mx.RUnlock()
@@ -89,13 +288,13 @@ func (c *sessionCache) get(sessionID string) int {
 			delete(c.entries, sessionID)
 			c.mx.Unlock()
 		}
-		return 0
+		return model.SessionUser{}, false
 	}
-	return entry.userID
+	return entry.user, true
 }
 
-func (c *sessionCache) set(sessionID string, userID int) {
-	if userID == 0 {
+func (c *sessionCache) set(sessionID string, user model.SessionUser) {
+	if user.UserID == 0 {
 		return
 	}
 	c.mx.Lock()
@@ -107,11 +306,27 @@ func (c *sessionCache) set(sessionID string, userID int) {
 		}
 	}
 	c.entries[sessionID] = cachedSession{
-		userID:    userID,
+		user:      user,
 		expiresAt: time.Now().Add(c.ttl),
 	}
 }
 
+func (c *sessionCache) delete(sessionID string) {
+	c.mx.Lock()
+	delete(c.entries, sessionID)
+	c.mx.Unlock()
+}
+
+func (c *sessionCache) deleteUser(userID int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for key, entry := range c.entries {
+		if entry.user.UserID == userID {
+			delete(c.entries, key)
+		}
+	}
+}
+
 func (c *sessionCache) evictExpiredLocked() {
 	now := time.Now()
 	for key, entry := range c.entries {
@@ -7,25 +7,56 @@ import (
 )
 
 type Store struct {
-	db          DBTX
-	UserRepo    *UserRepository
-	SessionRepo *SessionRepository
-	ProductRepo *ProductRepository
-	OrderRepo   *OrderRepository
+	db              DBTX
+	UserRepo        *UserRepository
+	SessionRepo     *SessionRepository
+	ProductRepo     *ProductRepository
+	OrderRepo       *OrderRepository
+	OrderEventRepo  *OrderEventRepository
+	MaintenanceRepo *MaintenanceRepository
+	DeadLetterRepo  *DeadLetterRepository
+	ProofRepo       *DeliveryProofRepository
+	PlannerRepo     *PlannerProfileRepository
+	RobotRepo       *RobotRepository
+	PlanRepo        *PlanRepository
+	CategoryRepo    *CategoryRepository
+	RecommendRepo   *RecommendationRepository
+	FavoriteRepo    *FavoriteRepository
+	TagRepo         *TagRepository
+	APIKeyRepo      *APIKeyRepository
+	ResetRepo       *PasswordResetRepository
 }
 
 func NewStore(db DBTX) *Store {
 	return &Store{
-		db:          db,
-		UserRepo:    NewUserRepository(db),
-		SessionRepo: NewSessionRepository(db),
-		ProductRepo: NewProductRepository(db),
-		OrderRepo:   NewOrderRepository(db),
+		db:              db,
+		UserRepo:        NewUserRepository(db),
+		SessionRepo:     NewSessionRepository(db),
+		ProductRepo:     NewProductRepository(db),
+		OrderRepo:       NewOrderRepository(db),
+		OrderEventRepo:  NewOrderEventRepository(db),
+		MaintenanceRepo: NewMaintenanceRepository(db),
+		DeadLetterRepo:  NewDeadLetterRepository(db),
+		ProofRepo:       NewDeliveryProofRepository(db),
+		PlannerRepo:     NewPlannerProfileRepository(db),
+		RobotRepo:       NewRobotRepository(db),
+		PlanRepo:        NewPlanRepository(db),
+		CategoryRepo:    NewCategoryRepository(db),
+		RecommendRepo:   NewRecommendationRepository(db),
+		FavoriteRepo:    NewFavoriteRepository(db),
+		TagRepo:         NewTagRepository(db),
+		APIKeyRepo:      NewAPIKeyRepository(db),
+		ResetRepo:       NewPasswordResetRepository(db),
 	}
 }
 
 func (s *Store) ExecTx(ctx context.Context, fn func(txStore *Store) error) error {
-	db, ok := s.db.(*sqlx.DB)
+	inner := s.db
+	counting, isCounting := inner.(*countingDB)
+	if isCounting {
+		inner = counting.DBTX
+	}
+	db, ok := inner.(*sqlx.DB)
 	if !ok {
 		return fn(s)
 	}
@@ -36,7 +67,11 @@ func (s *Store) ExecTx(ctx context.Context, fn func(txStore *Store) error) error
 	}
 	defer tx.Rollback()
 
-	txStore := NewStore(tx)
+	var txDB DBTX = tx
+	if isCounting {
+		txDB = WithQueryCounting(tx)
+	}
+	txStore := NewStore(txDB)
 	if err := fn(txStore); err != nil {
 		return err
 	}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type TagRepository struct {
+	db DBTX
+}
+
+func NewTagRepository(db DBTX) *TagRepository {
+	return &TagRepository{db: db}
+}
+
+// SetForProduct は商品のタグをnamesに置き換える。まだないタグは追加する。トランザクション内で使う
+func (r *TagRepository) SetForProduct(ctx context.Context, productID int, names []string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM product_tags WHERE product_id = ?", productID); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	if _, err := r.db.ExecContext(ctx, "INSERT IGNORE INTO tags (name) VALUES (?)"+strings.Repeat(", (?)", len(names)-1), args...); err != nil {
+		return err
+	}
+	query, args, err := sqlx.In("INSERT INTO product_tags (product_id, tag_id) SELECT ?, tag_id FROM tags WHERE name IN (?)", productID, names)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, r.db.Rebind(query), args...)
+	return err
+}
+
+// ForProducts は商品IDごとのタグを名前の順に返す。タグのない商品は含まない
+func (r *TagRepository) ForProducts(ctx context.Context, productIDs []int) (map[int][]string, error) {
+	tags := make(map[int][]string)
+	if len(productIDs) == 0 {
+		return tags, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT pt.product_id, t.name
+		FROM product_tags pt
+		JOIN tags t ON t.tag_id = pt.tag_id
+		WHERE pt.product_id IN (?)
+		ORDER BY pt.product_id, t.name`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ProductID int    `db:"product_id"`
+		Name      string `db:"name"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		tags[row.ProductID] = append(tags[row.ProductID], row.Name)
+	}
+	return tags, nil
+}
+
+// tagFilter はproductColumnの商品にnamesのタグがすべて付いているという条件と、その引数を返す
+func tagFilter(productColumn string, names []string) (string, []interface{}) {
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	return productColumn + " IN (SELECT pt.product_id FROM product_tags pt JOIN tags t ON t.tag_id = pt.tag_id" +
+		" WHERE t.name IN (?" + strings.Repeat(", ?", len(names)-1) + ") GROUP BY pt.product_id HAVING COUNT(*) = " + strconv.Itoa(len(names)) + ")", args
+}
@@ -5,45 +5,170 @@ import (
 	"database/sql"
 	"errors"
 
+	"backend/internal/fieldcrypt"
 	"backend/internal/model"
+
+	"github.com/go-sql-driver/mysql"
 )
 
+// ErrDuplicateUserName は同じユーザー名のユーザーが既にいることを表す
+var ErrDuplicateUserName = errors.New("duplicate user name")
+
+// MySQLの一意制約違反のエラー番号
+const mysqlDuplicateEntry = 1062
+
+// ユーザー名の暗号化に使うCodec。nilなら暗号化しない
+var userFieldCodec *fieldcrypt.Codec
+
+// SetUserFieldCodec はユーザーの個人情報カラムの暗号化に使うCodecを設定する
+// 起動時に一度だけ呼び出すこと
+func SetUserFieldCodec(codec *fieldcrypt.Codec) {
+	userFieldCodec = codec
+}
+
 type UserRepository struct {
-	db DBTX
+	db    DBTX
+	codec *fieldcrypt.Codec
 }
 
 func NewUserRepository(db DBTX) *UserRepository {
-	return &UserRepository{db: db}
+	return &UserRepository{db: db, codec: userFieldCodec}
+}
+
+// userRow は暗号化カラムを含むusersテーブルの1行
+type userRow struct {
+	model.User
+	UserNameEnc sql.NullString `db:"user_name_enc"`
 }
 
 // ユーザー名からユーザー情報を取得
 // ログイン時に使用
 func (r *UserRepository) FindByUserName(ctx context.Context, userName string) (*model.User, error) {
-	var user model.User
-	query := "SELECT user_id, password_hash, user_name FROM users WHERE user_name = ?"
-
-	err := r.db.GetContext(ctx, &user, query, userName)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	if r.codec == nil {
+		var user model.User
+		query := "SELECT user_id, password_hash, user_name, role FROM users WHERE user_name = ?"
+		if err := r.db.GetContext(ctx, &user, query, userName); err != nil {
 			return nil, err
 		}
+		return &user, nil
+	}
+
+	var row userRow
+	query := "SELECT user_id, password_hash, user_name, role, user_name_enc FROM users WHERE user_name_bidx = ?"
+	err := r.db.GetContext(ctx, &row, query, r.codec.BlindIndex(userName))
+	if errors.Is(err, sql.ErrNoRows) {
+		// まだ暗号化されていない行は平文のカラムで検索する
+		query = "SELECT user_id, password_hash, user_name, role, user_name_enc FROM users WHERE user_name = ? AND user_name_bidx IS NULL"
+		err = r.db.GetContext(ctx, &row, query, userName)
+	}
+	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return r.decode(row)
+}
+
+// ユーザーを作成し、ユーザーIDを返す
+// Codecがあればユーザー名は暗号化して保存し、平文のカラムには'#'とブラインドインデックスを入れる
+func (r *UserRepository) Create(ctx context.Context, userName, passwordHash string) (int, error) {
+	query := "INSERT INTO users (password_hash, user_name) VALUES (?, ?)"
+	args := []interface{}{passwordHash, userName}
+	if r.codec != nil {
+		enc, err := r.codec.Encrypt(userName)
+		if err != nil {
+			return 0, err
+		}
+		bidx := r.codec.BlindIndex(userName)
+		query = "INSERT INTO users (password_hash, user_name, user_name_enc, user_name_bidx) VALUES (?, ?, ?, ?)"
+		args = []interface{}{passwordHash, "#" + bidx, enc, bidx}
+	}
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return 0, ErrDuplicateUserName
+		}
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
 }
 
 // ユーザーIDからユーザー情報を取得
 // セッション検証時に使用
 func (r *UserRepository) FindByUserID(ctx context.Context, userID int) (*model.User, error) {
-	var user model.User
-	query := "SELECT user_id, password_hash, user_name FROM users WHERE user_id = ?"
+	if r.codec == nil {
+		var user model.User
+		query := "SELECT user_id, password_hash, user_name, role FROM users WHERE user_id = ?"
+		if err := r.db.GetContext(ctx, &user, query, userID); err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+
+	var row userRow
+	query := "SELECT user_id, password_hash, user_name, role, user_name_enc FROM users WHERE user_id = ?"
+	if err := r.db.GetContext(ctx, &row, query, userID); err != nil {
+		return nil, err
+	}
+	return r.decode(row)
+}
 
-	err := r.db.GetContext(ctx, &user, query, userID)
+// UpdatePasswordHash はユーザーのパスワードのハッシュを置き換える
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET password_hash = ? WHERE user_id = ?", passwordHash, userID)
+	return err
+}
+
+// ListForReencryption は暗号化・鍵ローテーション対象を走査するため、user_id順にユーザーを取得する
+func (r *UserRepository) ListForReencryption(ctx context.Context, afterUserID, limit int) ([]model.User, error) {
+	var rows []userRow
+	query := "SELECT user_id, password_hash, user_name, role, user_name_enc FROM users WHERE user_id > ? ORDER BY user_id LIMIT ?"
+	if err := r.db.SelectContext(ctx, &rows, query, afterUserID, limit); err != nil {
+		return nil, err
+	}
+	users := make([]model.User, 0, len(rows))
+	for _, row := range rows {
+		user, err := r.decode(row)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, nil
+}
+
+// NeedsReencryption は暗号化されていない、または古い鍵で暗号化されている行かを返す
+func (r *UserRepository) NeedsReencryption(ctx context.Context, userID int) (bool, error) {
+	var enc sql.NullString
+	if err := r.db.GetContext(ctx, &enc, "SELECT user_name_enc FROM users WHERE user_id = ?", userID); err != nil {
+		return false, err
+	}
+	return !enc.Valid || r.codec.NeedsRotation(enc.String), nil
+}
+
+// SaveEncryptedName はユーザー名を現在の鍵で暗号化して保存する
+// scrubがtrueなら平文のカラムをユーザーIDに基づく値で置き換える
+func (r *UserRepository) SaveEncryptedName(ctx context.Context, userID int, userName string, scrub bool) error {
+	enc, err := r.codec.Encrypt(userName)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	query := "UPDATE users SET user_name_enc = ?, user_name_bidx = ? WHERE user_id = ?"
+	if scrub {
+		query = "UPDATE users SET user_name_enc = ?, user_name_bidx = ?, user_name = CONCAT('#', user_id) WHERE user_id = ?"
+	}
+	_, err = r.db.ExecContext(ctx, query, enc, r.codec.BlindIndex(userName), userID)
+	return err
+}
+
+func (r *UserRepository) decode(row userRow) (*model.User, error) {
+	user := row.User
+	if row.UserNameEnc.Valid && row.UserNameEnc.String != "" {
+		name, err := r.codec.Decrypt(row.UserNameEnc.String)
+		if err != nil {
 			return nil, err
 		}
-		return nil, err
+		user.UserName = name
 	}
 	return &user, nil
 }
@@ -2,13 +2,23 @@ package server
 
 import (
 	"backend/internal/db"
+	"backend/internal/fieldcrypt"
 	"backend/internal/handler"
 	"backend/internal/middleware"
+	"backend/internal/model"
+	"backend/internal/objectstore"
 	"backend/internal/repository"
 	"backend/internal/service"
+	"backend/internal/telemetry"
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
@@ -16,6 +26,10 @@ import (
 
 type Server struct {
 	Router *chi.Mux
+	// 期限切れのセッションを削除するジョブ。Runで開始し、終了時に止める
+	sessionJanitor *service.SessionJanitor
+	// セッションの延長と最終利用時刻をまとめて書き込む。Runで開始し、終了時に残りを書き込む
+	authService *service.AuthService
 }
 
 func NewServer() (*Server, *sqlx.DB, error) {
@@ -24,40 +38,145 @@ func NewServer() (*Server, *sqlx.DB, error) {
 		return nil, nil, err
 	}
 
-	store := repository.NewStore(dbConn)
+	fieldCodec, err := fieldcrypt.NewCodecFromEnv()
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	if fieldCodec == nil {
+		log.Println("Warning: FIELD_ENCRYPTION_KEYS is not set. User fields are stored unencrypted")
+	}
+	repository.SetUserFieldCodec(fieldCodec)
+
+	store := repository.NewStore(repository.WithQueryCounting(dbConn))
+
+	orderEvents := service.NewOrderEventBus()
 
 	authService := service.NewAuthService(store)
-	orderService := service.NewOrderService(store)
-	productService := service.NewProductService(store)
-	robotService := service.NewRobotService(store)
+	orderService := service.NewOrderService(store, orderEvents)
+	productService := service.NewProductService(store, orderEvents)
+	robotService := service.NewRobotService(store, orderEvents)
+	if err := robotService.Planner().Reload(context.Background()); err != nil {
+		log.Printf("Failed to load planner profiles, using defaults: %v", err)
+	}
+	robotService.StartPlanReaper(context.Background())
+	maintenanceService := service.NewMaintenanceService(store)
+	deadLetterService := service.NewDeadLetterService(store)
+	thumbnailService := service.NewThumbnailService()
+	thumbnailService.SetFailureHandler(func(imagePath string, err error) {
+		deadLetterService.Record(context.Background(), "thumbnail", imagePath, err)
+	})
+	deadLetterService.RegisterRetryHandler("thumbnail", func(ctx context.Context, payload string) error {
+		return thumbnailService.Regenerate(payload)
+	})
+	thumbnailService.Start(context.Background())
+	objects := objectstore.NewLocalStoreFromEnv()
+	proofService, err := service.NewDeliveryProofService(store, objects)
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	reconciliationService := service.NewReconciliationService(store, objects)
+	reconciliationService.Start(context.Background())
+	service.NewArchiveService(store).Start(context.Background())
+	service.NewOrderScheduler(store, orderEvents).Start(context.Background())
+	service.NewPopularityService(store).Start(context.Background())
+	service.NewStockAlertService(store).Start(context.Background())
+	service.NewRecommendationService(store).Start(context.Background())
 
 	authHandler := handler.NewAuthHandler(authService)
-	productHandler := handler.NewProductHandler(productService)
-	orderHandler := handler.NewOrderHandler(orderService)
-	robotHandler := handler.NewRobotHandler(robotService)
+	productHandler := handler.NewProductHandler(productService, thumbnailService, service.NewProductImageService(store))
+	orderHandler := handler.NewOrderHandler(orderService, proofService)
+	robotHandler := handler.NewRobotHandler(robotService, proofService)
+	apiKeyService := service.NewAPIKeyService(store)
+	adminHandler := handler.NewAdminHandler(maintenanceService, deadLetterService, robotService.Planner(), reconciliationService, productService, apiKeyService)
+	objectHandler := handler.NewObjectHandler(proofService)
+	internalHandler := handler.NewInternalHandler(orderService, productService)
 
-	userAuthMW := middleware.UserAuthMiddleware(store.SessionRepo)
+	requestStats := telemetry.NewRequestStats(4096)
+	healthService := service.NewHealthService(dbConn.Stats, requestStats, deadLetterService, orderEvents)
+	healthHandler := handler.NewHealthHandler(healthService)
+	inflight := telemetry.NewInflightRegistry()
+	debugHandler := handler.NewDebugHandler(inflight)
+	sessionJanitor := service.NewSessionJanitor(store)
+	metricsHandler := handler.NewMetricsHandler(robotService.SolverStats(), sessionJanitor.Stats())
 
+	// 購入者向けのAPIは購入の権限を持つ役割にだけ許す。自分のアカウントの管理はどの役割にも許す
+	sessionAuthMW := middleware.UserAuthMiddleware(store.SessionRepo)
+	shopPermissionMW := middleware.RequirePermission(model.PermissionShop)
+	userAuthMW := func(next http.Handler) http.Handler {
+		return sessionAuthMW(shopPermissionMW(next))
+	}
+
+	// ロボットは管理APIで発行したAPIキーで認証する。ROBOT_API_KEYは発行前から使っている共通のキー
 	robotAPIKey := os.Getenv("ROBOT_API_KEY")
 	if robotAPIKey == "" {
 		log.Println("Warning: ROBOT_API_KEY is not set. Using default key 'test-robot-key'")
 		robotAPIKey = "test-robot-key"
 	}
-	robotAuthMW := middleware.RobotAuthMiddleware(robotAPIKey)
+	// 共通のキーは全ロボットで同じため、ROBOT_API_KEY_ROBOT_IDで紐づけない限りロボットとしての操作（計画・ステータスの報告など）はできない
+	legacyRobotID := os.Getenv("ROBOT_API_KEY_ROBOT_ID")
+	// APIキーのないリクエストは、配送の権限を持つ役割のセッションなら受け付ける
+	robotAuthMW := middleware.KeyOrSessionAuth("X-API-Key",
+		middleware.APIKeyAuthMiddleware(apiKeyService, robotAPIKey, legacyRobotID),
+		sessionAuthMW, middleware.RequirePermission(model.PermissionDeliver))
+
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
+	if adminAPIKey == "" {
+		log.Println("Warning: ADMIN_API_KEY is not set. Using default key 'test-admin-key'")
+		adminAPIKey = "test-admin-key"
+	}
+	// 管理者キーのないリクエストは、管理者のセッションなら受け付ける
+	adminAuthMW := middleware.KeyOrSessionAuth("X-ADMIN-KEY",
+		middleware.AdminAuthMiddleware(adminAPIKey),
+		sessionAuthMW, middleware.RequireRole(model.RoleAdmin))
+
+	internalAPIKey := os.Getenv("INTERNAL_API_KEY")
+	if internalAPIKey == "" {
+		log.Println("Warning: INTERNAL_API_KEY is not set. Using default key 'test-internal-key'")
+		internalAPIKey = "test-internal-key"
+	}
+	internalAuthMW := middleware.InternalAuthMiddleware(internalAPIKey)
+
+	securityCfg := middleware.DefaultSecurityHeadersConfig()
+	if csp := os.Getenv("SECURITY_CSP"); csp != "" {
+		securityCfg.ContentSecurityPolicy = csp
+	}
+	if v := os.Getenv("SECURITY_HSTS_MAX_AGE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			securityCfg.HSTSMaxAge = n
+		}
+	}
+	securityMW := middleware.SecurityHeadersMiddleware(securityCfg)
+
+	partialMW := middleware.PartialResultsMiddleware(
+		envDuration("LIST_PARTIAL_TIMEOUT", 5*time.Second),
+		envDuration("LIST_PARTIAL_RESERVE", 200*time.Millisecond),
+	)
 
 	r := chi.NewRouter()
 	// トレースミドルウェアを無効化してパフォーマンス最適化
+	r.Use(middleware.RequestMetricsMiddleware(requestStats))
+	r.Use(middleware.InflightMiddleware(inflight))
 
 	r.Get("/api/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
+	// オートスケーラー用。nginxからは公開しない
+	r.Get("/internal/health/score", healthHandler.Score)
+	// 監視用。nginxからは公開しない
+	r.Get("/metrics", metricsHandler.Metrics)
+	// 処理中のリクエストの確認用。ユーザーIDを含むため管理者キーを要求する
+	r.With(adminAuthMW).Get("/debug/inflight", debugHandler.Inflight)
 
 	s := &Server{
-		Router: r,
+		Router:         r,
+		sessionJanitor: sessionJanitor,
+		authService:    authService,
 	}
 
-	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, userAuthMW, robotAuthMW)
+	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, adminHandler, objectHandler, internalHandler, sessionAuthMW, userAuthMW, robotAuthMW, adminAuthMW, internalAuthMW, securityMW, partialMW)
 
 	return s, dbConn, nil
 }
@@ -67,23 +186,124 @@ func (s *Server) setupRoutes(
 	productHandler *handler.ProductHandler,
 	orderHandler *handler.OrderHandler,
 	robotHandler *handler.RobotHandler,
+	adminHandler *handler.AdminHandler,
+	objectHandler *handler.ObjectHandler,
+	internalHandler *handler.InternalHandler,
+	sessionAuthMW func(http.Handler) http.Handler,
 	userAuthMW func(http.Handler) http.Handler,
 	robotAuthMW func(http.Handler) http.Handler,
+	adminAuthMW func(http.Handler) http.Handler,
+	internalAuthMW func(http.Handler) http.Handler,
+	securityMW func(http.Handler) http.Handler,
+	partialMW func(http.Handler) http.Handler,
 ) {
-	s.Router.Post("/api/login", authHandler.Login)
-	s.Router.Get("/api/verify", authHandler.Verify)
-	s.Router.Route("/api/v1", func(r chi.Router) {
-		r.Use(userAuthMW)
-		r.Post("/product", productHandler.List)
-		r.Post("/product/post", productHandler.CreateOrders)
-		r.Post("/orders", orderHandler.List)
-		r.Get("/image", productHandler.GetImage)
+	// 画像は他ページへの埋め込みを許可し、画像以外のリソース読み込みを禁止する
+	imageSecurityMW := middleware.SecurityHeadersOverride(map[string]string{
+		"Content-Security-Policy": "default-src 'none'; img-src 'self'; sandbox",
+		"X-Frame-Options":         "",
+	})
+	// フィード（SSEの注文ステータスと配送待ちの注文）はデータだけを返すため、他ページからの読み込みを許可し、リソースの読み込みを禁止する
+	feedSecurityMW := middleware.SecurityHeadersOverride(map[string]string{
+		"Content-Security-Policy": "default-src 'none'",
+		"X-Frame-Options":         "",
+	})
+
+	// ブラウザ向けのエンドポイントにのみセキュリティヘッダーを付与する
+	s.Router.Group(func(r chi.Router) {
+		r.Use(securityMW)
+		r.Post("/api/login", authHandler.Login)
+		r.Post("/api/logout", authHandler.Logout)
+		r.Post("/api/register", authHandler.Register)
+		r.Post("/api/session/refresh", authHandler.RefreshSession)
+		r.Post("/api/password-reset", authHandler.RequestPasswordReset)
+		r.Post("/api/password-reset/confirm", authHandler.ConfirmPasswordReset)
+		r.Route("/api/user", func(r chi.Router) {
+			r.Use(sessionAuthMW)
+			r.Post("/password", authHandler.ChangePassword)
+			r.Get("/sessions", authHandler.ListSessions)
+			r.Delete("/sessions/{id}", authHandler.RevokeSession)
+		})
+		r.Get("/api/verify", authHandler.Verify)
+		r.Route("/api/v1", func(r chi.Router) {
+			r.Use(userAuthMW)
+			r.With(partialMW).Post("/product", productHandler.List)
+			r.Post("/product/post", productHandler.CreateOrders)
+			r.Post("/orders", orderHandler.List)
+			r.With(imageSecurityMW).Get("/image", productHandler.GetImage)
+			r.Get("/categories", productHandler.Categories)
+		})
+
+		r.Route("/api/products", func(r chi.Router) {
+			r.Use(userAuthMW)
+			r.Get("/recommended", productHandler.Recommended)
+			r.Get("/{id}", productHandler.Detail)
+			r.With(imageSecurityMW).Get("/{id}/image", productHandler.ProductImage)
+			r.Post("/{id}/favorite", productHandler.AddFavorite)
+			r.Delete("/{id}/favorite", productHandler.RemoveFavorite)
+		})
+
+		r.Route("/api/orders", func(r chi.Router) {
+			r.Use(userAuthMW)
+			r.Get("/export", orderHandler.Export)
+			r.Post("/cancel", orderHandler.CancelMany)
+			r.With(middleware.ExcludeFromLatency, feedSecurityMW).Get("/stream", orderHandler.Stream)
+			r.Get("/{id}", orderHandler.Detail)
+			r.Get("/{id}/events", orderHandler.Events)
+			r.Post("/{id}/cancel", orderHandler.Cancel)
+			r.With(middleware.ExcludeFromLatency).Get("/{id}/status", orderHandler.Status)
+		})
+
+		// 署名付きURLでのダウンロード。署名の検証はハンドラで行う
+		r.With(imageSecurityMW).Get(service.ObjectDownloadPath+"/*", objectHandler.Download)
 	})
 
 	s.Router.Route("/api/robot", func(r chi.Router) {
 		r.Use(robotAuthMW)
 		r.Get("/delivery-plan", robotHandler.GetDeliveryPlan)
+		r.Get("/delivery-plan/preview", robotHandler.PreviewDeliveryPlan)
+		r.Post("/delivery-plan/amend", robotHandler.AmendDeliveryPlan)
+		r.Get("/delivery-plan/{planID}", robotHandler.GetDeliveryPlanChunk)
+		r.Post("/delivery-plans/batch", robotHandler.GetDeliveryPlans)
+		r.Get("/plans", robotHandler.ListPlans)
+		r.Post("/plan/release", robotHandler.ReleasePlan)
+		r.Post("/heartbeat", robotHandler.Heartbeat)
 		r.Patch("/orders/status", robotHandler.UpdateOrderStatus)
+		r.Post("/orders/{id}/proof", robotHandler.AttachDeliveryProof)
+		r.Post("/orders/{id}/fail", robotHandler.FailDelivery)
+	})
+
+	s.Router.Route("/api/admin", func(r chi.Router) {
+		r.Use(adminAuthMW)
+		r.Post("/analyze", adminHandler.StartAnalyze)
+		r.Get("/analyze", adminHandler.AnalyzeStatus)
+		r.With(partialMW).Get("/dead-letters", adminHandler.ListDeadLetters)
+		r.Get("/dead-letters/metrics", adminHandler.DeadLetterMetrics)
+		r.Post("/dead-letters/{id}/retry", adminHandler.RetryDeadLetter)
+		r.Delete("/dead-letters/{id}", adminHandler.DiscardDeadLetter)
+		r.Get("/planner/profiles", adminHandler.ListPlannerProfiles)
+		r.Put("/planner/profiles/{name}", adminHandler.SavePlannerProfile)
+		r.Put("/planner/assignments/{robotID}", adminHandler.AssignPlannerProfile)
+		r.Get("/planner/metrics", adminHandler.PlannerMetrics)
+		r.Post("/reports/reconciliation", adminHandler.GenerateReconciliationReport)
+		r.Get("/reports/reconciliation/{date}", adminHandler.GetReconciliationReport)
+		r.Post("/products", adminHandler.CreateProduct)
+		r.Post("/products/import", adminHandler.ImportProducts)
+		r.Put("/products/{id}", adminHandler.UpdateProduct)
+		r.Delete("/products/{id}", adminHandler.DeleteProduct)
+		r.Post("/products/{id}/restore", adminHandler.RestoreProduct)
+		r.Put("/products/{id}/tags", adminHandler.SetProductTags)
+		r.Get("/products/{id}/value-history", adminHandler.ProductValueHistory)
+		r.Get("/api-keys", adminHandler.ListAPIKeys)
+		r.Post("/api-keys", adminHandler.CreateAPIKey)
+		r.Post("/api-keys/{id}/rotate", adminHandler.RotateAPIKey)
+		r.Delete("/api-keys/{id}", adminHandler.RevokeAPIKey)
+	})
+
+	// 倉庫管理システムなど社内の他システム向け
+	s.Router.Route("/api/internal", func(r chi.Router) {
+		r.Use(internalAuthMW)
+		r.With(securityMW, feedSecurityMW).Get("/shipping-orders", internalHandler.ShippingOrders)
+		r.Get("/products/sample", internalHandler.SampleProducts)
 	})
 }
 
@@ -93,8 +313,46 @@ func (s *Server) Run() {
 		appPort = "8080"
 	}
 
+	// SIGINT/SIGTERMを受けたら処理中のリクエストを待ってから止める
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	janitorDone := s.sessionJanitor.Start(ctx)
+	extenderDone := s.authService.StartSessionExtender(ctx)
+
+	shutdownTimeout := envDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+	srv := &http.Server{Addr: ":" + appPort, Handler: s.Router}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down server: %v", err)
+		}
+	}()
+
 	log.Printf("Starting server on :%s", appPort)
-	if err := http.ListenAndServe(":"+appPort, s.Router); err != nil {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	<-shutdownDone
+	<-extenderDone
+	// 処理中だったリクエストの分も含め、まだ書き込んでいないセッションの延長と最終利用時刻を書き込む
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := s.authService.FlushSessions(flushCtx); err != nil {
+		log.Printf("Failed to flush sessions on shutdown: %v", err)
+	}
+	cancel()
+	<-janitorDone
+	log.Println("Server stopped")
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
 }
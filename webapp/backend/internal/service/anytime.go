@@ -0,0 +1,17 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// anytimeContext は選定を打ち切る期限を付けたcontextを返す
+// ctxの期限までの残り時間のうちfractionを使った時点で打ち切り、残りを引き当てなどの後続の処理に回す
+// ctxに期限がない、またはfractionが0以下ならctxをそのまま返す
+func anytimeContext(ctx context.Context, fraction float64) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || fraction <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, time.Now().Add(time.Duration(float64(time.Until(deadline))*fraction)))
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+)
+
+// 発行するキーの先頭に付ける文字列。ログなどに紛れたキーを見つけやすくする
+const apiKeyPrefix = "rbk_"
+
+// APIKeyService はロボット向けのAPIキーを発行・検証する
+// キーはSHA-256のハッシュだけを保存し、検証した結果は短い間キャッシュする
+type APIKeyService struct {
+	store *repository.Store
+	// ローテーションした古いキーを使える時間
+	rotationGrace time.Duration
+	// 検証した結果をキャッシュする時間
+	cacheTTL time.Duration
+	mx       sync.Mutex
+	cache    map[string]cachedAPIKey
+}
+
+type cachedAPIKey struct {
+	key       *model.APIKey
+	expiresAt time.Time
+}
+
+func NewAPIKeyService(store *repository.Store) *APIKeyService {
+	return &APIKeyService{
+		store:         store,
+		rotationGrace: parseDurationEnv("API_KEY_ROTATION_GRACE", time.Hour),
+		cacheTTL:      parseDurationEnv("API_KEY_CACHE_TTL", 10*time.Second),
+		cache:         make(map[string]cachedAPIKey),
+	}
+}
+
+// Authenticate はキーが有効ならそのAPIキーを返す。無効ならErrInvalidAPIKeyを返す
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (*model.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	hash := hashSecret(key)
+	now := time.Now()
+
+	s.mx.Lock()
+	cached, ok := s.cache[hash]
+	s.mx.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.key, nil
+	}
+
+	apiKey, err := s.store.APIKeyRepo.FindActiveByHash(ctx, hash, now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := now.Add(s.cacheTTL)
+	if apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(expiresAt) {
+		expiresAt = *apiKey.ExpiresAt
+	}
+	s.mx.Lock()
+	for h, c := range s.cache {
+		if !now.Before(c.expiresAt) {
+			delete(s.cache, h)
+		}
+	}
+	s.cache[hash] = cachedAPIKey{key: apiKey, expiresAt: expiresAt}
+	s.mx.Unlock()
+	return apiKey, nil
+}
+
+// Create はAPIキーを発行する。robotIDを指定したキーはそのロボットとしてだけ使える
+func (s *APIKeyService) Create(ctx context.Context, name, robotID string) (*model.IssuedAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, fmt.Errorf("%w: name must be 1 to 255 characters", ErrInvalidAPIKey)
+	}
+	if len(robotID) > 64 {
+		return nil, fmt.Errorf("%w: robot_id must be at most 64 characters", ErrInvalidAPIKey)
+	}
+	var robot *string
+	if robotID != "" {
+		robot = &robotID
+	}
+	return s.issue(ctx, s.store, name, robot)
+}
+
+// Rotate は同じ名前・ロボットのAPIキーを新しく発行し、古いキーはrotationGrace後に使えなくする
+func (s *APIKeyService) Rotate(ctx context.Context, apiKeyID int64) (*model.IssuedAPIKey, error) {
+	var issued *model.IssuedAPIKey
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		old, err := txStore.APIKeyRepo.GetByID(ctx, apiKeyID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrAPIKeyNotFound, apiKeyID)
+		}
+		if err != nil {
+			return err
+		}
+		if old.RevokedAt != nil {
+			return fmt.Errorf("%w: %d is revoked", ErrAPIKeyNotFound, apiKeyID)
+		}
+		issued, err = s.issue(ctx, txStore, old.Name, old.RobotID)
+		if err != nil {
+			return err
+		}
+		return txStore.APIKeyRepo.ExpireAt(ctx, apiKeyID, time.Now().Add(s.rotationGrace))
+	})
+	return issued, err
+}
+
+// Revoke はAPIキーを取り消す。既に取り消したキーでもエラーにしない
+// このプロセスのキャッシュからはすぐに消すが、他のプロセスではcacheTTLの間使える
+func (s *APIKeyService) Revoke(ctx context.Context, apiKeyID int64) error {
+	if err := s.store.APIKeyRepo.Revoke(ctx, apiKeyID, time.Now()); err != nil {
+		return err
+	}
+	s.mx.Lock()
+	for h, c := range s.cache {
+		if c.key.APIKeyID == apiKeyID {
+			delete(s.cache, h)
+		}
+	}
+	s.mx.Unlock()
+	return nil
+}
+
+// List は取り消していないAPIキーを返す
+func (s *APIKeyService) List(ctx context.Context) ([]model.APIKey, error) {
+	return s.store.APIKeyRepo.List(ctx)
+}
+
+func (s *APIKeyService) issue(ctx context.Context, store *repository.Store, name string, robotID *string) (*model.IssuedAPIKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	apiKey := model.APIKey{
+		Name:      name,
+		RobotID:   robotID,
+		KeyPrefix: key[:len(apiKeyPrefix)+6],
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+	id, err := store.APIKeyRepo.Create(ctx, apiKey, hashSecret(key))
+	if err != nil {
+		return nil, err
+	}
+	apiKey.APIKeyID = id
+	return &model.IssuedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+// hashSecret はAPIキーやパスワード再設定のトークンを保存するときのハッシュを返す
+// どちらも十分に長い乱数なので、パスワードと違って遅いハッシュにはしない
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// apiKeyDB はapi_keysをハッシュごとに持つ
+type apiKeyDB struct {
+	orderDB
+	keys   map[string]*model.APIKey
+	nextID int64
+}
+
+func (db *apiKeyDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	for hash, key := range db.keys {
+		match := hash == args[0]
+		if id, ok := args[0].(int64); ok {
+			match = key.APIKeyID == id
+		}
+		if !match {
+			continue
+		}
+		if strings.Contains(query, "key_hash") && (key.RevokedAt != nil || (key.ExpiresAt != nil && !key.ExpiresAt.After(args[1].(time.Time)))) {
+			return sql.ErrNoRows
+		}
+		*dest.(*model.APIKey) = *key
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (db *apiKeyDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "INSERT"):
+		db.nextID++
+		robotID, _ := args[1].(*string)
+		db.keys[args[2].(string)] = &model.APIKey{APIKeyID: db.nextID, Name: args[0].(string), RobotID: robotID}
+		return insertResult(db.nextID), nil
+	case strings.Contains(query, "expires_at ="):
+		for _, key := range db.keys {
+			if key.APIKeyID == args[1].(int64) {
+				at := args[0].(time.Time)
+				key.ExpiresAt = &at
+			}
+		}
+	case strings.Contains(query, "revoked_at ="):
+		for _, key := range db.keys {
+			if key.APIKeyID == args[1].(int64) {
+				at := args[0].(time.Time)
+				key.RevokedAt = &at
+			}
+		}
+	}
+	return insertResult(0), nil
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	db := &apiKeyDB{keys: make(map[string]*model.APIKey)}
+	svc := &APIKeyService{store: repository.NewStore(db), rotationGrace: time.Hour, cacheTTL: time.Minute, cache: make(map[string]cachedAPIKey)}
+	ctx := context.Background()
+
+	issued, err := svc.Create(ctx, "robot-7", "robot-7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(issued.Key, apiKeyPrefix) || !strings.HasPrefix(issued.Key, issued.KeyPrefix) {
+		t.Fatalf("unexpected key %q with prefix %q", issued.Key, issued.KeyPrefix)
+	}
+	if _, ok := db.keys[issued.Key]; ok {
+		t.Fatal("key must be stored hashed")
+	}
+	key, err := svc.Authenticate(ctx, issued.Key)
+	if err != nil || key.RobotID == nil || *key.RobotID != "robot-7" {
+		t.Fatalf("expected key for robot-7, got %+v, %v", key, err)
+	}
+	if _, err := svc.Authenticate(ctx, apiKeyPrefix+"unknown"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected ErrInvalidAPIKey, got %v", err)
+	}
+
+	// ローテーションしても猶予期間の間は古いキーも使える
+	rotated, err := svc.Rotate(ctx, issued.APIKeyID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rotated.Key == issued.Key || rotated.Name != "robot-7" {
+		t.Fatalf("unexpected rotated key: %+v", rotated)
+	}
+	if old := db.keys[hashSecret(issued.Key)]; old.ExpiresAt == nil {
+		t.Fatal("expected the old key to expire after the grace period")
+	}
+	if _, err := svc.Authenticate(ctx, rotated.Key); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 取り消したキーはキャッシュにあってもすぐに使えなくなる
+	if err := svc.Revoke(ctx, rotated.APIKeyID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, rotated.Key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected revoked key to be rejected, got %v", err)
+	}
+	if _, err := svc.Rotate(ctx, rotated.APIKeyID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("expected ErrAPIKeyNotFound for revoked key, got %v", err)
+	}
+}
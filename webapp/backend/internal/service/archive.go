@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/internal/repository"
+)
+
+// ArchiveService は配送完了から一定日数が過ぎた注文を定期的に退避先へ移す
+// 注文テーブルを小さく保ち、注文履歴の一覧や配送待ちの集計を速くする。退避した注文は一覧でarchivedを指定すると読める
+type ArchiveService struct {
+	store *repository.Store
+	// 配送完了からafterを過ぎた注文を退避する（0以下なら退避しない）
+	after time.Duration
+	every time.Duration
+	// 1回のトランザクションで各注文テーブルから移す件数の上限
+	batch int
+}
+
+func NewArchiveService(store *repository.Store) *ArchiveService {
+	return &ArchiveService{
+		store: store,
+		after: time.Duration(parseIntEnv("ORDER_ARCHIVE_AFTER_DAYS", 0)) * 24 * time.Hour,
+		every: parseDurationEnv("ORDER_ARCHIVE_INTERVAL", time.Hour),
+		batch: parseIntEnv("ORDER_ARCHIVE_BATCH", 1000),
+	}
+}
+
+// Start はevery間隔で退避するジョブを開始する。ORDER_ARCHIVE_AFTER_DAYSが未設定なら何もしない
+func (s *ArchiveService) Start(ctx context.Context) {
+	if s.after <= 0 {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.every):
+			}
+
+			archived, err := s.Archive(ctx)
+			if err != nil {
+				log.Printf("Failed to archive completed orders after %d: %v", archived, err)
+			} else if archived > 0 {
+				log.Printf("Archived %d orders completed over %s ago", archived, s.after)
+			}
+		}
+	}()
+}
+
+// Archive は配送完了からafterを過ぎた注文をすべて退避し、退避した件数を返す
+// ロックを長く持たないよう、batch件ずつ別のトランザクションで移す
+func (s *ArchiveService) Archive(ctx context.Context) (int, error) {
+	before := time.Now().Add(-s.after)
+	total := 0
+	for {
+		var n int
+		err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			n, err = txStore.OrderRepo.ArchiveCompleted(ctx, before, s.batch)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n == 0 {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/repository"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// archiveDB は退避の対象として、呼ばれるたびにbatchesの先頭の注文IDを返す
+type archiveDB struct {
+	batches [][]int64
+	execs   []string
+}
+
+func (db *archiveDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sql.ErrNoRows
+}
+
+func (db *archiveDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if len(db.batches) > 0 {
+		*dest.(*[]int64) = db.batches[0]
+		db.batches = db.batches[1:]
+	}
+	return nil
+}
+
+func (db *archiveDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return nil, errors.New("unexpected query")
+}
+
+func (db *archiveDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db.execs = append(db.execs, strings.Fields(query)[0]+" "+strings.Fields(query)[2])
+	return driver.RowsAffected(1), nil
+}
+
+func (db *archiveDB) Rebind(query string) string { return query }
+
+func TestArchiveMovesCompletedOrdersInBatches(t *testing.T) {
+	db := &archiveDB{batches: [][]int64{{1, 2}, {3}}}
+	svc := NewArchiveService(repository.NewStore(db))
+	svc.after = 30 * 24 * time.Hour
+	svc.batch = 2
+
+	archived, err := svc.Archive(context.Background())
+	if err != nil || archived != 3 {
+		t.Fatalf("expected 3 orders to be archived, got %d %v", archived, err)
+	}
+	want := []string{"INSERT orders_archive", "DELETE orders", "INSERT orders_archive", "DELETE orders"}
+	if strings.Join(db.execs, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected each batch to be copied then deleted, got %v", db.execs)
+	}
+}
@@ -4,27 +4,60 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"log"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrInvalidPassword = errors.New("invalid password")
 	ErrInternalServer  = errors.New("internal server error")
+	// ErrInvalidCredentials はログインでユーザー名かパスワードが違うことを表す。どちらが違うかは区別しない
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUserNameTaken は登録しようとしたユーザー名が既に使われていることを表す
+	ErrUserNameTaken = errors.New("user name already taken")
+	// ErrInvalidRegistration は登録内容（ユーザー名・パスワード）が不正であることを表す
+	ErrInvalidRegistration = errors.New("invalid registration")
+	// ErrSessionNotFound は削除しようとしたセッションがユーザーのものでないことを表す
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+const (
+	maxUserNameLength = 255
+	// bcryptが扱えるパスワードの長さの上限
+	maxPasswordBytes = 72
 )
 
 type AuthService struct {
 	store     *repository.Store
 	userCache *userCache
+	// 同じユーザー名の同時の検索をまとめる
+	userLookups userLookupGroup
+	// パスワードのハッシュの方式とパラメータ
+	hasher passwordHashing
+	// 登録時に求めるパスワードの推定エントロピー（ビット）
+	minPasswordEntropy float64
+	// ログイン試行の制限。nilなら制限しない
+	limiter LoginLimiter
+	// セッションの有効期間と、延長待ちのセッションをまとめて書き込む間隔
+	sessionDuration time.Duration
+	extendEvery     time.Duration
+	// パスワード再設定のトークンの有効期間と、トークンを利用者に届ける先
+	resetTTL      time.Duration
+	resetNotifier PasswordResetNotifier
+	// 申請に応えて裏で発行中のトークン
+	resetJobs sync.WaitGroup
 }
 
 func NewAuthService(store *repository.Store) *AuthService {
@@ -33,34 +66,127 @@ func NewAuthService(store *repository.Store) *AuthService {
 	var cache *userCache
 	if cacheTTL > 0 && cacheSize > 0 {
 		cache = newUserCache(cacheTTL, cacheSize)
+		cache.missTTL = parseDurationEnv("AUTH_USER_NEGATIVE_CACHE_TTL", time.Second)
+	}
+	hasher, err := newPasswordHasherFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to prepare password hasher: %v", err)
+	}
+	sessionDuration := parseDurationEnv("SESSION_DURATION", 24*time.Hour)
+	store.SessionRepo.SetSliding(sessionDuration, parseDurationEnv("SESSION_REFRESH_THRESHOLD", 6*time.Hour))
+	return &AuthService{
+		store:              store,
+		userCache:          cache,
+		hasher:             hasher,
+		minPasswordEntropy: float64(parseIntEnv("AUTH_MIN_PASSWORD_ENTROPY", 50)),
+		limiter:            newMemoryLoginLimiter(),
+		sessionDuration:    sessionDuration,
+		extendEvery:        parseDurationEnv("SESSION_EXTEND_INTERVAL", 5*time.Second),
+		resetTTL:           parseDurationEnv("PASSWORD_RESET_TTL", 30*time.Minute),
+		resetNotifier:      newResetNotifierFromEnv(),
 	}
-	return &AuthService{store: store, userCache: cache}
 }
 
-func (s *AuthService) Login(ctx context.Context, userName, password string) (string, time.Time, error) {
+// StartSessionExtender は延長待ちのセッションの有効期限と最終利用時刻をextendEvery間隔でまとめて書き込むジョブを開始する
+// ctxが終わると止まり、返したチャネルを閉じる。止まった後に残った分はFlushSessionsで書き込む
+func (s *AuthService) StartSessionExtender(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	if s.extendEvery <= 0 {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.extendEvery):
+			}
+
+			if err := s.FlushSessions(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to extend sessions: %v", err)
+			}
+		}
+	}()
+	return done
+}
+
+// FlushSessions は延長待ちのセッションの有効期限と最終利用時刻を書き込む
+func (s *AuthService) FlushSessions(ctx context.Context) error {
+	_, err := s.store.SessionRepo.FlushExtensions(ctx)
+	return err
+}
+
+// RefreshSession は有効なセッションの有効期限を今からsessionDurationまで延ばし、新しい有効期限を返す
+func (s *AuthService) RefreshSession(ctx context.Context, sessionID string) (time.Time, error) {
+	expiresAt := time.Now().Add(s.sessionDuration)
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		err := s.store.SessionRepo.Extend(ctx, sessionID, expiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return ErrInternalServer
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
+}
+
+// SetLoginLimiter はログイン試行の制限を差し替える。起動時に一度だけ呼び出すこと
+func (s *AuthService) SetLoginLimiter(limiter LoginLimiter) {
+	s.limiter = limiter
+}
+
+// Login はパスワードを確かめてセッションを発行する
+// 失敗が多すぎるユーザー名・接続元IPは、パスワードを確かめる前に*LoginThrottledErrorで断る
+func (s *AuthService) Login(ctx context.Context, userName, password string, client model.ClientInfo) (string, time.Time, error) {
+	if s.limiter != nil {
+		wait, err := s.limiter.Allow(ctx, userName, client.IP)
+		if err != nil {
+			return "", time.Time{}, ErrInternalServer
+		}
+		if wait > 0 {
+			return "", time.Time{}, &LoginThrottledError{RetryAfter: wait}
+		}
+	}
+
 	var sessionID string
 	var expiresAt time.Time
 	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
 		user, err := s.getUser(ctx, userName)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				return ErrUserNotFound
+				// パスワードが違うときと同じだけ時間をかけ、同じエラーを返す
+				s.hasher.Verify(s.hasher.DummyHash(), password)
+				return ErrInvalidCredentials
 			}
 			return ErrInternalServer
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
-		if err != nil {
-			return ErrInvalidPassword
+		if !s.hasher.Verify(user.PasswordHash, password) {
+			return ErrInvalidCredentials
 		}
+		s.rehashIfNeeded(ctx, user, password)
 
-		sessionDuration := 24 * time.Hour
-		sessionID, expiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, sessionDuration)
+		sessionID, expiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, user.Role, s.sessionDuration, client)
 		if err != nil {
 			return ErrInternalServer
 		}
 		return nil
 	})
+	if s.limiter != nil {
+		// 失敗の記録に失敗してもログインの結果は変えない
+		if errors.Is(err, ErrInvalidCredentials) {
+			s.limiter.Failed(ctx, userName, client.IP)
+		} else if err == nil {
+			s.limiter.Succeeded(ctx, userName)
+		}
+	}
 	if err != nil {
 		return "", time.Time{}, err
 	}
@@ -94,22 +220,202 @@ func (s *AuthService) VerifySession(ctx context.Context, sessionID string) (*mod
 	return user, nil
 }
 
-func (s *AuthService) getUser(ctx context.Context, userName string) (*model.User, error) {
+// rehashIfNeeded はパスワードのハッシュの方式・パラメータが今の設定と違えば、今の設定で作り直して保存する
+// ログインはそのまま続けたいので、失敗してもログに出すだけにする
+func (s *AuthService) rehashIfNeeded(ctx context.Context, user *model.User, password string) {
+	if !s.hasher.NeedsRehash(user.PasswordHash) {
+		return
+	}
+	hash, err := s.hasher.Hash(password)
+	if err == nil {
+		err = s.store.UserRepo.UpdatePasswordHash(ctx, user.UserID, hash)
+	}
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", user.UserID, err)
+		return
+	}
 	if s.userCache != nil {
-		if cached := s.userCache.get(userName); cached != nil {
-			return cached, nil
-		}
+		s.userCache.deleteUser(user.UserID)
 	}
-	user, err := s.store.UserRepo.FindByUserName(ctx, userName)
+}
+
+// Register はユーザーを作成する。ログインはしない
+func (s *AuthService) Register(ctx context.Context, userName, password string) (*model.User, error) {
+	userName = strings.TrimSpace(userName)
+	if userName == "" || utf8.RuneCountInString(userName) > maxUserNameLength || strings.HasPrefix(userName, "#") {
+		return nil, fmt.Errorf("%w: user_name must be 1 to %d characters and must not start with '#'", ErrInvalidRegistration, maxUserNameLength)
+	}
+	if err := s.validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	var user *model.User
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		// 一意制約でも弾くが、暗号化前の行と暗号化した行の間の重複は制約では検出できないため先に確かめる
+		if _, err := s.store.UserRepo.FindByUserName(ctx, userName); err == nil {
+			return ErrUserNameTaken
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return ErrInternalServer
+		}
+
+		hash, err := s.hasher.Hash(password)
+		if err != nil {
+			return ErrInternalServer
+		}
+		userID, err := s.store.UserRepo.Create(ctx, userName, hash)
+		if errors.Is(err, repository.ErrDuplicateUserName) {
+			return ErrUserNameTaken
+		}
+		if err != nil {
+			return ErrInternalServer
+		}
+		user = &model.User{UserID: userID, PasswordHash: hash, UserName: userName, Role: model.RoleUser}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	// 登録前にログインを試したユーザー名の「いない」という結果を消す
 	if s.userCache != nil {
-		s.userCache.set(userName, user)
+		s.userCache.delete(userName)
 	}
 	return user, nil
 }
 
+// validatePassword は新しいパスワードがbcryptで扱える長さで、十分に推測しにくいかを確かめる
+func (s *AuthService) validatePassword(password string) error {
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: password must be at most %d bytes", ErrInvalidRegistration, maxPasswordBytes)
+	}
+	if passwordEntropy(password) < s.minPasswordEntropy {
+		return fmt.Errorf("%w: password is too weak; use a longer password mixing letters, digits and symbols", ErrInvalidRegistration)
+	}
+	return nil
+}
+
+// passwordEntropy は使われている文字の種類と長さからパスワードのエントロピー（ビット）を推定する
+// 同じ文字が続く部分は1文字として数える
+func passwordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	length := 0
+	prev := rune(-1)
+	for _, c := range password {
+		if c != prev {
+			length++
+		}
+		prev = c
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// Logout はセッションを削除する。everywhereならそのユーザーのすべてのセッションを削除する
+// 既に無効なセッションでもエラーにしない
+func (s *AuthService) Logout(ctx context.Context, sessionID string, everywhere bool) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if everywhere {
+			userID, err := s.store.SessionRepo.FindUserBySessionID(ctx, sessionID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			if err != nil {
+				return ErrInternalServer
+			}
+			if _, err := s.store.SessionRepo.DeleteAllForUser(ctx, userID); err != nil {
+				return ErrInternalServer
+			}
+			return nil
+		}
+		if err := s.store.SessionRepo.DeleteByUUID(ctx, sessionID); err != nil {
+			return ErrInternalServer
+		}
+		return nil
+	})
+}
+
+// ListSessions はユーザーの有効なセッションを返す。currentSessionIDのセッションには印を付ける
+func (s *AuthService) ListSessions(ctx context.Context, userID int, currentSessionID string) ([]model.SessionInfo, error) {
+	var sessions []model.SessionInfo
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		sessions, err = s.store.SessionRepo.ListActiveForUser(ctx, userID)
+		if err != nil {
+			return ErrInternalServer
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == currentSessionID
+	}
+	return sessions, nil
+}
+
+// RevokeSession はユーザーのセッションを1つ削除する。ユーザーのセッションでなければErrSessionNotFoundを返す
+func (s *AuthService) RevokeSession(ctx context.Context, userID int, id int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		err := s.store.SessionRepo.DeleteForUser(ctx, userID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrSessionNotFound, id)
+		}
+		if err != nil {
+			return ErrInternalServer
+		}
+		return nil
+	})
+}
+
+// getUser はユーザー名でユーザーを引く。いなければsql.ErrNoRowsを返す
+// いなかったことも短い間キャッシュし、存在しないユーザー名でのログインが続いてもDBを引かない
+func (s *AuthService) getUser(ctx context.Context, userName string) (*model.User, error) {
+	if s.userCache != nil {
+		if cached, ok := s.userCache.get(userName); ok {
+			if cached == nil {
+				return nil, sql.ErrNoRows
+			}
+			return cached, nil
+		}
+	}
+	return s.userLookups.do(ctx, userName, func(ctx context.Context) (*model.User, error) {
+		if s.userCache == nil {
+			return s.store.UserRepo.FindByUserName(ctx, userName)
+		}
+		generation := s.userCache.begin()
+		user, err := s.store.UserRepo.FindByUserName(ctx, userName)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.userCache.setMissing(userName, generation)
+		} else if err == nil {
+			s.userCache.set(userName, user, generation)
+		}
+		return user, err
+	})
+}
+
 func parseDurationEnv(key string, fallback time.Duration) time.Duration {
 	raw := os.Getenv(key)
 	if raw == "" {
@@ -121,6 +427,13 @@ func parseDurationEnv(key string, fallback time.Duration) time.Duration {
 	return fallback
 }
 
+func parseBoolEnv(key string, fallback bool) bool {
+	if b, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return b
+	}
+	return fallback
+}
+
 func parseIntEnv(key string, fallback int) int {
 	raw := os.Getenv(key)
 	if raw == "" {
@@ -133,14 +446,19 @@ func parseIntEnv(key string, fallback int) int {
 }
 
 type userCache struct {
-	mx         sync.RWMutex
-	entries    map[string]cachedUser
+	mx      sync.RWMutex
+	entries map[string]cachedUser
+	// エントリを消すたびに進める。読み込みの間に消されていれば、読み込んだ結果を保存しない
+	generation uint64
 	ttl        time.Duration
+	// ユーザーがいなかったことをキャッシュする時間。0ならキャッシュしない
+	missTTL    time.Duration
 	maxEntries int
 }
 
 type cachedUser struct {
 	user      model.User
+	missing   bool
 	expiresAt time.Time
 }
 
@@ -152,7 +470,8 @@ func newUserCache(ttl time.Duration, maxEntries int) *userCache {
 	}
 }
 
-func (c *userCache) get(userName string) *model.User {
+// get はキャッシュしたユーザーを返す。いなかったことをキャッシュしていれば(nil, true)を返す
+func (c *userCache) get(userName string) (*model.User, bool) {
 	c.mx.RLock()
 	entry, ok := c.entries[userName]
 	c.mx.RUnlock()
@@ -162,27 +481,71 @@ func (c *userCache) get(userName string) *model.User {
 			delete(c.entries, userName)
 			c.mx.Unlock()
 		}
-		return nil
+		return nil, false
+	}
+	if entry.missing {
+		return nil, true
 	}
 	userCopy := entry.user
-	return &userCopy
+	return &userCopy, true
+}
+
+// begin はユーザーを読み込む前に呼び、setやsetMissingに渡す世代を返す
+func (c *userCache) begin() uint64 {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.generation
 }
 
-func (c *userCache) set(userName string, user *model.User) {
+// set は読み込んだユーザーを保存する。beginの後にエントリが消されていれば保存しない
+func (c *userCache) set(userName string, user *model.User, generation uint64) {
 	if user == nil {
 		return
 	}
+	c.put(userName, cachedUser{user: *user, expiresAt: time.Now().Add(c.ttl)}, generation)
+}
+
+// setMissing はユーザー名のユーザーがいなかったことをmissTTLの間キャッシュする
+// beginの後に登録などでエントリが消されていれば保存しない
+func (c *userCache) setMissing(userName string, generation uint64) {
+	if c.missTTL <= 0 {
+		return
+	}
+	c.put(userName, cachedUser{missing: true, expiresAt: time.Now().Add(c.missTTL)}, generation)
+}
+
+func (c *userCache) put(userName string, entry cachedUser, generation uint64) {
 	c.mx.Lock()
 	defer c.mx.Unlock()
+	if c.generation != generation {
+		return
+	}
 	if len(c.entries) >= c.maxEntries {
 		c.evictExpiredLocked()
 		if len(c.entries) >= c.maxEntries {
 			c.evictOldestLocked()
 		}
 	}
-	c.entries[userName] = cachedUser{
-		user:      *user,
-		expiresAt: time.Now().Add(c.ttl),
+	c.entries[userName] = entry
+}
+
+// delete はユーザー名のエントリを消す
+func (c *userCache) delete(userName string) {
+	c.mx.Lock()
+	c.generation++
+	delete(c.entries, userName)
+	c.mx.Unlock()
+}
+
+// deleteUser はユーザーIDがuserIDのエントリを消す
+func (c *userCache) deleteUser(userID int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.generation++
+	for key, entry := range c.entries {
+		if !entry.missing && entry.user.UserID == userID {
+			delete(c.entries, key)
+		}
 	}
 }
 
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionDB はsessionsのセッションIDに対応するユーザーIDと、有効期限expiresAtを返す
+type sessionDB struct {
+	orderDB
+	sessions  map[string]int
+	expiresAt time.Time
+}
+
+func (db *sessionDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	userID, ok := db.sessions[args[0].(string)]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	row := reflect.ValueOf(dest).Elem()
+	row.FieldByName("UserID").SetInt(int64(userID))
+	row.FieldByName("ExpiresAt").Set(reflect.ValueOf(db.expiresAt))
+	return nil
+}
+
+func TestSessionSlidingExpiration(t *testing.T) {
+	db := &sessionDB{sessions: map[string]int{"s1": 7, "s2": 8}, expiresAt: time.Now().Add(time.Hour)}
+	store := repository.NewStore(db)
+	store.SessionRepo.SetSliding(24*time.Hour, 6*time.Hour)
+	ctx := context.Background()
+
+	if _, err := store.SessionRepo.FindUserBySessionID(ctx, "s1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.SessionRepo.PendingExpiry("s1"); !ok {
+		t.Fatal("expected session close to expiry to be extended")
+	}
+	db.expiresAt = time.Now().Add(20 * time.Hour)
+	store.SessionRepo.FindUserBySessionID(ctx, "s2")
+	if _, ok := store.SessionRepo.PendingExpiry("s2"); ok {
+		t.Fatal("expected session far from expiry not to be extended")
+	}
+
+	if _, err := store.SessionRepo.FlushExtensions(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 延長しないセッションも最終利用時刻だけは書き込む
+	want := []string{
+		"UPDATE user_sessions SET expires_at = ?, last_seen_at = ? WHERE session_uuid IN (?) AND expires_at > ?",
+		"UPDATE user_sessions SET last_seen_at = ? WHERE session_uuid IN (?) AND expires_at > ?",
+	}
+	if !reflect.DeepEqual(db.writes, want) {
+		t.Fatalf("unexpected writes: %v", db.writes)
+	}
+	if _, ok := store.SessionRepo.PendingExpiry("s1"); ok {
+		t.Fatal("expected flushed session not to be pending")
+	}
+}
+
+// flakySessionDB は書き込みをfailsの回数だけ失敗させる
+type flakySessionDB struct {
+	sessionDB
+	fails int
+}
+
+func (db *flakySessionDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if db.fails > 0 {
+		db.fails--
+		return nil, errors.New("connection reset")
+	}
+	return db.sessionDB.ExecContext(ctx, query, args...)
+}
+
+func TestSessionFlushRequeuesOnFailure(t *testing.T) {
+	db := &flakySessionDB{sessionDB: sessionDB{sessions: map[string]int{"s1": 7}, expiresAt: time.Now().Add(time.Hour)}, fails: 1}
+	store := repository.NewStore(db)
+	store.SessionRepo.SetSliding(24*time.Hour, 6*time.Hour)
+	svc := &AuthService{store: store}
+	ctx := context.Background()
+
+	store.SessionRepo.FindUserBySessionID(ctx, "s1")
+	if err := svc.FlushSessions(ctx); err == nil {
+		t.Fatal("expected flush to fail")
+	}
+	if _, ok := store.SessionRepo.PendingExpiry("s1"); !ok {
+		t.Fatal("expected failed extension to be requeued")
+	}
+
+	if err := svc.FlushSessions(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := store.SessionRepo.PendingExpiry("s1"); ok {
+		t.Fatal("expected flushed session not to be pending")
+	}
+	if len(db.writes) != 1 || !strings.HasPrefix(db.writes[0], "UPDATE user_sessions SET expires_at = ?, last_seen_at = ?") {
+		t.Fatalf("unexpected writes: %v", db.writes)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	db := &sessionDB{sessions: map[string]int{"s1": 7}}
+	svc := &AuthService{store: repository.NewStore(db)}
+	ctx := context.Background()
+
+	if err := svc.Logout(ctx, "s1", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 1 || db.writes[0] != "DELETE FROM user_sessions WHERE session_uuid = ?" {
+		t.Fatalf("unexpected writes: %v", db.writes)
+	}
+
+	db.writes = nil
+	if err := svc.Logout(ctx, "s1", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 1 || db.writes[0] != "DELETE FROM user_sessions WHERE user_id = ?" {
+		t.Fatalf("unexpected writes: %v", db.writes)
+	}
+
+	// 既に無効なセッションでのログアウトはエラーにしない
+	db.writes = nil
+	if err := svc.Logout(ctx, "unknown", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("unexpected writes: %v", db.writes)
+	}
+}
+
+// sessionListDB はユーザーIDごとのセッションを返す
+type sessionListDB struct {
+	orderDB
+	sessions map[int][]model.SessionInfo
+}
+
+func (db *sessionListDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	for _, session := range db.sessions[args[1].(int)] {
+		if session.ID == args[0].(int64) {
+			*dest.(*string) = session.SessionID
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (db *sessionListDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	*dest.(*[]model.SessionInfo) = append([]model.SessionInfo(nil), db.sessions[args[0].(int)]...)
+	return nil
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	db := &sessionListDB{sessions: map[int][]model.SessionInfo{
+		7: {{ID: 1, SessionID: "s1"}, {ID: 2, SessionID: "s2"}},
+		8: {{ID: 3, SessionID: "s3"}},
+	}}
+	svc := &AuthService{store: repository.NewStore(db)}
+	ctx := context.Background()
+
+	sessions, err := svc.ListSessions(ctx, 7, "s2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].Current || !sessions[1].Current {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+
+	if err := svc.RevokeSession(ctx, 7, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 1 || db.writes[0] != "DELETE FROM user_sessions WHERE session_uuid = ?" {
+		t.Fatalf("unexpected writes: %v", db.writes)
+	}
+
+	// 他のユーザーのセッションは削除できない
+	db.writes = nil
+	if err := svc.RevokeSession(ctx, 7, 3); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("unexpected writes: %v", db.writes)
+	}
+}
+
+func TestPasswordEntropy(t *testing.T) {
+	weak := []string{"", "password", "aaaaaaaaaaaaaaaaaaaa", "12345678"}
+	strong := []string{"correcthorsebattery", "Tr0ub4dor&3x", "s3cure-passw0rd"}
+	for _, p := range weak {
+		if e := passwordEntropy(p); e >= 50 {
+			t.Errorf("expected %q to be weak, got %.1f bits", p, e)
+		}
+	}
+	for _, p := range strong {
+		if e := passwordEntropy(p); e < 50 {
+			t.Errorf("expected %q to be strong, got %.1f bits", p, e)
+		}
+	}
+}
+
+// registerDB は既存のユーザー名をusersに持ち、作成したユーザーのIDとして100を返す
+type registerDB struct {
+	orderDB
+	users map[string]bool
+}
+
+func (db *registerDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if !db.users[args[0].(string)] {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *registerDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db.users[args[1].(string)] = true
+	return insertResult(100), nil
+}
+
+func TestRegister(t *testing.T) {
+	db := &registerDB{users: map[string]bool{"taken": true}}
+	svc := &AuthService{store: repository.NewStore(db), hasher: &passwordHasher{algorithm: passwordHashBcrypt, bcryptCost: bcrypt.MinCost}, minPasswordEntropy: 50}
+	ctx := context.Background()
+
+	user, err := svc.Register(ctx, " alice ", "correcthorsebattery")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.UserID != 100 || user.UserName != "alice" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("correcthorsebattery")) != nil {
+		t.Fatalf("password hash does not match")
+	}
+
+	if _, err := svc.Register(ctx, "taken", "correcthorsebattery"); !errors.Is(err, ErrUserNameTaken) {
+		t.Fatalf("expected ErrUserNameTaken, got %v", err)
+	}
+	for _, tc := range []struct{ name, password string }{
+		{"bob", "password"},
+		{"", "correcthorsebattery"},
+		{"#1", "correcthorsebattery"},
+		{"bob", strings.Repeat("ab1!", 20)},
+	} {
+		if _, err := svc.Register(ctx, tc.name, tc.password); !errors.Is(err, ErrInvalidRegistration) {
+			t.Errorf("expected ErrInvalidRegistration for %q/%q, got %v", tc.name, tc.password, err)
+		}
+	}
+}
+
+// countingHasher はVerifyに渡したハッシュを記録する
+type countingHasher struct {
+	*passwordHasher
+	verified []string
+}
+
+func (h *countingHasher) Verify(hash, password string) bool {
+	h.verified = append(h.verified, hash)
+	return h.passwordHasher.Verify(hash, password)
+}
+
+func TestLoginFailureComparesDummyHash(t *testing.T) {
+	t.Setenv("AUTH_BCRYPT_COST", strconv.Itoa(bcrypt.MinCost))
+	inner, err := newPasswordHasherFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := bcrypt.Cost([]byte(inner.DummyHash())); err != nil {
+		t.Fatalf("expected a bcrypt dummy hash, got %q: %v", inner.DummyHash(), err)
+	}
+	hash, err := inner.Hash("correcthorsebattery")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hasher := &countingHasher{passwordHasher: inner}
+	db := &userLookupDB{users: map[string]model.User{"alice": {UserID: 1, UserName: "alice", PasswordHash: hash}}}
+	svc := &AuthService{store: repository.NewStore(db), hasher: hasher}
+	ctx := context.Background()
+
+	// ユーザーがいてもいなくても、ハッシュを1回比べて同じエラーを返す
+	for userName, want := range map[string]string{"alice": hash, "nobody": inner.DummyHash()} {
+		hasher.verified = nil
+		if _, _, err := svc.Login(ctx, userName, "wrong-password", model.ClientInfo{}); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected ErrInvalidCredentials for %q, got %v", userName, err)
+		}
+		if len(hasher.verified) != 1 || hasher.verified[0] != want {
+			t.Fatalf("expected %q to compare %q once, got %q", userName, want, hasher.verified)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+var ErrUnknownCategory = errors.New("unknown category")
+
+// expandCategory はreq.CategoryIDのカテゴリとその子孫のカテゴリIDをreq.CategoryIDsに設定する
+// 存在しないカテゴリはErrUnknownCategoryを返す。CategoryIDが0なら何もしない
+func expandCategory(ctx context.Context, store *repository.Store, req *model.ListRequest) error {
+	req.CategoryIDs = nil
+	if req.CategoryID == 0 {
+		return nil
+	}
+	categories, err := store.CategoryRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+	children := make(map[int][]int, len(categories))
+	found := false
+	for _, c := range categories {
+		if c.CategoryID == req.CategoryID {
+			found = true
+		}
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.CategoryID)
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %d", ErrUnknownCategory, req.CategoryID)
+	}
+
+	ids := []int{req.CategoryID}
+	seen := map[int]bool{req.CategoryID: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	req.CategoryIDs = ids
+	return nil
+}
+
+// ListCategories はすべてのカテゴリを返す。階層はparent_idでたどる
+func (s *ProductService) ListCategories(ctx context.Context) ([]model.Category, error) {
+	return s.store.CategoryRepo.List(ctx)
+}
+
+// CategoryFacets はreqの検索に一致する商品の数をカテゴリごとに返す
+func (s *ProductService) CategoryFacets(ctx context.Context, userID int, req model.ListRequest) ([]model.CategoryFacet, error) {
+	return s.store.ProductRepo.CategoryFacets(ctx, userID, req)
+}
+
+// CategoryFacets はreqの絞り込みに一致するユーザーの注文の数を、商品のカテゴリごとに返す
+func (s *OrderService) CategoryFacets(ctx context.Context, userID int, req model.ListRequest) ([]model.CategoryFacet, error) {
+	return s.store.OrderRepo.CategoryFacets(ctx, userID, req)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// categoryDB はカテゴリの一覧の読み込みにcategoriesを返す
+type categoryDB struct {
+	orderDB
+	categories []model.Category
+}
+
+func (db *categoryDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	*dest.(*[]model.Category) = db.categories
+	return nil
+}
+
+func TestExpandCategoryIncludesDescendants(t *testing.T) {
+	parent := func(id int) *int { return &id }
+	store := repository.NewStore(&categoryDB{categories: []model.Category{
+		{CategoryID: 1, Name: "家具"},
+		{CategoryID: 2, ParentID: parent(1), Name: "椅子"},
+		{CategoryID: 3, ParentID: parent(2), Name: "オフィスチェア"},
+		{CategoryID: 4, Name: "家電"},
+		{CategoryID: 5, ParentID: parent(1), Name: "机"},
+	}})
+
+	req := model.ListRequest{CategoryID: 1}
+	if err := expandCategory(context.Background(), store, &req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(req.CategoryIDs, []int{1, 2, 5, 3}) {
+		t.Fatalf("expected the category and its descendants, got %v", req.CategoryIDs)
+	}
+
+	req = model.ListRequest{CategoryID: 9}
+	if err := expandCategory(context.Background(), store, &req); !errors.Is(err, ErrUnknownCategory) {
+		t.Fatalf("expected ErrUnknownCategory, got %v", err)
+	}
+	req = model.ListRequest{}
+	if err := expandCategory(context.Background(), store, &req); err != nil || req.CategoryIDs != nil {
+		t.Fatalf("expected no category filter, got %v, %v", req.CategoryIDs, err)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrNoRetryHandler     = errors.New("no retry handler registered for kind")
+)
+
+// RetryFunc はデッドレターのペイロードを受け取り、元の処理を再実行する
+type RetryFunc func(ctx context.Context, payload string) error
+
+// DeadLetterService は最終的に失敗した非同期処理を保存し、再試行・破棄を提供する
+type DeadLetterService struct {
+	store *repository.Store
+
+	mx       sync.RWMutex
+	handlers map[string]RetryFunc
+}
+
+func NewDeadLetterService(store *repository.Store) *DeadLetterService {
+	return &DeadLetterService{store: store, handlers: make(map[string]RetryFunc)}
+}
+
+// RegisterRetryHandler は種別ごとの再実行処理を登録する
+func (s *DeadLetterService) RegisterRetryHandler(kind string, fn RetryFunc) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.handlers[kind] = fn
+}
+
+// Record は失敗した処理を保存する。保存自体に失敗した場合はログに残して破棄する
+func (s *DeadLetterService) Record(ctx context.Context, kind, payload string, cause error) {
+	if _, err := s.store.DeadLetterRepo.Create(ctx, kind, payload, cause.Error()); err != nil {
+		log.Printf("Failed to record dead letter (kind=%s): %v; original error: %v", kind, err, cause)
+	}
+}
+
+// List はデッドレターを新しい順に返す。期限が迫って途中で打ち切った場合はpartialがtrueになる
+func (s *DeadLetterService) List(ctx context.Context, kind string, limit, offset int) (letters []model.DeadLetter, partial bool, err error) {
+	return s.store.DeadLetterRepo.List(ctx, kind, limit, offset)
+}
+
+// Depth は種別ごとの滞留件数を返す
+func (s *DeadLetterService) Depth(ctx context.Context) ([]repository.DeadLetterDepth, error) {
+	return s.store.DeadLetterRepo.CountByKind(ctx)
+}
+
+// Retry はデッドレターを再実行し、成功したら削除する。失敗した場合は試行回数を増やして残す
+func (s *DeadLetterService) Retry(ctx context.Context, id int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		dl, err := s.store.DeadLetterRepo.FindByID(ctx, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrDeadLetterNotFound
+			}
+			return err
+		}
+
+		s.mx.RLock()
+		fn, ok := s.handlers[dl.Kind]
+		s.mx.RUnlock()
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNoRetryHandler, dl.Kind)
+		}
+
+		if retryErr := fn(ctx, dl.Payload); retryErr != nil {
+			if err := s.store.DeadLetterRepo.MarkFailed(ctx, id, retryErr.Error()); err != nil {
+				return err
+			}
+			return retryErr
+		}
+		_, err = s.store.DeadLetterRepo.Delete(ctx, id)
+		return err
+	})
+}
+
+// Discard はデッドレターを再実行せずに削除する
+func (s *DeadLetterService) Discard(ctx context.Context, id int64) error {
+	deleted, err := s.store.DeadLetterRepo.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ロボットが報告できる配送失敗の理由
+var deliveryFailureReasons = map[string]bool{
+	"recipient_absent":  true,
+	"address_not_found": true,
+	"access_denied":     true,
+	"damaged":           true,
+	"robot_fault":       true,
+	"other":             true,
+}
+
+// FailDelivery はrobotIDのロボットが配送中の注文の配送に失敗したことを記録する
+// 注文は配送待ちに戻して失敗の回数を数え、回数がmaxDeliveryRetriesを超えたら配送失敗にして以後の計画から外す
+func (s *RobotService) FailDelivery(ctx context.Context, robotID string, orderID int64, reason string) (*model.DeliveryFailureResponse, error) {
+	if !deliveryFailureReasons[reason] {
+		return nil, fmt.Errorf("%w: unknown failure reason %q", ErrInvalidStatusUpdate, reason)
+	}
+
+	var resp *model.DeliveryFailureResponse
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			order, err := txStore.OrderRepo.LockRetryCount(ctx, orderID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("%w: %d", ErrOrderNotFound, orderID)
+				}
+				return err
+			}
+			if err := checkAssignment(order, robotID); err != nil {
+				return err
+			}
+			status, retries := order.ShippedStatus, order.RetryCount
+			next := "shipping"
+			if retries+1 > s.maxDeliveryRetries {
+				next = "failed"
+			}
+			if err := checkTransition(orderID, status, next); err != nil {
+				return err
+			}
+
+			if err := txStore.OrderRepo.IncrementRetryCount(ctx, orderID); err != nil {
+				return err
+			}
+			if err := txStore.OrderEventRepo.AppendDeliveryFailure(ctx, orderID, next, robotID, reason); err != nil {
+				return err
+			}
+			if err := txStore.OrderEventRepo.Project(ctx, []int64{orderID}); err != nil {
+				return err
+			}
+			resp = &model.DeliveryFailureResponse{OrderID: orderID, ShippedStatus: next, RetryCount: retries + 1}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish([]int64{orderID}, resp.ShippedStatus)
+	return resp, nil
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/objectstore"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrProofTooLarge        = errors.New("delivery proof is too large")
+	ErrUnsupportedProofType = errors.New("unsupported delivery proof type")
+	ErrOrderNotCompleted    = errors.New("order is not completed")
+)
+
+// 配達証明として受け付ける形式と保存時の拡張子
+// 種別はクライアントの申告ではなく内容から判定する
+var proofContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// DeliveryProofService は配送完了した注文に添付する配達証明（写真・署名）を管理する
+type DeliveryProofService struct {
+	store    *repository.Store
+	objects  objectstore.Store
+	signer   *objectstore.URLSigner
+	maxBytes int64
+	urlTTL   time.Duration
+}
+
+// ダウンロードURLのパス。server側のルーティングと合わせること
+const ObjectDownloadPath = "/api/objects"
+
+func NewDeliveryProofService(store *repository.Store, objects objectstore.Store) (*DeliveryProofService, error) {
+	signer, err := objectstore.NewURLSigner(ObjectDownloadPath, []byte(os.Getenv("OBJECT_URL_SECRET")))
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("OBJECT_URL_SECRET") == "" {
+		log.Println("Warning: OBJECT_URL_SECRET is not set. Signed download URLs are invalidated on restart")
+	}
+	return &DeliveryProofService{
+		store:    store,
+		objects:  objects,
+		signer:   signer,
+		maxBytes: int64(parseIntEnv("DELIVERY_PROOF_MAX_BYTES", 5<<20)),
+		urlTTL:   parseDurationEnv("DELIVERY_PROOF_URL_TTL", 15*time.Minute),
+	}, nil
+}
+
+// MaxBytes は受け付ける配達証明の最大サイズ
+func (s *DeliveryProofService) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// Attach は配送完了した注文に配達証明を添付する。既に添付済みなら差し替える
+func (s *DeliveryProofService) Attach(ctx context.Context, orderID int64, data []byte) (*model.DeliveryProof, error) {
+	if int64(len(data)) > s.maxBytes {
+		return nil, ErrProofTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	ext, ok := proofContentTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedProofType, contentType)
+	}
+
+	proof := &model.DeliveryProof{
+		OrderID:     orderID,
+		ObjectKey:   fmt.Sprintf("delivery-proofs/%d/%s%s", orderID, uuid.NewString(), ext),
+		ContentType: contentType,
+		SizeBytes:   len(data),
+		UploadedAt:  time.Now(),
+	}
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		status, err := s.store.OrderRepo.GetStatusByID(ctx, orderID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrOrderNotFound
+			}
+			return err
+		}
+		if status != "completed" {
+			return ErrOrderNotCompleted
+		}
+		// 本体を先に保存し、参照が保存されていないオブジェクトだけが残り得るようにする
+		if err := s.objects.Put(ctx, proof.ObjectKey, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		return s.store.ProofRepo.Upsert(ctx, proof)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.sign(proof)
+	return proof, nil
+}
+
+// ForOrder は注文の配達証明を署名付きURL付きで返す。添付がなければnil
+func (s *DeliveryProofService) ForOrder(ctx context.Context, orderID int64) (*model.DeliveryProof, error) {
+	proof, err := s.store.ProofRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	s.sign(proof)
+	return proof, nil
+}
+
+// OpenSigned は署名付きURLを検証してオブジェクトを開き、Content-Typeとともに返す
+func (s *DeliveryProofService) OpenSigned(ctx context.Context, key, expires, signature string) (io.ReadCloser, string, error) {
+	if err := s.signer.Verify(key, expires, signature); err != nil {
+		return nil, "", err
+	}
+	body, err := s.objects.Open(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, mime.TypeByExtension(path.Ext(key)), nil
+}
+
+func (s *DeliveryProofService) sign(proof *model.DeliveryProof) {
+	proof.URL, proof.ExpiresAt = s.signer.Sign(proof.ObjectKey, s.urlTTL)
+}
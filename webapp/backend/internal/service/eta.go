@@ -0,0 +1,33 @@
+package service
+
+import (
+	"time"
+
+	"backend/internal/model"
+)
+
+// CostModel は配送計画の注文をすべて配り終えるまでの所要時間を見積もる
+type CostModel interface {
+	Estimate(orders []model.Order) time.Duration
+}
+
+// linearCostModel は注文1件ごとの受け渡しの時間と、重量1kgあたりの移動の時間の和で見積もる
+type linearCostModel struct {
+	perItem     time.Duration
+	perKilogram time.Duration
+}
+
+func newCostModelFromEnv() CostModel {
+	return linearCostModel{
+		perItem:     parseDurationEnv("ROBOT_ETA_PER_ITEM", 30*time.Second),
+		perKilogram: parseDurationEnv("ROBOT_ETA_PER_KG", 5*time.Second),
+	}
+}
+
+func (m linearCostModel) Estimate(orders []model.Order) time.Duration {
+	var weight model.Grams
+	for _, o := range orders {
+		weight += o.Weight
+	}
+	return time.Duration(len(orders))*m.perItem + time.Duration(weight.Kilograms()*float64(m.perKilogram))
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// AddFavorite は商品を利用者のお気に入りに加える。すでにお気に入りなら何もしない
+// 存在しない商品と削除した商品はErrProductNotFoundを返す
+func (s *ProductService) AddFavorite(ctx context.Context, userID, productID int) error {
+	_, err := s.store.ProductRepo.GetByID(ctx, productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.store.FavoriteRepo.Add(ctx, userID, productID); err != nil {
+		return err
+	}
+	s.lists.invalidateUser(userID)
+	return nil
+}
+
+// RemoveFavorite は商品を利用者のお気に入りから外す。お気に入りでなければ何もしない
+func (s *ProductService) RemoveFavorite(ctx context.Context, userID, productID int) error {
+	if err := s.store.FavoriteRepo.Remove(ctx, userID, productID); err != nil {
+		return err
+	}
+	s.lists.invalidateUser(userID)
+	return nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"backend/internal/telemetry"
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// HealthService はオートスケーラー向けに、複数の指標を0〜100の単一スコアにまとめる
+// 各指標を0（限界）〜1（余裕あり）に正規化し、設定した重みで加重平均する
+type HealthService struct {
+	dbStats     func() sql.DBStats
+	requests    *telemetry.RequestStats
+	deadLetters *DeadLetterService
+	events      *OrderEventBus
+
+	window  time.Duration
+	weights healthWeights
+	limits  healthLimits
+}
+
+type healthWeights struct {
+	dbPool  float64
+	latency float64
+	errors  float64
+	queues  float64
+}
+
+// 各指標がこの値に達したときにその指標のスコアを0とする
+type healthLimits struct {
+	p99             time.Duration
+	errorRate       float64
+	deadLetterDepth int
+}
+
+// HealthComponent は1指標分のスコアと元の値
+type HealthComponent struct {
+	Score  float64 `json:"score"`
+	Weight float64 `json:"weight"`
+	Value  float64 `json:"value"`
+}
+
+type HealthScore struct {
+	Score      int                        `json:"score"`
+	Components map[string]HealthComponent `json:"components"`
+}
+
+func NewHealthService(dbStats func() sql.DBStats, requests *telemetry.RequestStats, deadLetters *DeadLetterService, events *OrderEventBus) *HealthService {
+	return &HealthService{
+		dbStats:     dbStats,
+		requests:    requests,
+		deadLetters: deadLetters,
+		events:      events,
+		window:      parseDurationEnv("HEALTH_WINDOW", time.Minute),
+		weights: healthWeights{
+			dbPool:  parseWeightEnv("HEALTH_WEIGHT_DB_POOL", 1),
+			latency: parseWeightEnv("HEALTH_WEIGHT_LATENCY", 1),
+			errors:  parseWeightEnv("HEALTH_WEIGHT_ERRORS", 1),
+			queues:  parseWeightEnv("HEALTH_WEIGHT_QUEUES", 1),
+		},
+		limits: healthLimits{
+			p99:             parseDurationEnv("HEALTH_P99_LIMIT", time.Second),
+			errorRate:       float64(parseIntEnv("HEALTH_ERROR_RATE_LIMIT_PERCENT", 5)) / 100,
+			deadLetterDepth: parseIntEnv("HEALTH_DEAD_LETTER_LIMIT", 1000),
+		},
+	}
+}
+
+// Score は現在のヘルススコアを計算する
+func (s *HealthService) Score(ctx context.Context) HealthScore {
+	components := make(map[string]HealthComponent, 4)
+
+	pool := s.dbStats()
+	saturation := 0.0
+	if pool.MaxOpenConnections > 0 {
+		saturation = float64(pool.InUse) / float64(pool.MaxOpenConnections)
+	}
+	components["db_pool"] = HealthComponent{Score: headroom(saturation), Weight: s.weights.dbPool, Value: saturation}
+
+	snap := s.requests.Snapshot(s.window)
+	components["latency"] = HealthComponent{
+		Score:  headroom(float64(snap.P99) / float64(s.limits.p99)),
+		Weight: s.weights.latency,
+		Value:  snap.P99.Seconds(),
+	}
+	components["errors"] = HealthComponent{
+		Score:  headroom(snap.ErrorRate / s.limits.errorRate),
+		Weight: s.weights.errors,
+		Value:  snap.ErrorRate,
+	}
+
+	// キューは最も逼迫しているものを採用する
+	waiters, maxWaiters := s.events.Waiters()
+	queueLoad := float64(waiters) / float64(maxWaiters)
+	depth := 0
+	if depths, err := s.deadLetters.Depth(ctx); err != nil {
+		log.Printf("Failed to read dead letter depth for health score: %v", err)
+		queueLoad = 1
+	} else {
+		for _, d := range depths {
+			depth += d.Count
+		}
+		queueLoad = math.Max(queueLoad, float64(depth)/float64(s.limits.deadLetterDepth))
+	}
+	components["queues"] = HealthComponent{Score: headroom(queueLoad), Weight: s.weights.queues, Value: queueLoad}
+
+	var weighted, total float64
+	for _, c := range components {
+		weighted += c.Score * c.Weight
+		total += c.Weight
+	}
+	score := 100
+	if total > 0 {
+		score = int(math.Round(100 * weighted / total))
+	}
+	return HealthScore{Score: score, Components: components}
+}
+
+// 負荷率(0〜1, 1で限界)を余裕度(1〜0)に変換する
+func headroom(load float64) float64 {
+	if math.IsNaN(load) || load <= 0 {
+		return 1
+	}
+	if load >= 1 {
+		return 0
+	}
+	return 1 - load
+}
+
+// 重みは0で無効化できるよう、0以上の数値を受け付ける
+func parseWeightEnv(key string, fallback float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	if v, err := strconv.ParseFloat(raw, 64); err == nil && v >= 0 && !math.IsInf(v, 0) {
+		return v
+	}
+	return fallback
+}
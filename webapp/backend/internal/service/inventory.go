@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"backend/internal/repository"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// InventoryService は商品の在庫を、注文の作成で減らし取り消しで戻す
+// 在庫の増減は注文の作成・取り消しと同じトランザクションで行う
+//
+// unlimitedなら在庫を数えず、在庫の列によらず注文を受け付ける（在庫を管理する前と同じ動作）。
+type InventoryService struct {
+	unlimited bool
+}
+
+func NewInventoryService() *InventoryService {
+	return &InventoryService{unlimited: parseBoolEnv("INVENTORY_UNLIMITED", true)}
+}
+
+// Reserve は商品IDごとの数量だけ在庫を減らす。在庫が足りない商品があればErrInsufficientStockを返す
+// 途中で失敗した場合に減らした在庫は、トランザクションのロールバックで戻る
+func (s *InventoryService) Reserve(ctx context.Context, txStore *repository.Store, quantities map[int]int) error {
+	if s.unlimited {
+		return nil
+	}
+	for _, productID := range sortedProductIDs(quantities) {
+		ok, err := txStore.ProductRepo.DecrementStock(ctx, productID, quantities[productID])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: product %d", ErrInsufficientStock, productID)
+		}
+	}
+	return nil
+}
+
+// Restore は取り消した注文の商品の在庫を戻す
+func (s *InventoryService) Restore(ctx context.Context, txStore *repository.Store, orderIDs []int64) error {
+	if s.unlimited || len(orderIDs) == 0 {
+		return nil
+	}
+	quantities, err := txStore.OrderRepo.CountByProduct(ctx, orderIDs)
+	if err != nil {
+		return err
+	}
+	for _, productID := range sortedProductIDs(quantities) {
+		if err := txStore.ProductRepo.IncrementStock(ctx, productID, quantities[productID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedProductIDs は商品IDを昇順に返す。商品の行ロックを常に同じ順に取り、デッドロックを避ける
+func sortedProductIDs(quantities map[int]int) []int {
+	ids := make([]int, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
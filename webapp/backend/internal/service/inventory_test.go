@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/repository"
+)
+
+// stockDB は商品IDごとの在庫を持ち、在庫を増減する更新だけを受け付ける
+type stockDB struct {
+	orderDB
+	stock map[int]int
+}
+
+func (db *stockDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	n, productID := args[0].(int), args[1].(int)
+	switch {
+	case strings.Contains(query, "stock - ?"):
+		if db.stock[productID] < n {
+			return driver.RowsAffected(0), nil
+		}
+		db.stock[productID] -= n
+	case strings.Contains(query, "stock + ?"):
+		db.stock[productID] += n
+	default:
+		return nil, errors.New("unexpected query")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func TestInventoryReserve(t *testing.T) {
+	db := &stockDB{stock: map[int]int{1: 3, 2: 1}}
+	store := repository.NewStore(db)
+	inventory := &InventoryService{}
+
+	if err := inventory.Reserve(context.Background(), store, map[int]int{1: 2, 2: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[int]int{1: 1, 2: 0}; !reflect.DeepEqual(db.stock, want) {
+		t.Fatalf("expected stock %v, got %v", want, db.stock)
+	}
+	if err := inventory.Reserve(context.Background(), store, map[int]int{2: 1}); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+	if err := store.ProductRepo.IncrementStock(context.Background(), 2, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if db.stock[2] != 1 {
+		t.Fatalf("expected stock 1 after restoring, got %d", db.stock[2])
+	}
+}
+
+func TestInventoryUnlimited(t *testing.T) {
+	db := &stockDB{stock: map[int]int{}}
+	inventory := &InventoryService{unlimited: true}
+	if err := inventory.Reserve(context.Background(), repository.NewStore(db), map[int]int{1: 5}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.stock) != 0 {
+		t.Fatalf("expected no stock updates, got %v", db.stock)
+	}
+}
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/telemetry"
+)
+
+// 注文の選定に使った解法。解法ごとの集計に使う
+const (
+	strategyGreedy = "greedy"
+	// 貪欲解が分数緩和の上界に達しており、そのまま最適解として使った
+	strategyGreedyOptimal = "greedy_optimal"
+	// 貪欲解がepsilonの許容範囲に収まった
+	strategyEpsilonGreedy = "epsilon_greedy"
+	// epsilonの許容範囲の貪欲解を分枝限定法で改善した（partialは時間予算内に探索を終えられなかった）
+	strategyBranchAndBound        = "branch_and_bound"
+	strategyBranchAndBoundPartial = "branch_and_bound_partial"
+	strategyDP                    = "dp"
+	strategyParallelDP            = "dp_parallel"
+	strategyVolumeDP              = "volume_dp"
+	strategyVolumeGreedy          = "volume_greedy"
+	// 2次元DPの計算量の上限を超え、貪欲解を分枝限定法で改善した
+	strategyVolumeBranchAndBound        = "volume_branch_and_bound"
+	strategyVolumeBranchAndBoundPartial = "volume_branch_and_bound_partial"
+)
+
+type pathNode struct {
+	itemIndex int
+	prevIdx   int
+}
+
+// solvePlan はプロファイルのアルゴリズムに従って注文を選ぶ
+// epsilonの許容範囲で貪欲解を採用する場合も、時間予算があれば分枝限定法で厳密解を探し、価値の高い方を返す
+func solvePlan(ctx context.Context, items []model.Order, capacity model.Grams, opts planOptions) ([]bool, string, error) {
+	if opts.algorithm == plannerGreedy || opts.epsilon > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		bounds := newFractionalBounds(items)
+		lowerBound, greedy := bounds.greedy(capacity)
+		if opts.algorithm == plannerGreedy {
+			return greedy, strategyGreedy, nil
+		}
+		upperBound := bounds.upperBound(capacity, -1)
+		if float64(lowerBound) >= (1-opts.epsilon)*float64(upperBound) {
+			if lowerBound >= upperBound {
+				return greedy, strategyGreedyOptimal, nil
+			}
+			if opts.exactBudget <= 0 {
+				return greedy, strategyEpsilonGreedy, nil
+			}
+			chosen, complete, err := solveBranchAndBound(ctx, items, capacity, -1, greedy, opts.exactBudget)
+			if !complete {
+				return chosen, strategyBranchAndBoundPartial, err
+			}
+			return chosen, strategyBranchAndBound, err
+		}
+	}
+	return solveKnapsack(ctx, items, capacity, opts.parallelism)
+}
+
+// solveKnapsack は重量が正の注文について0-1ナップサック問題を厳密に解き、
+// itemsと同じ並びで各注文を選ぶかどうかを返す
+//
+// 貪欲解を下界として使い、分数緩和による上界と比較して採否が確定する注文を先に固定する。
+// 上界が下界を下回る選択肢は最適解になり得ないため、固定しても最適性は失われない。
+// 貪欲解が全体の上界に達していればDPを省略し、そうでなければ未確定の注文だけをDPで解く。
+// 未確定の注文と積載量が十分に大きければ、DPの表を最大parallelism個のワーカーで分けて計算する。
+// 選んだ注文とあわせて、使った解法を返す
+func solveKnapsack(ctx context.Context, items []model.Order, capacity model.Grams, parallelism int) ([]bool, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	chosen := make([]bool, len(items))
+	if capacity <= 0 || len(items) == 0 {
+		return chosen, strategyGreedyOptimal, nil
+	}
+
+	bounds := newFractionalBounds(items)
+	lowerBound, greedy := bounds.greedy(capacity)
+	if lowerBound >= bounds.upperBound(capacity, -1) {
+		return greedy, strategyGreedyOptimal, nil
+	}
+
+	const (
+		undecided = iota
+		fixedIn
+		fixedOut
+	)
+	state := make([]int, len(items))
+	remaining := capacity
+	for i, item := range items {
+		switch {
+		case item.Weight > capacity || item.Value <= 0:
+			state[i] = fixedOut
+		case item.Value+bounds.upperBound(capacity-item.Weight, i) < lowerBound:
+			state[i] = fixedOut
+		case bounds.upperBound(capacity, i) < lowerBound:
+			state[i] = fixedIn
+			remaining -= item.Weight
+		}
+	}
+
+	free := make([]int, 0, len(items))
+	var freeWeight model.Grams
+	for i, st := range state {
+		switch st {
+		case fixedIn:
+			chosen[i] = true
+		case undecided:
+			free = append(free, i)
+			freeWeight += items[i].Weight
+		}
+	}
+	if remaining > freeWeight {
+		remaining = freeWeight
+	}
+	if len(free) == 0 || remaining <= 0 {
+		return chosen, strategyDP, nil
+	}
+	if workers := parallelDPWorkers(parallelism, len(free), remaining); workers > 1 {
+		if err := parallelKnapsackDP(ctx, items, free, remaining, workers, chosen); err != nil {
+			return nil, "", err
+		}
+		return chosen, strategyParallelDP, nil
+	}
+
+	// DPの表はグラム単位の積載量を添字、ポイント単位の価値を値とする
+	bestValue := make([]model.Points, remaining+1)
+	bestPathIdx := make([]int, remaining+1)
+	for i := range bestPathIdx {
+		bestPathIdx[i] = -1
+	}
+	paths := make([]pathNode, 0, len(free))
+
+	const checkEvery = 4096
+	steps := 0
+
+	for _, idx := range free {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		w := items[idx].Weight
+		if w > remaining {
+			continue
+		}
+		v := items[idx].Value
+		for currentCap := remaining; currentCap >= w; currentCap-- {
+			candidate := bestValue[currentCap-w] + v
+			if candidate > bestValue[currentCap] {
+				bestValue[currentCap] = candidate
+				prevIdx := bestPathIdx[currentCap-w]
+				pathIdx := len(paths)
+				paths = append(paths, pathNode{itemIndex: idx, prevIdx: prevIdx})
+				bestPathIdx[currentCap] = pathIdx
+			}
+			steps++
+			if steps%checkEvery == 0 {
+				select {
+				case <-ctx.Done():
+					return nil, "", ctx.Err()
+				default:
+				}
+			}
+		}
+	}
+
+	var (
+		bestCap  model.Grams
+		maxValue model.Points
+	)
+	for cap := model.Grams(0); cap <= remaining; cap++ {
+		if bestValue[cap] > maxValue {
+			maxValue = bestValue[cap]
+			bestCap = cap
+		}
+	}
+	for idx := bestPathIdx[bestCap]; idx != -1; idx = paths[idx].prevIdx {
+		chosen[paths[idx].itemIndex] = true
+	}
+	return chosen, strategyDP, nil
+}
+
+// fractionalBounds は価値密度の降順に並べた注文の累積和を持ち、
+// 分数緩和の上界をO(log n)で求める
+type fractionalBounds struct {
+	items []model.Order
+	order []int // 価値密度の降順に並べたitemsの添字
+	rank  []int // itemsの添字 -> order上の位置
+	prefW []model.Grams
+	prefV []model.Points
+	n     int
+}
+
+func newFractionalBounds(items []model.Order) *fractionalBounds {
+	n := len(items)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		x, y := items[order[a]], items[order[b]]
+		// 価値密度の比較は交差積で行う（ポイント×グラム）
+		return int(x.Value)*int(y.Weight) > int(y.Value)*int(x.Weight)
+	})
+	rank := make([]int, n)
+	prefW := make([]model.Grams, n+1)
+	prefV := make([]model.Points, n+1)
+	for pos, idx := range order {
+		rank[idx] = pos
+		prefW[pos+1] = prefW[pos] + items[idx].Weight
+		prefV[pos+1] = prefV[pos] + items[idx].Value
+	}
+	return &fractionalBounds{items: items, order: order, rank: rank, prefW: prefW, prefV: prefV, n: n}
+}
+
+// greedy は価値密度順に入るものを詰めた解とその価値を返す
+// 単独で最も価値の高い注文の方が良ければそちらを返す
+func (b *fractionalBounds) greedy(capacity model.Grams) (model.Points, []bool) {
+	chosen := make([]bool, b.n)
+	remaining := capacity
+	var value model.Points
+	bestSingle := -1
+	for _, idx := range b.order {
+		item := b.items[idx]
+		if item.Value <= 0 || item.Weight > capacity {
+			continue
+		}
+		if bestSingle == -1 || item.Value > b.items[bestSingle].Value {
+			bestSingle = idx
+		}
+		if item.Weight <= remaining {
+			chosen[idx] = true
+			remaining -= item.Weight
+			value += item.Value
+		}
+	}
+	if bestSingle != -1 && b.items[bestSingle].Value > value {
+		chosen = make([]bool, b.n)
+		chosen[bestSingle] = true
+		value = b.items[bestSingle].Value
+	}
+	return value, chosen
+}
+
+// upperBound はskip番目の注文を除いた分数緩和の最適値（切り捨て）を返す。skipが負なら除外なし
+func (b *fractionalBounds) upperBound(capacity model.Grams, skip int) model.Points {
+	if capacity <= 0 {
+		return 0
+	}
+	skipPos := b.n
+	var (
+		skipW model.Grams
+		skipV model.Points
+	)
+	m := b.n
+	if skip >= 0 {
+		skipPos = b.rank[skip]
+		skipW, skipV = b.items[skip].Weight, b.items[skip].Value
+		m--
+	}
+	// skipを除いた並びの先頭t件の重量・価値
+	prefix := func(t int) (model.Grams, model.Points) {
+		if t <= skipPos {
+			return b.prefW[t], b.prefV[t]
+		}
+		return b.prefW[t+1] - skipW, b.prefV[t+1] - skipV
+	}
+	at := func(t int) model.Order {
+		if t < skipPos {
+			return b.items[b.order[t]]
+		}
+		return b.items[b.order[t+1]]
+	}
+
+	t := sort.Search(m+1, func(t int) bool {
+		w, _ := prefix(t)
+		return w > capacity
+	}) - 1
+	w, v := prefix(t)
+	if t < m {
+		next := at(t)
+		if next.Value > 0 {
+			v += model.Points(int(capacity-w) * int(next.Value) / int(next.Weight))
+		}
+	}
+	return v
+}
+
+// recordSolve は1回の選定を解法ごとの集計に記録する
+// 解の質は分数緩和の上界と比べる。容積も制約する場合は重量と容積それぞれの上界の小さい方を使う
+func recordSolve(stats *telemetry.SolverStats, strategy string, items []model.Order, chosen []bool, weightCap model.Grams, volumeCap model.CubicCentimeters, useVolume bool, elapsed time.Duration) {
+	var value model.Points
+	for i, ok := range chosen {
+		if ok {
+			value += items[i].Value
+		}
+	}
+	upperBound := newFractionalBounds(items).upperBound(weightCap, -1)
+	if useVolume {
+		// 容積を重量に見立てて同じ上界を求める
+		byVolume := make([]model.Order, len(items))
+		for i, item := range items {
+			byVolume[i] = model.Order{Weight: model.Grams(item.Volume), Value: item.Value}
+		}
+		if v := newFractionalBounds(byVolume).upperBound(model.Grams(volumeCap), -1); v < upperBound {
+			upperBound = v
+		}
+	}
+	stats.Record(strategy, len(items), int64(weightCap), elapsed, int64(value), int64(upperBound))
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"backend/internal/model"
+)
+
+// solveBranchAndBound は分枝限定法で0-1ナップサック問題を時間予算内に厳密に解く
+// volumeCapが0以上なら容積も制約とし、負なら容積は制約しない。initialを初期解とし、これより良い解が見つからなければinitialを返す
+// 予算内に探索を終えられなければ、それまでの最良解とfalseを返す
+func solveBranchAndBound(ctx context.Context, items []model.Order, weightCap model.Grams, volumeCap model.CubicCentimeters, initial []bool, budget time.Duration) ([]bool, bool, error) {
+	best := append([]bool(nil), initial...)
+	var bestValue model.Points
+	for i, ok := range initial {
+		if ok {
+			bestValue += items[i].Value
+		}
+	}
+	if budget <= 0 {
+		return best, false, nil
+	}
+
+	// 入りうる注文だけを価値密度の降順に並べて探索する
+	useVolume := volumeCap >= 0
+	density := func(o model.Order) float64 {
+		size := 0.0
+		if weightCap > 0 {
+			size += float64(o.Weight) / float64(weightCap)
+		}
+		if useVolume && volumeCap > 0 {
+			size += float64(o.Volume) / float64(volumeCap)
+		}
+		if size == 0 {
+			return float64(o.Value) * 1e18
+		}
+		return float64(o.Value) / size
+	}
+	order := make([]int, 0, len(items))
+	for i, item := range items {
+		if item.Value > 0 && item.Weight <= weightCap && (!useVolume || item.Volume <= volumeCap) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return density(items[order[a]]) > density(items[order[b]]) })
+
+	// 上界は重量（と容積）それぞれの分数緩和の小さい方。各制約の価値密度順に残りの注文を詰める
+	byWeight := append([]int(nil), order...)
+	sort.SliceStable(byWeight, func(a, b int) bool {
+		x, y := items[byWeight[a]], items[byWeight[b]]
+		return int(x.Value)*int(y.Weight) > int(y.Value)*int(x.Weight)
+	})
+	var byVolume []int
+	if useVolume {
+		byVolume = append([]int(nil), order...)
+		sort.SliceStable(byVolume, func(a, b int) bool {
+			x, y := items[byVolume[a]], items[byVolume[b]]
+			return int(x.Value)*int(y.Volume) > int(y.Value)*int(x.Volume)
+		})
+	}
+	depthOf := make([]int, len(items))
+	for d, i := range order {
+		depthOf[i] = d
+	}
+	fractional := func(sorted []int, depth int, remaining int, size func(model.Order) int) float64 {
+		bound := 0.0
+		for _, i := range sorted {
+			if depthOf[i] < depth {
+				continue
+			}
+			s := size(items[i])
+			if s <= remaining {
+				remaining -= s
+				bound += float64(items[i].Value)
+				continue
+			}
+			return bound + float64(items[i].Value)*float64(remaining)/float64(s)
+		}
+		return bound
+	}
+	upperBound := func(depth int, remW model.Grams, remV model.CubicCentimeters) float64 {
+		bound := fractional(byWeight, depth, int(remW), func(o model.Order) int { return int(o.Weight) })
+		if useVolume {
+			if v := fractional(byVolume, depth, int(remV), func(o model.Order) int { return int(o.Volume) }); v < bound {
+				bound = v
+			}
+		}
+		return bound
+	}
+
+	deadline := time.Now().Add(budget)
+	const checkEvery = 1024
+	nodes := 0
+	aborted := false
+	current := make([]bool, len(items))
+
+	var search func(depth int, remW model.Grams, remV model.CubicCentimeters, value model.Points)
+	search = func(depth int, remW model.Grams, remV model.CubicCentimeters, value model.Points) {
+		if aborted {
+			return
+		}
+		nodes++
+		if nodes%checkEvery == 0 && (time.Now().After(deadline) || ctx.Err() != nil) {
+			aborted = true
+			return
+		}
+		if value > bestValue {
+			bestValue = value
+			copy(best, current)
+		}
+		if depth == len(order) || float64(value)+upperBound(depth, remW, remV) <= float64(bestValue) {
+			return
+		}
+		i := order[depth]
+		item := items[i]
+		if item.Weight <= remW && (!useVolume || item.Volume <= remV) {
+			current[i] = true
+			search(depth+1, remW-item.Weight, remV-item.Volume, value+item.Value)
+			current[i] = false
+		}
+		search(depth+1, remW, remV, value)
+	}
+	search(0, weightCap, volumeCap, 0)
+
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	return best, !aborted, nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"backend/internal/model"
+)
+
+// DPを並列化する条件。小さな表ではワーカーの同期の方が高くつく
+const (
+	parallelDPMinItems    = 32
+	parallelDPMinCapacity = 1 << 16
+	// ワーカー1つが受け持つ積載量の幅の下限
+	parallelDPMinChunk = 1 << 14
+	// 採否の記録（注文数×積載量のビット）の上限。超える場合は逐次のDPで解く
+	maxParallelDPBits = 1 << 30
+)
+
+// parallelDPWorkers はDPに使うワーカー数を返す。1なら逐次のDPで解く
+func parallelDPWorkers(parallelism, items int, capacity model.Grams) int {
+	if parallelism <= 1 || items < parallelDPMinItems || capacity < parallelDPMinCapacity {
+		return 1
+	}
+	if items > maxParallelDPBits/(int(capacity)+1) {
+		return 1
+	}
+	if limit := (int(capacity) + 1) / parallelDPMinChunk; parallelism > limit {
+		parallelism = limit
+	}
+	return parallelism
+}
+
+// parallelKnapsackDP はfreeの注文について積載量capacityのDPを解き、選んだ注文をchosenに記録する
+//
+// 注文ごとに前の行から次の行を求める2行のDPとし、積載量の範囲をワーカーで分けて計算する。
+// 各セルは前の行だけを読むので、同じ注文の中ではワーカー同士が干渉しない。
+// 採否は注文ごとのビット集合に記録し、範囲の境界を64の倍数に揃えてワーカー間で同じ語に書かないようにする。
+func parallelKnapsackDP(ctx context.Context, items []model.Order, free []int, capacity model.Grams, workers int, chosen []bool) error {
+	cells := int(capacity) + 1
+	words := (cells + 63) / 64
+	prev := make([]model.Points, cells)
+	next := make([]model.Points, cells)
+	take := make([][]uint64, len(free))
+
+	// 各ワーカーが受け持つ[lo, hi)。境界は64の倍数
+	chunk := (words + workers - 1) / workers * 64
+	type task struct {
+		k    int
+		prev []model.Points
+		next []model.Points
+	}
+	tasks := make([]chan task, workers)
+	var wg sync.WaitGroup
+	for wi := range tasks {
+		tasks[wi] = make(chan task)
+		lo := wi * chunk
+		hi := lo + chunk
+		if hi > cells {
+			hi = cells
+		}
+		go func(in <-chan task) {
+			for t := range in {
+				item := items[free[t.k]]
+				w, v := int(item.Weight), item.Value
+				bits := take[t.k]
+				for c := lo; c < hi; c++ {
+					best := t.prev[c]
+					if c >= w {
+						if candidate := t.prev[c-w] + v; candidate > best {
+							best = candidate
+							bits[c/64] |= 1 << (c % 64)
+						}
+					}
+					t.next[c] = best
+				}
+				wg.Done()
+			}
+		}(tasks[wi])
+	}
+	defer func() {
+		for _, ch := range tasks {
+			close(ch)
+		}
+	}()
+
+	for k := range free {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		take[k] = make([]uint64, words)
+		wg.Add(workers)
+		for _, ch := range tasks {
+			ch <- task{k: k, prev: prev, next: next}
+		}
+		wg.Wait()
+		prev, next = next, prev
+	}
+
+	// 表は単調なので、最大の価値は上限のセルにある
+	c := int(capacity)
+	for k := len(free) - 1; k >= 0; k-- {
+		if take[k][c/64]&(1<<(c%64)) != 0 {
+			idx := free[k]
+			chosen[idx] = true
+			c -= int(items[idx].Weight)
+		}
+	}
+	return nil
+}
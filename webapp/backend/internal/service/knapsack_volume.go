@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"sort"
+
+	"backend/internal/model"
+)
+
+// 重量と容積の2次元DPで扱う計算量の上限（注文数×表のセル数）
+// 採否の記録は1セル1ビットなので、上限いっぱいでも8MiB程度に収まる。超える場合は貪欲解で近似する
+const maxVolumeDPSteps = 1 << 26
+
+// solveVolumePlan は重量と容積の両方に上限がある0-1ナップサック問題を解き、
+// itemsと同じ並びで各注文を選ぶかどうかと、使った解法を返す
+// epsilonは使わず、greedyの指定または計算量が上限を超える場合のみ貪欲解を返す
+// 計算量が上限を超える場合は、時間予算の範囲で分枝限定法により貪欲解の改善を試みる
+func solveVolumePlan(ctx context.Context, items []model.Order, weightCap model.Grams, volumeCap model.CubicCentimeters, opts planOptions) ([]bool, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	if weightCap < 0 || volumeCap < 0 || len(items) == 0 {
+		return make([]bool, len(items)), strategyVolumeDP, nil
+	}
+	if opts.algorithm == plannerGreedy {
+		return greedyVolumePlan(items, weightCap, volumeCap), strategyVolumeGreedy, nil
+	}
+	cells := (int(weightCap) + 1) * (int(volumeCap) + 1)
+	if cells > maxVolumeDPSteps/len(items) {
+		greedy := greedyVolumePlan(items, weightCap, volumeCap)
+		if opts.exactBudget <= 0 {
+			return greedy, strategyVolumeGreedy, nil
+		}
+		chosen, complete, err := solveBranchAndBound(ctx, items, weightCap, volumeCap, greedy, opts.exactBudget)
+		if !complete {
+			return chosen, strategyVolumeBranchAndBoundPartial, err
+		}
+		return chosen, strategyVolumeBranchAndBound, err
+	}
+	chosen, err := solveVolumeKnapsack(ctx, items, weightCap, volumeCap)
+	return chosen, strategyVolumeDP, err
+}
+
+// isExactStrategy は解法が厳密解を返すかどうか
+func isExactStrategy(strategy string) bool {
+	switch strategy {
+	case strategyGreedy, strategyEpsilonGreedy, strategyBranchAndBoundPartial, strategyVolumeGreedy, strategyVolumeBranchAndBoundPartial:
+		return false
+	}
+	return true
+}
+
+// solveVolumeKnapsack は(重量, 容積)を添字とする表で2次元のDPを厳密に解く
+func solveVolumeKnapsack(ctx context.Context, items []model.Order, weightCap model.Grams, volumeCap model.CubicCentimeters) ([]bool, error) {
+	stride := int(volumeCap) + 1
+	cells := (int(weightCap) + 1) * stride
+	best := make([]model.Points, cells)
+	// take[i]は注文iを採用して値が更新されたセルのビット集合
+	take := make([][]uint64, len(items))
+
+	const checkEvery = 4096
+	steps := 0
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if item.Value <= 0 || item.Weight > weightCap || item.Volume > volumeCap {
+			continue
+		}
+		bits := make([]uint64, (cells+63)/64)
+		take[i] = bits
+		w0, v0 := int(item.Weight), int(item.Volume)
+		for w := int(weightCap); w >= w0; w-- {
+			for v := int(volumeCap); v >= v0; v-- {
+				cell := w*stride + v
+				candidate := best[cell-w0*stride-v0] + item.Value
+				if candidate > best[cell] {
+					best[cell] = candidate
+					bits[cell/64] |= 1 << (cell % 64)
+				}
+				steps++
+				if steps%checkEvery == 0 {
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					default:
+					}
+				}
+			}
+		}
+	}
+
+	// 表は単調（大きいセルほど価値が高いか等しい）なので、最大の価値は上限のセルにある
+	chosen := make([]bool, len(items))
+	w, v := int(weightCap), int(volumeCap)
+	for i := len(items) - 1; i >= 0; i-- {
+		bits := take[i]
+		if bits == nil {
+			continue
+		}
+		cell := w*stride + v
+		if bits[cell/64]&(1<<(cell%64)) != 0 {
+			chosen[i] = true
+			w -= int(items[i].Weight)
+			v -= int(items[i].Volume)
+		}
+	}
+	return chosen, nil
+}
+
+// greedyVolumePlan は上限に対する重量と容積の占有率の和あたりの価値が高い順に詰める
+// 単独で最も価値の高い注文の方が良ければそちらを返す
+func greedyVolumePlan(items []model.Order, weightCap model.Grams, volumeCap model.CubicCentimeters) []bool {
+	size := func(o model.Order) float64 {
+		var s float64
+		if volumeCap > 0 {
+			s += float64(o.Volume) / float64(volumeCap)
+		}
+		if weightCap > 0 {
+			s += float64(o.Weight) / float64(weightCap)
+		}
+		return s
+	}
+	order := make([]int, 0, len(items))
+	bestSingle := -1
+	for i, item := range items {
+		if item.Value <= 0 || item.Weight > weightCap || item.Volume > volumeCap {
+			continue
+		}
+		order = append(order, i)
+		if bestSingle == -1 || item.Value > items[bestSingle].Value {
+			bestSingle = i
+		}
+	}
+	// 大きさ0の注文は密度が無限大なので先頭に置く
+	sort.SliceStable(order, func(a, b int) bool {
+		x, y := items[order[a]], items[order[b]]
+		sx, sy := size(x), size(y)
+		if sx == 0 || sy == 0 {
+			return sx == 0 && sy != 0
+		}
+		return float64(x.Value)/sx > float64(y.Value)/sy
+	})
+
+	chosen := make([]bool, len(items))
+	remainingW, remainingV := weightCap, volumeCap
+	var value model.Points
+	for _, i := range order {
+		item := items[i]
+		if item.Weight <= remainingW && item.Volume <= remainingV {
+			chosen[i] = true
+			remainingW -= item.Weight
+			remainingV -= item.Volume
+			value += item.Value
+		}
+	}
+	if bestSingle != -1 && items[bestSingle].Value > value {
+		chosen = make([]bool, len(items))
+		chosen[bestSingle] = true
+	}
+	return chosen
+}
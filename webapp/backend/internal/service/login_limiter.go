@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrTooManyLoginAttempts はログインの試行が多すぎて一時的に受け付けないことを表す
+var ErrTooManyLoginAttempts = errors.New("too many login attempts")
+
+// LoginThrottledError はRetryAfter経ってから再試行すべきことを表す
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%v: retry after %s", ErrTooManyLoginAttempts, e.RetryAfter)
+}
+
+func (e *LoginThrottledError) Unwrap() error { return ErrTooManyLoginAttempts }
+
+// LoginLimiter はユーザー名・接続元IPごとのログインの失敗を制限する
+// 総当たりを防ぐためのもので、正しいパスワードでのログインは何度繰り返しても制限しない
+//
+// 用意しているのはプロセス内の実装だけで、Redisを使う実装は依存するクライアントがないため見送った
+// 複数台で制限を共有するときは、このインターフェースを実装してSetLoginLimiterで差し替える
+type LoginLimiter interface {
+	// Allow は試行を受け付けられるか確かめ、受け付けられなければ再試行までの時間を返す。試行は数えない
+	Allow(ctx context.Context, userName, clientIP string) (time.Duration, error)
+	// Failed はパスワード違いなどで失敗した試行を数える
+	Failed(ctx context.Context, userName, clientIP string) error
+	// Succeeded はログインに成功したユーザーの失敗回数を消す
+	Succeeded(ctx context.Context, userName string) error
+	// AllowReset はパスワード再設定の申請を1回数え、受け付けられなければ再試行までの時間を返す
+	// ログインとは別に数え、申請が続いてもそのユーザーのログインは止めない
+	AllowReset(ctx context.Context, userName, clientIP string) (time.Duration, error)
+}
+
+// memoryLoginLimiter はプロセス内のトークンバケットと失敗回数でログインを制限する
+// ユーザー名と接続元IPそれぞれにバケットを持ち、失敗するたびにトークンを1つ使う。どちらかが空なら受け付けない
+// maxFailures回続けて失敗したユーザー名はlockout経つまで受け付けない
+type memoryLoginLimiter struct {
+	mx sync.Mutex
+	// ユーザー名ごと・接続元IPごとの、失敗で使うトークンの補充の速さとバケットの容量
+	user loginRate
+	ip   loginRate
+	// ユーザー名ごとのパスワード再設定の申請の補充の速さとバケットの容量
+	reset loginRate
+	// ロックするまでの連続失敗回数と、ロックする時間
+	maxFailures int
+	lockout     time.Duration
+	// この数を超えたら満杯のバケットやロックの切れた記録を捨てる
+	maxEntries int
+	buckets    map[string]*loginBucket
+	failures   map[string]*loginFailures
+	now        func() time.Time
+}
+
+// loginRate はトークンバケットの1秒あたりに補充するトークン数と容量。どちらかが0以下なら制限しない
+type loginRate struct {
+	perSecond float64
+	burst     float64
+}
+
+func (r loginRate) enabled() bool {
+	return r.perSecond > 0 && r.burst > 0
+}
+
+type loginBucket struct {
+	rate    loginRate
+	tokens  float64
+	updated time.Time
+}
+
+type loginFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// newMemoryLoginLimiter は環境変数の設定で制限を作る
+// 接続元IPごとの制限は、同じNATの利用者をまとめて止めないよう既定では行わない
+func newMemoryLoginLimiter() *memoryLoginLimiter {
+	return &memoryLoginLimiter{
+		user: loginRate{
+			perSecond: float64(parseIntEnv("LOGIN_FAILURE_RATE_PER_MINUTE", 10)) / 60,
+			burst:     float64(parseIntEnv("LOGIN_FAILURE_RATE_BURST", 5)),
+		},
+		ip: loginRate{
+			perSecond: float64(parseIntEnv("LOGIN_IP_FAILURE_RATE_PER_MINUTE", 0)) / 60,
+			burst:     float64(parseIntEnv("LOGIN_IP_FAILURE_RATE_BURST", 0)),
+		},
+		reset: loginRate{
+			perSecond: float64(parseIntEnv("PASSWORD_RESET_RATE_PER_MINUTE", 1)) / 60,
+			burst:     float64(parseIntEnv("PASSWORD_RESET_RATE_BURST", 3)),
+		},
+		maxFailures: parseIntEnv("LOGIN_MAX_FAILURES", 5),
+		lockout:     parseDurationEnv("LOGIN_LOCKOUT_DURATION", 5*time.Minute),
+		maxEntries:  parseIntEnv("LOGIN_LIMITER_SIZE", 10000),
+		buckets:     make(map[string]*loginBucket),
+		failures:    make(map[string]*loginFailures),
+		now:         time.Now,
+	}
+}
+
+func (l *memoryLoginLimiter) Allow(ctx context.Context, userName, clientIP string) (time.Duration, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	now := l.now()
+	if f, ok := l.failures[userName]; ok && now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now), nil
+	}
+	var wait time.Duration
+	for _, b := range l.bucketsFor(userName, clientIP, now) {
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration(math.Ceil((1-b.tokens)/b.rate.perSecond*float64(time.Second))))
+		}
+	}
+	return wait, nil
+}
+
+// bucketsFor はuserNameとclientIPのうち制限するもののバケットを返す
+func (l *memoryLoginLimiter) bucketsFor(userName, clientIP string, now time.Time) []*loginBucket {
+	var buckets []*loginBucket
+	if l.user.enabled() {
+		buckets = append(buckets, l.bucket("user:"+userName, l.user, now))
+	}
+	if l.ip.enabled() && clientIP != "" {
+		buckets = append(buckets, l.bucket("ip:"+clientIP, l.ip, now))
+	}
+	return buckets
+}
+
+// AllowReset はユーザー名ごとの申請のバケットと接続元IPのバケットからトークンを1つずつ使う
+// ログインのバケットとはキーを分け、ログインの失敗によるロックも見ない
+func (l *memoryLoginLimiter) AllowReset(ctx context.Context, userName, clientIP string) (time.Duration, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	now := l.now()
+	var buckets []*loginBucket
+	if l.reset.enabled() {
+		buckets = append(buckets, l.bucket("reset:"+userName, l.reset, now))
+	}
+	if l.ip.enabled() && clientIP != "" {
+		buckets = append(buckets, l.bucket("reset-ip:"+clientIP, l.ip, now))
+	}
+	return takeToken(buckets), nil
+}
+
+// takeToken はbucketsのすべてにトークンがあれば1つずつ使って0を、なければ使わずに補充までの時間を返す
+func takeToken(buckets []*loginBucket) time.Duration {
+	var wait time.Duration
+	for _, b := range buckets {
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration(math.Ceil((1-b.tokens)/b.rate.perSecond*float64(time.Second))))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0
+}
+
+// bucket は経過時間分のトークンを補充したバケットを返す
+func (l *memoryLoginLimiter) bucket(key string, rate loginRate, now time.Time) *loginBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxEntries {
+			l.evictLocked(now)
+		}
+		b = &loginBucket{rate: rate, tokens: rate.burst, updated: now}
+		l.buckets[key] = b
+		return b
+	}
+	b.tokens = min(rate.burst, b.tokens+now.Sub(b.updated).Seconds()*rate.perSecond)
+	b.updated = now
+	return b
+}
+
+func (l *memoryLoginLimiter) Failed(ctx context.Context, userName, clientIP string) error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	now := l.now()
+	for _, b := range l.bucketsFor(userName, clientIP, now) {
+		b.tokens = max(0, b.tokens-1)
+	}
+	if l.maxFailures <= 0 {
+		return nil
+	}
+	f, ok := l.failures[userName]
+	if !ok {
+		if len(l.failures) >= l.maxEntries {
+			l.evictLocked(now)
+		}
+		f = &loginFailures{}
+		l.failures[userName] = f
+	}
+	f.count++
+	if f.count >= l.maxFailures {
+		f.count = 0
+		f.lockedUntil = now.Add(l.lockout)
+	}
+	return nil
+}
+
+func (l *memoryLoginLimiter) Succeeded(ctx context.Context, userName string) error {
+	l.mx.Lock()
+	delete(l.failures, userName)
+	l.mx.Unlock()
+	return nil
+}
+
+// evictLocked は満杯まで補充されたバケットと、ロックが切れた失敗の記録を捨てる
+func (l *memoryLoginLimiter) evictLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.rate.perSecond >= b.rate.burst {
+			delete(l.buckets, key)
+		}
+	}
+	for key, f := range l.failures {
+		if f.count == 0 && !now.Before(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
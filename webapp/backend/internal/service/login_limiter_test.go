@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestLoginLimiter(now *time.Time) *memoryLoginLimiter {
+	return &memoryLoginLimiter{
+		user:        loginRate{perSecond: 1, burst: 2},
+		ip:          loginRate{perSecond: 1, burst: 2},
+		reset:       loginRate{perSecond: 1, burst: 2},
+		maxFailures: 3,
+		lockout:     time.Minute,
+		maxEntries:  100,
+		buckets:     make(map[string]*loginBucket),
+		failures:    make(map[string]*loginFailures),
+		now:         func() time.Time { return *now },
+	}
+}
+
+func TestLoginLimiterRateLimitsFailuresPerUserAndIP(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginLimiter(&now)
+	l.maxFailures = 0
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if wait, _ := l.Allow(ctx, "alice", "10.0.0.1"); wait != 0 {
+			t.Fatalf("attempt %d: expected to be allowed, got wait %s", i, wait)
+		}
+		l.Failed(ctx, "alice", "10.0.0.1")
+	}
+	if wait, _ := l.Allow(ctx, "alice", "10.0.0.2"); wait != time.Second {
+		t.Fatalf("expected user bucket to be empty, got wait %s", wait)
+	}
+	if wait, _ := l.Allow(ctx, "bob", "10.0.0.1"); wait != time.Second {
+		t.Fatalf("expected IP bucket to be empty, got wait %s", wait)
+	}
+	if wait, _ := l.Allow(ctx, "bob", "10.0.0.2"); wait != 0 {
+		t.Fatalf("expected other user and IP to be allowed, got wait %s", wait)
+	}
+
+	now = now.Add(time.Second)
+	if wait, _ := l.Allow(ctx, "alice", "10.0.0.1"); wait != 0 {
+		t.Fatalf("expected a refilled token, got wait %s", wait)
+	}
+}
+
+func TestLoginLimiterDoesNotCountSuccessfulLogins(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginLimiter(&now)
+	ctx := context.Background()
+
+	// 同じユーザーが何度ログインし直しても止めない
+	for i := 0; i < 100; i++ {
+		if wait, _ := l.Allow(ctx, "alice", "10.0.0.1"); wait != 0 {
+			t.Fatalf("attempt %d: expected to be allowed, got wait %s", i, wait)
+		}
+		l.Succeeded(ctx, "alice")
+	}
+}
+
+func TestLoginLimiterLocksOutAfterFailures(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginLimiter(&now)
+	l.user, l.ip = loginRate{}, loginRate{}
+	ctx := context.Background()
+
+	l.Failed(ctx, "alice", "")
+	l.Failed(ctx, "alice", "")
+	l.Succeeded(ctx, "alice")
+	l.Failed(ctx, "alice", "")
+	l.Failed(ctx, "alice", "")
+	if wait, _ := l.Allow(ctx, "alice", ""); wait != 0 {
+		t.Fatalf("expected success to reset failures, got wait %s", wait)
+	}
+	l.Failed(ctx, "alice", "")
+	if wait, _ := l.Allow(ctx, "alice", ""); wait != time.Minute {
+		t.Fatalf("expected lockout, got wait %s", wait)
+	}
+
+	now = now.Add(time.Minute)
+	if wait, _ := l.Allow(ctx, "alice", ""); wait != 0 {
+		t.Fatalf("expected lockout to expire, got wait %s", wait)
+	}
+}
+
+func TestLoginLimiterSkipsIPLimitByDefault(t *testing.T) {
+	l := newMemoryLoginLimiter()
+	ctx := context.Background()
+
+	// 同じ接続元から別々のユーザーがログインしても止めない
+	for i := 0; i < 20; i++ {
+		if wait, _ := l.Allow(ctx, fmt.Sprintf("user%d", i), "10.0.0.1"); wait != 0 {
+			t.Fatalf("attempt %d: expected to be allowed, got wait %s", i, wait)
+		}
+	}
+}
+
+func TestLoginLimiterCountsResetRequestsSeparately(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginLimiter(&now)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if wait, _ := l.AllowReset(ctx, "alice", ""); wait != 0 {
+			t.Fatalf("request %d: expected to be allowed, got wait %s", i, wait)
+		}
+	}
+	if wait, _ := l.AllowReset(ctx, "alice", ""); wait != time.Second {
+		t.Fatalf("expected reset bucket to be empty, got wait %s", wait)
+	}
+	// 申請が多すぎてもログインは止めない
+	if wait, _ := l.Allow(ctx, "alice", ""); wait != 0 {
+		t.Fatalf("expected login to be unaffected, got wait %s", wait)
+	}
+	// ログインでロックされても申請は受け付ける
+	for i := 0; i < 3; i++ {
+		l.Failed(ctx, "bob", "")
+	}
+	if wait, _ := l.AllowReset(ctx, "bob", ""); wait != 0 {
+		t.Fatalf("expected reset to be unaffected by a login lockout, got wait %s", wait)
+	}
+}
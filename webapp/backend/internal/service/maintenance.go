@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"backend/internal/repository"
+)
+
+var (
+	ErrMaintenanceRunning   = errors.New("maintenance job already running")
+	ErrMaintenanceThrottled = errors.New("maintenance job ran too recently")
+)
+
+// 統計情報の更新対象となる、更新・参照の多いテーブル
+var hotTables = []string{"orders", "products", "users", "user_sessions"}
+
+// AnalyzeProgress は統計情報更新ジョブの進捗
+type AnalyzeProgress struct {
+	Running    bool                       `json:"running"`
+	StartedAt  *time.Time                 `json:"started_at,omitempty"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+	Tables     []string                   `json:"tables"`
+	Completed  int                        `json:"completed"`
+	Current    string                     `json:"current,omitempty"`
+	Results    []repository.AnalyzeResult `json:"results,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+type MaintenanceService struct {
+	store       *repository.Store
+	minInterval time.Duration
+	tablePause  time.Duration
+
+	mx       sync.Mutex
+	progress AnalyzeProgress
+}
+
+func NewMaintenanceService(store *repository.Store) *MaintenanceService {
+	return &MaintenanceService{
+		store:       store,
+		minInterval: parseDurationEnv("ADMIN_ANALYZE_MIN_INTERVAL", time.Minute),
+		tablePause:  parseDurationEnv("ADMIN_ANALYZE_TABLE_PAUSE", 500*time.Millisecond),
+		progress:    AnalyzeProgress{Tables: hotTables},
+	}
+}
+
+// StartAnalyze は統計情報更新ジョブをバックグラウンドで開始する
+// 実行中、または前回の開始から最小間隔が経過していない場合はエラーを返す
+func (s *MaintenanceService) StartAnalyze() (AnalyzeProgress, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.progress.Running {
+		return s.snapshotLocked(), ErrMaintenanceRunning
+	}
+	if s.progress.StartedAt != nil && time.Since(*s.progress.StartedAt) < s.minInterval {
+		return s.snapshotLocked(), ErrMaintenanceThrottled
+	}
+
+	now := time.Now()
+	s.progress = AnalyzeProgress{
+		Running:   true,
+		StartedAt: &now,
+		Tables:    hotTables,
+	}
+	go s.runAnalyze(context.Background())
+	return s.snapshotLocked(), nil
+}
+
+// AnalyzeProgress は直近のジョブの進捗を返す
+func (s *MaintenanceService) AnalyzeProgress() AnalyzeProgress {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.snapshotLocked()
+}
+
+func (s *MaintenanceService) runAnalyze(ctx context.Context) {
+	var jobErr error
+	for i, table := range hotTables {
+		s.mx.Lock()
+		s.progress.Current = table
+		s.mx.Unlock()
+
+		results, err := s.store.MaintenanceRepo.AnalyzeTable(ctx, table)
+		if err != nil {
+			jobErr = err
+			break
+		}
+
+		s.mx.Lock()
+		s.progress.Completed++
+		s.progress.Results = append(s.progress.Results, results...)
+		s.mx.Unlock()
+
+		// テーブル間で間隔を空け、ベンチマーク中のI/O負荷の集中を避ける
+		if i < len(hotTables)-1 && s.tablePause > 0 {
+			time.Sleep(s.tablePause)
+		}
+	}
+
+	now := time.Now()
+	s.mx.Lock()
+	s.progress.Running = false
+	s.progress.Current = ""
+	s.progress.FinishedAt = &now
+	if jobErr != nil {
+		s.progress.Error = jobErr.Error()
+	}
+	s.mx.Unlock()
+
+	if jobErr != nil {
+		log.Printf("ANALYZE TABLE failed: %v", jobErr)
+	} else {
+		log.Printf("ANALYZE TABLE finished for %d tables", len(hotTables))
+	}
+}
+
+func (s *MaintenanceService) snapshotLocked() AnalyzeProgress {
+	p := s.progress
+	p.Results = append([]repository.AnalyzeResult(nil), s.progress.Results...)
+	return p
+}
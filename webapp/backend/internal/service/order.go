@@ -5,30 +5,350 @@ import (
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrOrderNotCancellable = errors.New("order cannot be cancelled")
+	ErrOrderForbidden      = errors.New("order belongs to another user")
 )
 
 type OrderService struct {
-	store *repository.Store
+	store  *repository.Store
+	events *OrderEventBus
+	recent *recentOrdersCache
+	counts *orderCountCache
+	stream *orderStreamHub
+	// 取り消した注文の在庫を戻す
+	inventory *InventoryService
 }
 
-func NewOrderService(store *repository.Store) *OrderService {
-	return &OrderService{store: store}
+func NewOrderService(store *repository.Store, events *OrderEventBus) *OrderService {
+	recent := newRecentOrdersCache(
+		parseIntEnv("RECENT_ORDERS_CAPACITY", 50),
+		parseIntEnv("RECENT_ORDERS_MAX_USERS", 10000),
+		parseDurationEnv("RECENT_ORDERS_TTL", 30*time.Second),
+	)
+	counts := newOrderCountCache(
+		parseIntEnv("ORDER_COUNT_CACHE_MAX_ENTRIES", 10000),
+		parseDurationEnv("ORDER_COUNT_CACHE_TTL", 5*time.Second),
+	)
+	stream := newOrderStreamHub(
+		parseIntEnv("ORDER_STREAM_MAX_SUBSCRIBERS", 1000),
+		parseIntEnv("ORDER_STREAM_QUEUE_SIZE", 1024),
+		store.OrderRepo.UserIDsByID,
+	)
+	events.AddListener(recent)
+	events.AddListener(counts)
+	events.AddListener(stream)
+	return &OrderService{store: store, events: events, recent: recent, counts: counts, stream: stream, inventory: NewInventoryService()}
 }
 
-// ユーザーの注文履歴を取得
-func (s *OrderService) FetchOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
-	var orders []model.Order
-	var total int
+// ユーザーの注文履歴を取得し、注文と総件数、続きの有無を返す
+// 検索なし・既定の並び順の1ページ目は、ユーザーごとの最新注文のキャッシュから返す
+// それ以外の総件数は絞り込みごとに短い時間キャッシュし、req.EstimateTotalを指定した2ページ目以降は数えずに-1を返す
+func (s *OrderService) FetchOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, bool, error) {
+	if err := expandCategory(ctx, s.store, &req); err != nil {
+		return nil, 0, false, err
+	}
+	if s.recent.servable(req) {
+		if orders, total, ok := s.recent.get(userID, req.PageSize); ok {
+			return orders, total, len(orders) < total, nil
+		}
+	}
+
+	var (
+		orders  []model.Order
+		total   int
+		hasNext bool
+	)
 	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if s.recent.servable(req) {
+			latest, latestTotal, err := s.recent.load(ctx, userID, func(ctx context.Context, limit int) ([]model.Order, int, error) {
+				// キャッシュは列を絞らずに持ち、返す列はハンドラで選ぶ
+				latestReq := req
+				latestReq.PageSize = limit
+				latestReq.Fields, latestReq.Columns = nil, nil
+				return s.store.OrderRepo.ListOrders(ctx, userID, latestReq)
+			})
+			if err != nil {
+				return err
+			}
+			if len(latest) > req.PageSize {
+				latest = latest[:req.PageSize]
+			}
+			orders, total = latest, latestTotal
+			return nil
+		}
+
+		if req.EstimateTotal && req.Offset > 0 {
+			// 続きの有無は1件多く読んで判定する
+			found, err := s.store.OrderRepo.FindOrders(ctx, userID, req, req.PageSize+1)
+			if err != nil {
+				return err
+			}
+			if len(found) > req.PageSize {
+				found, hasNext = found[:req.PageSize], true
+			}
+			orders, total = found, -1
+			return nil
+		}
+
+		if cached, ok := s.counts.get(userID, req); ok {
+			found, err := s.store.OrderRepo.FindOrders(ctx, userID, req, req.PageSize)
+			if err != nil {
+				return err
+			}
+			orders, total = found, cached
+			return nil
+		}
+
+		generation := s.counts.begin()
 		var fetchErr error
 		orders, total, fetchErr = s.store.OrderRepo.ListOrders(ctx, userID, req)
 		if fetchErr != nil {
 			return fetchErr
 		}
+		s.counts.put(userID, req, generation, total)
 		return nil
 	})
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, false, err
+	}
+	if total >= 0 {
+		hasNext = req.Offset+len(orders) < total
+	}
+	return orders, total, hasNext, nil
+}
+
+// ExportOrders はユーザーの注文履歴をreqの絞り込みと並び順ですべて読み、1件ずつfnに渡す
+// 履歴が大きくても書き出しながら返せるよう、処理時間の上限は設けず呼び出し元のctxに従う
+func (s *OrderService) ExportOrders(ctx context.Context, userID int, req model.ListRequest, fn func(model.Order) error) error {
+	if err := expandCategory(ctx, s.store, &req); err != nil {
+		return err
+	}
+	return s.store.OrderRepo.EachOrder(ctx, userID, req, fn)
+}
+
+// 注文ステータスを取得する。waitが正の場合はステータスが変わるかwaitが経過するまで待つ
+// knownStatusが現在のステータスと異なる場合は待たずに返す
+func (s *OrderService) WaitForStatus(ctx context.Context, userID int, orderID int64, knownStatus string, wait time.Duration) (string, bool, error) {
+	var (
+		events <-chan OrderStatusEvent
+		cancel = func() {}
+	)
+	// 取得と待ち受けの間の変更を取りこぼさないよう、先に待ち受けを登録する
+	if wait > 0 {
+		var err error
+		events, cancel, err = s.events.Subscribe(orderID)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	defer cancel()
+
+	status, err := s.store.OrderRepo.GetStatus(ctx, orderID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, ErrOrderNotFound
+		}
+		return "", false, err
+	}
+	if wait <= 0 || (knownStatus != "" && knownStatus != status) {
+		return status, knownStatus != "" && knownStatus != status, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case ev := <-events:
+		return ev.Status, ev.Status != status, nil
+	case <-timer.C:
+		return status, false, nil
+	case <-ctx.Done():
+		return "", false, ctx.Err()
+	}
+}
+
+// StreamStatuses はユーザーの注文のステータス変更を受け取るチャネルを返す
+// 受け取りが遅れるとチャネルが閉じられる。返されたcancelは必ず呼び出すこと
+func (s *OrderService) StreamStatuses(userID int) (<-chan OrderStatusEvent, func(), error) {
+	return s.stream.subscribe(userID)
+}
+
+// 注文と、その注文のイベント履歴を取得
+// 他のユーザーの注文はErrOrderForbiddenを返す
+func (s *OrderService) GetOrderDetail(ctx context.Context, userID int, orderID int64) (*model.Order, []model.OrderEvent, error) {
+	var (
+		order  *model.Order
+		events []model.OrderEvent
+	)
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		if order, err = s.ownedOrder(ctx, userID, orderID); err != nil {
+			return err
+		}
+		events, err = s.store.OrderEventRepo.ListByOrder(ctx, orderID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return order, events, nil
+}
+
+// OrderEvents は注文のイベント履歴を発生順に返す。他のユーザーの注文はErrOrderForbiddenを返す
+func (s *OrderService) OrderEvents(ctx context.Context, userID int, orderID int64) ([]model.OrderEvent, error) {
+	var events []model.OrderEvent
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if _, err := s.ownedOrder(ctx, userID, orderID); err != nil {
+			return err
+		}
+		var err error
+		events, err = s.store.OrderEventRepo.ListByOrder(ctx, orderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ownedOrder は注文を読み、userIDのユーザーの注文であることを確かめる
+func (s *OrderService) ownedOrder(ctx context.Context, userID int, orderID int64) (*model.Order, error) {
+	order, err := s.store.OrderRepo.GetByID(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	if order.UserID != userID {
+		return nil, fmt.Errorf("%w: order %d", ErrOrderForbidden, orderID)
+	}
+	return order, nil
+}
+
+// CancelOrder はユーザーの注文を取り消す。取り消せるのは配送待ち（shipping）の注文のみで、
+// ロボットが引き受けた後や配送完了後の注文はErrOrderNotCancellableを返す
+func (s *OrderService) CancelOrder(ctx context.Context, userID int, orderID int64) (time.Time, error) {
+	cancelledAt := time.Now()
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			// 計画の生成と同じ行ロックを取り、引き当てと取り消しが同時に成立しないようにする
+			status, err := txStore.OrderRepo.LockStatus(ctx, orderID, userID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrOrderNotFound
+				}
+				return err
+			}
+			if err := checkTransition(orderID, status, "cancelled"); err != nil {
+				return fmt.Errorf("%w: %v", ErrOrderNotCancellable, err)
+			}
+			if err := recordStatusChange(ctx, txStore, []int64{orderID}, "cancelled", model.OrderEventActorUser); err != nil {
+				return err
+			}
+			if err := s.inventory.Restore(ctx, txStore, []int64{orderID}); err != nil {
+				return err
+			}
+			return txStore.OrderRepo.MarkCancelled(ctx, orderID, userID, cancelledAt)
+		})
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	s.events.Publish([]int64{orderID}, "cancelled")
+	return cancelledAt, nil
+}
+
+// 1回のリクエストでまとめて取り消せる注文の上限
+const maxBulkCancel = 1000
+
+var ErrInvalidCancelRequest = errors.New("invalid order cancel request")
+
+// CancelOrders はユーザーの複数の注文を1つのトランザクションでまとめて取り消し、指定順に注文ごとの結果を返す
+// 存在しない・他のユーザーの・配送待ちでない・重複して指定した注文は取り消さずに理由を返し、残りの注文だけを取り消す
+func (s *OrderService) CancelOrders(ctx context.Context, userID int, orderIDs []int64) ([]model.CancelOrderResult, time.Time, error) {
+	if len(orderIDs) == 0 || len(orderIDs) > maxBulkCancel {
+		return nil, time.Time{}, fmt.Errorf("%w: order_ids must contain 1-%d entries", ErrInvalidCancelRequest, maxBulkCancel)
+	}
+	for _, id := range orderIDs {
+		if id <= 0 {
+			return nil, time.Time{}, fmt.Errorf("%w: order_id must be positive", ErrInvalidCancelRequest)
+		}
+	}
+
+	cancelledAt := time.Now()
+	var (
+		results   []model.CancelOrderResult
+		cancelled []int64
+	)
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			// 計画の生成と同じ行ロックを取り、引き当てと取り消しが同時に成立しないようにする
+			locked, err := txStore.OrderRepo.LockOwnedStatuses(ctx, orderIDs)
+			if err != nil {
+				return err
+			}
+			results = make([]model.CancelOrderResult, len(orderIDs))
+			seen := make(map[int64]bool, len(orderIDs))
+			for i, id := range orderIDs {
+				results[i] = cancelResult(id, userID, locked, seen)
+				if results[i].Result == model.CancelResultCancelled {
+					cancelled = append(cancelled, id)
+				}
+			}
+			if len(cancelled) == 0 {
+				return nil
+			}
+			if err := recordStatusChange(ctx, txStore, cancelled, "cancelled", model.OrderEventActorUser); err != nil {
+				return err
+			}
+			if err := s.inventory.Restore(ctx, txStore, cancelled); err != nil {
+				return err
+			}
+			return txStore.OrderRepo.MarkCancelledMany(ctx, cancelled, userID, cancelledAt)
+		})
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	s.events.Publish(cancelled, "cancelled")
+	return results, cancelledAt, nil
+}
+
+// cancelResult はロックした注文から、注文を取り消すか、取り消さない理由を判定する
+func cancelResult(orderID int64, userID int, locked map[int64]model.Order, seen map[int64]bool) model.CancelOrderResult {
+	skipped := model.CancelOrderResult{OrderID: orderID, Result: model.CancelResultSkipped}
+	if seen[orderID] {
+		skipped.Reason = model.CancelSkipDuplicate
+		return skipped
+	}
+	seen[orderID] = true
+	order, ok := locked[orderID]
+	switch {
+	case !ok:
+		skipped.Reason = model.CancelSkipNotFound
+	case order.UserID != userID:
+		skipped.Reason = model.CancelSkipForbidden
+	case checkTransition(orderID, order.ShippedStatus, "cancelled") != nil:
+		skipped.Reason = model.CancelSkipNotCancellable
+		skipped.ShippedStatus = order.ShippedStatus
+	default:
+		return model.CancelOrderResult{OrderID: orderID, Result: model.CancelResultCancelled}
+	}
+	return skipped
+}
+
+// 注文ステータスの変更はorder_eventsへの追記を正とし、ordersテーブルはその射影として更新する
+func recordStatusChange(ctx context.Context, txStore *repository.Store, orderIDs []int64, status, actor string) error {
+	if err := txStore.OrderEventRepo.Append(ctx, orderIDs, model.OrderEventStatusChanged, status, actor); err != nil {
+		return err
 	}
-	return orders, total, nil
+	return txStore.OrderEventRepo.Project(ctx, orderIDs)
 }
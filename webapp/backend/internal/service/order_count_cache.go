@@ -0,0 +1,142 @@
+package service
+
+import (
+	"backend/internal/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orderCountCache はユーザーと絞り込みの組ごとに注文履歴の総件数を短い時間だけ保持する
+// 2ページ目以降や並び順の変更で同じ絞り込みのCOUNT(*)を繰り返さないために使う
+//
+// 注文の作成時はそのユーザーのエントリを破棄する。ステータス変更は持ち主が分からないため破棄せず、
+// ステータスで絞り込んだ件数はttlの間だけ古いことがある。
+type orderCountCache struct {
+	mx         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	byUser map[int]map[orderCountKey]orderCount
+	size   int
+	// 読み込み中に注文が作成されたか判定するための世代。いずれかのユーザーの注文が作成されるたびに進める
+	generation uint64
+}
+
+// orderCountKey は総件数に影響する絞り込み。ページや並び順は含めない
+type orderCountKey struct {
+	search      string
+	searchType  string
+	statuses    string
+	createdFrom time.Time
+	createdTo   time.Time
+	archived    bool
+	// 商品の価値・重さの範囲。指定がなければ-1
+	valueMin, valueMax   int
+	weightMin, weightMax int
+	// 商品のカテゴリ。指定がなければ0
+	categoryID int
+}
+
+type orderCount struct {
+	total    int
+	loadedAt time.Time
+}
+
+func newOrderCountCache(maxEntries int, ttl time.Duration) *orderCountCache {
+	return &orderCountCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		byUser:     make(map[int]map[orderCountKey]orderCount),
+	}
+}
+
+func orderCountKeyFor(req model.ListRequest) orderCountKey {
+	return orderCountKey{
+		search:      req.Search,
+		searchType:  req.Type,
+		statuses:    strings.Join(req.Status, ","),
+		createdFrom: req.CreatedFrom,
+		createdTo:   req.CreatedTo,
+		archived:    req.Archived,
+		valueMin:    unitOrNone(req.ValueMin),
+		valueMax:    unitOrNone(req.ValueMax),
+		weightMin:   unitOrNone(req.WeightMin),
+		weightMax:   unitOrNone(req.WeightMax),
+		categoryID:  req.CategoryID,
+	}
+}
+
+func unitOrNone[T ~int](v *T) int {
+	if v == nil {
+		return -1
+	}
+	return int(*v)
+}
+
+// get は保持している総件数を返す。ttlが0以下なら常に保持していない
+func (c *orderCountCache) get(userID int, req model.ListRequest) (int, bool) {
+	if c.ttl <= 0 {
+		return 0, false
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	entry, ok := c.byUser[userID][orderCountKeyFor(req)]
+	if !ok || c.now().Sub(entry.loadedAt) >= c.ttl {
+		return 0, false
+	}
+	return entry.total, true
+}
+
+// begin は総件数の読み込みの前に呼び、putに渡す世代を返す
+func (c *orderCountCache) begin() uint64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.generation
+}
+
+// put は読み込んだ総件数を保存する。beginの後に注文が作成されていれば保存しない
+func (c *orderCountCache) put(userID int, req model.ListRequest, generation uint64, total int) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.generation != generation {
+		return
+	}
+	key := orderCountKeyFor(req)
+	if _, exists := c.byUser[userID][key]; !exists {
+		if c.size >= c.maxEntries {
+			for evict := range c.byUser {
+				c.removeLocked(evict)
+				break
+			}
+		}
+		c.size++
+	}
+	counts, ok := c.byUser[userID]
+	if !ok {
+		counts = make(map[orderCountKey]orderCount)
+		c.byUser[userID] = counts
+	}
+	counts[key] = orderCount{total: total, loadedAt: c.now()}
+}
+
+// OrdersCreated はユーザーの総件数が変わるため、そのユーザーのエントリを破棄する
+func (c *orderCountCache) OrdersCreated(userID int, orderIDs []int64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.generation++
+	c.removeLocked(userID)
+}
+
+// OrderStatusChanged は持ち主が分からないため何もしない。ステータスで絞り込んだ件数はttlで更新される
+func (c *orderCountCache) OrderStatusChanged(orderIDs []int64, status string) {}
+
+func (c *orderCountCache) removeLocked(userID int) {
+	c.size -= len(c.byUser[userID])
+	delete(c.byUser, userID)
+}
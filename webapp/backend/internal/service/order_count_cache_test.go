@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestOrderCountCache(t *testing.T) {
+	now := time.Now()
+	c := newOrderCountCache(2, time.Second)
+	c.now = func() time.Time { return now }
+
+	shipping := model.ListRequest{Status: []string{"shipping"}, PageSize: 20}
+	c.put(1, shipping, c.begin(), 42)
+
+	// ページや並び順が違っても同じ絞り込みなら同じ件数を返す
+	page2 := shipping
+	page2.Offset, page2.SortField = 20, "o.created_at"
+	if total, ok := c.get(1, page2); !ok || total != 42 {
+		t.Fatalf("get = %d, %v; want 42, true", total, ok)
+	}
+	if _, ok := c.get(1, model.ListRequest{PageSize: 20}); ok {
+		t.Fatal("expected a different filter to miss")
+	}
+	if _, ok := c.get(2, shipping); ok {
+		t.Fatal("expected another user to miss")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := c.get(1, shipping); ok {
+		t.Fatal("expected the count to expire after the ttl")
+	}
+}
+
+func TestOrderCountCacheDropsCountsOnCreate(t *testing.T) {
+	c := newOrderCountCache(10, time.Minute)
+	req := model.ListRequest{PageSize: 20}
+
+	c.put(1, req, c.begin(), 5)
+	c.OrdersCreated(1, []int64{6})
+	if _, ok := c.get(1, req); ok {
+		t.Fatal("expected the count to be dropped when the user creates orders")
+	}
+
+	// 読み込み中に注文が作成された場合は、古い件数を保存しない
+	generation := c.begin()
+	c.OrdersCreated(1, []int64{7})
+	c.put(1, req, generation, 6)
+	if _, ok := c.get(1, req); ok {
+		t.Fatal("expected a count loaded before the create not to be stored")
+	}
+}
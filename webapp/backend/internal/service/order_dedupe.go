@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"backend/internal/model"
+)
+
+// orderDeduper は同じユーザーが同じ商品の組をwindow以内に再び注文したとき、前回作成した注文IDを返す
+// ボタンの二度押しなどで重複した注文が作られるのを防ぐ。プロセス内でのみ判定する
+//
+// 前回の注文がまだ作成中なら、その結果を待って同じ注文IDを返す。前回の作成が失敗していれば改めて作成する。
+type orderDeduper struct {
+	mx        sync.Mutex
+	window    time.Duration
+	now       func() time.Time
+	entries   map[orderDedupeKey]*orderDedupeEntry
+	lastSweep time.Time
+}
+
+type orderDedupeKey struct {
+	userID      int
+	fingerprint [sha256.Size]byte
+}
+
+type orderDedupeEntry struct {
+	done      chan struct{}
+	orderIDs  []string
+	err       error
+	createdAt time.Time
+}
+
+func newOrderDeduper(window time.Duration) *orderDeduper {
+	return &orderDeduper{
+		window:  window,
+		now:     time.Now,
+		entries: make(map[orderDedupeKey]*orderDedupeEntry),
+	}
+}
+
+// do は同じ注文が作成済みか作成中ならその注文IDとtrueを返し、そうでなければcreateで作成する
+// windowが0以下なら常にcreateを呼ぶ
+func (d *orderDeduper) do(ctx context.Context, userID int, items []model.RequestItem, create func() ([]string, error)) ([]string, bool, error) {
+	if d.window <= 0 {
+		ids, err := create()
+		return ids, false, err
+	}
+	key := orderDedupeKey{userID: userID, fingerprint: orderFingerprint(items)}
+	var entry *orderDedupeEntry
+	for entry == nil {
+		d.mx.Lock()
+		d.sweepLocked()
+		prev, ok := d.entries[key]
+		if !ok || d.expiredLocked(prev) {
+			entry = &orderDedupeEntry{done: make(chan struct{})}
+			d.entries[key] = entry
+			d.mx.Unlock()
+			break
+		}
+		d.mx.Unlock()
+
+		select {
+		case <-prev.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if prev.err == nil {
+			return prev.orderIDs, true, nil
+		}
+		// 前回の作成は失敗して取り除かれているため、作成し直す
+	}
+
+	ids, err := create()
+	d.mx.Lock()
+	entry.orderIDs, entry.err, entry.createdAt = ids, err, d.now()
+	if err != nil && d.entries[key] == entry {
+		delete(d.entries, key)
+	}
+	d.mx.Unlock()
+	close(entry.done)
+	return ids, false, err
+}
+
+// expiredLocked は作成済みでwindowを過ぎたエントリか判定する
+func (d *orderDeduper) expiredLocked(entry *orderDedupeEntry) bool {
+	select {
+	case <-entry.done:
+		return d.now().Sub(entry.createdAt) >= d.window
+	default:
+		return false
+	}
+}
+
+// sweepLocked はwindowを過ぎた作成済みのエントリを取り除く。走査はwindowごとに1回にとどめる
+func (d *orderDeduper) sweepLocked() {
+	now := d.now()
+	if now.Sub(d.lastSweep) < d.window {
+		return
+	}
+	d.lastSweep = now
+	for key, entry := range d.entries {
+		if d.expiredLocked(entry) {
+			delete(d.entries, key)
+		}
+	}
+}
+
+// orderFingerprint は注文する商品の組を、指定の順序によらない値にする
+// 数量0の商品は注文されないため含めない
+func orderFingerprint(items []model.RequestItem) [sha256.Size]byte {
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			continue
+		}
+		var deliverBy, shipAfter int64
+		if item.DeliverBy != nil {
+			deliverBy = item.DeliverBy.UnixNano()
+		}
+		if item.ShipAfter != nil {
+			shipAfter = item.ShipAfter.UnixNano()
+		}
+		lines = append(lines, fmt.Sprintf("%d|%d|%d|%d|%d|%s", item.ProductID, item.Quantity, item.Priority, deliverBy, shipAfter, item.Metadata))
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
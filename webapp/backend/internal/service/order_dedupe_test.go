@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestOrderDeduperReturnsPreviousOrders(t *testing.T) {
+	now := time.Now()
+	d := newOrderDeduper(5 * time.Second)
+	d.now = func() time.Time { return now }
+
+	calls := 0
+	create := func() ([]string, error) {
+		calls++
+		return []string{strconv.Itoa(calls)}, nil
+	}
+	items := []model.RequestItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}}
+	reordered := []model.RequestItem{{ProductID: 2, Quantity: 1}, {ProductID: 3, Quantity: 0}, {ProductID: 1, Quantity: 2}}
+
+	first, duplicate, err := d.do(context.Background(), 1, items, create)
+	if err != nil || duplicate {
+		t.Fatalf("first request: %v, duplicate=%v", err, duplicate)
+	}
+	again, duplicate, err := d.do(context.Background(), 1, reordered, create)
+	if err != nil || !duplicate || !reflect.DeepEqual(again, first) {
+		t.Fatalf("expected the same item set to return %v, got %v (duplicate=%v, err=%v)", first, again, duplicate, err)
+	}
+	if _, duplicate, _ := d.do(context.Background(), 2, items, create); duplicate {
+		t.Fatal("expected another user's order not to be a duplicate")
+	}
+	if _, duplicate, _ := d.do(context.Background(), 1, []model.RequestItem{{ProductID: 1, Quantity: 3}}, create); duplicate {
+		t.Fatal("expected a different quantity not to be a duplicate")
+	}
+
+	now = now.Add(5 * time.Second)
+	if _, duplicate, _ := d.do(context.Background(), 1, items, create); duplicate {
+		t.Fatal("expected the same item set to be ordered again after the window")
+	}
+	if calls != 4 {
+		t.Fatalf("expected 4 creations, got %d", calls)
+	}
+}
+
+func TestOrderDeduperWaitsForInflightCreate(t *testing.T) {
+	d := newOrderDeduper(time.Minute)
+	items := []model.RequestItem{{ProductID: 1, Quantity: 1}}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go d.do(context.Background(), 1, items, func() ([]string, error) {
+		close(started)
+		<-release
+		return []string{"10"}, nil
+	})
+	<-started
+
+	got := make(chan []string)
+	go func() {
+		ids, _, _ := d.do(context.Background(), 1, items, func() ([]string, error) {
+			t.Error("expected the second request not to create orders")
+			return nil, nil
+		})
+		got <- ids
+	}()
+	close(release)
+	if ids := <-got; !reflect.DeepEqual(ids, []string{"10"}) {
+		t.Fatalf("expected the second request to get the first request's orders, got %v", ids)
+	}
+}
+
+func TestOrderDeduperRetriesAfterFailure(t *testing.T) {
+	d := newOrderDeduper(time.Minute)
+	items := []model.RequestItem{{ProductID: 1, Quantity: 1}}
+
+	if _, _, err := d.do(context.Background(), 1, items, func() ([]string, error) {
+		return nil, errors.New("deadlock")
+	}); err == nil {
+		t.Fatal("expected the failure to be returned")
+	}
+	ids, duplicate, err := d.do(context.Background(), 1, items, func() ([]string, error) {
+		return []string{"11"}, nil
+	})
+	if err != nil || duplicate || !reflect.DeepEqual(ids, []string{"11"}) {
+		t.Fatalf("expected a failed request to be created again, got %v (duplicate=%v, err=%v)", ids, duplicate, err)
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrTooManyWaiters = errors.New("too many status waiters")
+
+// OrderStatusEvent は注文ステータスの変更を表す
+type OrderStatusEvent struct {
+	OrderID int64     `json:"order_id"`
+	Status  string    `json:"shipped_status"`
+	At      time.Time `json:"at"`
+}
+
+// OrderEventBus は注文ステータス変更をプロセス内で配信する
+// 注文ごとに待ち受けを登録し、変更があった注文の待ち受けだけを起こす
+type OrderEventBus struct {
+	mx         sync.Mutex
+	waiters    map[int64]map[*orderWaiter]struct{}
+	count      int
+	maxWaiters int
+	listeners  []OrderChangeListener
+}
+
+// OrderChangeListener はコミット済みの注文の作成・ステータス変更を受け取る
+// 呼び出しは変更を行ったリクエストの中で同期的に行われるため、重い処理をしないこと
+type OrderChangeListener interface {
+	OrdersCreated(userID int, orderIDs []int64)
+	OrderStatusChanged(orderIDs []int64, status string)
+}
+
+type orderWaiter struct {
+	ch chan OrderStatusEvent
+}
+
+func NewOrderEventBus() *OrderEventBus {
+	return &OrderEventBus{
+		waiters:    make(map[int64]map[*orderWaiter]struct{}),
+		maxWaiters: parseIntEnv("ORDER_STATUS_MAX_WAITERS", 1000),
+	}
+}
+
+// Subscribe は注文の次のステータス変更を待つチャネルを返す
+// 返されたcancelは必ず呼び出すこと
+func (b *OrderEventBus) Subscribe(orderID int64) (<-chan OrderStatusEvent, func(), error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.count >= b.maxWaiters {
+		return nil, nil, ErrTooManyWaiters
+	}
+	w := &orderWaiter{ch: make(chan OrderStatusEvent, 1)}
+	set, ok := b.waiters[orderID]
+	if !ok {
+		set = make(map[*orderWaiter]struct{})
+		b.waiters[orderID] = set
+	}
+	set[w] = struct{}{}
+	b.count++
+
+	cancel := func() {
+		b.mx.Lock()
+		defer b.mx.Unlock()
+		b.removeLocked(orderID, w)
+	}
+	return w.ch, cancel, nil
+}
+
+// AddListener は注文変更の通知先を登録する
+func (b *OrderEventBus) AddListener(l OrderChangeListener) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.listeners = append(b.listeners, l)
+}
+
+// PublishCreated はユーザーの注文が作成されたことを通知する
+func (b *OrderEventBus) PublishCreated(userID int, orderIDs []int64) {
+	if len(orderIDs) == 0 {
+		return
+	}
+	for _, l := range b.currentListeners() {
+		l.OrdersCreated(userID, orderIDs)
+	}
+}
+
+func (b *OrderEventBus) currentListeners() []OrderChangeListener {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.listeners
+}
+
+// Publish は指定注文の待ち受けと通知先にステータス変更を通知する
+func (b *OrderEventBus) Publish(orderIDs []int64, status string) {
+	if len(orderIDs) == 0 {
+		return
+	}
+	for _, l := range b.currentListeners() {
+		l.OrderStatusChanged(orderIDs, status)
+	}
+	now := time.Now()
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.count == 0 {
+		return
+	}
+	for _, id := range orderIDs {
+		for w := range b.waiters[id] {
+			w.ch <- OrderStatusEvent{OrderID: id, Status: status, At: now}
+			b.removeLocked(id, w)
+		}
+	}
+}
+
+func (b *OrderEventBus) removeLocked(orderID int64, w *orderWaiter) {
+	set, ok := b.waiters[orderID]
+	if !ok {
+		return
+	}
+	if _, ok := set[w]; !ok {
+		return
+	}
+	delete(set, w)
+	b.count--
+	if len(set) == 0 {
+		delete(b.waiters, orderID)
+	}
+}
+
+// Waiters は現在の待ち受け数と上限を返す
+func (b *OrderEventBus) Waiters() (int, int) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.count, b.maxWaiters
+}
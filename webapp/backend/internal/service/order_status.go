@@ -0,0 +1,35 @@
+package service
+
+import "fmt"
+
+// 注文ステータスの遷移。配送待ち→配送中→配送完了の順に進み、配送待ちの間は取り消せる
+// 配送中の注文は、ロボットが引き受けたまま進まない場合や配送に失敗した場合に配送待ちへ戻すことがある
+// 配送の失敗が上限を超えた注文は配送失敗となり、以後配送しない
+// 配送開始日時を指定した注文は、その日時まで予約中として配送待ちの前に置く
+var orderStatusTransitions = map[string]map[string]bool{
+	"scheduled":  {"shipping": true, "cancelled": true},
+	"shipping":   {"delivering": true, "cancelled": true},
+	"delivering": {"completed": true, "shipping": true, "failed": true},
+	"completed":  {},
+	"cancelled":  {},
+	"failed":     {},
+}
+
+// StatusTransitionError は注文ステータスの遷移が許されないことを表す
+type StatusTransitionError struct {
+	OrderID int64
+	From    string
+	To      string
+}
+
+func (e *StatusTransitionError) Error() string {
+	return fmt.Sprintf("order %d cannot change from %q to %q", e.OrderID, e.From, e.To)
+}
+
+// checkTransition は注文のステータスをfromからtoへ変えてよいか判定する
+func checkTransition(orderID int64, from, to string) error {
+	if !orderStatusTransitions[from][to] {
+		return &StatusTransitionError{OrderID: orderID, From: from, To: to}
+	}
+	return nil
+}
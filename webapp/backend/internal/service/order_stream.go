@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrTooManyStreams = errors.New("too many order streams")
+
+// orderStreamHub はユーザーごとに注文ステータスの変更を配信する
+// 変更通知には注文の持ち主が含まれないため、購読者がいる間だけ別のgoroutineで持ち主を引いてから配る
+type orderStreamHub struct {
+	mx      sync.Mutex
+	subs    map[int]map[*orderStream]struct{}
+	count   int
+	maxSubs int
+
+	owners  func(ctx context.Context, orderIDs []int64) (map[int64]int, error)
+	changes chan orderStreamChange
+	once    sync.Once
+}
+
+type orderStream struct {
+	ch chan OrderStatusEvent
+}
+
+type orderStreamChange struct {
+	orderIDs []int64
+	status   string
+	at       time.Time
+}
+
+func newOrderStreamHub(maxSubs, queueSize int, owners func(ctx context.Context, orderIDs []int64) (map[int64]int, error)) *orderStreamHub {
+	return &orderStreamHub{
+		subs:    make(map[int]map[*orderStream]struct{}),
+		maxSubs: maxSubs,
+		owners:  owners,
+		changes: make(chan orderStreamChange, queueSize),
+	}
+}
+
+// subscribe はユーザーの注文のステータス変更を受け取るチャネルを返す
+// 受け取りが追いつかない購読はチャネルを閉じて打ち切る。返されたcancelは必ず呼び出すこと
+func (h *orderStreamHub) subscribe(userID int) (<-chan OrderStatusEvent, func(), error) {
+	h.once.Do(func() { go h.run() })
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if h.count >= h.maxSubs {
+		return nil, nil, ErrTooManyStreams
+	}
+	s := &orderStream{ch: make(chan OrderStatusEvent, 64)}
+	set, ok := h.subs[userID]
+	if !ok {
+		set = make(map[*orderStream]struct{})
+		h.subs[userID] = set
+	}
+	set[s] = struct{}{}
+	h.count++
+
+	cancel := func() {
+		h.mx.Lock()
+		defer h.mx.Unlock()
+		h.removeLocked(userID, s)
+	}
+	return s.ch, cancel, nil
+}
+
+// OrdersCreated は作成された注文をステータス変更としては配信しない
+func (h *orderStreamHub) OrdersCreated(userID int, orderIDs []int64) {}
+
+// OrderStatusChanged は購読者がいれば変更を配信待ちに積む
+// 配信待ちが溢れた変更は捨てる。購読側は再接続時に一覧を取り直す前提とする
+func (h *orderStreamHub) OrderStatusChanged(orderIDs []int64, status string) {
+	h.mx.Lock()
+	listening := h.count > 0
+	h.mx.Unlock()
+	if !listening {
+		return
+	}
+	select {
+	case h.changes <- orderStreamChange{orderIDs: orderIDs, status: status, at: time.Now()}:
+	default:
+		log.Printf("Order stream queue is full, dropped %d status changes to %s", len(orderIDs), status)
+	}
+}
+
+func (h *orderStreamHub) run() {
+	for change := range h.changes {
+		h.dispatch(change)
+	}
+}
+
+func (h *orderStreamHub) dispatch(change orderStreamChange) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	owners, err := h.owners(ctx, change.orderIDs)
+	if err != nil {
+		log.Printf("Failed to look up owners of %d orders for streaming: %v", len(change.orderIDs), err)
+		return
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	for _, id := range change.orderIDs {
+		userID, ok := owners[id]
+		if !ok {
+			continue
+		}
+		for s := range h.subs[userID] {
+			select {
+			case s.ch <- OrderStatusEvent{OrderID: id, Status: change.status, At: change.at}:
+			default:
+				h.removeLocked(userID, s)
+			}
+		}
+	}
+}
+
+func (h *orderStreamHub) removeLocked(userID int, s *orderStream) {
+	set, ok := h.subs[userID]
+	if !ok {
+		return
+	}
+	if _, ok := set[s]; !ok {
+		return
+	}
+	delete(set, s)
+	close(s.ch)
+	h.count--
+	if len(set) == 0 {
+		delete(h.subs, userID)
+	}
+}
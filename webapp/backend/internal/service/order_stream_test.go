@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOrderStreamHubDeliversToOwner(t *testing.T) {
+	owners := map[int64]int{1: 10, 2: 20}
+	hub := newOrderStreamHub(2, 8, func(ctx context.Context, orderIDs []int64) (map[int64]int, error) {
+		return owners, nil
+	})
+
+	alice, cancelAlice, err := hub.subscribe(10)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer cancelAlice()
+	bob, cancelBob, err := hub.subscribe(20)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer cancelBob()
+	if _, _, err := hub.subscribe(30); !errors.Is(err, ErrTooManyStreams) {
+		t.Fatalf("third subscribe err = %v, want ErrTooManyStreams", err)
+	}
+
+	hub.OrderStatusChanged([]int64{1}, "delivering")
+
+	select {
+	case ev := <-alice:
+		if ev.OrderID != 1 || ev.Status != "delivering" {
+			t.Fatalf("event = %+v, want order 1 delivering", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("owner did not receive the status change")
+	}
+	select {
+	case ev := <-bob:
+		t.Fatalf("other user received %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOrderStreamHubClosesSlowSubscriber(t *testing.T) {
+	hub := newOrderStreamHub(1, 8, func(ctx context.Context, orderIDs []int64) (map[int64]int, error) {
+		return map[int64]int{1: 10}, nil
+	})
+	events, cancel, err := hub.subscribe(10)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer cancel()
+
+	for i := 0; i <= cap(events); i++ {
+		hub.dispatch(orderStreamChange{orderIDs: []int64{1}, status: "delivering"})
+	}
+	for range events {
+	}
+	if _, release, err := hub.subscribe(10); err != nil {
+		t.Fatalf("subscribe after close: %v", err)
+	} else {
+		release()
+	}
+}
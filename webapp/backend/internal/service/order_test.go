@@ -0,0 +1,169 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// orderDB は注文IDごとの注文を持ち、発行した書き込みを記録する
+type orderDB struct {
+	orders map[int64]model.Order
+	writes []string
+}
+
+func (db *orderDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	order, ok := db.orders[args[0].(int64)]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	switch dest := dest.(type) {
+	case *string:
+		*dest = order.ShippedStatus
+	case *model.Order:
+		*dest = order
+	default:
+		return errors.New("unexpected query")
+	}
+	return nil
+}
+
+func (db *orderDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if orders, ok := dest.(*[]model.Order); ok {
+		for _, arg := range args {
+			if order, ok := db.orders[arg.(int64)]; ok {
+				*orders = append(*orders, order)
+			}
+		}
+	}
+	return nil
+}
+
+func (db *orderDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return nil, errors.New("unexpected query")
+}
+
+func (db *orderDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db.writes = append(db.writes, strings.Join(strings.Fields(query), " "))
+	return driver.RowsAffected(1), nil
+}
+
+func (db *orderDB) Rebind(query string) string { return query }
+
+func TestCancelOrder(t *testing.T) {
+	db := &orderDB{orders: map[int64]model.Order{
+		1: {OrderID: 1, UserID: 1, ShippedStatus: "shipping"},
+		2: {OrderID: 2, UserID: 1, ShippedStatus: "delivering"},
+		3: {OrderID: 3, UserID: 1, ShippedStatus: "completed"},
+	}}
+	events := NewOrderEventBus()
+	svc := NewOrderService(repository.NewStore(db), events)
+
+	changes, stop, err := events.Subscribe(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	cancelledAt, err := svc.CancelOrder(context.Background(), 1, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cancelledAt.IsZero() {
+		t.Fatal("expected the cancellation time to be returned")
+	}
+	select {
+	case ev := <-changes:
+		if ev.Status != "cancelled" {
+			t.Fatalf("expected a cancelled event, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the cancellation to be published")
+	}
+	if len(db.writes) != 3 {
+		t.Fatalf("expected the event, its projection and cancelled_at to be written, got %v", db.writes)
+	}
+
+	db.writes = nil
+	for _, orderID := range []int64{2, 3} {
+		if _, err := svc.CancelOrder(context.Background(), 1, orderID); !errors.Is(err, ErrOrderNotCancellable) {
+			t.Fatalf("order %d: expected ErrOrderNotCancellable, got %v", orderID, err)
+		}
+	}
+	if _, err := svc.CancelOrder(context.Background(), 1, 4); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected rejected cancellations not to write, got %v", db.writes)
+	}
+}
+
+func TestCancelOrders(t *testing.T) {
+	db := &orderDB{orders: map[int64]model.Order{
+		1: {OrderID: 1, UserID: 1, ShippedStatus: "shipping"},
+		2: {OrderID: 2, UserID: 1, ShippedStatus: "delivering"},
+		3: {OrderID: 3, UserID: 2, ShippedStatus: "shipping"},
+		5: {OrderID: 5, UserID: 1, ShippedStatus: "shipping"},
+	}}
+	svc := NewOrderService(repository.NewStore(db), NewOrderEventBus())
+
+	results, _, err := svc.CancelOrders(context.Background(), 1, []int64{1, 2, 3, 4, 1, 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []model.CancelOrderResult{
+		{OrderID: 1, Result: model.CancelResultCancelled},
+		{OrderID: 2, Result: model.CancelResultSkipped, Reason: model.CancelSkipNotCancellable, ShippedStatus: "delivering"},
+		{OrderID: 3, Result: model.CancelResultSkipped, Reason: model.CancelSkipForbidden},
+		{OrderID: 4, Result: model.CancelResultSkipped, Reason: model.CancelSkipNotFound},
+		{OrderID: 1, Result: model.CancelResultSkipped, Reason: model.CancelSkipDuplicate},
+		{OrderID: 5, Result: model.CancelResultCancelled},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("unexpected results:\n got %+v\nwant %+v", results, want)
+	}
+	// 取り消せる注文はまとめて1回ずつ書き込む
+	if len(db.writes) != 3 {
+		t.Fatalf("expected the event, its projection and cancelled_at to be written once, got %v", db.writes)
+	}
+
+	db.writes = nil
+	if _, _, err := svc.CancelOrders(context.Background(), 1, []int64{2, 3}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected nothing to be written when no order can be cancelled, got %v", db.writes)
+	}
+	for _, ids := range [][]int64{nil, {0}, make([]int64, maxBulkCancel+1)} {
+		if _, _, err := svc.CancelOrders(context.Background(), 1, ids); !errors.Is(err, ErrInvalidCancelRequest) {
+			t.Fatalf("expected ErrInvalidCancelRequest for %d ids, got %v", len(ids), err)
+		}
+	}
+}
+
+func TestGetOrderDetailChecksOwner(t *testing.T) {
+	db := &orderDB{orders: map[int64]model.Order{1: {OrderID: 1, UserID: 1, ProductName: "chello", ShippedStatus: "shipping"}}}
+	svc := NewOrderService(repository.NewStore(db), NewOrderEventBus())
+
+	order, _, err := svc.GetOrderDetail(context.Background(), 1, 1)
+	if err != nil || order.ProductName != "chello" {
+		t.Fatalf("expected the owner to see the order, got %+v %v", order, err)
+	}
+	if _, _, err := svc.GetOrderDetail(context.Background(), 2, 1); !errors.Is(err, ErrOrderForbidden) {
+		t.Fatalf("expected ErrOrderForbidden, got %v", err)
+	}
+	if _, _, err := svc.GetOrderDetail(context.Background(), 1, 2); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if _, err := svc.OrderEvents(context.Background(), 2, 1); !errors.Is(err, ErrOrderForbidden) {
+		t.Fatalf("expected the events of another user's order to be forbidden, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// パスワードのハッシュの方式
+const (
+	passwordHashBcrypt   = "bcrypt"
+	passwordHashArgon2id = "argon2id"
+)
+
+var errMalformedPasswordHash = errors.New("malformed password hash")
+
+// argon2Params はargon2idのパラメータ。memoryはKiB
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
+	saltLen uint32
+}
+
+// passwordHashing はAuthServiceがパスワードのハッシュを作り、確かめるのに使う
+// テストでは呼び出しを数える実装に差し替える
+type passwordHashing interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) bool
+	NeedsRehash(hash string) bool
+	// DummyHash はユーザーがいないときに比べるハッシュを返す
+	DummyHash() string
+}
+
+// passwordHasher は設定した方式・パラメータでパスワードのハッシュを作る
+// 確かめるときはハッシュの形式から方式を判別するので、設定を変えても既存のハッシュでログインできる
+type passwordHasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     argon2Params
+	// ユーザーがいないときに比べるダミーのハッシュ。設定した方式・パラメータで起動時に作る
+	dummy string
+}
+
+// newPasswordHasherFromEnv は環境変数の設定でハッシュを作る準備をする
+// ダミーのハッシュを作れなければ、ユーザーがいるかどうかが応答時間でわかってしまうためエラーにする
+func newPasswordHasherFromEnv() (*passwordHasher, error) {
+	algorithm := passwordHashBcrypt
+	if os.Getenv("PASSWORD_HASH_ALGORITHM") == passwordHashArgon2id {
+		algorithm = passwordHashArgon2id
+	}
+	cost := parseIntEnv("AUTH_BCRYPT_COST", bcrypt.DefaultCost)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	h := &passwordHasher{
+		algorithm:  algorithm,
+		bcryptCost: cost,
+		argon2: argon2Params{
+			time:    uint32(parseIntEnv("ARGON2_TIME", 1)),
+			memory:  uint32(parseIntEnv("ARGON2_MEMORY_KIB", 64*1024)),
+			threads: uint8(min(parseIntEnv("ARGON2_THREADS", 2), 255)),
+			keyLen:  32,
+			saltLen: 16,
+		},
+	}
+	dummy, err := h.Hash("dummy-password-for-timing")
+	if err != nil {
+		return nil, fmt.Errorf("create dummy password hash: %w", err)
+	}
+	h.dummy = dummy
+	return h, nil
+}
+
+// Hash は設定した方式でパスワードのハッシュを作る
+func (h *passwordHasher) Hash(password string) (string, error) {
+	if h.algorithm != passwordHashArgon2id {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(hash), err
+	}
+	p := h.argon2
+	salt := make([]byte, p.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify はパスワードがハッシュと一致するかを返す
+func (h *passwordHasher) Verify(hash, password string) bool {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	p, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+// DummyHash はユーザーがいないときに比べるハッシュを返す
+// 比べることでパスワードを確かめたのと同じだけ時間をかけ、応答時間からユーザーがいるかどうかを知られないようにする
+func (h *passwordHasher) DummyHash() string {
+	return h.dummy
+}
+
+// NeedsRehash はハッシュの方式が今の設定と違うか、パラメータが今の設定より弱いかを返す
+// 設定より強いハッシュは作り直さず、設定を下げても弱くしない
+func (h *passwordHasher) NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		if h.algorithm != passwordHashBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < h.bcryptCost
+	}
+	if h.algorithm != passwordHashArgon2id {
+		return true
+	}
+	p, _, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return p.time < h.argon2.time || p.memory < h.argon2.memory || uint32(len(key)) < h.argon2.keyLen
+}
+
+// parseArgon2Hash は$argon2id$v=19$m=...,t=...,p=...$salt$key の形式のハッシュを読む
+func parseArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != passwordHashArgon2id {
+		return p, nil, nil, errMalformedPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errMalformedPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, errMalformedPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errMalformedPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errMalformedPasswordHash
+	}
+	p.saltLen = uint32(len(salt))
+	p.keyLen = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testArgon2Hasher() *passwordHasher {
+	return &passwordHasher{
+		algorithm:  passwordHashArgon2id,
+		bcryptCost: bcrypt.MinCost,
+		argon2:     argon2Params{time: 1, memory: 64, threads: 1, keyLen: 32, saltLen: 16},
+	}
+}
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	h := testArgon2Hasher()
+	hash, err := h.Hash("correcthorsebattery")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+	if !h.Verify(hash, "correcthorsebattery") || h.Verify(hash, "wrong") {
+		t.Fatal("argon2id hash does not verify correctly")
+	}
+	if h.NeedsRehash(hash) {
+		t.Fatal("hash with current parameters must not need rehash")
+	}
+
+	stronger := testArgon2Hasher()
+	stronger.argon2.time = 2
+	if !stronger.NeedsRehash(hash) {
+		t.Fatal("hash with outdated parameters must need rehash")
+	}
+	if !stronger.Verify(hash, "correcthorsebattery") {
+		t.Fatal("outdated hash must still verify")
+	}
+	// 設定を下げても、設定より強いハッシュは作り直さない
+	strongHash, _ := stronger.Hash("correcthorsebattery")
+	if h.NeedsRehash(strongHash) {
+		t.Fatal("hash stronger than the current parameters must not need rehash")
+	}
+	if h.Verify("$argon2id$v=19$m=64,t=1,p=1$!!$!!", "x") {
+		t.Fatal("malformed hash must not verify")
+	}
+}
+
+func TestPasswordHasherMigratesBetweenAlgorithms(t *testing.T) {
+	bcryptHasher := &passwordHasher{algorithm: passwordHashBcrypt, bcryptCost: bcrypt.MinCost}
+	bcryptHash, err := bcryptHasher.Hash("correcthorsebattery")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bcryptHasher.NeedsRehash(bcryptHash) {
+		t.Fatal("bcrypt hash with current cost must not need rehash")
+	}
+	if !(&passwordHasher{algorithm: passwordHashBcrypt, bcryptCost: bcrypt.MinCost + 1}).NeedsRehash(bcryptHash) {
+		t.Fatal("bcrypt hash with outdated cost must need rehash")
+	}
+	strongBcrypt, _ := (&passwordHasher{algorithm: passwordHashBcrypt, bcryptCost: bcrypt.MinCost + 1}).Hash("correcthorsebattery")
+	if bcryptHasher.NeedsRehash(strongBcrypt) {
+		t.Fatal("bcrypt hash with a higher cost must not need rehash")
+	}
+
+	// 方式を切り替えても既存のbcryptのハッシュでログインでき、作り直しの対象になる
+	h := testArgon2Hasher()
+	if !h.Verify(bcryptHash, "correcthorsebattery") {
+		t.Fatal("bcrypt hash must verify after switching to argon2id")
+	}
+	if !h.NeedsRehash(bcryptHash) {
+		t.Fatal("bcrypt hash must need rehash after switching to argon2id")
+	}
+	argonHash, _ := h.Hash("correcthorsebattery")
+	if !bcryptHasher.NeedsRehash(argonHash) {
+		t.Fatal("argon2id hash must need rehash after switching to bcrypt")
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordDB はユーザー1人と、そのパスワード再設定のトークンを持つ
+type passwordDB struct {
+	orderDB
+	user   model.User
+	tokens map[string]bool // トークンのハッシュ -> 使用済みか
+}
+
+func (db *passwordDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	switch {
+	case strings.Contains(query, "password_reset_tokens"):
+		used, ok := db.tokens[args[0].(string)]
+		if !ok || used {
+			return sql.ErrNoRows
+		}
+		*dest.(*int) = db.user.UserID
+	case args[0] == db.user.UserID || args[0] == db.user.UserName:
+		*dest.(*model.User) = db.user
+	default:
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *passwordDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO password_reset_tokens"):
+		db.tokens[args[1].(string)] = false
+	case strings.HasPrefix(query, "UPDATE password_reset_tokens"):
+		for hash := range db.tokens {
+			db.tokens[hash] = true
+		}
+	case strings.HasPrefix(query, "UPDATE users SET password_hash"):
+		db.user.PasswordHash = args[0].(string)
+	}
+	return db.orderDB.ExecContext(ctx, query, args...)
+}
+
+// tokenCatcher は届けたトークンを覚えておく
+type tokenCatcher struct {
+	token string
+}
+
+func (c *tokenCatcher) Notify(ctx context.Context, user *model.User, token string, expiresAt time.Time) error {
+	c.token = token
+	return nil
+}
+
+func newPasswordTestService(t *testing.T, password string) (*AuthService, *passwordDB, *tokenCatcher) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &passwordDB{user: model.User{UserID: 7, UserName: "alice", PasswordHash: string(hash)}, tokens: make(map[string]bool)}
+	catcher := &tokenCatcher{}
+	svc := &AuthService{store: repository.NewStore(db), hasher: &passwordHasher{algorithm: passwordHashBcrypt, bcryptCost: bcrypt.MinCost}, minPasswordEntropy: 50, resetTTL: time.Minute, resetNotifier: catcher}
+	return svc, db, catcher
+}
+
+func revokedAllSessions(db *passwordDB) bool {
+	for _, w := range db.writes {
+		if w == "DELETE FROM user_sessions WHERE user_id = ?" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestChangePassword(t *testing.T) {
+	svc, db, _ := newPasswordTestService(t, "correcthorsebattery")
+	ctx := context.Background()
+
+	if err := svc.ChangePassword(ctx, 7, "wrong", "Tr0ub4dor&3x"); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("expected ErrInvalidPassword, got %v", err)
+	}
+	if err := svc.ChangePassword(ctx, 7, "correcthorsebattery", "weak"); !errors.Is(err, ErrInvalidRegistration) {
+		t.Fatalf("expected ErrInvalidRegistration, got %v", err)
+	}
+	if revokedAllSessions(db) {
+		t.Fatal("sessions must be kept when the password is not changed")
+	}
+
+	if err := svc.ChangePassword(ctx, 7, "correcthorsebattery", "Tr0ub4dor&3x"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(db.user.PasswordHash), []byte("Tr0ub4dor&3x")) != nil {
+		t.Fatal("password was not changed")
+	}
+	if !revokedAllSessions(db) {
+		t.Fatalf("expected all sessions to be revoked, writes: %v", db.writes)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	svc, db, catcher := newPasswordTestService(t, "correcthorsebattery")
+	ctx := context.Background()
+
+	// 存在しないユーザー名でもエラーにせず、トークンも発行しない
+	if err := svc.RequestPasswordReset(ctx, "nobody", model.ClientInfo{}); err != nil {
+		t.Fatalf("expected silent success, got %v", err)
+	}
+	svc.resetJobs.Wait()
+	if catcher.token != "" {
+		t.Fatalf("expected no token for an unknown user, got %q", catcher.token)
+	}
+	err := svc.RequestPasswordReset(ctx, "alice", model.ClientInfo{})
+	svc.resetJobs.Wait()
+	if err != nil || catcher.token == "" {
+		t.Fatalf("expected a token, got %v", err)
+	}
+	if _, ok := db.tokens[catcher.token]; ok {
+		t.Fatal("token must be stored hashed")
+	}
+
+	if err := svc.ConfirmPasswordReset(ctx, "wrong-token", "Tr0ub4dor&3x"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected ErrInvalidResetToken, got %v", err)
+	}
+	if err := svc.ConfirmPasswordReset(ctx, catcher.token, "Tr0ub4dor&3x"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(db.user.PasswordHash), []byte("Tr0ub4dor&3x")) != nil {
+		t.Fatal("password was not reset")
+	}
+	if !revokedAllSessions(db) {
+		t.Fatalf("expected all sessions to be revoked, writes: %v", db.writes)
+	}
+	// 使ったトークンはもう使えない
+	if err := svc.ConfirmPasswordReset(ctx, catcher.token, "s3cure-passw0rd"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected used token to be rejected, got %v", err)
+	}
+}
+
+func TestPasswordResetRequestDoesNotWaitForLookup(t *testing.T) {
+	db := &userLookupDB{
+		users:   map[string]model.User{"alice": {UserID: 7, UserName: "alice"}},
+		release: make(chan struct{}),
+	}
+	catcher := &tokenCatcher{}
+	svc := &AuthService{store: repository.NewStore(db), resetTTL: time.Minute, resetNotifier: catcher}
+
+	// ユーザーの検索を待たずに返すため、ユーザーがいてもいなくても応答時間は変わらない
+	for _, name := range []string{"alice", "nobody"} {
+		if err := svc.RequestPasswordReset(context.Background(), name, model.ClientInfo{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	close(db.release)
+	svc.resetJobs.Wait()
+	if catcher.token == "" {
+		t.Fatal("expected the token to be issued in the background")
+	}
+}
+
+func TestPasswordResetRequestGuards(t *testing.T) {
+	svc, db, _ := newPasswordTestService(t, "correcthorsebattery")
+	ctx := context.Background()
+	now := time.Now()
+	svc.SetLoginLimiter(newTestLoginLimiter(&now))
+
+	// 多すぎる申請はトークンを作らずに断る
+	for i := 0; i < 2; i++ {
+		if err := svc.RequestPasswordReset(ctx, "alice", model.ClientInfo{IP: "10.0.0.1"}); err != nil {
+			t.Fatalf("attempt %d: unexpected error: %v", i, err)
+		}
+		svc.resetJobs.Wait()
+	}
+	writes := len(db.writes)
+	var throttled *LoginThrottledError
+	if err := svc.RequestPasswordReset(ctx, "alice", model.ClientInfo{IP: "10.0.0.1"}); !errors.As(err, &throttled) {
+		t.Fatalf("expected LoginThrottledError, got %v", err)
+	}
+	svc.resetJobs.Wait()
+	if len(db.writes) != writes {
+		t.Fatalf("throttled request must not create a token, writes: %v", db.writes[writes:])
+	}
+	// 申請が多すぎてもログインは止めない
+	if _, _, err := svc.Login(ctx, "alice", "correcthorsebattery", model.ClientInfo{IP: "10.0.0.1"}); errors.As(err, &throttled) {
+		t.Fatalf("expected reset requests not to throttle login, got %v", err)
+	}
+
+	// 届け先がなければ受け付けない
+	svc.SetPasswordResetNotifier(nil)
+	if err := svc.RequestPasswordReset(ctx, "bob", model.ClientInfo{}); !errors.Is(err, ErrPasswordResetUnavailable) {
+		t.Fatalf("expected ErrPasswordResetUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"context"
+	"time"
+)
+
+// AmendPlan は配送中のロボットの残りの積載量に、前回の計画の後に届いた注文から追加で積む分を選んで引き当てる
+// spec.Capacityには残りの積載量を指定する。前回の計画の注文は選び直さず、新しい注文だけを候補にする
+// 前回の計画がなければ、配送待ちの注文すべてを候補にする
+func (s *RobotService) AmendPlan(ctx context.Context, spec model.RobotSpec) (*model.DeliveryPlan, error) {
+	var plan model.DeliveryPlan
+	profile, opts := s.resolvePlan(spec)
+	var solveTime time.Duration
+
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		if spec, err = s.admitRobot(ctx, spec); err != nil {
+			return err
+		}
+		return s.claimTx(ctx, func(txStore *repository.Store, taken map[int64]bool, final bool) error {
+			generation := s.results.begin()
+			orders, err := loadArrivedOrders(ctx, txStore, spec.RobotID)
+			if err != nil {
+				return err
+			}
+			plan, solveTime, err = s.selectPlan(ctx, withoutOrders(orders, taken), spec, profile, opts, generation)
+			if err != nil {
+				return err
+			}
+			lost, err := lockPlanOrders(ctx, txStore, &plan, taken)
+			if err != nil {
+				return err
+			}
+			if lost > 0 && len(plan.Orders) == 0 && !final {
+				return errOrdersTaken
+			}
+			return claimPlan(ctx, txStore, &plan, solveTime)
+		})
+	})
+	s.planner.record(profile, &plan, spec.Capacity, solveTime, err)
+	if err != nil {
+		return nil, err
+	}
+	if orderIDs := planOrderIDs(&plan); len(orderIDs) > 0 {
+		s.events.Publish(orderIDs, "delivering")
+	}
+	return &plan, nil
+}
+
+// loadArrivedOrders はrobotIDのロボットの前回の計画より後に作成された配送待ちの注文を読む
+func loadArrivedOrders(ctx context.Context, store *repository.Store, robotID string) ([]model.Order, error) {
+	latest, err := store.PlanRepo.List(ctx, robotID, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(latest) == 0 {
+		return store.OrderRepo.GetShippingOrders(ctx)
+	}
+	return store.OrderRepo.GetShippingOrdersCreatedAfter(ctx, latest[0].CreatedAt)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// amendDB は前回の計画の履歴と、その後に届いた注文を返すDBを模す
+type amendDB struct {
+	readOnlyDB
+	lastPlan    *model.DeliveryPlanRecord
+	orderArgs   [][]interface{}
+	execs       []string
+	insertedIDs int64
+}
+
+func (db *amendDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if records, ok := dest.(*[]model.DeliveryPlanRecord); ok {
+		if db.lastPlan != nil {
+			*records = append(*records, *db.lastPlan)
+		}
+		return nil
+	}
+	db.orderArgs = append(db.orderArgs, args)
+	return db.readOnlyDB.SelectContext(ctx, dest, query, args...)
+}
+
+func (db *amendDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db.execs = append(db.execs, query)
+	db.insertedIDs++
+	return insertResult(db.insertedIDs), nil
+}
+
+type insertResult int64
+
+func (r insertResult) LastInsertId() (int64, error) { return int64(r), nil }
+func (r insertResult) RowsAffected() (int64, error) { return 1, nil }
+
+func TestAmendPlanReadsOrdersArrivedAfterLastPlan(t *testing.T) {
+	planned := time.Now().Add(-time.Minute)
+	db := &amendDB{
+		readOnlyDB: readOnlyDB{orders: []model.Order{
+			{OrderID: 10, Weight: 2, Value: 20},
+			{OrderID: 11, Weight: 4, Value: 30},
+		}},
+		lastPlan: &model.DeliveryPlanRecord{PlanID: 1, RobotID: "robot", CreatedAt: planned},
+	}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+
+	plan, err := svc.AmendPlan(context.Background(), model.RobotSpec{RobotID: "robot", Capacity: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Orders) != 1 || plan.Orders[0].OrderID != 11 {
+		t.Fatalf("expected the remaining capacity to be topped off with order 11, got %+v", plan.Orders)
+	}
+	if len(db.orderQueries) != 1 || !strings.Contains(db.orderQueries[0], "o.created_at > ?") || strings.Contains(db.orderQueries[0], "FOR UPDATE") {
+		t.Fatalf("expected an unlocked read of orders newer than the last plan, got %v", db.orderQueries)
+	}
+	if len(db.lockQueries) != 1 {
+		t.Fatalf("expected the chosen order to be locked when claimed, got %v", db.lockQueries)
+	}
+	if got := db.orderArgs[0]; len(got) == 0 || !got[0].(time.Time).Equal(planned) {
+		t.Fatalf("expected orders created after %v, got %v", planned, got)
+	}
+	// 追加分もステータスの変更とロボットの記録、履歴の記録を行う
+	if len(db.execs) != 4 || !strings.Contains(db.execs[2], "assigned_robot_id") || !strings.Contains(db.execs[3], "INSERT INTO delivery_plans") {
+		t.Fatalf("expected the amendment to be claimed and recorded, got %v", db.execs)
+	}
+}
+
+func TestAmendPlanWithoutPreviousPlanConsidersAllOrders(t *testing.T) {
+	db := &amendDB{readOnlyDB: readOnlyDB{orders: []model.Order{{OrderID: 10, Weight: 2, Value: 20}}}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+
+	plan, err := svc.AmendPlan(context.Background(), model.RobotSpec{RobotID: "robot", Capacity: 5})
+	if err != nil || len(plan.Orders) != 1 {
+		t.Fatalf("unexpected amendment: %+v %v", plan, err)
+	}
+	if strings.Contains(db.orderQueries[0], "created_at >") {
+		t.Fatalf("expected every shipping order to be a candidate: %s", db.orderQueries[0])
+	}
+}
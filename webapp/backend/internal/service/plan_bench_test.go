@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+// 選定の解法の比較に使う注文の分布
+// uniform: 重量・価値とも一様, heavy_tailed: 重量・価値ともパレート分布,
+// correlated: 価値が重量にほぼ比例（価値密度が揃い、事前の採否の確定が効きにくい）
+var benchDistributions = []string{"uniform", "heavy_tailed", "correlated"}
+
+var benchSizes = []int{10, 100, 1000, 10000, 100000}
+
+// 比較する解法。epsilonは許容誤差付きの近似（貪欲解が上界の99%以上なら採用）、dpは事前の確定つきの厳密解
+var benchStrategies = []struct {
+	name   string
+	solver Solver
+	c      SolverConstraints
+	exact  bool
+}{
+	{name: "greedy", solver: greedySolver{}},
+	{name: "epsilon", solver: exactSolver{}, c: SolverConstraints{Epsilon: 0.01}, exact: true},
+	{name: "dp", solver: dpSolver{}, exact: true},
+	{name: "branch_and_bound", solver: branchAndBoundSolver{}, c: SolverConstraints{ExactBudget: 50 * time.Millisecond}},
+}
+
+// DPの表がこのセル数を超える組み合わせは、ベンチマークの時間とメモリに収まらないため計測しない
+const maxBenchDPCells = 1 << 30
+
+func benchOrders(distribution string, n int, rng *rand.Rand) []model.Order {
+	pareto := func(scale float64) int {
+		return int(math.Min(scale/math.Pow(1-rng.Float64(), 1/1.5), scale*100))
+	}
+	orders := make([]model.Order, n)
+	for i := range orders {
+		var w, v int
+		switch distribution {
+		case "uniform":
+			w, v = 100+rng.Intn(9900), 1+rng.Intn(1000)
+		case "heavy_tailed":
+			w, v = 100+pareto(500), 1+pareto(50)
+		case "correlated":
+			w = 100 + rng.Intn(9900)
+			v = w/10 + rng.Intn(20)
+		}
+		orders[i] = model.Order{OrderID: int64(i + 1), Weight: model.Grams(w), Value: model.Points(v)}
+	}
+	return orders
+}
+
+// benchCapacity はロボット1台分の積載量。注文の合計重量の1/4を上限200kgで切る
+func benchCapacity(orders []model.Order) model.Grams {
+	var total model.Grams
+	for _, o := range orders {
+		total += o.Weight
+	}
+	return min(total/4, 200000)
+}
+
+// BenchmarkSelectionStrategies は注文の分布と件数ごとに各解法の価値と所要時間を比べる
+// PLAN_BENCH_CSVにパスを指定すると、結果をCSVで書き出す（make bench-plan）
+// 閾値（epsilon、厳密解の時間予算、DPの並列化の下限など）を見直す際に使う
+func BenchmarkSelectionStrategies(b *testing.B) {
+	// サブベンチマークはb.Nを増やしながら繰り返し呼ばれるため、最後の計測で上書きする
+	var (
+		mx      sync.Mutex
+		results = map[string][]string{}
+		names   []string
+	)
+	for _, distribution := range benchDistributions {
+		for _, n := range benchSizes {
+			orders := benchOrders(distribution, n, rand.New(rand.NewSource(int64(n))))
+			capacity := benchCapacity(orders)
+			upper := newFractionalBounds(orders).upperBound(capacity, -1)
+			for _, strategy := range benchStrategies {
+				b.Run(fmt.Sprintf("%s/n=%d/%s", distribution, n, strategy.name), func(b *testing.B) {
+					if strategy.exact && n*int(capacity) > maxBenchDPCells {
+						b.Skipf("DP table of %d cells is too large", n*int(capacity))
+					}
+					c := strategy.c
+					c.WeightCapacity = capacity
+					c.Parallelism = 1
+					var value model.Points
+					for i := 0; i < b.N; i++ {
+						plan, err := strategy.solver.Solve(context.Background(), orders, c)
+						if err != nil {
+							b.Fatal(err)
+						}
+						value = 0
+						for j, ok := range plan.Chosen {
+							if ok {
+								value += orders[j].Value
+							}
+						}
+					}
+					gap := 0.0
+					if upper > 0 {
+						gap = 1 - float64(value)/float64(upper)
+					}
+					b.ReportMetric(float64(value), "value")
+					b.ReportMetric(gap, "gap")
+
+					mx.Lock()
+					defer mx.Unlock()
+					if _, ok := results[b.Name()]; !ok {
+						names = append(names, b.Name())
+					}
+					results[b.Name()] = []string{
+						distribution, strconv.Itoa(n), strconv.Itoa(int(capacity)), strategy.name,
+						strconv.Itoa(int(value)), strconv.Itoa(int(upper)), strconv.FormatFloat(gap, 'f', 6, 64),
+						strconv.FormatInt(b.Elapsed().Nanoseconds()/int64(b.N), 10),
+					}
+				})
+			}
+		}
+	}
+
+	path := os.Getenv("PLAN_BENCH_CSV")
+	if path == "" {
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	rows := [][]string{{"distribution", "orders", "capacity", "strategy", "value", "upper_bound", "gap", "ns_per_op"}}
+	for _, name := range names {
+		rows = append(rows, results[name])
+	}
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		b.Fatal(err)
+	}
+}
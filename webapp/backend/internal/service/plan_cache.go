@@ -0,0 +1,111 @@
+package service
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"backend/internal/model"
+)
+
+// planResultCache は配送待ちの注文の集合と計画の指定が同じ間、選定結果を使い回す
+// ロボットが同じ秒に繰り返しポーリングした場合に、同じナップサック問題を解き直さないために使う
+//
+// 注文のステータスが変わったら世代を進めてすべて破棄する。
+// 選定中に世代が進んだ場合、その結果は古い可能性があるため保存しない。
+type planResultCache struct {
+	mx         sync.Mutex
+	ttl        time.Duration
+	now        func() time.Time
+	generation uint64
+	entries    map[uint64]cachedPlan
+}
+
+type cachedPlan struct {
+	plan      model.DeliveryPlan
+	expiresAt time.Time
+}
+
+func newPlanResultCache(ttl time.Duration) *planResultCache {
+	return &planResultCache{ttl: ttl, now: time.Now, entries: make(map[uint64]cachedPlan)}
+}
+
+// planCacheKey は注文IDの集合と計画の指定からキャッシュのキーを求める
+func planCacheKey(orders []model.Order, spec model.RobotSpec, profile string) uint64 {
+	ids := make([]int64, len(orders))
+	for i, o := range orders {
+		ids[i] = o.OrderID
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, id := range ids {
+		binary.LittleEndian.PutUint64(buf[:], uint64(id))
+		h.Write(buf[:])
+	}
+	binary.LittleEndian.PutUint64(buf[:], uint64(spec.Capacity))
+	h.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], uint64(spec.VolumeCapacity))
+	h.Write(buf[:])
+	h.Write([]byte(spec.RobotID))
+	h.Write([]byte{0})
+	h.Write([]byte(profile))
+	return h.Sum64()
+}
+
+// begin は選定を始める時点の世代を返す。putに渡して、選定中に変更がなかったかを判定する
+func (c *planResultCache) begin() uint64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.generation
+}
+
+// get はキャッシュ済みの計画の複製を返す
+func (c *planResultCache) get(key uint64) (model.DeliveryPlan, bool) {
+	if c.ttl <= 0 {
+		return model.DeliveryPlan{}, false
+	}
+	c.mx.Lock()
+	entry, ok := c.entries[key]
+	c.mx.Unlock()
+	if !ok || c.now().After(entry.expiresAt) {
+		return model.DeliveryPlan{}, false
+	}
+	plan := entry.plan
+	plan.Orders = append([]model.Order(nil), entry.plan.Orders...)
+	return plan, true
+}
+
+// put は世代generationで選定した計画を保存する
+func (c *planResultCache) put(key, generation uint64, plan model.DeliveryPlan) {
+	if c.ttl <= 0 {
+		return
+	}
+	plan.Orders = append([]model.Order(nil), plan.Orders...)
+	now := c.now()
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if generation != c.generation {
+		return
+	}
+	for k, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedPlan{plan: plan, expiresAt: now.Add(c.ttl)}
+}
+
+// OrdersCreated は何もしない。新しい注文は注文IDの集合が変わるためキーで区別できる
+func (c *planResultCache) OrdersCreated(userID int, orderIDs []int64) {}
+
+// OrderStatusChanged は世代を進め、保持している計画をすべて破棄する
+func (c *planResultCache) OrderStatusChanged(orderIDs []int64, status string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.generation++
+	clear(c.entries)
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestPlanResultCacheKey(t *testing.T) {
+	orders := []model.Order{{OrderID: 3}, {OrderID: 1}, {OrderID: 2}}
+	spec := model.RobotSpec{RobotID: "robot", Capacity: 100}
+	key := planCacheKey(orders, spec, "default")
+
+	reordered := []model.Order{{OrderID: 1}, {OrderID: 2}, {OrderID: 3}}
+	if planCacheKey(reordered, spec, "default") != key {
+		t.Fatalf("expected the key to ignore the order of the rows")
+	}
+	for name, other := range map[string]uint64{
+		"orders":   planCacheKey(orders[:2], spec, "default"),
+		"capacity": planCacheKey(orders, model.RobotSpec{RobotID: "robot", Capacity: 101}, "default"),
+		"volume":   planCacheKey(orders, model.RobotSpec{RobotID: "robot", Capacity: 100, VolumeCapacity: 5}, "default"),
+		"profile":  planCacheKey(orders, spec, "greedy"),
+	} {
+		if other == key {
+			t.Fatalf("expected a different key when %s changes", name)
+		}
+	}
+}
+
+func TestPlanResultCacheInvalidation(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newPlanResultCache(time.Second)
+	c.now = func() time.Time { return now }
+	plan := model.DeliveryPlan{RobotID: "robot", TotalValue: 10, Orders: []model.Order{{OrderID: 1}}}
+
+	c.put(1, c.begin(), plan)
+	got, ok := c.get(1)
+	if !ok || got.TotalValue != 10 || len(got.Orders) != 1 {
+		t.Fatalf("expected a cached plan, got %+v %v", got, ok)
+	}
+	got.Orders[0].OrderID = 99
+	if again, _ := c.get(1); again.Orders[0].OrderID != 1 {
+		t.Fatalf("cached plan must not share orders with callers")
+	}
+
+	// 選定中にステータスが変わった結果は保存しない
+	generation := c.begin()
+	c.OrderStatusChanged([]int64{1}, "delivering")
+	if _, ok := c.get(1); ok {
+		t.Fatalf("expected a status change to drop cached plans")
+	}
+	c.put(2, generation, plan)
+	if _, ok := c.get(2); ok {
+		t.Fatalf("expected a plan from a stale generation to be discarded")
+	}
+
+	c.put(3, c.begin(), plan)
+	now = now.Add(2 * time.Second)
+	if _, ok := c.get(3); ok {
+		t.Fatalf("expected the plan to expire after the ttl")
+	}
+
+	disabled := newPlanResultCache(0)
+	disabled.put(1, disabled.begin(), plan)
+	if _, ok := disabled.get(1); ok {
+		t.Fatalf("expected a zero ttl to disable the cache")
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"backend/internal/model"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPlanNotFound  = errors.New("plan not found or expired")
+	ErrInvalidCursor = errors.New("invalid plan cursor")
+)
+
+// planChunkStore は分割して返す配送計画をサーバー側に保持する
+// 注文の引き当ては計画生成時に一括で済んでいるため、ここでは読み出しのみを扱う
+type planChunkStore struct {
+	mx    sync.Mutex
+	plans map[string]storedPlan
+	ttl   time.Duration
+}
+
+type storedPlan struct {
+	plan      model.DeliveryPlan
+	expiresAt time.Time
+}
+
+func newPlanChunkStore(ttl time.Duration) *planChunkStore {
+	return &planChunkStore{plans: make(map[string]storedPlan), ttl: ttl}
+}
+
+// save は計画を保存し、先頭のチャンクを返す
+func (s *planChunkStore) save(plan *model.DeliveryPlan, chunkSize int) (*model.DeliveryPlan, error) {
+	planID := uuid.NewString()
+	now := time.Now()
+	s.mx.Lock()
+	for id, stored := range s.plans {
+		if now.After(stored.expiresAt) {
+			delete(s.plans, id)
+		}
+	}
+	s.plans[planID] = storedPlan{plan: *plan, expiresAt: now.Add(s.ttl)}
+	s.mx.Unlock()
+	return s.chunk(planID, "", chunkSize)
+}
+
+// chunk はcursorの位置からchunkSize件の注文を含む計画を返す
+func (s *planChunkStore) chunk(planID, cursor string, chunkSize int) (*model.DeliveryPlan, error) {
+	s.mx.Lock()
+	stored, ok := s.plans[planID]
+	s.mx.Unlock()
+	if !ok || time.Now().After(stored.expiresAt) {
+		return nil, ErrPlanNotFound
+	}
+
+	offset := 0
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 || n > len(stored.plan.Orders) {
+			return nil, ErrInvalidCursor
+		}
+		offset = n
+	}
+	end := offset + chunkSize
+	if end > len(stored.plan.Orders) {
+		end = len(stored.plan.Orders)
+	}
+
+	chunk := stored.plan
+	chunk.PlanID = planID
+	chunk.TotalOrders = len(stored.plan.Orders)
+	chunk.Orders = stored.plan.Orders[offset:end]
+	if end < len(stored.plan.Orders) {
+		chunk.NextCursor = strconv.Itoa(end)
+	}
+	return &chunk, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"context"
+	"log"
+	"time"
+)
+
+// ReleasePlan はrobotIDのロボットが引き受けたまま配送中の注文をすべて配送待ちに戻し、戻した注文IDを返す
+// ロボットが計画を引き受けた後に故障した場合に、残った注文を他のロボットに回すために使う
+func (s *RobotService) ReleasePlan(ctx context.Context, robotID string) ([]int64, error) {
+	var released []int64
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		released, err = s.releaseDelivering(ctx, robotID, time.Time{}, robotID)
+		return err
+	})
+	return released, err
+}
+
+// releaseDelivering は配送中の注文を1つのトランザクションで配送待ちに戻す
+// 注文に行ロックを取ってから戻すため、その間に完了した注文は戻さない
+func (s *RobotService) releaseDelivering(ctx context.Context, robotID string, before time.Time, actor string) ([]int64, error) {
+	var released []int64
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		ids, err := txStore.OrderEventRepo.DeliveringOrderIDs(ctx, robotID, before)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		released, err = txStore.OrderRepo.LockDelivering(ctx, ids)
+		if err != nil || len(released) == 0 {
+			return err
+		}
+		return recordStatusChange(ctx, txStore, released, "shipping", actor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(released) > 0 {
+		s.events.Publish(released, "shipping")
+	}
+	return released, nil
+}
+
+// StartPlanReaper は引き受けたまま進まない注文を定期的に配送待ちに戻すジョブを開始する
+// 引き受けてからreleaseAfterを過ぎても完了しない注文と、heartbeatTimeoutを過ぎても生存を通知しないロボットの注文を戻す
+// どちらも0（ROBOT_PLAN_RELEASE_AFTERとROBOT_HEARTBEAT_TIMEOUTが未設定）なら何もしない
+func (s *RobotService) StartPlanReaper(ctx context.Context) {
+	if s.releaseAfter <= 0 && s.heartbeatTimeout <= 0 {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.reapEvery):
+			}
+
+			if s.releaseAfter > 0 {
+				released, err := s.releaseDelivering(ctx, "", time.Now().Add(-s.releaseAfter), model.OrderEventActorPlanReaper)
+				if err != nil {
+					log.Printf("Failed to release stale delivering orders: %v", err)
+				} else if len(released) > 0 {
+					log.Printf("Released %d orders stuck in 'delivering' for over %s", len(released), s.releaseAfter)
+				}
+			}
+			if s.heartbeatTimeout > 0 {
+				if err := s.releaseSilentRobots(ctx); err != nil {
+					log.Printf("Failed to release orders of silent robots: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// releaseSilentRobots はheartbeatTimeoutを過ぎても生存を通知しないロボットが引き受けた注文を配送待ちに戻す
+func (s *RobotService) releaseSilentRobots(ctx context.Context) error {
+	robotIDs, err := s.store.RobotRepo.ListSilent(ctx, time.Now().Add(-s.heartbeatTimeout))
+	if err != nil {
+		return err
+	}
+	for _, robotID := range robotIDs {
+		released, err := s.releaseDelivering(ctx, robotID, time.Time{}, model.OrderEventActorPlanReaper)
+		if err != nil {
+			return err
+		}
+		if len(released) > 0 {
+			log.Printf("Released %d orders of %s, silent for over %s", len(released), robotID, s.heartbeatTimeout)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// releaseDB は配送中の注文のうち、lockedだけがまだ配送中のDBを模す
+type releaseDB struct {
+	delivering []int64
+	locked     []int64
+	selects    []string
+	execs      []string
+	execArgs   [][]interface{}
+}
+
+func (db *releaseDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sql.ErrNoRows
+}
+
+func (db *releaseDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db.selects = append(db.selects, query)
+	ids := dest.(*[]int64)
+	if strings.Contains(query, "FOR UPDATE") {
+		*ids = append(*ids, db.locked...)
+	} else {
+		*ids = append(*ids, db.delivering...)
+	}
+	return nil
+}
+
+func (db *releaseDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (db *releaseDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db.execs = append(db.execs, query)
+	db.execArgs = append(db.execArgs, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (db *releaseDB) Rebind(query string) string { return query }
+
+func TestReleasePlanReturnsLockedOrdersToShipping(t *testing.T) {
+	db := &releaseDB{delivering: []int64{1, 2, 3}, locked: []int64{1, 3}}
+	events := NewOrderEventBus()
+	svc := NewRobotService(repository.NewStore(db), events)
+	updates, cancel, err := events.Subscribe(3)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer cancel()
+
+	released, err := svc.ReleasePlan(context.Background(), "robot-001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// ロックを取れなかった（完了済みの）注文2は戻さない
+	if len(released) != 2 || released[0] != 1 || released[1] != 3 {
+		t.Fatalf("expected orders 1 and 3 to be released, got %v", released)
+	}
+	if len(db.selects) != 2 || !strings.Contains(db.selects[0], "e.actor = ?") || strings.Contains(db.selects[0], "occurred_at") {
+		t.Fatalf("expected a lookup by robot followed by a lock, got %v", db.selects)
+	}
+	if len(db.execs) != 2 || !strings.Contains(db.execs[0], "INSERT INTO order_events") {
+		t.Fatalf("expected the release to append events and project them, got %v", db.execs)
+	}
+	args := db.execArgs[0]
+	if args[1] != "shipping" || args[3] != "robot-001" {
+		t.Fatalf("expected a shipping event by the robot, got %v", args)
+	}
+	select {
+	case ev := <-updates:
+		if ev.Status != "shipping" {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the release to be published")
+	}
+}
+
+func TestReleasePlanWithoutDeliveringOrders(t *testing.T) {
+	db := &releaseDB{}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+
+	released, err := svc.ReleasePlan(context.Background(), "robot-001")
+	if err != nil || len(released) != 0 {
+		t.Fatalf("expected nothing to release, got %v %v", released, err)
+	}
+	if len(db.selects) != 1 || len(db.execs) != 0 {
+		t.Fatalf("expected a single lookup and no writes, got %v %v", db.selects, db.execs)
+	}
+}
+
+func TestPlanReaperReleasesStaleOrders(t *testing.T) {
+	db := &releaseDB{delivering: []int64{7}, locked: []int64{7}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+
+	released, err := svc.releaseDelivering(context.Background(), "", time.Now().Add(-time.Hour), model.OrderEventActorPlanReaper)
+	if err != nil || len(released) != 1 {
+		t.Fatalf("unexpected release: %v %v", released, err)
+	}
+	if strings.Contains(db.selects[0], "e.actor = ?") || !strings.Contains(db.selects[0], "e.occurred_at < ?") {
+		t.Fatalf("expected the reaper to look up every robot's stale orders: %s", db.selects[0])
+	}
+	if actor := db.execArgs[0][3]; actor != model.OrderEventActorPlanReaper {
+		t.Fatalf("expected the reaper to be recorded as the actor, got %v", actor)
+	}
+}
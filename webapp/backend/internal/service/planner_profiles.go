@@ -0,0 +1,304 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// プランナーのアルゴリズム（組み込みの解法の登録名）
+const (
+	plannerExact  = "exact"
+	plannerGreedy = "greedy"
+)
+
+// 公平性モード
+const (
+	fairnessNone  = "none"
+	fairnessAging = "aging"
+)
+
+// 割り当てがなく、どのコホートにも入らないロボットが使うプロファイル名
+const defaultPlannerProfile = "default"
+
+var (
+	ErrInvalidPlannerProfile = errors.New("invalid planner profile")
+	ErrPlannerProfileMissing = errors.New("planner profile not found")
+)
+
+// PlannerProfileService はロボットごと・コホートごとのプランナー設定を保持し、
+// プロファイル別の計画結果を集計してA/Bテストに使えるようにする
+//
+// 設定はDBに保存し、メモリ上のスナップショットを定期的に読み直すため、再起動なしで切り替えられる。
+type PlannerProfileService struct {
+	store    *repository.Store
+	defaults planOptions
+	refresh  time.Duration
+	solvers  *solverRegistry
+
+	mx          sync.RWMutex
+	profiles    map[string]model.PlannerProfile
+	assignments map[string]string
+	rollouts    []model.PlannerProfile // rollout_percentが正のプロファイル（名前順）
+	loadedAt    time.Time
+	reloading   atomic.Bool
+
+	metricsMx sync.Mutex
+	metrics   map[string]*PlannerMetrics
+}
+
+// PlannerMetrics はプロファイルごとの計画結果の累計
+type PlannerMetrics struct {
+	Profile       string       `json:"profile"`
+	Plans         int          `json:"plans"`
+	Errors        int          `json:"errors"`
+	Orders        int          `json:"orders"`
+	TotalValue    model.Points `json:"total_value"`
+	TotalWeight   model.Grams  `json:"total_weight"`
+	TotalCapacity model.Grams  `json:"total_capacity"`
+	Utilization   float64      `json:"utilization"`
+	AvgSolveMs    float64      `json:"avg_solve_ms"`
+
+	solveTime time.Duration
+}
+
+func newPlannerProfileService(store *repository.Store, defaults planOptions) *PlannerProfileService {
+	return &PlannerProfileService{
+		store:       store,
+		defaults:    defaults,
+		refresh:     parseDurationEnv("PLANNER_PROFILE_REFRESH", 30*time.Second),
+		solvers:     newSolverRegistry(),
+		profiles:    make(map[string]model.PlannerProfile),
+		assignments: make(map[string]string),
+		metrics:     make(map[string]*PlannerMetrics),
+	}
+}
+
+// Reload はDBからプロファイルと割り当てを読み直す
+func (s *PlannerProfileService) Reload(ctx context.Context) error {
+	profiles, err := s.store.PlannerRepo.ListProfiles(ctx)
+	if err != nil {
+		return err
+	}
+	assignments, err := s.store.PlannerRepo.ListAssignments(ctx)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]model.PlannerProfile, len(profiles))
+	var rollouts []model.PlannerProfile
+	for _, p := range profiles {
+		byName[p.Name] = p
+		if p.RolloutPercent > 0 {
+			rollouts = append(rollouts, p)
+		}
+	}
+	byRobot := make(map[string]string, len(assignments))
+	for _, a := range assignments {
+		byRobot[a.RobotID] = a.ProfileName
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.profiles = byName
+	s.assignments = byRobot
+	s.rollouts = rollouts
+	s.loadedAt = time.Now()
+	return nil
+}
+
+// Resolve はロボットが使うプロファイル名と設定を返す
+// 個別の割り当てを優先し、なければロボットIDのハッシュでコホートを決める
+func (s *PlannerProfileService) Resolve(robotID string) (string, planOptions) {
+	s.maybeReload()
+
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	name, ok := s.assignments[robotID]
+	if !ok {
+		name = defaultPlannerProfile
+		bucket := robotBucket(robotID)
+		cumulative := 0
+		for _, p := range s.rollouts {
+			cumulative += p.RolloutPercent
+			if bucket < cumulative {
+				name = p.Name
+				break
+			}
+		}
+	}
+	if p, ok := s.profiles[name]; ok {
+		return name, profileOptions(p)
+	}
+	return defaultPlannerProfile, s.defaults
+}
+
+// 読み直しは計画の生成を待たせないよう裏で行う
+func (s *PlannerProfileService) maybeReload() {
+	s.mx.RLock()
+	stale := time.Since(s.loadedAt) > s.refresh
+	s.mx.RUnlock()
+	if !stale || !s.reloading.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.reloading.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.Reload(ctx); err != nil {
+			log.Printf("Failed to reload planner profiles: %v", err)
+		}
+	}()
+}
+
+// ロボットIDを0〜99のコホート番号に振り分ける
+func robotBucket(robotID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(robotID))
+	return int(h.Sum32() % 100)
+}
+
+func profileOptions(p model.PlannerProfile) planOptions {
+	return planOptions{
+		zeroWeightCap:    p.ZeroWeightCap,
+		zeroWeightPolicy: p.ZeroWeightPolicy,
+		algorithm:        p.Algorithm,
+		epsilon:          p.Epsilon,
+		fairnessMode:     p.FairnessMode,
+		agingBoost:       p.AgingBoost,
+	}
+}
+
+// Profiles は保存済みのプロファイルと割り当てを返す
+func (s *PlannerProfileService) Profiles() ([]model.PlannerProfile, map[string]string) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	profiles := make([]model.PlannerProfile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	assignments := make(map[string]string, len(s.assignments))
+	for robotID, name := range s.assignments {
+		assignments[robotID] = name
+	}
+	return profiles, assignments
+}
+
+// SaveProfile はプロファイルを検証して保存し、すぐに反映する
+func (s *PlannerProfileService) SaveProfile(ctx context.Context, p model.PlannerProfile) error {
+	if p.Algorithm == "" {
+		p.Algorithm = plannerExact
+	}
+	if p.FairnessMode == "" {
+		p.FairnessMode = fairnessNone
+	}
+	if p.ZeroWeightPolicy == "" {
+		p.ZeroWeightPolicy = zeroWeightOldestFirst
+	}
+	if err := validatePlannerProfile(p, s.solvers); err != nil {
+		return err
+	}
+
+	s.mx.RLock()
+	rollout := p.RolloutPercent
+	for _, other := range s.rollouts {
+		if other.Name != p.Name {
+			rollout += other.RolloutPercent
+		}
+	}
+	s.mx.RUnlock()
+	if rollout > 100 {
+		return fmt.Errorf("%w: rollout percentages add up to %d%%", ErrInvalidPlannerProfile, rollout)
+	}
+
+	if err := s.store.PlannerRepo.UpsertProfile(ctx, p); err != nil {
+		return err
+	}
+	return s.Reload(ctx)
+}
+
+func validatePlannerProfile(p model.PlannerProfile, solvers *solverRegistry) error {
+	_, registered := solvers.lookup(p.Algorithm)
+	switch {
+	case p.Name == "" || len(p.Name) > 64:
+		return fmt.Errorf("%w: name must be 1-64 characters", ErrInvalidPlannerProfile)
+	case !registered:
+		return fmt.Errorf("%w: algorithm must be one of %s", ErrInvalidPlannerProfile, solvers.describe())
+	case validatePlanEpsilon(p.Epsilon) != nil:
+		return fmt.Errorf("%w: epsilon must be in [0, 1)", ErrInvalidPlannerProfile)
+	case p.FairnessMode != fairnessNone && p.FairnessMode != fairnessAging:
+		return fmt.Errorf("%w: fairness_mode must be %q or %q", ErrInvalidPlannerProfile, fairnessNone, fairnessAging)
+	case p.AgingBoost < 0:
+		return fmt.Errorf("%w: aging_boost must not be negative", ErrInvalidPlannerProfile)
+	case p.ZeroWeightPolicy != zeroWeightOldestFirst && p.ZeroWeightPolicy != zeroWeightValueFirst:
+		return fmt.Errorf("%w: zero_weight_policy must be %q or %q", ErrInvalidPlannerProfile, zeroWeightOldestFirst, zeroWeightValueFirst)
+	case p.RolloutPercent < 0 || p.RolloutPercent > 100:
+		return fmt.Errorf("%w: rollout_percent must be between 0 and 100", ErrInvalidPlannerProfile)
+	}
+	return nil
+}
+
+// Assign はロボットにプロファイルを割り当てる。profileNameが空なら割り当てを外す
+func (s *PlannerProfileService) Assign(ctx context.Context, robotID, profileName string) error {
+	if profileName != "" && profileName != defaultPlannerProfile {
+		s.mx.RLock()
+		_, ok := s.profiles[profileName]
+		s.mx.RUnlock()
+		if !ok {
+			return ErrPlannerProfileMissing
+		}
+	}
+	if err := s.store.PlannerRepo.SetAssignment(ctx, robotID, profileName); err != nil {
+		return err
+	}
+	return s.Reload(ctx)
+}
+
+// record は計画1件の結果をプロファイルの集計に加える
+func (s *PlannerProfileService) record(profile string, plan *model.DeliveryPlan, capacity model.Grams, elapsed time.Duration, err error) {
+	s.metricsMx.Lock()
+	defer s.metricsMx.Unlock()
+	m, ok := s.metrics[profile]
+	if !ok {
+		m = &PlannerMetrics{Profile: profile}
+		s.metrics[profile] = m
+	}
+	if err != nil {
+		m.Errors++
+		return
+	}
+	m.Plans++
+	m.Orders += len(plan.Orders)
+	m.TotalValue += plan.TotalValue
+	m.TotalWeight += plan.TotalWeight
+	m.TotalCapacity += capacity
+	m.solveTime += elapsed
+}
+
+// Metrics はプロファイルごとの計画結果の累計を返す
+func (s *PlannerProfileService) Metrics() []PlannerMetrics {
+	s.metricsMx.Lock()
+	defer s.metricsMx.Unlock()
+	out := make([]PlannerMetrics, 0, len(s.metrics))
+	for _, m := range s.metrics {
+		snapshot := *m
+		if m.TotalCapacity > 0 {
+			snapshot.Utilization = float64(m.TotalWeight) / float64(m.TotalCapacity)
+		}
+		if m.Plans > 0 {
+			snapshot.AvgSolveMs = float64(m.solveTime.Microseconds()) / 1000 / float64(m.Plans)
+		}
+		out = append(out, snapshot)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Profile < out[j].Profile })
+	return out
+}
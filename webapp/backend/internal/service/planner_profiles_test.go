@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestResolvePlannerProfileCohorts(t *testing.T) {
+	defaults := planOptions{algorithm: plannerExact, fairnessMode: fairnessNone}
+	s := newPlannerProfileService(nil, defaults)
+	s.loadedAt = time.Now()
+	s.profiles = map[string]model.PlannerProfile{
+		"greedy-test": {Name: "greedy-test", Algorithm: plannerGreedy, FairnessMode: fairnessNone, RolloutPercent: 30},
+		"pinned":      {Name: "pinned", Algorithm: plannerExact, Epsilon: 0.1, FairnessMode: fairnessNone},
+	}
+	s.rollouts = []model.PlannerProfile{s.profiles["greedy-test"]}
+	s.assignments = map[string]string{"robot-pinned": "pinned"}
+
+	if name, opts := s.Resolve("robot-pinned"); name != "pinned" || opts.epsilon != 0.1 {
+		t.Fatalf("expected explicit assignment to win, got %s %+v", name, opts)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		robotID := fmt.Sprintf("robot-%d", i)
+		name, _ := s.Resolve(robotID)
+		counts[name]++
+		if again, _ := s.Resolve(robotID); again != name {
+			t.Fatalf("expected %s to stay in the same cohort", robotID)
+		}
+	}
+	if counts["greedy-test"] < 200 || counts["greedy-test"] > 400 {
+		t.Fatalf("expected roughly 30%% of robots in the rollout, got %v", counts)
+	}
+	if counts["greedy-test"]+counts[defaultPlannerProfile] != 1000 {
+		t.Fatalf("unexpected profiles resolved: %v", counts)
+	}
+}
+
+func TestSolvePlanAlgorithms(t *testing.T) {
+	// 貪欲解(1,2: 価値9)より厳密解(2,3: 価値10)が良い問題
+	items := []model.Order{
+		{OrderID: 1, Weight: 1, Value: 3},
+		{OrderID: 2, Weight: 3, Value: 6},
+		{OrderID: 3, Weight: 2, Value: 4},
+	}
+	value := func(chosen []bool) model.Points {
+		var v model.Points
+		for i, ok := range chosen {
+			if ok {
+				v += items[i].Value
+			}
+		}
+		return v
+	}
+
+	exact, _, _ := solvePlan(context.Background(), items, 5, planOptions{algorithm: plannerExact})
+	greedy, _, _ := solvePlan(context.Background(), items, 5, planOptions{algorithm: plannerGreedy})
+	loose, _, _ := solvePlan(context.Background(), items, 5, planOptions{algorithm: plannerExact, epsilon: 0.5})
+	if value(exact) != 10 || value(greedy) != 9 || value(loose) != 9 {
+		t.Fatalf("unexpected values: exact=%d greedy=%d epsilon=%d", value(exact), value(greedy), value(loose))
+	}
+}
+
+func TestAgingBoostFavorsOlderOrders(t *testing.T) {
+	now := time.Now()
+	orders := []model.Order{
+		{OrderID: 1, Weight: 5, Value: 10, CreatedAt: now},
+		{OrderID: 2, Weight: 5, Value: 8, CreatedAt: now.Add(-3 * time.Hour)},
+	}
+	opts := planOptions{algorithm: plannerExact, fairnessMode: fairnessAging, agingBoost: 1}
+
+	plan, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", 5, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Orders) != 1 || plan.Orders[0].OrderID != 2 {
+		t.Fatalf("expected the older order to be chosen, got %+v", plan.Orders)
+	}
+	if plan.TotalValue != 8 {
+		t.Fatalf("expected the plan to report the original value, got %d", plan.TotalValue)
+	}
+}
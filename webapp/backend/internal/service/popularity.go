@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/internal/repository"
+)
+
+// PopularityService は商品ごとの注文数（人気順の並び替えに使う）を定期的に集計し直す
+// 一覧の並び替えのたびに注文を集計しないよう、集計結果を商品の行に書き込んでおく
+type PopularityService struct {
+	store *repository.Store
+	every time.Duration
+	// 1回のUPDATEで書き込む商品の数の上限
+	batch int
+}
+
+func NewPopularityService(store *repository.Store) *PopularityService {
+	return &PopularityService{
+		store: store,
+		every: parseDurationEnv("PRODUCT_POPULARITY_INTERVAL", 5*time.Minute),
+		batch: parseIntEnv("PRODUCT_POPULARITY_BATCH", 500),
+	}
+}
+
+// Start は起動直後とevery間隔で注文数を集計し直すジョブを開始する
+func (s *PopularityService) Start(ctx context.Context) {
+	go func() {
+		for {
+			updated, err := s.Refresh(ctx)
+			if err != nil {
+				log.Printf("Failed to refresh product popularity after %d: %v", updated, err)
+			} else if updated > 0 {
+				log.Printf("Refreshed popularity of %d products", updated)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.every):
+			}
+		}
+	}()
+}
+
+// Refresh は注文数を集計し、値が変わった商品だけ書き込んでその数を返す
+// 書き込みはbatch件ずつ別々に行い、商品の行ロックを長く持たないようにする
+func (s *PopularityService) Refresh(ctx context.Context) (int, error) {
+	counts, err := s.store.OrderRepo.CountAllByProduct(ctx)
+	if err != nil {
+		return 0, err
+	}
+	current, err := s.store.ProductRepo.Popularities(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := make(map[int]int)
+	for productID, popularity := range current {
+		if counts[productID] != popularity {
+			changed[productID] = counts[productID]
+		}
+	}
+	ids := sortedProductIDs(changed)
+	updated := 0
+	for start := 0; start < len(ids); start += s.batch {
+		end := min(start+s.batch, len(ids))
+		batch := make(map[int]int, end-start)
+		for _, productID := range ids[start:end] {
+			batch[productID] = changed[productID]
+		}
+		if err := s.store.ProductRepo.SetPopularity(ctx, batch); err != nil {
+			return updated, err
+		}
+		updated += len(batch)
+	}
+	return updated, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/repository"
+)
+
+// popularityDB は注文テーブルごとの商品別の注文数と、商品ごとの現在の注文数を返す
+type popularityDB struct {
+	orderDB
+	counts      map[string]map[int]int // テーブル -> 商品ID -> 注文数
+	popularity  map[int]int
+	updateSizes []int
+}
+
+func (db *popularityDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	rows := db.popularity
+	column := "Popularity"
+	if strings.Contains(query, "GROUP BY") {
+		rows = db.counts[strings.Fields(query)[6]]
+		column = "Count"
+	}
+	slice := reflect.ValueOf(dest).Elem()
+	for productID, n := range rows {
+		row := reflect.New(slice.Type().Elem()).Elem()
+		row.FieldByName("ProductID").SetInt(int64(productID))
+		row.FieldByName(column).SetInt(int64(n))
+		slice.Set(reflect.Append(slice, row))
+	}
+	return nil
+}
+
+func (db *popularityDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	n := len(args) / 3
+	db.updateSizes = append(db.updateSizes, n)
+	for i := 0; i < n; i++ {
+		db.popularity[args[2*i].(int)] = args[2*i+1].(int)
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func TestPopularityRefresh(t *testing.T) {
+	db := &popularityDB{
+		counts: map[string]map[int]int{
+			"orders":         {1: 3, 2: 1},
+			"orders_archive": {1: 2, 3: 4},
+		},
+		popularity: map[int]int{1: 5, 2: 0, 3: 1, 4: 2},
+	}
+	svc := &PopularityService{store: repository.NewStore(db), batch: 2}
+
+	updated, err := svc.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated != 3 || !reflect.DeepEqual(db.updateSizes, []int{2, 1}) {
+		t.Fatalf("expected 3 products updated in batches of 2, got %d %v", updated, db.updateSizes)
+	}
+	if want := map[int]int{1: 5, 2: 1, 3: 4, 4: 0}; !reflect.DeepEqual(db.popularity, want) {
+		t.Fatalf("expected popularity %v, got %v", want, db.popularity)
+	}
+}
@@ -1,45 +1,196 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
 
 	"backend/internal/model"
 	"backend/internal/repository"
 )
 
 type ProductService struct {
-	store *repository.Store
+	store  *repository.Store
+	events *OrderEventBus
+	// 同じ商品の組の注文をこの時間内に繰り返すと、前回の注文IDを返す（ORDER_DEDUPE_WINDOWが未設定なら判定しない）
+	dedupe *orderDeduper
+	// 注文の作成で在庫を減らす
+	inventory *InventoryService
+	// 商品一覧のページ。管理APIで商品を変更したら破棄する
+	lists *productListCache
+	// 負荷試験向けに注文数に比例して商品を抽出する。1回に抽出できる数はmaxSampleSizeまで
+	sampler       *productSampler
+	maxSampleSize int
+	// 管理APIとCSVで登録する商品の検証
+	validator *ProductValidator
+	// CSVで一括登録できる商品の行数とファイルサイズの上限
+	maxImportRows  int
+	maxImportBytes int64
 }
 
-func NewProductService(store *repository.Store) *ProductService {
-	return &ProductService{store: store}
+func NewProductService(store *repository.Store, events *OrderEventBus) *ProductService {
+	lists := newProductListCache(
+		parseIntEnv("PRODUCT_LIST_CACHE_MAX_ENTRIES", 1000),
+		parseDurationEnv("PRODUCT_LIST_CACHE_TTL", 30*time.Second),
+	)
+	return &ProductService{
+		store:          store,
+		events:         events,
+		dedupe:         newOrderDeduper(parseDurationEnv("ORDER_DEDUPE_WINDOW", 0)),
+		inventory:      NewInventoryService(),
+		lists:          lists,
+		sampler:        newProductSampler(parseDurationEnv("PRODUCT_SAMPLE_TTL", time.Minute)),
+		maxSampleSize:  parseIntEnv("PRODUCT_SAMPLE_MAX", 1000),
+		validator:      NewProductValidator(),
+		maxImportRows:  parseIntEnv("PRODUCT_IMPORT_MAX_ROWS", 100000),
+		maxImportBytes: int64(parseIntEnv("PRODUCT_IMPORT_MAX_BYTES", 32<<20)),
+	}
 }
 
+var (
+	ErrInvalidOrderPriority  = errors.New("invalid order priority")
+	ErrInvalidOrderDeliverBy = errors.New("invalid order deliver_by")
+	ErrInvalidOrderProduct   = errors.New("invalid order product")
+	ErrInvalidOrderMetadata  = errors.New("invalid order metadata")
+	ErrInvalidOrderShipAfter = errors.New("invalid order ship_after")
+)
+
+// 注文に付けられるメタデータの上限（空白を除いたJSONのバイト数）
+const maxOrderMetadataBytes = 4096
+
+// CreateOrders はユーザーの注文を作成し、作成した注文IDを返す
+// 未来の配送開始日時を指定した注文は予約中（scheduled）で作成し、その日時まで配送しない
+// 重複の判定が有効な場合、同じ商品の組を直前に注文していれば新たに作成せず前回の注文IDを返す
 func (s *ProductService) CreateOrders(ctx context.Context, userID int, items []model.RequestItem) ([]string, error) {
-	var insertedOrderIDs []string
+	now := time.Now()
+	for i, item := range items {
+		if item.Priority < model.OrderPriorityNormal || item.Priority > model.OrderPriorityUrgent {
+			return nil, fmt.Errorf("%w: priority must be between %d and %d", ErrInvalidOrderPriority, model.OrderPriorityNormal, model.OrderPriorityUrgent)
+		}
+		if item.DeliverBy != nil && !item.DeliverBy.After(now) {
+			return nil, fmt.Errorf("%w: deliver_by must be in the future", ErrInvalidOrderDeliverBy)
+		}
+		if item.ShipAfter == nil {
+			continue
+		}
+		// 過ぎた日時はすぐに配送してよいという指定として扱う
+		if !item.ShipAfter.After(now) {
+			items[i].ShipAfter = nil
+			continue
+		}
+		if item.DeliverBy != nil && !item.ShipAfter.Before(*item.DeliverBy) {
+			return nil, fmt.Errorf("%w: ship_after must be before deliver_by", ErrInvalidOrderShipAfter)
+		}
+	}
+	for i := range items {
+		metadata, err := normalizeOrderMetadata(items[i].Metadata)
+		if err != nil {
+			return nil, err
+		}
+		items[i].Metadata = metadata
+	}
+	if err := s.validateOrderProducts(ctx, items); err != nil {
+		return nil, err
+	}
+
+	orderIDs, duplicate, err := s.dedupe.do(ctx, userID, items, func() ([]string, error) {
+		return s.createOrders(ctx, userID, items)
+	})
+	if duplicate {
+		log.Printf("Returned %d existing orders for a duplicate request from user %d", len(orderIDs), userID)
+	}
+	return orderIDs, err
+}
+
+func (s *ProductService) createOrders(ctx context.Context, userID int, items []model.RequestItem) ([]string, error) {
+	var (
+		insertedOrderIDs []string
+		createdIDs       []int64
+	)
 
 	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-		itemsToProcess := make(map[int]int)
+		// 同じ商品でも優先度や配送期限、配送開始日時、メタデータが異なれば別の注文として扱う
+		type orderKey struct {
+			productID int
+			priority  int
+			deliverBy int64
+			shipAfter int64
+			metadata  string
+		}
+		itemsToProcess := make(map[orderKey]int)
+		deliverBy := make(map[orderKey]time.Time)
+		shipAfter := make(map[orderKey]time.Time)
 		for _, item := range items {
-			if item.Quantity > 0 {
-				itemsToProcess[item.ProductID] = item.Quantity
+			if item.Quantity <= 0 {
+				continue
 			}
+			key := orderKey{productID: item.ProductID, priority: item.Priority, metadata: string(item.Metadata)}
+			if item.DeliverBy != nil {
+				key.deliverBy = item.DeliverBy.UnixNano()
+				deliverBy[key] = *item.DeliverBy
+			}
+			if item.ShipAfter != nil {
+				key.shipAfter = item.ShipAfter.UnixNano()
+				shipAfter[key] = *item.ShipAfter
+			}
+			itemsToProcess[key] = item.Quantity
 		}
 		if len(itemsToProcess) == 0 {
 			return nil
 		}
+		quantities := make(map[int]int)
+		for key, quantity := range itemsToProcess {
+			quantities[key.productID] += quantity
+		}
+		if err := s.inventory.Reserve(ctx, txStore, quantities); err != nil {
+			return err
+		}
 
-		for pID, quantity := range itemsToProcess {
+		var statuses []string
+		byStatus := make(map[string][]int64)
+		for key, quantity := range itemsToProcess {
 			for i := 0; i < quantity; i++ {
 				order := &model.Order{
-					UserID:    userID,
-					ProductID: pID,
+					UserID:        userID,
+					ProductID:     key.productID,
+					Priority:      key.priority,
+					ShippedStatus: "shipping",
+				}
+				if key.metadata != "" {
+					order.Metadata = model.OrderMetadata(key.metadata)
+				}
+				if t, ok := deliverBy[key]; ok {
+					order.DeliverBy = sql.NullTime{Time: t, Valid: true}
+				}
+				if t, ok := shipAfter[key]; ok {
+					order.ShipAfter = sql.NullTime{Time: t, Valid: true}
+					order.ShippedStatus = "scheduled"
 				}
 				orderID, err := txStore.OrderRepo.Create(ctx, order)
 				if err != nil {
 					return err
 				}
 				insertedOrderIDs = append(insertedOrderIDs, orderID)
+				id, err := strconv.ParseInt(orderID, 10, 64)
+				if err != nil {
+					return err
+				}
+				createdIDs = append(createdIDs, id)
+				if _, ok := byStatus[order.ShippedStatus]; !ok {
+					statuses = append(statuses, order.ShippedStatus)
+				}
+				byStatus[order.ShippedStatus] = append(byStatus[order.ShippedStatus], id)
+			}
+		}
+		for _, status := range statuses {
+			if err := txStore.OrderEventRepo.Append(ctx, byStatus[status], model.OrderEventCreated, status, model.OrderEventActorUser); err != nil {
+				return err
 			}
 		}
 		return nil
@@ -48,10 +199,66 @@ func (s *ProductService) CreateOrders(ctx context.Context, userID int, items []m
 	if err != nil {
 		return nil, err
 	}
+	s.events.PublishCreated(userID, createdIDs)
 	return insertedOrderIDs, nil
 }
 
-func (s *ProductService) FetchProducts(ctx context.Context, userID int, req model.ListRequest) ([]model.Product, int, error) {
-	products, total, err := s.store.ProductRepo.ListProducts(ctx, userID, req)
-	return products, total, err
+// normalizeOrderMetadata は注文のメタデータがJSONオブジェクトで上限以内か検証し、空白を除いて返す
+func normalizeOrderMetadata(metadata model.OrderMetadata) (model.OrderMetadata, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, metadata); err != nil {
+		return nil, fmt.Errorf("%w: metadata must be valid JSON", ErrInvalidOrderMetadata)
+	}
+	if buf.Len() == 0 || buf.Bytes()[0] != '{' {
+		return nil, fmt.Errorf("%w: metadata must be a JSON object", ErrInvalidOrderMetadata)
+	}
+	if buf.Len() > maxOrderMetadataBytes {
+		return nil, fmt.Errorf("%w: metadata must be at most %d bytes", ErrInvalidOrderMetadata, maxOrderMetadataBytes)
+	}
+	return model.OrderMetadata(buf.Bytes()), nil
+}
+
+// validateOrderProducts は存在しない商品・削除した商品と、重さが0の商品の注文を拒否する
+// 重さ0の注文は積載量を使わずに計画へ積まれ続けるため、計画が際限なく大きくなるのを防ぐ
+func (s *ProductService) validateOrderProducts(ctx context.Context, items []model.RequestItem) error {
+	productIDs := make([]int, 0, len(items))
+	for _, item := range items {
+		if item.Quantity > 0 {
+			productIDs = append(productIDs, item.ProductID)
+		}
+	}
+	weights, err := s.store.ProductRepo.WeightsByID(ctx, productIDs)
+	if err != nil {
+		return err
+	}
+	for _, id := range productIDs {
+		weight, ok := weights[id]
+		if !ok {
+			return fmt.Errorf("%w: product %d is not available", ErrInvalidOrderProduct, id)
+		}
+		if weight <= 0 {
+			return fmt.Errorf("%w: product %d has no weight", ErrInvalidOrderProduct, id)
+		}
+	}
+	return nil
+}
+
+// FetchProducts は商品一覧を返す。期限が迫って途中で打ち切った場合はpartialがtrueになる
+// 一覧のページはキャッシュから返し、なければ読み込んで保存する。途中で打ち切った結果は保存しない
+func (s *ProductService) FetchProducts(ctx context.Context, userID int, req model.ListRequest) (products []model.Product, total int, partial bool, err error) {
+	if err := expandCategory(ctx, s.store, &req); err != nil {
+		return nil, 0, false, err
+	}
+	if products, total, ok := s.lists.get(userID, req); ok {
+		return products, total, false, nil
+	}
+	generation := s.lists.begin()
+	products, total, partial, err = s.store.ProductRepo.ListProducts(ctx, userID, req)
+	if err == nil && !partial {
+		s.lists.put(userID, req, generation, products, total)
+	}
+	return products, total, partial, err
 }
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+var (
+	ErrInvalidProduct  = errors.New("invalid product")
+	ErrProductNotFound = errors.New("product not found")
+)
+
+// CreateProduct は商品を検証して追加し、採番した商品IDを設定した商品を返す
+func (s *ProductService) CreateProduct(ctx context.Context, product model.Product) (*model.Product, error) {
+	if err := s.validator.Validate(&product); err != nil {
+		return nil, err
+	}
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		if err := txStore.ProductRepo.Create(ctx, &product); err != nil {
+			return err
+		}
+		return txStore.ProductRepo.RecordValueChanges(ctx, []int{product.ProductID}, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.lists.invalidate()
+	return &product, nil
+}
+
+// UpdateProduct は商品のすべての項目を置き換え、価値が変わればその履歴を残す
+// 配送待ちの注文の重さも変わるため、すでに選定済みの配送計画は商品の変更前の値のまま進む
+// 注文の価値は注文時点のものを使うため、価値を変えても作成済みの注文には影響しない
+func (s *ProductService) UpdateProduct(ctx context.Context, product model.Product) (*model.Product, error) {
+	if err := s.validator.Validate(&product); err != nil {
+		return nil, err
+	}
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		current, err := lockProduct(ctx, txStore, product.ProductID)
+		if err != nil {
+			return err
+		}
+		if err := txStore.ProductRepo.Update(ctx, product); err != nil {
+			return err
+		}
+		if current.Value == product.Value {
+			return nil
+		}
+		return txStore.ProductRepo.RecordValueChanges(ctx, []int{product.ProductID}, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.lists.invalidate()
+	return &product, nil
+}
+
+// DeleteProduct は商品を論理削除し、一覧や新しい注文の対象から外す。削除済みの商品なら何もしない
+// 行は残すため、過去の注文や削除と同時に受け付けた注文は削除した商品もそのまま参照できる
+func (s *ProductService) DeleteProduct(ctx context.Context, productID int) error {
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		product, err := lockProduct(ctx, txStore, productID)
+		if err != nil || product.DeletedAt != nil {
+			return err
+		}
+		return txStore.ProductRepo.SoftDelete(ctx, productID, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	s.lists.invalidate()
+	return nil
+}
+
+// RestoreProduct は削除した商品を元に戻し、戻した商品を返す。削除していない商品ならそのまま返す
+func (s *ProductService) RestoreProduct(ctx context.Context, productID int) (*model.Product, error) {
+	var product model.Product
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		var err error
+		product, err = lockProduct(ctx, txStore, productID)
+		if err != nil || product.DeletedAt == nil {
+			return err
+		}
+		product.DeletedAt = nil
+		return txStore.ProductRepo.Restore(ctx, productID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.lists.invalidate()
+	return &product, nil
+}
+
+// GetProductDetail は商品とそのタグ、その商品の注文数・平均の配送時間を返す
+func (s *ProductService) GetProductDetail(ctx context.Context, productID int) (*model.ProductDetail, error) {
+	product, err := s.store.ProductRepo.GetByID(ctx, productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.store.OrderRepo.ProductStats(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.store.TagRepo.ForProducts(ctx, []int{productID})
+	if err != nil {
+		return nil, err
+	}
+	product.Tags = tags[productID]
+	detail := &model.ProductDetail{Product: product, TimesOrdered: stats.Orders}
+	if stats.Delivered > 0 {
+		avg := float64(stats.DeliverySeconds) / float64(stats.Delivered)
+		detail.AverageDeliverySeconds = &avg
+	}
+	return detail, nil
+}
+
+// ValueHistory は商品の価値の履歴を古い順に返す
+func (s *ProductService) ValueHistory(ctx context.Context, productID int) ([]model.ProductValueChange, error) {
+	history, err := s.store.ProductRepo.ValueHistory(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	// 商品の追加時に必ず履歴を残すため、履歴がなければ商品も存在しない
+	if len(history) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+	}
+	return history, nil
+}
+
+func lockProduct(ctx context.Context, txStore *repository.Store, productID int) (model.Product, error) {
+	product, err := txStore.ProductRepo.LockByID(ctx, productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return product, fmt.Errorf("%w: %d", ErrProductNotFound, productID)
+	}
+	return product, err
+}
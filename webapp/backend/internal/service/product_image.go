@@ -0,0 +1,185 @@
+package service
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"backend/internal/repository"
+)
+
+var (
+	ErrProductImageNotFound = errors.New("product image not found")
+	ErrInvalidImageSize     = errors.New("invalid image size")
+)
+
+// ProductImage は配信する画像のバイト列とヘッダーに使う値
+type ProductImage struct {
+	Data        []byte
+	ContentType string
+	ETag        string
+}
+
+// ProductImageService は商品画像を指定サイズに縮小して返す
+// 縮小した画像はメモリ上のLRUに合計PRODUCT_IMAGE_CACHE_BYTESまで保持する
+type ProductImageService struct {
+	store    *repository.Store
+	imageDir string
+	maxSide  int
+	cache    *imageCache
+}
+
+func NewProductImageService(store *repository.Store) *ProductImageService {
+	imageDir := os.Getenv("IMAGE_DIR")
+	if imageDir == "" {
+		imageDir = "/app/images"
+	}
+	return &ProductImageService{
+		store:    store,
+		imageDir: imageDir,
+		maxSide:  parseIntEnv("PRODUCT_IMAGE_MAX_SIDE", 2048),
+		cache:    newImageCache(parseIntEnv("PRODUCT_IMAGE_CACHE_BYTES", 64<<20)),
+	}
+}
+
+// Get は商品画像を縦横比を保ったままw×hに収まるよう縮小して返す
+// w・hが0ならその辺は制限せず、どちらも0なら原寸のまま返す
+func (s *ProductImageService) Get(ctx context.Context, productID, w, h int) (*ProductImage, error) {
+	if w < 0 || h < 0 || w > s.maxSide || h > s.maxSide {
+		return nil, fmt.Errorf("%w: w and h must be between 0 and %d", ErrInvalidImageSize, s.maxSide)
+	}
+	imagePath, err := s.store.ProductRepo.ImageByID(ctx, productID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && imagePath == "") {
+		return nil, ErrProductImageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rel := filepath.Clean(imagePath)
+	if filepath.IsAbs(rel) || strings.Contains(rel, "..") {
+		return nil, ErrProductImageNotFound
+	}
+	fullPath := filepath.Join(s.imageDir, rel)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, ErrProductImageNotFound
+	}
+
+	// 元画像の更新時刻とサイズをキーに含め、画像を差し替えたら別のエントリにする
+	key := fmt.Sprintf("%s:%d:%d:%dx%d", rel, info.ModTime().UnixNano(), info.Size(), w, h)
+	if img, ok := s.cache.get(key); ok {
+		return img, nil
+	}
+
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	// デコードできない形式（webpなど）は縮小せずに返す
+	if (w > 0 || h > 0) && isThumbnailSource(rel) {
+		if data, err = resizeImageData(data, w, h, rel); err != nil {
+			return nil, err
+		}
+	}
+	img := &ProductImage{Data: data, ContentType: productImageContentType(rel), ETag: imageETag(data)}
+	s.cache.add(key, img)
+	return img, nil
+}
+
+// resizeImageData は画像をw×hに収まるよう縮小して書き出す。縮小が不要なら元のバイト列を返す
+func resizeImageData(data []byte, w, h int, path string) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	if w == 0 {
+		w = b.Dx()
+	}
+	if h == 0 {
+		h = b.Dy()
+	}
+	resized := fitImage(src, w, h)
+	if resized == src {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, resized, path); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func productImageContentType(path string) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path))); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// imageETag は内容から強いETagを作る
+func imageETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// imageCache は画像を合計maxBytesまで保持するLRU
+type imageCache struct {
+	mx       sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List // 先頭ほど最近使った
+	entries  map[string]*list.Element
+}
+
+type imageCacheEntry struct {
+	key   string
+	image *ProductImage
+}
+
+func newImageCache(maxBytes int) *imageCache {
+	return &imageCache{maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *imageCache) get(key string) (*ProductImage, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*imageCacheEntry).image, true
+}
+
+// add は画像を保持し、上限を超えた分を古い順に捨てる。上限より大きい画像は保持しない
+func (c *imageCache) add(key string, img *ProductImage) {
+	if len(img.Data) > c.maxBytes {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&imageCacheEntry{key: key, image: img})
+	c.size += len(img.Data)
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*imageCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= len(entry.image.Data)
+	}
+}
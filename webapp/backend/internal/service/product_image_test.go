@@ -0,0 +1,97 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"backend/internal/repository"
+)
+
+// imageDB は商品IDごとの画像パスを返す
+type imageDB struct {
+	orderDB
+	images map[int]string
+}
+
+func (db *imageDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	image, ok := db.images[args[0].(int)]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*dest.(*string) = image
+	return nil
+}
+
+func TestProductImageGet(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "chair.png"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	svc := &ProductImageService{
+		store:    repository.NewStore(&imageDB{images: map[int]string{1: "chair.png", 2: "../secret.png"}}),
+		imageDir: dir,
+		maxSide:  1000,
+		cache:    newImageCache(1 << 20),
+	}
+
+	img, err := svc.Get(context.Background(), 1, 100, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatalf("failed to decode the resized image: %v", err)
+	}
+	if got := decoded.Bounds().Size(); got != image.Pt(100, 50) {
+		t.Fatalf("expected 100x50, got %v", got)
+	}
+	if img.ContentType != "image/png" || img.ETag == "" {
+		t.Fatalf("unexpected headers: %q %q", img.ContentType, img.ETag)
+	}
+	if cached, _ := svc.Get(context.Background(), 1, 100, 100); cached != img {
+		t.Fatal("expected the resized image to be cached")
+	}
+
+	original, err := svc.Get(context.Background(), 1, 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(original.Data, buf.Bytes()) || original.ETag == img.ETag {
+		t.Fatal("expected the original image with its own ETag")
+	}
+
+	for _, productID := range []int{2, 3} {
+		if _, err := svc.Get(context.Background(), productID, 100, 100); !errors.Is(err, ErrProductImageNotFound) {
+			t.Fatalf("product %d: expected ErrProductImageNotFound, got %v", productID, err)
+		}
+	}
+	if _, err := svc.Get(context.Background(), 1, 1001, 0); !errors.Is(err, ErrInvalidImageSize) {
+		t.Fatalf("expected ErrInvalidImageSize, got %v", err)
+	}
+}
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newImageCache(10)
+	cache.add("a", &ProductImage{Data: make([]byte, 4)})
+	cache.add("b", &ProductImage{Data: make([]byte, 4)})
+	cache.get("a")
+	cache.add("c", &ProductImage{Data: make([]byte, 4)})
+	cache.add("huge", &ProductImage{Data: make([]byte, 11)})
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "huge": false} {
+		if _, ok := cache.get(key); ok != want {
+			t.Fatalf("%s: expected cached=%v", key, want)
+		}
+	}
+}
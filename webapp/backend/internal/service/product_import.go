@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+var ErrInvalidProductImport = errors.New("invalid product import")
+
+// productImportBatchSize は1回のINSERTで登録する商品の数
+const productImportBatchSize = 1000
+
+// productImportColumns はCSVのヘッダーに使える列。name・value・weightは必須
+var productImportColumns = map[string]bool{
+	"product_id": false, "name": true, "value": true, "weight": true,
+	"volume": false, "image": false, "description": false, "stock": false, "low_stock_threshold": false,
+}
+
+// ImportMaxBytes はCSVファイルの大きさの上限を返す
+func (s *ProductService) ImportMaxBytes() int64 {
+	return s.maxImportBytes
+}
+
+// ImportProducts はヘッダー付きのCSVから商品を一括で登録する
+// product_idを指定した行は同じIDの商品を置き換え、指定しない行は新しく追加する。
+// 不正な行が1つでもあれば何も登録せず、その行をすべて返す。登録は1つのトランザクションで行う。
+func (s *ProductService) ImportProducts(ctx context.Context, r io.Reader) (*model.ProductImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the CSV is empty", ErrInvalidProductImport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProductImport, err)
+	}
+	columns, err := productImportHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.ProductImportResult{Errors: []model.ProductImportRowError{}}
+	var products []model.Product
+	seen := make(map[int]int) // 商品ID -> 最初に指定した行
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			// 引用符の不整合などは以降の行の区切りも信用できないため、そこで読み込みをやめる
+			result.Errors = append(result.Errors, model.ProductImportRowError{Row: parseErr.StartLine, Error: parseErr.Err.Error()})
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if len(products)+len(result.Errors) >= s.maxImportRows {
+			return nil, fmt.Errorf("%w: the CSV must contain at most %d rows", ErrInvalidProductImport, s.maxImportRows)
+		}
+		product, err := s.parseImportRow(columns, record)
+		if err == nil && product.ProductID != 0 {
+			if first, ok := seen[product.ProductID]; ok {
+				err = fmt.Errorf("product_id %d is already used in row %d", product.ProductID, first)
+			}
+			seen[product.ProductID] = line
+		}
+		if err != nil {
+			rowErr := model.ProductImportRowError{Row: line, Error: err.Error()}
+			var invalid *ProductValidationError
+			if errors.As(err, &invalid) {
+				rowErr.Fields = invalid.Fields
+			}
+			result.Errors = append(result.Errors, rowErr)
+			continue
+		}
+		products = append(products, product)
+	}
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+	if len(products) == 0 {
+		return nil, fmt.Errorf("%w: the CSV has no rows", ErrInvalidProductImport)
+	}
+
+	err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		for start := 0; start < len(products); start += productImportBatchSize {
+			end := min(start+productImportBatchSize, len(products))
+			if err := txStore.ProductRepo.Upsert(ctx, products[start:end]); err != nil {
+				return err
+			}
+		}
+		// 追加した商品の商品IDはわからないため、全商品の価値を履歴と突き合わせる
+		return txStore.ProductRepo.RecordValueChanges(ctx, nil, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.lists.invalidate()
+	result.Imported = len(products)
+	return result, nil
+}
+
+// productImportHeader はヘッダーの列名ごとの位置を返す
+func productImportHeader(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := productImportColumns[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidProductImport, name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w: duplicate column %q", ErrInvalidProductImport, name)
+		}
+		columns[name] = i
+	}
+	for name, required := range productImportColumns {
+		if _, ok := columns[name]; required && !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrInvalidProductImport, name)
+		}
+	}
+	return columns, nil
+}
+
+// parseImportRow はCSVの1行を商品にして検証する。空の列は0・空文字として扱う
+func (s *ProductService) parseImportRow(columns map[string]int, record []string) (model.Product, error) {
+	var product model.Product
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"product_id", &product.ProductID},
+		{"value", (*int)(&product.Value)},
+		{"weight", (*int)(&product.Weight)},
+		{"volume", (*int)(&product.Volume)},
+		{"stock", &product.Stock},
+		{"low_stock_threshold", &product.LowStockThreshold},
+	}
+	for _, col := range ints {
+		raw := field(col.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return product, fmt.Errorf("%s must be an integer", col.name)
+		}
+		*col.dest = n
+	}
+	if product.ProductID < 0 {
+		return product, errors.New("product_id must not be negative")
+	}
+	product.Name = field("name")
+	product.Image = field("image")
+	if i, ok := columns["description"]; ok && i < len(record) {
+		product.Description = record[i]
+	}
+	return product, s.validator.Validate(&product)
+}
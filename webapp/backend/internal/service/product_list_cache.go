@@ -0,0 +1,147 @@
+package service
+
+import (
+	"backend/internal/model"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// productListCache は商品一覧のページを、利用者と絞り込み・並び順・ページの組ごとに短い時間だけ保持する
+// 商品はめったに変わらないため、ページを表示するたびにSQLを発行しないために使う
+// ページには利用者ごとのお気に入りかどうかを含むため、利用者の間では共有しない
+//
+// 管理APIで商品を変更したときはすべてのエントリを、お気に入りを変更したときはその利用者のエントリを破棄する。
+// 注文による在庫数・注文数（人気順）の変化では破棄せず、ttlの間だけ古いことがある。
+type productListCache struct {
+	mx         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	entries map[productListKey]productListPage
+	// 読み込み中に商品が変更されたか判定するための世代。破棄するたびに進める
+	generation uint64
+}
+
+// productListKey は商品一覧の結果に影響する項目。カテゴリは子孫に展開した後のもの
+type productListKey struct {
+	userID     int
+	search     string
+	searchType string
+	sort       string
+	offset     int
+	pageSize   int
+	categories string
+	columns    string
+	after      model.ListKeyset
+	favorites  bool
+	tags       string
+}
+
+type productListPage struct {
+	products []model.Product
+	total    int
+	loadedAt time.Time
+}
+
+func newProductListCache(maxEntries int, ttl time.Duration) *productListCache {
+	return &productListCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[productListKey]productListPage),
+	}
+}
+
+func productListKeyFor(userID int, req model.ListRequest) productListKey {
+	sort := req.SortField + " " + req.SortOrder
+	if len(req.Sort) > 0 {
+		sort = fmt.Sprint(req.Sort)
+	}
+	// キーセットのカーソルがなければゼロ値。ID 0の商品はないため、カーソルの位置と区別できる
+	var after model.ListKeyset
+	if req.After != nil {
+		after = *req.After
+	}
+	return productListKey{
+		userID:     userID,
+		search:     req.Search,
+		searchType: req.Type,
+		sort:       sort,
+		offset:     req.Offset,
+		pageSize:   req.PageSize,
+		categories: fmt.Sprint(req.CategoryIDs),
+		columns:    strings.Join(req.Columns, ","),
+		after:      after,
+		favorites:  req.FavoritesOnly,
+		tags:       strings.Join(req.Tags, ","),
+	}
+}
+
+// get は保持しているページのコピーと総件数を返す。ttlが0以下なら常に保持していない
+func (c *productListCache) get(userID int, req model.ListRequest) ([]model.Product, int, bool) {
+	if c.ttl <= 0 {
+		return nil, 0, false
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	key := productListKeyFor(userID, req)
+	page, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	if c.now().Sub(page.loadedAt) >= c.ttl {
+		delete(c.entries, key)
+		return nil, 0, false
+	}
+	return append([]model.Product(nil), page.products...), page.total, true
+}
+
+// begin はページの読み込みの前に呼び、putに渡す世代を返す
+func (c *productListCache) begin() uint64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.generation
+}
+
+// put は読み込んだページを保存する。beginの後に破棄されていれば保存しない
+func (c *productListCache) put(userID int, req model.ListRequest, generation uint64, products []model.Product, total int) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.generation != generation {
+		return
+	}
+	key := productListKeyFor(userID, req)
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		for evict := range c.entries {
+			delete(c.entries, evict)
+			break
+		}
+	}
+	c.entries[key] = productListPage{products: append([]model.Product(nil), products...), total: total, loadedAt: c.now()}
+}
+
+// invalidate は商品の変更後に呼び、すべてのエントリを破棄する
+func (c *productListCache) invalidate() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.generation++
+	c.entries = make(map[productListKey]productListPage)
+}
+
+// invalidateUser は利用者のお気に入りの変更後に呼び、その利用者のエントリを破棄する
+func (c *productListCache) invalidateUser(userID int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.generation++
+	for key := range c.entries {
+		if key.userID == userID {
+			delete(c.entries, key)
+		}
+	}
+}
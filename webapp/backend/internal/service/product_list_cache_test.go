@@ -0,0 +1,78 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestProductListCache(t *testing.T) {
+	now := time.Now()
+	c := newProductListCache(2, time.Second)
+	c.now = func() time.Time { return now }
+
+	req := model.ListRequest{Search: "chair", SortField: "name", SortOrder: "ASC", PageSize: 20}
+	c.put(1, req, c.begin(), []model.Product{{ProductID: 1}}, 1)
+
+	products, total, ok := c.get(1, req)
+	if !ok || total != 1 || len(products) != 1 || products[0].ProductID != 1 {
+		t.Fatalf("get = %v, %d, %v; want the stored page", products, total, ok)
+	}
+	products[0].Name = "modified"
+	if again, _, _ := c.get(1, req); again[0].Name != "" {
+		t.Fatal("expected get to return a copy")
+	}
+
+	page2 := req
+	page2.Offset = 20
+	sorted := req
+	sorted.SortOrder = "DESC"
+	for _, other := range []model.ListRequest{page2, sorted} {
+		if _, _, ok := c.get(1, other); ok {
+			t.Fatalf("expected %+v to miss", other)
+		}
+	}
+	if _, _, ok := c.get(2, req); ok {
+		t.Fatal("expected another user's request to miss")
+	}
+
+	now = now.Add(time.Second)
+	if _, _, ok := c.get(1, req); ok {
+		t.Fatal("expected the page to expire after the ttl")
+	}
+}
+
+func TestProductListCacheInvalidate(t *testing.T) {
+	c := newProductListCache(10, time.Minute)
+	req := model.ListRequest{PageSize: 20}
+
+	c.put(1, req, c.begin(), []model.Product{{ProductID: 1}}, 1)
+	c.invalidate()
+	if _, _, ok := c.get(1, req); ok {
+		t.Fatal("expected the page to be dropped when products change")
+	}
+
+	// 読み込み中に商品が変更された場合は、古いページを保存しない
+	generation := c.begin()
+	c.invalidate()
+	c.put(1, req, generation, []model.Product{{ProductID: 1}}, 1)
+	if _, _, ok := c.get(1, req); ok {
+		t.Fatal("expected a page loaded before the change not to be stored")
+	}
+}
+
+func TestProductListCacheInvalidateUser(t *testing.T) {
+	c := newProductListCache(10, time.Minute)
+	req := model.ListRequest{PageSize: 20}
+
+	c.put(1, req, c.begin(), []model.Product{{ProductID: 1, IsFavorite: true}}, 1)
+	c.put(2, req, c.begin(), []model.Product{{ProductID: 1}}, 1)
+	c.invalidateUser(1)
+	if _, _, ok := c.get(1, req); ok {
+		t.Fatal("expected the user's pages to be dropped when the favorites change")
+	}
+	if _, _, ok := c.get(2, req); !ok {
+		t.Fatal("expected other users' pages to be kept")
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+var ErrInvalidSampleRequest = errors.New("invalid sample request")
+
+// 指定がない場合に抽出する商品の数
+const defaultSampleSize = 10
+
+// productSampler は商品を注文数（popularity）に比例した確率で抽出する
+// 抽出のたびに全商品を読まないよう、エイリアス表を作ってttlの間だけ使い回す
+// 注文数は定期的に集計し直す値のため、ttlの間の古さは抽出の偏りにほとんど影響しない
+type productSampler struct {
+	mx       sync.Mutex
+	ttl      time.Duration
+	now      func() time.Time
+	table    *aliasTable
+	loadedAt time.Time
+}
+
+func newProductSampler(ttl time.Duration) *productSampler {
+	return &productSampler{ttl: ttl, now: time.Now}
+}
+
+// aliasTable はWalkerのエイリアス法の表。1回の抽出を一様乱数2つで行う
+type aliasTable struct {
+	ids   []int
+	prob  []float64
+	alias []int
+}
+
+// newAliasTable は重みに比例した確率でidsを抽出する表を作る。重みの合計が0ならすべて同じ確率にする
+func newAliasTable(ids []int, weights []int) *aliasTable {
+	n := len(ids)
+	t := &aliasTable{ids: ids, prob: make([]float64, n), alias: make([]int, n)}
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	scaled := make([]float64, n)
+	for i, w := range weights {
+		if total == 0 {
+			scaled[i] = 1
+		} else {
+			scaled[i] = float64(w) * float64(n) / float64(total)
+		}
+	}
+	var small, large []int
+	for i, p := range scaled {
+		if p < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		s, l := small[len(small)-1], large[len(large)-1]
+		small = small[:len(small)-1]
+		t.prob[s], t.alias[s] = scaled[s], l
+		scaled[l] -= 1 - scaled[s]
+		if scaled[l] < 1 {
+			large = large[:len(large)-1]
+			small = append(small, l)
+		}
+	}
+	// 丸め誤差で残った列は、その列の商品を必ず選ぶ
+	for _, i := range append(small, large...) {
+		t.prob[i] = 1
+	}
+	return t
+}
+
+// pick は列iと[0, 1)の乱数uから商品IDを選ぶ
+func (t *aliasTable) pick(i int, u float64) int {
+	if u < t.prob[i] {
+		return t.ids[i]
+	}
+	return t.ids[t.alias[i]]
+}
+
+func (t *aliasTable) sample() int {
+	return t.pick(rand.IntN(len(t.ids)), rand.Float64())
+}
+
+// SampleProducts は削除していない商品から、注文数に比例した確率でn件を重複ありで抽出する
+// 負荷試験のツールが実際に近い組み合わせの注文を作るために使う
+// 表を作った後に削除した商品は返さないため、n件に満たないことがある
+func (s *ProductService) SampleProducts(ctx context.Context, n int) ([]model.Product, error) {
+	if n == 0 {
+		n = defaultSampleSize
+	}
+	if n < 0 || n > s.maxSampleSize {
+		return nil, fmt.Errorf("%w: n must be between 1 and %d", ErrInvalidSampleRequest, s.maxSampleSize)
+	}
+	table, err := s.sampler.load(ctx, s.store)
+	if err != nil {
+		return nil, err
+	}
+	if len(table.ids) == 0 {
+		return []model.Product{}, nil
+	}
+	sampled := make([]int, n)
+	unique := make(map[int]bool)
+	for i := range sampled {
+		sampled[i] = table.sample()
+		unique[sampled[i]] = true
+	}
+	products, err := s.store.ProductRepo.GetByIDs(ctx, sortedKeys(unique))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]model.Product, len(products))
+	for _, p := range products {
+		byID[p.ProductID] = p
+	}
+	result := make([]model.Product, 0, n)
+	for _, id := range sampled {
+		if p, ok := byID[id]; ok {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// load は作成からttlが過ぎていなければ保持している表を、過ぎていれば作り直した表を返す
+// 作り直しは同時に1つだけ行い、その間の抽出は作り直しを待つ
+func (p *productSampler) load(ctx context.Context, store *repository.Store) (*aliasTable, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.table != nil && p.now().Sub(p.loadedAt) < p.ttl {
+		return p.table, nil
+	}
+	popularities, err := store.ProductRepo.ActivePopularities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := sortedKeys(popularities)
+	weights := make([]int, len(ids))
+	for i, id := range ids {
+		weights[i] = popularities[id]
+	}
+	p.table, p.loadedAt = newAliasTable(ids, weights), p.now()
+	return p.table, nil
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAliasTable(t *testing.T) {
+	table := newAliasTable([]int{10, 20, 30}, []int{0, 1, 3})
+
+	// 各列で選ばれる確率を足し合わせると、重みの比になる
+	got := make(map[int]float64)
+	for i := range table.ids {
+		got[table.ids[i]] += table.prob[i] / 3
+		got[table.ids[table.alias[i]]] += (1 - table.prob[i]) / 3
+	}
+	want := map[int]float64{10: 0, 20: 0.25, 30: 0.75}
+	for id, p := range want {
+		if math.Abs(got[id]-p) > 1e-9 {
+			t.Fatalf("probability of %d = %v, want %v", id, got[id], p)
+		}
+	}
+
+	counts := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		counts[table.sample()]++
+	}
+	if counts[10] != 0 {
+		t.Fatalf("expected a product without orders never to be sampled, got %d", counts[10])
+	}
+	if counts[30] < 7000 || counts[30] > 8000 {
+		t.Fatalf("expected about 7500 samples of the popular product, got %d", counts[30])
+	}
+}
+
+func TestAliasTableWithoutOrders(t *testing.T) {
+	table := newAliasTable([]int{1, 2}, []int{0, 0})
+	for i := range table.ids {
+		if table.prob[i] != 1 {
+			t.Fatalf("expected every product to be equally likely, got %v", table.prob)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// 1つの商品に付けられるタグの数と、タグの名前の長さの上限（tagsテーブルの列の長さ）
+const (
+	maxProductTags = 20
+	maxTagLength   = 64
+)
+
+// SetProductTags は商品のタグを置き換え、名前の順にそろえたタグを返す
+// タグは前後の空白を除いて小文字にそろえ、重複を除く。空のtagsならすべてのタグを外す
+func (s *ProductService) SetProductTags(ctx context.Context, productID int, tags []string) ([]string, error) {
+	tags, err := normalizeProductTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		if _, err := lockProduct(ctx, txStore, productID); err != nil {
+			return err
+		}
+		return txStore.TagRepo.SetForProduct(ctx, productID, tags)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.lists.invalidate()
+	return tags, nil
+}
+
+// normalizeProductTags はタグを小文字にそろえて重複を除き、名前の順に並べる
+func normalizeProductTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, &ProductValidationError{Fields: []model.ProductFieldError{{Field: "tags", Error: "must be 1-" + strconv.Itoa(maxTagLength) + " characters each"}}}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxProductTags {
+		return nil, &ProductValidationError{Fields: []model.ProductFieldError{{Field: "tags", Error: "must be at most " + strconv.Itoa(maxProductTags) + " tags"}}}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
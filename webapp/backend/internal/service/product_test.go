@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestNormalizeOrderMetadata(t *testing.T) {
+	got, err := normalizeOrderMetadata(model.OrderMetadata(`{ "gift": true,
+		"note": "置き配" }`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != `{"gift":true,"note":"置き配"}` {
+		t.Fatalf("expected compacted metadata, got %s", got)
+	}
+	if got, err := normalizeOrderMetadata(nil); err != nil || got != nil {
+		t.Fatalf("expected no metadata, got %s, %v", got, err)
+	}
+
+	for _, invalid := range []string{
+		`["gift"]`,
+		`"gift"`,
+		`{"gift":`,
+		`{"note":"` + strings.Repeat("a", maxOrderMetadataBytes) + `"}`,
+	} {
+		if _, err := normalizeOrderMetadata(model.OrderMetadata(invalid)); !errors.Is(err, ErrInvalidOrderMetadata) {
+			t.Fatalf("%.20s: expected ErrInvalidOrderMetadata, got %v", invalid, err)
+		}
+	}
+}
+
+func TestCreateOrdersRejectsShipAfterPastDeadline(t *testing.T) {
+	deliverBy := time.Now().Add(time.Hour)
+	shipAfter := deliverBy.Add(time.Minute)
+	svc := &ProductService{}
+	_, err := svc.CreateOrders(context.Background(), 1, []model.RequestItem{
+		{ProductID: 1, Quantity: 1, DeliverBy: &deliverBy, ShipAfter: &shipAfter},
+	})
+	if !errors.Is(err, ErrInvalidOrderShipAfter) {
+		t.Fatalf("expected ErrInvalidOrderShipAfter, got %v", err)
+	}
+}
+
+func TestProductValidator(t *testing.T) {
+	v := &ProductValidator{maxValue: 1000, maxWeight: 5000, maxNameLength: 10, maxDescriptionBytes: 20}
+	product := model.Product{Name: "  chair ", Value: 1000, Weight: 5000}
+	if err := v.Validate(&product); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if product.Name != "chair" {
+		t.Fatalf("expected the name to be trimmed, got %q", product.Name)
+	}
+
+	for _, invalid := range []model.Product{
+		{Name: " ", Value: 1, Weight: 1},
+		{Name: "long chair!", Value: 1, Weight: 1},
+		{Name: "chair", Value: -1},
+		{Name: "chair", Value: 1001},
+		{Name: "chair", Weight: 5001},
+		{Name: "chair", Volume: -1},
+		{Name: "chair", Stock: -1},
+		{Name: "chair", Image: strings.Repeat("a", maxProductImageLength+1)},
+		{Name: "chair", Description: strings.Repeat("a", 21)},
+	} {
+		if err := v.Validate(&invalid); !errors.Is(err, ErrInvalidProduct) {
+			t.Fatalf("%+v: expected ErrInvalidProduct, got %v", invalid, err)
+		}
+	}
+
+	err := v.Validate(&model.Product{Name: "chair", Value: -1, Weight: -1})
+	var invalid *ProductValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a ProductValidationError, got %v", err)
+	}
+	want := []model.ProductFieldError{{Field: "value", Error: "must be between 0 and 1000"}, {Field: "weight", Error: "must be between 0 and 5000"}}
+	if !reflect.DeepEqual(invalid.Fields, want) {
+		t.Fatalf("fields = %+v, want %+v", invalid.Fields, want)
+	}
+}
+
+// productDB は商品1件を持ち、発行した書き込みを記録する
+type productDB struct {
+	orderDB
+	product model.Product
+}
+
+func (db *productDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if args[0] != db.product.ProductID {
+		return sql.ErrNoRows
+	}
+	*dest.(*model.Product) = db.product
+	return nil
+}
+
+func TestDeleteProduct(t *testing.T) {
+	db := &productDB{product: model.Product{ProductID: 1, Name: "chair"}}
+	svc := NewProductService(repository.NewStore(db), NewOrderEventBus())
+
+	if err := svc.DeleteProduct(context.Background(), 2); !errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("expected ErrProductNotFound, got %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected a missing product not to be written, got %v", db.writes)
+	}
+
+	if err := svc.DeleteProduct(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 1 || !strings.HasPrefix(db.writes[0], "UPDATE products SET deleted_at = ?") {
+		t.Fatalf("expected the product to be soft deleted, got %v", db.writes)
+	}
+
+	deletedAt := time.Now()
+	db.product.DeletedAt = &deletedAt
+	if err := svc.DeleteProduct(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 1 {
+		t.Fatalf("expected a deleted product not to be deleted again, got %v", db.writes)
+	}
+}
+
+func TestRestoreProduct(t *testing.T) {
+	deletedAt := time.Now()
+	db := &productDB{product: model.Product{ProductID: 1, Name: "chair", DeletedAt: &deletedAt}}
+	svc := NewProductService(repository.NewStore(db), NewOrderEventBus())
+
+	if _, err := svc.RestoreProduct(context.Background(), 2); !errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("expected ErrProductNotFound, got %v", err)
+	}
+
+	product, err := svc.RestoreProduct(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if product.DeletedAt != nil {
+		t.Fatalf("expected the restored product to have no deleted_at, got %v", product.DeletedAt)
+	}
+	if len(db.writes) != 1 || !strings.HasPrefix(db.writes[0], "UPDATE products SET deleted_at = NULL") {
+		t.Fatalf("expected the product to be restored, got %v", db.writes)
+	}
+
+	db.product.DeletedAt = nil
+	if _, err := svc.RestoreProduct(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 1 {
+		t.Fatalf("expected a product that is not deleted not to be written, got %v", db.writes)
+	}
+}
+
+func TestUpdateProductRecordsValueHistory(t *testing.T) {
+	db := &productDB{product: model.Product{ProductID: 1, Name: "chair", Value: 100}}
+	svc := NewProductService(repository.NewStore(db), NewOrderEventBus())
+
+	if _, err := svc.UpdateProduct(context.Background(), model.Product{ProductID: 1, Name: "armchair", Value: 100}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 1 {
+		t.Fatalf("expected no history when the value is unchanged, got %v", db.writes)
+	}
+
+	db.writes = nil
+	if _, err := svc.UpdateProduct(context.Background(), model.Product{ProductID: 1, Name: "chair", Value: 150}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 3 || !strings.HasPrefix(db.writes[1], "UPDATE product_value_history") || !strings.HasPrefix(db.writes[2], "INSERT INTO product_value_history") {
+		t.Fatalf("expected the value change to be recorded, got %v", db.writes)
+	}
+}
+
+func TestImportProducts(t *testing.T) {
+	db := &orderDB{}
+	svc := &ProductService{store: repository.NewStore(db), lists: newProductListCache(10, 0), validator: &ProductValidator{maxValue: 1000, maxWeight: 5000, maxNameLength: maxProductNameLength, maxDescriptionBytes: maxProductDescriptionBytes}, maxImportRows: 10000}
+
+	var csv strings.Builder
+	csv.WriteString("name,value,weight,description\n")
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&csv, "item %d,100,200,\"large, blue\"\n", i)
+	}
+	result, err := svc.ImportProducts(context.Background(), strings.NewReader(csv.String()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Imported != 2500 || len(result.Errors) != 0 {
+		t.Fatalf("expected 2500 imported products, got %+v", result)
+	}
+	// 3回に分けたINSERTと、価値の履歴の更新（閉じる・加える）
+	if len(db.writes) != 5 {
+		t.Fatalf("expected 3 batches and 2 history updates, got %d", len(db.writes))
+	}
+}
+
+func TestImportProductsReportsInvalidRows(t *testing.T) {
+	db := &orderDB{}
+	svc := &ProductService{store: repository.NewStore(db), lists: newProductListCache(10, 0), validator: &ProductValidator{maxValue: 1000, maxWeight: 5000, maxNameLength: maxProductNameLength, maxDescriptionBytes: maxProductDescriptionBytes}, maxImportRows: 10000}
+
+	result, err := svc.ImportProducts(context.Background(), strings.NewReader(
+		"product_id,name,value,weight\n"+
+			"1,chair,100,200\n"+
+			"2,,100,200\n"+
+			"3,desk,abc,200\n"+
+			"1,table,100,200\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rows []int
+	for _, rowErr := range result.Errors {
+		rows = append(rows, rowErr.Row)
+	}
+	if result.Imported != 0 || !reflect.DeepEqual(rows, []int{3, 4, 5}) {
+		t.Fatalf("expected errors in rows 3-5 and nothing imported, got %+v", result)
+	}
+	if fields := result.Errors[0].Fields; len(fields) != 1 || fields[0].Field != "name" {
+		t.Fatalf("expected the missing name to be reported as a field error, got %+v", fields)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected no writes, got %v", db.writes)
+	}
+
+	if _, err := svc.ImportProducts(context.Background(), strings.NewReader("name,price\nchair,100\n")); !errors.Is(err, ErrInvalidProductImport) {
+		t.Fatalf("expected ErrInvalidProductImport, got %v", err)
+	}
+}
+
+// detailDB は商品1件と、注文テーブルごとの注文の集計を返す
+type detailDB struct {
+	orderDB
+	product model.Product
+	stats   []model.ProductOrderStats
+	reads   int
+}
+
+func (db *detailDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if args[0] != db.product.ProductID {
+		return sql.ErrNoRows
+	}
+	switch dest := dest.(type) {
+	case *model.Product:
+		*dest = db.product
+	case *model.ProductOrderStats:
+		*dest = db.stats[db.reads]
+		db.reads++
+	default:
+		return errors.New("unexpected query")
+	}
+	return nil
+}
+
+func TestGetProductDetail(t *testing.T) {
+	// 注文テーブルと退避先の集計を合わせる
+	db := &detailDB{
+		product: model.Product{ProductID: 1, Name: "chair"},
+		stats: []model.ProductOrderStats{
+			{Orders: 3, Delivered: 1, DeliverySeconds: 100},
+			{Orders: 2, Delivered: 2, DeliverySeconds: 500},
+		},
+	}
+	svc := NewProductService(repository.NewStore(db), NewOrderEventBus())
+
+	detail, err := svc.GetProductDetail(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if detail.Name != "chair" || detail.TimesOrdered != 5 || detail.AverageDeliverySeconds == nil || *detail.AverageDeliverySeconds != 200 {
+		t.Fatalf("unexpected detail: %+v", detail)
+	}
+
+	if _, err := svc.GetProductDetail(context.Background(), 2); !errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("expected ErrProductNotFound, got %v", err)
+	}
+}
+
+func TestAddFavorite(t *testing.T) {
+	db := &productDB{product: model.Product{ProductID: 1, Name: "chair"}}
+	svc := NewProductService(repository.NewStore(db), NewOrderEventBus())
+
+	if err := svc.AddFavorite(context.Background(), 7, 2); !errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("expected ErrProductNotFound, got %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected a missing product not to be added, got %v", db.writes)
+	}
+	if err := svc.AddFavorite(context.Background(), 7, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.writes) != 1 || !strings.HasPrefix(db.writes[0], "INSERT IGNORE INTO favorites") {
+		t.Fatalf("expected the product to be added to the favorites, got %v", db.writes)
+	}
+}
+
+func TestNormalizeProductTags(t *testing.T) {
+	got, err := normalizeProductTags([]string{" Heavy ", "fragile", "HEAVY"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"fragile", "heavy"}) {
+		t.Fatalf("tags = %v, want [fragile heavy]", got)
+	}
+
+	for _, invalid := range [][]string{
+		{" "},
+		{strings.Repeat("a", maxTagLength+1)},
+	} {
+		if _, err := normalizeProductTags(invalid); !errors.Is(err, ErrInvalidProduct) {
+			t.Fatalf("%q: expected ErrInvalidProduct, got %v", invalid, err)
+		}
+	}
+	tooMany := make([]string, maxProductTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag%d", i)
+	}
+	if _, err := normalizeProductTags(tooMany); !errors.Is(err, ErrInvalidProduct) {
+		t.Fatalf("expected ErrInvalidProduct for too many tags, got %v", err)
+	}
+}
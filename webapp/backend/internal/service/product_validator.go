@@ -0,0 +1,89 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"backend/internal/model"
+)
+
+// productsテーブルの列の長さ。設定で上限を変えても、これを超えることはできない
+const (
+	maxProductNameLength       = 255
+	maxProductImageLength      = 500
+	maxProductDescriptionBytes = 65535
+)
+
+// ProductValidationError は商品の検証エラー。不正な項目をすべて持つ
+// errors.IsでErrInvalidProductとして判定できる
+type ProductValidationError struct {
+	Fields []model.ProductFieldError
+}
+
+func (e *ProductValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + " " + f.Error
+	}
+	return ErrInvalidProduct.Error() + ": " + strings.Join(parts, "; ")
+}
+
+func (e *ProductValidationError) Unwrap() error {
+	return ErrInvalidProduct
+}
+
+// ProductValidator は管理APIとCSVでの一括登録で共通の、商品の項目の検証
+type ProductValidator struct {
+	maxValue            model.Points
+	maxWeight           model.Grams
+	maxNameLength       int
+	maxDescriptionBytes int
+}
+
+func NewProductValidator() *ProductValidator {
+	return &ProductValidator{
+		maxValue:            model.Points(parseIntEnv("PRODUCT_MAX_VALUE", 1000000)),
+		maxWeight:           model.Grams(parseIntEnv("PRODUCT_MAX_WEIGHT", 1000000)),
+		maxNameLength:       min(parseIntEnv("PRODUCT_MAX_NAME_LENGTH", maxProductNameLength), maxProductNameLength),
+		maxDescriptionBytes: min(parseIntEnv("PRODUCT_MAX_DESCRIPTION_BYTES", maxProductDescriptionBytes), maxProductDescriptionBytes),
+	}
+}
+
+// Validate は商品名の前後の空白を除き、各項目が範囲内か検証する
+// 不正な項目があれば、そのすべてを持つ*ProductValidationErrorを返す
+func (v *ProductValidator) Validate(product *model.Product) error {
+	product.Name = strings.TrimSpace(product.Name)
+	var fields []model.ProductFieldError
+	invalid := func(field, reason string) {
+		fields = append(fields, model.ProductFieldError{Field: field, Error: reason})
+	}
+	if product.Name == "" || utf8.RuneCountInString(product.Name) > v.maxNameLength {
+		invalid("name", "must be 1-"+strconv.Itoa(v.maxNameLength)+" characters")
+	}
+	if product.Value < 0 || product.Value > v.maxValue {
+		invalid("value", "must be between 0 and "+strconv.Itoa(int(v.maxValue)))
+	}
+	if product.Weight < 0 || product.Weight > v.maxWeight {
+		invalid("weight", "must be between 0 and "+strconv.Itoa(int(v.maxWeight)))
+	}
+	if product.Volume < 0 {
+		invalid("volume", "must not be negative")
+	}
+	if product.Stock < 0 {
+		invalid("stock", "must not be negative")
+	}
+	if product.LowStockThreshold < 0 {
+		invalid("low_stock_threshold", "must not be negative")
+	}
+	if len(product.Image) > maxProductImageLength {
+		invalid("image", "must be at most "+strconv.Itoa(maxProductImageLength)+" bytes")
+	}
+	if len(product.Description) > v.maxDescriptionBytes {
+		invalid("description", "must be at most "+strconv.Itoa(v.maxDescriptionBytes)+" bytes")
+	}
+	if len(fields) > 0 {
+		return &ProductValidationError{Fields: fields}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"backend/internal/model"
+	"context"
+	"sync"
+	"time"
+)
+
+// recentOrdersCache はユーザーごとに最新の注文を上限件数まで保持し、
+// 注文履歴の1ページ目（検索なし・注文ID降順）をSQLを使わずに返す
+//
+// 注文の作成時はそのユーザーのエントリを破棄し、ステータス変更時は保持している注文を書き換える。
+// 読み込み中に変更があった場合は、古い結果を保存しないようその読み込み結果を捨てる。
+type recentOrdersCache struct {
+	mx       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	maxUsers int
+	now      func() time.Time
+
+	byUser  map[int]*recentOrders
+	owner   map[int64]int // 保持している注文ID -> ユーザーID
+	pending map[*recentOrdersLoad]struct{}
+}
+
+type recentOrders struct {
+	orders   []model.Order // 注文ID降順
+	total    int
+	loadedAt time.Time
+}
+
+// recentOrdersLoad は進行中のSQLからの読み込み
+type recentOrdersLoad struct {
+	userID int
+	dirty  bool
+}
+
+func newRecentOrdersCache(capacity, maxUsers int, ttl time.Duration) *recentOrdersCache {
+	return &recentOrdersCache{
+		capacity: capacity,
+		ttl:      ttl,
+		maxUsers: maxUsers,
+		now:      time.Now,
+		byUser:   make(map[int]*recentOrders),
+		owner:    make(map[int64]int),
+		pending:  make(map[*recentOrdersLoad]struct{}),
+	}
+}
+
+// servable はリクエストがキャッシュから返せる形か判定する
+func (c *recentOrdersCache) servable(req model.ListRequest) bool {
+	return req.Search == "" && req.Offset == 0 &&
+		len(req.Status) == 0 && req.CreatedFrom.IsZero() && req.CreatedTo.IsZero() && !req.Archived &&
+		req.ValueMin == nil && req.ValueMax == nil && req.WeightMin == nil && req.WeightMax == nil && req.CategoryID == 0 &&
+		req.SortField == "o.order_id" && req.SortOrder == "DESC" &&
+		req.PageSize > 0 && req.PageSize <= c.capacity
+}
+
+// get はキャッシュ済みの先頭pageSize件と総件数を返す
+func (c *recentOrdersCache) get(userID, pageSize int) ([]model.Order, int, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	entry, ok := c.byUser[userID]
+	if !ok {
+		return nil, 0, false
+	}
+	if c.now().Sub(entry.loadedAt) > c.ttl {
+		c.removeLocked(userID)
+		return nil, 0, false
+	}
+	n := pageSize
+	if n > len(entry.orders) {
+		n = len(entry.orders)
+	}
+	// 保持している注文はステータス変更で書き換わるため、コピーを返す
+	orders := make([]model.Order, n)
+	copy(orders, entry.orders[:n])
+	return orders, entry.total, true
+}
+
+// load はユーザーの最新の注文をfetchで読み込んで返し、途中で変更がなければキャッシュに保存する
+func (c *recentOrdersCache) load(ctx context.Context, userID int, fetch func(ctx context.Context, limit int) ([]model.Order, int, error)) ([]model.Order, int, error) {
+	token := &recentOrdersLoad{userID: userID}
+	c.mx.Lock()
+	c.pending[token] = struct{}{}
+	c.mx.Unlock()
+
+	orders, total, err := fetch(ctx, c.capacity)
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	delete(c.pending, token)
+	if err != nil {
+		return nil, 0, err
+	}
+	if token.dirty {
+		return orders, total, nil
+	}
+	c.removeLocked(userID)
+	if len(c.byUser) >= c.maxUsers {
+		for evict := range c.byUser {
+			c.removeLocked(evict)
+			break
+		}
+	}
+	cached := make([]model.Order, len(orders))
+	copy(cached, orders)
+	c.byUser[userID] = &recentOrders{orders: cached, total: total, loadedAt: c.now()}
+	for _, o := range cached {
+		c.owner[o.OrderID] = userID
+	}
+	return orders, total, nil
+}
+
+// OrdersCreated は新しい注文が先頭に加わるため、ユーザーのエントリを破棄する
+func (c *recentOrdersCache) OrdersCreated(userID int, orderIDs []int64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.removeLocked(userID)
+	for token := range c.pending {
+		if token.userID == userID {
+			token.dirty = true
+		}
+	}
+}
+
+// OrderStatusChanged は保持している注文のステータスを書き換える
+func (c *recentOrdersCache) OrderStatusChanged(orderIDs []int64, status string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	// 読み込み中の結果がどのユーザーの注文を含むかは分からないため、すべて破棄する
+	for token := range c.pending {
+		token.dirty = true
+	}
+	for _, id := range orderIDs {
+		userID, ok := c.owner[id]
+		if !ok {
+			continue
+		}
+		entry := c.byUser[userID]
+		for i := range entry.orders {
+			if entry.orders[i].OrderID == id {
+				entry.orders[i].ShippedStatus = status
+				break
+			}
+		}
+	}
+}
+
+func (c *recentOrdersCache) removeLocked(userID int) {
+	entry, ok := c.byUser[userID]
+	if !ok {
+		return
+	}
+	for _, o := range entry.orders {
+		delete(c.owner, o.OrderID)
+	}
+	delete(c.byUser, userID)
+}
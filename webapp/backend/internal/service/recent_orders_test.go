@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+// fakeOrderTable はユーザーの注文を注文ID降順で返す読み込み元
+type fakeOrderTable struct {
+	orders []model.Order
+	reads  int
+}
+
+func (f *fakeOrderTable) fetch(ctx context.Context, limit int) ([]model.Order, int, error) {
+	f.reads++
+	n := limit
+	if n > len(f.orders) {
+		n = len(f.orders)
+	}
+	out := make([]model.Order, n)
+	for i := 0; i < n; i++ {
+		out[i] = f.orders[len(f.orders)-1-i]
+	}
+	return out, len(f.orders), nil
+}
+
+func (f *fakeOrderTable) setStatus(id int64, status string) {
+	for i := range f.orders {
+		if f.orders[i].OrderID == id {
+			f.orders[i].ShippedStatus = status
+		}
+	}
+}
+
+// キャッシュから返した1ページ目が、読み込み元から直接取得した結果と一致することを確認する
+func assertConsistent(t *testing.T, c *recentOrdersCache, table *fakeOrderTable, userID, pageSize int) {
+	t.Helper()
+	want, wantTotal, _ := table.fetch(context.Background(), pageSize)
+	table.reads--
+	got, total, ok := c.get(userID, pageSize)
+	if !ok {
+		got, total, _ = c.load(context.Background(), userID, table.fetch)
+		if len(got) > pageSize {
+			got = got[:pageSize]
+		}
+	}
+	if total != wantTotal || len(got) != len(want) {
+		t.Fatalf("expected %d orders (total %d), got %d (total %d)", len(want), wantTotal, len(got), total)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("order %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func newTestTable(userID, n int) *fakeOrderTable {
+	table := &fakeOrderTable{}
+	for i := 1; i <= n; i++ {
+		table.orders = append(table.orders, model.Order{OrderID: int64(i), UserID: userID, ShippedStatus: "shipping"})
+	}
+	return table
+}
+
+func TestRecentOrdersCacheServesFromMemory(t *testing.T) {
+	c := newRecentOrdersCache(5, 10, time.Minute)
+	table := newTestTable(1, 8)
+
+	assertConsistent(t, c, table, 1, 3)
+	assertConsistent(t, c, table, 1, 5)
+	if table.reads != 1 {
+		t.Fatalf("expected a single read from the table, got %d", table.reads)
+	}
+	if c.servable(model.ListRequest{PageSize: 6, SortField: "o.order_id", SortOrder: "DESC"}) {
+		t.Fatalf("page sizes above the capacity must fall back to SQL")
+	}
+	if c.servable(model.ListRequest{PageSize: 5, Search: "x", SortField: "o.order_id", SortOrder: "DESC"}) {
+		t.Fatalf("filtered requests must fall back to SQL")
+	}
+}
+
+func TestRecentOrdersCacheFollowsEvents(t *testing.T) {
+	c := newRecentOrdersCache(5, 10, time.Minute)
+	table := newTestTable(1, 8)
+	assertConsistent(t, c, table, 1, 5)
+
+	table.setStatus(8, "delivering")
+	table.setStatus(2, "delivering") // キャッシュ外の注文
+	c.OrderStatusChanged([]int64{8, 2}, "delivering")
+	assertConsistent(t, c, table, 1, 5)
+
+	table.orders = append(table.orders, model.Order{OrderID: 9, UserID: 1, ShippedStatus: "shipping"})
+	c.OrdersCreated(1, []int64{9})
+	assertConsistent(t, c, table, 1, 5)
+	if table.reads != 2 {
+		t.Fatalf("expected creation to force a reload, got %d reads", table.reads)
+	}
+}
+
+func TestRecentOrdersCacheDropsLoadRacingWithChange(t *testing.T) {
+	c := newRecentOrdersCache(5, 10, time.Minute)
+	table := newTestTable(1, 3)
+
+	stale, _, _ := c.load(context.Background(), 1, func(ctx context.Context, limit int) ([]model.Order, int, error) {
+		orders, total, err := table.fetch(ctx, limit)
+		// 読み込み後、保存前にステータスが変わった
+		table.setStatus(3, "completed")
+		c.OrderStatusChanged([]int64{3}, "completed")
+		return orders, total, err
+	})
+	if stale[0].ShippedStatus != "shipping" {
+		t.Fatalf("expected the racing load to see the old status")
+	}
+	if _, _, ok := c.get(1, 5); ok {
+		t.Fatalf("expected a load racing with a change not to be cached")
+	}
+	assertConsistent(t, c, table, 1, 5)
+}
+
+func TestRecentOrdersCacheExpires(t *testing.T) {
+	c := newRecentOrdersCache(5, 10, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	table := newTestTable(1, 3)
+	assertConsistent(t, c, table, 1, 5)
+
+	now = now.Add(2 * time.Minute)
+	if _, _, ok := c.get(1, 5); ok {
+		t.Fatalf("expected the entry to expire after the TTL")
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+var ErrInvalidRecommendationRequest = errors.New("invalid recommendation request")
+
+// おすすめの件数の既定値と上限、おすすめの元にする最近の注文の商品の数
+const (
+	defaultRecommendationLimit = 10
+	maxRecommendationLimit     = 50
+	recommendationSourceCount  = 10
+)
+
+// RecommendationService は一緒に注文されることの多い商品を定期的に集計し直す
+// 同じ利用者が注文したことのある商品の組を、両方を注文した利用者の数で数える
+type RecommendationService struct {
+	store *repository.Store
+	every time.Duration
+	// 商品ごとに残すおすすめの数
+	perProduct int
+	// 注文した商品がこれより多い利用者は数えない。商品の組の数は商品の数の二乗で増えるため
+	maxUserProducts int
+}
+
+func NewRecommendationService(store *repository.Store) *RecommendationService {
+	return &RecommendationService{
+		store:           store,
+		every:           parseDurationEnv("RECOMMENDATION_INTERVAL", 30*time.Minute),
+		perProduct:      parseIntEnv("RECOMMENDATION_PER_PRODUCT", 20),
+		maxUserProducts: parseIntEnv("RECOMMENDATION_MAX_USER_PRODUCTS", 200),
+	}
+}
+
+// Start は起動直後とevery間隔でおすすめを集計し直すジョブを開始する
+func (s *RecommendationService) Start(ctx context.Context) {
+	go func() {
+		for {
+			n, err := s.Refresh(ctx)
+			if err != nil {
+				log.Printf("Failed to refresh product recommendations: %v", err)
+			} else {
+				log.Printf("Refreshed %d product recommendations", n)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.every):
+			}
+		}
+	}()
+}
+
+// Refresh はおすすめを集計し、1つのトランザクションで全件を置き換えて、その件数を返す
+func (s *RecommendationService) Refresh(ctx context.Context) (int, error) {
+	byUser, err := s.store.OrderRepo.ProductsByUser(ctx)
+	if err != nil {
+		return 0, err
+	}
+	recs := coOccurrences(byUser, s.maxUserProducts, s.perProduct)
+	err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		return txStore.RecommendRepo.Replace(ctx, recs, 1000)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(recs), nil
+}
+
+// coOccurrences は利用者ごとの商品から、商品ごとに一緒に注文した利用者の多い順にperProduct件のおすすめを作る
+func coOccurrences(byUser map[int][]int, maxUserProducts, perProduct int) []model.ProductRecommendation {
+	counts := make(map[[2]int]int)
+	for _, productIDs := range byUser {
+		// 注文テーブルと退避先の両方に同じ商品の注文があれば重複するため、まとめる
+		seen := make(map[int]struct{}, len(productIDs))
+		for _, id := range productIDs {
+			seen[id] = struct{}{}
+		}
+		if len(seen) > maxUserProducts {
+			continue
+		}
+		unique := sortedKeys(seen)
+		for i, a := range unique {
+			for _, b := range unique[i+1:] {
+				counts[[2]int{a, b}]++
+			}
+		}
+	}
+
+	byProduct := make(map[int][]model.ProductRecommendation)
+	for pair, score := range counts {
+		byProduct[pair[0]] = append(byProduct[pair[0]], model.ProductRecommendation{ProductID: pair[0], RecommendedProductID: pair[1], Score: score})
+		byProduct[pair[1]] = append(byProduct[pair[1]], model.ProductRecommendation{ProductID: pair[1], RecommendedProductID: pair[0], Score: score})
+	}
+	var recs []model.ProductRecommendation
+	for _, productID := range sortedKeys(byProduct) {
+		candidates := byProduct[productID]
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].Score != candidates[j].Score {
+				return candidates[i].Score > candidates[j].Score
+			}
+			return candidates[i].RecommendedProductID < candidates[j].RecommendedProductID
+		})
+		recs = append(recs, candidates[:min(perProduct, len(candidates))]...)
+	}
+	return recs
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Recommend は利用者が最近注文した商品と一緒に注文されることの多い商品を返す
+// 注文のない利用者や、おすすめがlimit件に満たない場合は、注文数の多い商品で補う
+func (s *ProductService) Recommend(ctx context.Context, userID, limit int) ([]model.Product, error) {
+	if limit == 0 {
+		limit = defaultRecommendationLimit
+	}
+	if limit < 0 || limit > maxRecommendationLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidRecommendationRequest, maxRecommendationLimit)
+	}
+	recent, err := s.store.OrderRepo.RecentProductIDs(ctx, userID, recommendationSourceCount)
+	if err != nil {
+		return nil, err
+	}
+	products, err := s.store.RecommendRepo.ForProducts(ctx, recent, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) >= limit {
+		return products, nil
+	}
+
+	exclude := append([]int{}, recent...)
+	for _, p := range products {
+		exclude = append(exclude, p.ProductID)
+	}
+	topSellers, err := s.store.ProductRepo.TopSellers(ctx, exclude, limit-len(products))
+	if err != nil {
+		return nil, err
+	}
+	return append(products, topSellers...), nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestCoOccurrences(t *testing.T) {
+	recs := coOccurrences(map[int][]int{
+		1: {10, 20, 30},
+		2: {20, 10, 10},
+		3: {30, 40, 50, 60}, // 商品が多すぎるため数えない
+	}, 3, 1)
+	want := []model.ProductRecommendation{
+		{ProductID: 10, RecommendedProductID: 20, Score: 2},
+		{ProductID: 20, RecommendedProductID: 10, Score: 2},
+		{ProductID: 30, RecommendedProductID: 10, Score: 1},
+	}
+	if !reflect.DeepEqual(recs, want) {
+		t.Fatalf("expected %v, got %v", want, recs)
+	}
+}
+
+// recommendDB は利用者の最近の注文の商品、おすすめ、注文数の多い商品を返し、受け取ったクエリを記録する
+type recommendDB struct {
+	orderDB
+	recent      []int
+	recommended []model.Product
+	topSellers  []model.Product
+	queries     []string
+}
+
+func (db *recommendDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db.queries = append(db.queries, query)
+	switch {
+	case strings.Contains(query, "MAX(created_at)"):
+		*dest.(*[]int) = db.recent
+	case strings.Contains(query, "product_recommendations"):
+		*dest.(*[]model.Product) = db.recommended
+	case strings.Contains(query, "ORDER BY popularity DESC"):
+		*dest.(*[]model.Product) = db.topSellers[:args[len(args)-1].(int)]
+	default:
+		return errors.New("unexpected query")
+	}
+	return nil
+}
+
+func TestRecommendFallsBackToTopSellers(t *testing.T) {
+	db := &recommendDB{
+		recent:      []int{1},
+		recommended: []model.Product{{ProductID: 2}},
+		topSellers:  []model.Product{{ProductID: 3}, {ProductID: 4}, {ProductID: 5}},
+	}
+	svc := &ProductService{store: repository.NewStore(db)}
+
+	products, err := svc.Recommend(context.Background(), 7, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []int
+	for _, p := range products {
+		ids = append(ids, p.ProductID)
+	}
+	if !reflect.DeepEqual(ids, []int{2, 3, 4}) {
+		t.Fatalf("expected the recommendation followed by top sellers, got %v", ids)
+	}
+	if last := db.queries[len(db.queries)-1]; !strings.Contains(last, "NOT IN") {
+		t.Fatalf("expected top sellers to exclude recent and recommended products: %s", last)
+	}
+
+	if _, err := svc.Recommend(context.Background(), 7, maxRecommendationLimit+1); !errors.Is(err, ErrInvalidRecommendationRequest) {
+		t.Fatalf("expected ErrInvalidRecommendationRequest, got %v", err)
+	}
+}
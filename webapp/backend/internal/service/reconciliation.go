@@ -0,0 +1,226 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/objectstore"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrReportNotFound      = errors.New("reconciliation report not found")
+	ErrInvalidReportDate   = errors.New("invalid report date")
+	ErrUnknownReportFormat = errors.New("unknown report format")
+)
+
+// レポートに載せるSLA違反の注文IDの上限
+const maxSLABreachIDs = 100
+
+const reportDateLayout = "2006-01-02"
+
+// ReconciliationService は1日分の注文の作成・完了・滞留などを集計した突合レポートを作る
+// レポートはJSONとCSVでオブジェクトストレージに保存する
+type ReconciliationService struct {
+	store      *repository.Store
+	objects    objectstore.Store
+	sla        time.Duration
+	stuckAfter time.Duration
+	// 毎日この時刻（0時からの経過時間）に前日分のレポートを作る
+	runAt time.Duration
+}
+
+func NewReconciliationService(store *repository.Store, objects objectstore.Store) *ReconciliationService {
+	runAt := 10 * time.Minute
+	if v := os.Getenv("RECONCILIATION_RUN_AT"); v != "" {
+		if t, err := time.Parse("15:04", v); err == nil {
+			runAt = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		} else {
+			log.Printf("Invalid RECONCILIATION_RUN_AT %q, using 00:10", v)
+		}
+	}
+	return &ReconciliationService{
+		store:      store,
+		objects:    objects,
+		sla:        parseDurationEnv("RECONCILIATION_SLA", 24*time.Hour),
+		stuckAfter: parseDurationEnv("RECONCILIATION_STUCK_AFTER", 2*time.Hour),
+		runAt:      runAt,
+	}
+}
+
+// Start は毎日runAtに前日分のレポートを作るジョブを開始する
+func (s *ReconciliationService) Start(ctx context.Context) {
+	go func() {
+		for {
+			now := time.Now()
+			next := startOfDay(now).Add(s.runAt)
+			if !next.After(now) {
+				next = startOfDay(now.AddDate(0, 0, 1)).Add(s.runAt)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+
+			day := startOfDay(next.AddDate(0, 0, -1))
+			if _, err := s.Generate(ctx, day); err != nil {
+				log.Printf("Failed to generate reconciliation report for %s: %v", day.Format(reportDateLayout), err)
+			}
+		}
+	}()
+}
+
+// Generate はdayの1日分のレポートを作って保存する。同じ日のレポートがあれば作り直す
+func (s *ReconciliationService) Generate(ctx context.Context, day time.Time) (*model.ReconciliationReport, error) {
+	from := startOfDay(day)
+	to := from.AddDate(0, 0, 1)
+	report := &model.ReconciliationReport{
+		Date:              from.Format(reportDateLayout),
+		GeneratedAt:       time.Now(),
+		StuckThreshold:    s.stuckAfter.String(),
+		SLA:               s.sla.String(),
+		SLABreachOrderIDs: []int64{},
+		Robots:            []model.RobotDeliverySummary{},
+	}
+
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		created, err := s.store.OrderEventRepo.CountCreatedBetween(ctx, from, to)
+		if err != nil {
+			return err
+		}
+		for actor, n := range created {
+			if actor == model.OrderEventActorSupplyClone {
+				report.CloneTopUps += n
+			} else {
+				report.OrdersCreated += n
+			}
+		}
+
+		completed, err := s.store.OrderEventRepo.CompletedBetween(ctx, from, to)
+		if err != nil {
+			return err
+		}
+		report.OrdersCompleted = len(completed)
+		ids := make([]int64, len(completed))
+		for i, c := range completed {
+			ids[i] = c.OrderID
+		}
+		values, err := s.store.OrderRepo.ValuesByID(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		byRobot := make(map[string]*model.RobotDeliverySummary)
+		for _, c := range completed {
+			summary, ok := byRobot[c.RobotID]
+			if !ok {
+				summary = &model.RobotDeliverySummary{RobotID: c.RobotID}
+				byRobot[c.RobotID] = summary
+			}
+			summary.Orders++
+			summary.Value += values[c.OrderID]
+
+			if c.CompletedAt.Sub(c.CreatedAt) > s.sla {
+				report.SLABreaches++
+				if len(report.SLABreachOrderIDs) < maxSLABreachIDs {
+					report.SLABreachOrderIDs = append(report.SLABreachOrderIDs, c.OrderID)
+				}
+			}
+		}
+		for _, summary := range byRobot {
+			report.Robots = append(report.Robots, *summary)
+		}
+		sort.Slice(report.Robots, func(i, j int) bool { return report.Robots[i].RobotID < report.Robots[j].RobotID })
+
+		report.StuckOrders, err = s.store.OrderEventRepo.CountStuckDelivering(ctx, time.Now().Add(-s.stuckAfter))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.save(ctx, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (s *ReconciliationService) save(ctx context.Context, report *model.ReconciliationReport) error {
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.objects.Put(ctx, reportKey(report.Date, "json"), bytes.NewReader(body)); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeReportCSV(&buf, report); err != nil {
+		return err
+	}
+	return s.objects.Put(ctx, reportKey(report.Date, "csv"), &buf)
+}
+
+// Open は保存済みのレポートを指定形式(json|csv)で開く
+func (s *ReconciliationService) Open(ctx context.Context, date, format string) (io.ReadCloser, error) {
+	if _, err := time.Parse(reportDateLayout, date); err != nil {
+		return nil, ErrInvalidReportDate
+	}
+	if format != "json" && format != "csv" {
+		return nil, ErrUnknownReportFormat
+	}
+	body, err := s.objects.Open(ctx, reportKey(date, format))
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil, ErrReportNotFound
+	}
+	return body, err
+}
+
+// ParseReportDate はYYYY-MM-DD形式の日付をローカル時刻の0時として解釈する
+func ParseReportDate(date string) (time.Time, error) {
+	day, err := time.ParseInLocation(reportDateLayout, date, time.Local)
+	if err != nil {
+		return time.Time{}, ErrInvalidReportDate
+	}
+	return day, nil
+}
+
+func reportKey(date, format string) string {
+	return fmt.Sprintf("reports/reconciliation/%s.%s", date, format)
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// CSVは section,key,orders,value の4列。集計値はordersに、ロボットごとの行は件数と価値を入れる
+func writeReportCSV(w io.Writer, report *model.ReconciliationReport) error {
+	cw := csv.NewWriter(w)
+	rows := [][]string{
+		{"section", "key", "orders", "value"},
+		{"summary", "orders_created", strconv.Itoa(report.OrdersCreated), ""},
+		{"summary", "clone_top_ups", strconv.Itoa(report.CloneTopUps), ""},
+		{"summary", "orders_completed", strconv.Itoa(report.OrdersCompleted), ""},
+		{"summary", "stuck_orders", strconv.Itoa(report.StuckOrders), ""},
+		{"summary", "sla_breaches", strconv.Itoa(report.SLABreaches), ""},
+	}
+	for _, r := range report.Robots {
+		rows = append(rows, []string{"robot", r.RobotID, strconv.Itoa(r.Orders), strconv.Itoa(int(r.Value))})
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestWriteReportCSV(t *testing.T) {
+	report := &model.ReconciliationReport{
+		OrdersCreated:   10,
+		CloneTopUps:     2,
+		OrdersCompleted: 7,
+		StuckOrders:     1,
+		SLABreaches:     3,
+		Robots: []model.RobotDeliverySummary{
+			{RobotID: "robot-001", Orders: 5, Value: 500},
+			{RobotID: "", Orders: 2, Value: 80},
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeReportCSV(&buf, report); err != nil {
+		t.Fatalf("writeReportCSV: %v", err)
+	}
+	want := "section,key,orders,value\n" +
+		"summary,orders_created,10,\n" +
+		"summary,clone_top_ups,2,\n" +
+		"summary,orders_completed,7,\n" +
+		"summary,stuck_orders,1,\n" +
+		"summary,sla_breaches,3,\n" +
+		"robot,robot-001,5,500\n" +
+		"robot,,2,80\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected csv:\n%s", buf.String())
+	}
+}
@@ -4,112 +4,816 @@ import (
 	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
+	"backend/internal/telemetry"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"os"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type RobotService struct {
 	store        *repository.Store
-	cloneEnabled bool
-	supplyTarget int
+	events       *OrderEventBus
+	supply       SupplyPolicy
+	eta          CostModel
+	planner      *PlannerProfileService
+	chunks       *planChunkStore
+	deadline     deadlinePolicy
+	userFairness userFairnessPolicy
+	exactBudget  time.Duration
+	parallelism  int
+	anytime      float64
+	results      *planResultCache
+	solverStats  *telemetry.SolverStats
+	// 配送待ちの注文をこの件数までに絞って計画する（0以下なら全件を読む）
+	window int
+	// 1計画に含める注文数の上限（0以下なら無制限）
+	maxItems int
+	// 引き受けてからreleaseAfterを過ぎても完了しない注文を配送待ちに戻す（0以下なら戻さない）
+	releaseAfter time.Duration
+	reapEvery    time.Duration
+	// 一度でも生存を通知したロボットは、最後の通知からheartbeatTimeoutを過ぎると計画せず、引き受けた注文を配送待ちに戻す
+	// （0以下なら生存を監視しない）
+	heartbeatTimeout time.Duration
+	// 配送に失敗して配送待ちへ戻せる回数。超えた注文は配送失敗にする
+	maxDeliveryRetries int
 }
 
-func NewRobotService(store *repository.Store) *RobotService {
-	cloneEnabled := true
-	if v := os.Getenv("ROBOT_SHIPPING_CLONE_ENABLED"); v != "" {
-		if b, err := strconv.ParseBool(v); err == nil {
-			cloneEnabled = b
+// 配送期限の近い注文の扱い
+// 期限までwindowを切った注文は、期限に近づくほど最大boostまで価値を上乗せして選ぶ
+// 期限までforceWithinを切った（または過ぎた）注文は、どの優先度よりも先に積む
+type deadlinePolicy struct {
+	boost       model.Points
+	window      time.Duration
+	forceWithin time.Duration
+}
+
+// 期限切れ間近の注文に割り当てる、どの優先度よりも高い選定上の優先度
+const deadlineForcedPriority = model.OrderPriorityUrgent + 1
+
+// 重量0の注文を上限で打ち切る際の優先順
+const (
+	zeroWeightOldestFirst = "oldest"
+	zeroWeightValueFirst  = "value"
+)
+
+// 配送計画の選定に関する設定（プランナープロファイルの内容）
+type planOptions struct {
+	// 1計画に含める重量0の注文の上限（0以下は無制限）
+	zeroWeightCap    int
+	zeroWeightPolicy string
+	// exact: 厳密解, greedy: 価値密度順の貪欲解
+	algorithm string
+	// exactで、貪欲解が上界の(1-epsilon)倍以上ならDPを省略して貪欲解を使う
+	epsilon float64
+	// aging: 待ち時間1時間ごとにagingBoostだけ価値を上乗せして選定する（計画の合計価値は元の価値）
+	fairnessMode string
+	agingBoost   model.Points
+
+	// 計画ごとに指定されるロボットの容積の上限（0以下なら容積は制約しない）
+	volumeCapacity model.CubicCentimeters
+	// 配送期限の扱い。プロファイルによらずRobotServiceの設定を使う
+	deadline deadlinePolicy
+	// ユーザー間の公平性の扱い。プロファイルによらずRobotServiceの設定を使う
+	userFairness userFairnessPolicy
+	// 1計画に含める注文数の上限（0以下なら無制限）。解法によらず選定後に適用する
+	// プロファイルによらずRobotServiceの設定を使う
+	maxItems int
+	// 近似解を採用する場面で、分枝限定法による厳密解の探索に使える時間（0以下なら探索しない）
+	// プロファイルによらずRobotServiceの設定を使う
+	exactBudget time.Duration
+	// DPの表を分けて計算するワーカー数の上限（1以下なら並列化しない）
+	// プロファイルによらずRobotServiceの設定を使う
+	parallelism int
+	// 0より大きければ、リクエストの期限までの残り時間のこの割合を使った時点で選定を打ち切り、
+	// 打ち切った層は貪欲解で計画する。プロファイルによらずRobotServiceの設定を使う
+	anytime float64
+	// 解法ごとの集計の記録先（nilなら記録しない）
+	solverStats *telemetry.SolverStats
+	// algorithmに登録された解法。nilなら組み込みの解法から選ぶ
+	solver Solver
+}
+
+// RobotOption はNewRobotServiceの既定の設定を上書きする
+type RobotOption func(*planOptions)
+
+// WithPlanEpsilon は既定プロファイルの近似の許容誤差を設定する
+// 0なら常に厳密解を求め、大きくするほど精度と引き換えに計画の生成が速くなる
+// 範囲外の値は無視し、環境変数または既定値を使う
+func WithPlanEpsilon(epsilon float64) RobotOption {
+	return func(o *planOptions) {
+		if err := validatePlanEpsilon(epsilon); err != nil {
+			log.Printf("Ignoring plan epsilon %v: %v", epsilon, err)
+			return
 		}
+		o.epsilon = epsilon
 	}
+}
 
-	supplyTarget := 500
-	if v := os.Getenv("ROBOT_SHIPPING_SUPPLY_TARGET"); v != "" {
+// WithExactBudget は近似解を分枝限定法で改善する際の時間予算を設定する。0以下なら改善しない
+func WithExactBudget(budget time.Duration) RobotOption {
+	return func(o *planOptions) {
+		o.exactBudget = budget
+	}
+}
+
+// 許容誤差は[0, 1)。1以上では貪欲解の価値によらず常に貪欲解を採用してしまう
+func validatePlanEpsilon(epsilon float64) error {
+	if math.IsNaN(epsilon) || epsilon < 0 || epsilon >= 1 {
+		return fmt.Errorf("epsilon must be in [0, 1), got %v", epsilon)
+	}
+	return nil
+}
+
+func NewRobotService(store *repository.Store, events *OrderEventBus, options ...RobotOption) *RobotService {
+	// 重量0の注文は既定では打ち切らず、ROBOT_ZERO_WEIGHT_CAPを指定したときだけ上限を設ける
+	planOpts := planOptions{
+		zeroWeightPolicy: zeroWeightOldestFirst,
+		algorithm:        plannerExact,
+		fairnessMode:     fairnessNone,
+		exactBudget:      50 * time.Millisecond,
+		parallelism:      runtime.GOMAXPROCS(0),
+	}
+	if v := os.Getenv("ROBOT_ZERO_WEIGHT_CAP"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
-			supplyTarget = n
+			planOpts.zeroWeightCap = n
+		}
+	}
+	switch v := os.Getenv("ROBOT_ZERO_WEIGHT_POLICY"); v {
+	case zeroWeightOldestFirst, zeroWeightValueFirst:
+		planOpts.zeroWeightPolicy = v
+	case "":
+	default:
+		log.Printf("Unknown ROBOT_ZERO_WEIGHT_POLICY %q, using %q", v, zeroWeightOldestFirst)
+	}
+	if v := os.Getenv("ROBOT_PLAN_EPSILON"); v != "" {
+		eps, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			err = validatePlanEpsilon(eps)
+		}
+		if err != nil {
+			log.Printf("Invalid ROBOT_PLAN_EPSILON %q, using %v: %v", v, planOpts.epsilon, err)
+		} else {
+			planOpts.epsilon = eps
+		}
+	}
+	// 0を指定すると分枝限定法による改善を無効にできる
+	if v := os.Getenv("ROBOT_EXACT_BUDGET"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			planOpts.exactBudget = d
+		}
+	}
+	if v := os.Getenv("ROBOT_PLAN_PARALLELISM"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 {
+			planOpts.parallelism = n
+		}
+	}
+	if v := os.Getenv("ROBOT_PLAN_ANYTIME"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 && f < 1 {
+			planOpts.anytime = f
+		} else {
+			log.Printf("Invalid ROBOT_PLAN_ANYTIME %q, must be in (0, 1)", v)
 		}
 	}
-	if supplyTarget < 0 {
-		supplyTarget = 0
+	for _, option := range options {
+		option(&planOpts)
+	}
+
+	deadline := deadlinePolicy{
+		boost:       model.Points(50),
+		window:      parseDurationEnv("ROBOT_DEADLINE_WINDOW", 6*time.Hour),
+		forceWithin: 30 * time.Minute,
+	}
+	if v := os.Getenv("ROBOT_DEADLINE_BOOST"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			deadline.boost = model.Points(n)
+		}
+	}
+	// 0を指定すると強制的に積む扱いを無効にできる
+	if v := os.Getenv("ROBOT_DEADLINE_FORCE_WITHIN"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			deadline.forceWithin = d
+		}
+	}
+
+	// 同じ注文の集合に対する選定結果を使い回す時間。0を指定すると無効になる
+	results := newPlanResultCache(parseDurationEnv("ROBOT_PLAN_CACHE_TTL", time.Second))
+	if events != nil {
+		events.AddListener(results)
 	}
 
 	return &RobotService{
 		store:        store,
-		cloneEnabled: cloneEnabled,
-		supplyTarget: supplyTarget,
+		events:       events,
+		supply:       newSupplyPolicyFromEnv(),
+		eta:          newCostModelFromEnv(),
+		planner:      newPlannerProfileService(store, planOpts),
+		chunks:       newPlanChunkStore(parseDurationEnv("ROBOT_PLAN_CHUNK_TTL", 10*time.Minute)),
+		deadline:     deadline,
+		userFairness: newUserFairnessPolicy(),
+		exactBudget:  planOpts.exactBudget,
+		parallelism:  planOpts.parallelism,
+		anytime:      planOpts.anytime,
+		results:      results,
+		solverStats:  telemetry.NewSolverStats(),
+		window:       parseIntEnv("ROBOT_PLAN_WINDOW", 0),
+		maxItems:     parseIntEnv("ROBOT_PLAN_MAX_ITEMS", 0),
+		releaseAfter: parseDurationEnv("ROBOT_PLAN_RELEASE_AFTER", 0),
+		reapEvery:    parseDurationEnv("ROBOT_PLAN_REAP_INTERVAL", time.Minute),
+
+		heartbeatTimeout:   parseDurationEnv("ROBOT_HEARTBEAT_TIMEOUT", 0),
+		maxDeliveryRetries: parseIntEnv("ROBOT_MAX_DELIVERY_RETRIES", 3),
+	}
+}
+
+// resolvePlan はロボットのプロファイルを解決し、計画ごとの指定とサービス全体の設定を反映した選定設定を返す
+func (s *RobotService) resolvePlan(spec model.RobotSpec) (string, planOptions) {
+	profile, opts := s.planner.Resolve(spec.RobotID)
+	opts.volumeCapacity = spec.VolumeCapacity
+	opts.deadline = s.deadline
+	opts.userFairness = s.userFairness
+	opts.maxItems = s.maxItems
+	opts.exactBudget = s.exactBudget
+	opts.parallelism = s.parallelism
+	opts.anytime = s.anytime
+	opts.solverStats = s.solverStats
+	opts.solver, _ = s.planner.solvers.lookup(opts.algorithm)
+	return profile, opts
+}
+
+// RegisterSolver は解法をnameで登録する。プランナープロファイルのalgorithmにnameを指定すると使われる
+// 組み込みの解法（exact, greedy, dp, branch_and_bound）と同じ名前なら置き換える
+func (s *RobotService) RegisterSolver(name string, solver Solver) error {
+	return s.planner.solvers.register(name, solver)
+}
+
+// SolverStats は配送計画の選定に使った解法ごとの集計を返す
+func (s *RobotService) SolverStats() *telemetry.SolverStats {
+	return s.solverStats
+}
+
+// Planner はロボットごとのプランナープロファイルを管理するサービスを返す
+func (s *RobotService) Planner() *PlannerProfileService {
+	return s.planner
+}
+
+var (
+	ErrRobotNotFound = errors.New("robot not found")
+	ErrRobotInactive = errors.New("robot is not active")
+	ErrRobotSilent   = errors.New("robot has not sent a heartbeat recently")
+	// 他のロボットが引き当てた注文のステータスを報告した
+	ErrOrderNotAssigned = errors.New("order is assigned to another robot")
+)
+
+// admitRobot は登録済みの稼働中のロボットか確かめ、積載量を登録の上限に切り詰めたspecを返す
+func (s *RobotService) admitRobot(ctx context.Context, spec model.RobotSpec) (model.RobotSpec, error) {
+	robot, err := s.store.RobotRepo.FindByID(ctx, spec.RobotID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return spec, fmt.Errorf("%w: %s", ErrRobotNotFound, spec.RobotID)
+		}
+		return spec, err
+	}
+	if robot.Status != model.RobotStatusActive {
+		return spec, fmt.Errorf("%w: %s is %s", ErrRobotInactive, spec.RobotID, robot.Status)
+	}
+	if s.heartbeatTimeout > 0 && robot.LastHeartbeatAt.Valid && time.Since(robot.LastHeartbeatAt.Time) > s.heartbeatTimeout {
+		return spec, fmt.Errorf("%w: %s last seen at %s", ErrRobotSilent, spec.RobotID, robot.LastHeartbeatAt.Time.Format(time.RFC3339))
 	}
+	if spec.Capacity > robot.MaxCapacity {
+		spec.Capacity = robot.MaxCapacity
+	}
+	return spec, nil
+}
+
+// Heartbeat はロボットの生存の通知を記録する
+func (s *RobotService) Heartbeat(ctx context.Context, robotID string) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		err := s.store.RobotRepo.Heartbeat(ctx, robotID, time.Now())
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrRobotNotFound, robotID)
+		}
+		return err
+	})
 }
 
-func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string, capacity int) (*model.DeliveryPlan, error) {
+// GenerateDeliveryPlan はspecのロボットの配送計画を生成し、選んだ注文を引き当てる
+func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, spec model.RobotSpec) (*model.DeliveryPlan, error) {
 	var plan model.DeliveryPlan
+	profile, opts := s.resolvePlan(spec)
+	var solveTime time.Duration
 
 	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
-		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-			orders, err := txStore.OrderRepo.GetShippingOrders(ctx)
+		var err error
+		if spec, err = s.admitRobot(ctx, spec); err != nil {
+			return err
+		}
+		return s.claimTx(ctx, func(txStore *repository.Store, taken map[int64]bool, final bool) error {
+			var err error
+			plan, solveTime, err = s.planOrders(ctx, txStore, spec, profile, opts, taken)
 			if err != nil {
 				return err
 			}
-			plan, err = selectOrdersForDelivery(ctx, orders, robotID, capacity)
+			lost, err := lockPlanOrders(ctx, txStore, &plan, taken)
 			if err != nil {
 				return err
 			}
-			if len(plan.Orders) > 0 {
-				orderIDs := make([]int64, len(plan.Orders))
-				for i, order := range plan.Orders {
-					orderIDs[i] = order.OrderID
-				}
+			if lost > 0 && len(plan.Orders) == 0 && !final {
+				return errOrdersTaken
+			}
+			return claimPlan(ctx, txStore, &plan, solveTime)
+		})
+	})
+	s.planner.record(profile, &plan, spec.Capacity, solveTime, err)
+	if err != nil {
+		return nil, err
+	}
+	if orderIDs := planOrderIDs(&plan); len(orderIDs) > 0 {
+		s.events.Publish(orderIDs, "delivering")
+	}
+	return &plan, nil
+}
+
+// 選んだ注文を他のロボットに先に引き当てられたときに、計画を選び直す回数の上限
+const planClaimAttempts = 3
 
-				if err := txStore.OrderRepo.UpdateStatuses(ctx, orderIDs, "delivering"); err != nil {
+// errOrdersTaken は選んだ注文がすべて他のロボットに先に引き当てられ、計画を選び直すことを表す
+var errOrdersTaken = errors.New("planned orders were claimed by another robot")
+
+// claimTx は計画を選んで引き当てるfnをトランザクションで実行する
+// 注文は行ロックを取らずに読んで選定し、引き当てる注文にだけlockPlanOrdersでロックを取る
+// fnがerrOrdersTakenを返したらロールバックし、先に引き当てられた注文（taken）を候補から外して選び直す
+// 最後の試行（final）ではfnはerrOrdersTakenを返さず、残った注文だけで引き当てる
+func (s *RobotService) claimTx(ctx context.Context, fn func(txStore *repository.Store, taken map[int64]bool, final bool) error) error {
+	taken := make(map[int64]bool)
+	for attempt := 1; ; attempt++ {
+		err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			return fn(txStore, taken, attempt == planClaimAttempts)
+		})
+		if !errors.Is(err, errOrdersTaken) || attempt == planClaimAttempts {
+			return err
+		}
+	}
+}
+
+// lockPlanOrders は計画の注文に行ロックを取り、他のロボットが先に引き当てた（ロック中または配送待ちでない）注文を計画から外す
+// 外した注文はtakenに加え、外した件数を返す。残りの注文は元の計画の部分集合なので積載量の制約は満たしたまま
+func lockPlanOrders(ctx context.Context, txStore *repository.Store, plan *model.DeliveryPlan, taken map[int64]bool) (int, error) {
+	orderIDs := planOrderIDs(plan)
+	if len(orderIDs) == 0 {
+		return 0, nil
+	}
+	locked, err := txStore.OrderRepo.LockShippingOrders(ctx, orderIDs)
+	if err != nil {
+		return 0, err
+	}
+	if len(locked) == len(orderIDs) {
+		return 0, nil
+	}
+	isLocked := make(map[int64]bool, len(locked))
+	for _, id := range locked {
+		isLocked[id] = true
+	}
+	kept := make([]model.Order, 0, len(locked))
+	plan.TotalWeight, plan.TotalValue = 0, 0
+	var totalVolume model.CubicCentimeters
+	for _, o := range plan.Orders {
+		if !isLocked[o.OrderID] {
+			taken[o.OrderID] = true
+			continue
+		}
+		kept = append(kept, o)
+		plan.TotalWeight += o.Weight
+		plan.TotalValue += o.Value
+		totalVolume += o.Volume
+	}
+	if plan.TotalVolume != 0 {
+		plan.TotalVolume = totalVolume
+	}
+	lost := len(plan.Orders) - len(kept)
+	plan.Orders = kept
+	return lost, nil
+}
+
+// claimPlan は計画の注文をロボットが引き当てた（配送中）ことにして、計画を履歴に残す
+// 計画の注文にはlockPlanOrdersで行ロックを取っておく
+func claimPlan(ctx context.Context, txStore *repository.Store, plan *model.DeliveryPlan, solveTime time.Duration) error {
+	orderIDs := planOrderIDs(plan)
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	if err := recordStatusChange(ctx, txStore, orderIDs, "delivering", plan.RobotID); err != nil {
+		return err
+	}
+	if err := txStore.OrderRepo.AssignRobot(ctx, orderIDs, plan.RobotID); err != nil {
+		return err
+	}
+	if err := recordPlanHistory(ctx, txStore, plan, orderIDs, solveTime); err != nil {
+		return err
+	}
+	log.Printf("Updated status to 'delivering' for %d orders", len(orderIDs))
+	return nil
+}
+
+// recordPlanHistory は引き当てた計画を履歴に残す
+// 空の計画はポーリングのたびに行が増えるだけなので記録しない
+func recordPlanHistory(ctx context.Context, txStore *repository.Store, plan *model.DeliveryPlan, orderIDs []int64, solveTime time.Duration) error {
+	algorithm := "none"
+	if plan.Explanation != nil && len(plan.Explanation.Strategies) > 0 {
+		algorithm = strings.Join(plan.Explanation.Strategies, ",")
+	}
+	return txStore.PlanRepo.Insert(ctx, &model.DeliveryPlanRecord{
+		RobotID:     plan.RobotID,
+		Profile:     plan.Profile,
+		Algorithm:   algorithm,
+		OrderIDs:    orderIDs,
+		TotalWeight: plan.TotalWeight,
+		TotalValue:  plan.TotalValue,
+		TotalVolume: plan.TotalVolume,
+		DurationUs:  solveTime.Microseconds(),
+		CreatedAt:   time.Now(),
+	})
+}
+
+// ListPlans は配送計画の履歴を新しい順に返す。robotIDが空なら全ロボット分
+func (s *RobotService) ListPlans(ctx context.Context, robotID string, limit, offset int) ([]model.DeliveryPlanRecord, error) {
+	var records []model.DeliveryPlanRecord
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		records, err = s.store.PlanRepo.List(ctx, robotID, limit, offset)
+		return err
+	})
+	return records, err
+}
+
+// PreviewDeliveryPlan はspecのロボットの配送計画を生成するが、注文の引き当ては行わない
+// トランザクションもステータスの更新も行わないため、返した注文が実際の計画で選ばれるとは限らない
+func (s *RobotService) PreviewDeliveryPlan(ctx context.Context, spec model.RobotSpec) (*model.DeliveryPlan, error) {
+	var plan model.DeliveryPlan
+	profile, opts := s.resolvePlan(spec)
+
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		if spec, err = s.admitRobot(ctx, spec); err != nil {
+			return err
+		}
+		plan, _, err = s.planOrders(ctx, s.store, spec, profile, opts, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// loadShippingOrders は配送待ちの注文を読む。行ロックは取らない
+// windowが設定されていれば、全件ではなく計画に選ばれやすい順に上位window件だけを読む
+func (s *RobotService) loadShippingOrders(ctx context.Context, store *repository.Store) ([]model.Order, error) {
+	if s.window > 0 {
+		// 期限を考慮して価値を上乗せする注文は、価値密度によらず候補に残す
+		urgentBefore := time.Now().Add(max(s.deadline.window, s.deadline.forceWithin))
+		return store.OrderRepo.GetShippingOrderWindow(ctx, s.window, urgentBefore)
+	}
+	return store.OrderRepo.GetShippingOrders(ctx)
+}
+
+// withoutOrders はordersからtakenの注文を除いたものを返す
+func withoutOrders(orders []model.Order, taken map[int64]bool) []model.Order {
+	if len(taken) == 0 {
+		return orders
+	}
+	remaining := make([]model.Order, 0, len(orders))
+	for _, o := range orders {
+		if !taken[o.OrderID] {
+			remaining = append(remaining, o)
+		}
+	}
+	return remaining
+}
+
+// planOrders は配送待ちの注文を読み込んで計画を選定する
+// 注文の集合と指定が直前の選定と同じなら、選定をやり直さずにキャッシュした結果を返す
+// takenの注文は他のロボットが引き当て済みとして候補にしない
+func (s *RobotService) planOrders(ctx context.Context, store *repository.Store, spec model.RobotSpec, profile string, opts planOptions, taken map[int64]bool) (model.DeliveryPlan, time.Duration, error) {
+	generation := s.results.begin()
+	orders, err := s.loadShippingOrders(ctx, store)
+	if err != nil {
+		return model.DeliveryPlan{}, 0, err
+	}
+	return s.selectPlan(ctx, withoutOrders(orders, taken), spec, profile, opts, generation)
+}
+
+// selectPlan は読み込んだ注文から計画を選定する。generationは注文を読む前にs.results.beginで得た世代
+func (s *RobotService) selectPlan(ctx context.Context, orders []model.Order, spec model.RobotSpec, profile string, opts planOptions, generation uint64) (model.DeliveryPlan, time.Duration, error) {
+	key := planCacheKey(orders, spec, profile)
+	if plan, ok := s.results.get(key); ok {
+		return plan, 0, nil
+	}
+
+	start := time.Now()
+	telemetry.SetPhase(ctx, telemetry.PhasePlanning)
+	plan, err := selectOrdersForDelivery(ctx, orders, spec.RobotID, spec.Capacity, opts)
+	telemetry.SetPhase(ctx, telemetry.PhaseHandler)
+	if err != nil {
+		return model.DeliveryPlan{}, 0, err
+	}
+	solveTime := time.Since(start)
+	plan.Profile = profile
+	plan.EstimatedDuration = s.eta.Estimate(plan.Orders).Milliseconds()
+	s.results.put(key, generation, plan)
+	return plan, solveTime, nil
+}
+
+// 1回のバッチで計画を生成できるロボットの上限
+const maxBatchRobots = 32
+
+var ErrInvalidRobotBatch = errors.New("invalid robot batch")
+
+// GenerateDeliveryPlans は複数のロボットの配送計画を1つのトランザクションでまとめて生成・引き当てる
+// specsの並び順に計画を決め、先のロボットが選んだ注文は後のロボットの候補から外すため、
+// 同じ注文が2台のロボットに割り当てられることはない
+func (s *RobotService) GenerateDeliveryPlans(ctx context.Context, specs []model.RobotSpec) ([]model.DeliveryPlan, error) {
+	if err := validateRobotSpecs(specs); err != nil {
+		return nil, err
+	}
+	// 積載量を登録の上限に切り詰めるため、呼び出し元のspecsは書き換えない
+	specs = append([]model.RobotSpec(nil), specs...)
+	profiles := make([]string, len(specs))
+	opts := make([]planOptions, len(specs))
+	for i, spec := range specs {
+		profiles[i], opts[i] = s.resolvePlan(spec)
+	}
+
+	var (
+		plans      []model.DeliveryPlan
+		solveTimes []time.Duration
+	)
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		for i := range specs {
+			var err error
+			if specs[i], err = s.admitRobot(ctx, specs[i]); err != nil {
+				return err
+			}
+		}
+		return s.claimTx(ctx, func(txStore *repository.Store, taken map[int64]bool, final bool) error {
+			orders, err := s.loadShippingOrders(ctx, txStore)
+			if err != nil {
+				return err
+			}
+			telemetry.SetPhase(ctx, telemetry.PhasePlanning)
+			plans, solveTimes, err = planBatch(ctx, withoutOrders(orders, taken), specs, opts)
+			telemetry.SetPhase(ctx, telemetry.PhaseHandler)
+			if err != nil {
+				return err
+			}
+			for i := range plans {
+				lost, err := lockPlanOrders(ctx, txStore, &plans[i], taken)
+				if err != nil {
+					return err
+				}
+				if lost > 0 && len(plans[i].Orders) == 0 && !final {
+					return errOrdersTaken
+				}
+			}
+			for i := range plans {
+				plans[i].Profile = profiles[i]
+				if err := claimPlan(ctx, txStore, &plans[i], solveTimes[i]); err != nil {
 					return err
 				}
-				log.Printf("Updated status to 'delivering' for %d orders", len(orderIDs))
 			}
 			return nil
 		})
 	})
+	for i, spec := range specs {
+		var (
+			plan    *model.DeliveryPlan
+			elapsed time.Duration
+		)
+		if err == nil {
+			plan, elapsed = &plans[i], solveTimes[i]
+		}
+		s.planner.record(profiles[i], plan, spec.Capacity, elapsed, err)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &plan, nil
+	for i := range plans {
+		if orderIDs := planOrderIDs(&plans[i]); len(orderIDs) > 0 {
+			s.events.Publish(orderIDs, "delivering")
+		}
+	}
+	return plans, nil
 }
 
-func (s *RobotService) UpdateOrderStatus(ctx context.Context, orderID int64, newStatus string) error {
-	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+func validateRobotSpecs(specs []model.RobotSpec) error {
+	if len(specs) == 0 || len(specs) > maxBatchRobots {
+		return fmt.Errorf("%w: robots must contain 1-%d entries", ErrInvalidRobotBatch, maxBatchRobots)
+	}
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if spec.RobotID == "" || len(spec.RobotID) > 64 {
+			return fmt.Errorf("%w: robot_id must be 1-64 characters", ErrInvalidRobotBatch)
+		}
+		if seen[spec.RobotID] {
+			return fmt.Errorf("%w: duplicate robot_id %q", ErrInvalidRobotBatch, spec.RobotID)
+		}
+		seen[spec.RobotID] = true
+	}
+	return nil
+}
+
+// planBatch はspecsの順にロボットごとの計画を決め、選ばれた注文を以降の候補から外す
+func planBatch(ctx context.Context, orders []model.Order, specs []model.RobotSpec, opts []planOptions) ([]model.DeliveryPlan, []time.Duration, error) {
+	plans := make([]model.DeliveryPlan, len(specs))
+	solveTimes := make([]time.Duration, len(specs))
+	pool := orders
+	for i, spec := range specs {
+		start := time.Now()
+		plan, err := selectOrdersForDelivery(ctx, pool, spec.RobotID, spec.Capacity, opts[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		solveTimes[i] = time.Since(start)
+		plans[i] = plan
+
+		claimed := make(map[int64]bool, len(plan.Orders))
+		for _, o := range plan.Orders {
+			claimed[o.OrderID] = true
+		}
+		remaining := make([]model.Order, 0, len(pool)-len(claimed))
+		for _, o := range pool {
+			if !claimed[o.OrderID] {
+				remaining = append(remaining, o)
+			}
+		}
+		pool = remaining
+	}
+	return plans, solveTimes, nil
+}
+
+func planOrderIDs(plan *model.DeliveryPlan) []int64 {
+	orderIDs := make([]int64, len(plan.Orders))
+	for i, order := range plan.Orders {
+		orderIDs[i] = order.OrderID
+	}
+	return orderIDs
+}
+
+// GenerateChunkedDeliveryPlan は配送計画を生成・引き当てし、先頭のchunkSize件だけを返す
+// 残りはplan_idと返されたカーソルでPlanChunkから取得する
+func (s *RobotService) GenerateChunkedDeliveryPlan(ctx context.Context, spec model.RobotSpec, chunkSize int) (*model.DeliveryPlan, error) {
+	plan, err := s.GenerateDeliveryPlan(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	return s.chunks.save(plan, chunkSize)
+}
+
+// PlanChunk は分割された配送計画の続きを返す
+func (s *RobotService) PlanChunk(planID, cursor string, chunkSize int) (*model.DeliveryPlan, error) {
+	return s.chunks.chunk(planID, cursor, chunkSize)
+}
+
+// UpdateOrderStatus はrobotIDのロボットの報告で注文のステータスを更新する。許されない遷移は*StatusTransitionErrorを返す
+func (s *RobotService) UpdateOrderStatus(ctx context.Context, robotID string, orderID int64, newStatus string) error {
+	return s.applyStatusUpdates(ctx, robotID, []model.UpdateOrderStatusRequest{{OrderID: orderID, NewStatus: newStatus}})
+}
+
+// 1回のリクエストでまとめて更新できる注文ステータスの上限
+const maxBulkStatusUpdates = 500
+
+// ロボットが設定できる注文ステータス
+var robotSettableStatuses = map[string]bool{"shipping": true, "delivering": true, "completed": true}
+
+var ErrInvalidStatusUpdate = errors.New("invalid order status update")
+
+// UpdateOrderStatuses はrobotIDのロボットの報告で複数の注文のステータスを1つのトランザクションでまとめて更新する
+// 1件でも不正な指定があれば何も更新しない。配送完了した注文ごとに在庫の補充も同じトランザクションで行う
+func (s *RobotService) UpdateOrderStatuses(ctx context.Context, robotID string, updates []model.UpdateOrderStatusRequest) error {
+	if err := validateStatusUpdates(updates); err != nil {
+		return err
+	}
+	return s.applyStatusUpdates(ctx, robotID, updates)
+}
+
+// applyStatusUpdates は注文に行ロックを取って現在のステータスからの遷移を検証し、すべて許される場合のみ更新する
+// robotIDはイベントの主体として記録する
+func (s *RobotService) applyStatusUpdates(ctx context.Context, robotID string, updates []model.UpdateOrderStatusRequest) error {
+	// 同じステータスへの変更は1回の追記にまとめる
+	var statuses []string
+	byStatus := make(map[string][]int64)
+	for _, u := range updates {
+		if _, ok := byStatus[u.NewStatus]; !ok {
+			statuses = append(statuses, u.NewStatus)
+		}
+		byStatus[u.NewStatus] = append(byStatus[u.NewStatus], u.OrderID)
+	}
+
+	cloned := make(map[int][]int64)
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
 		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-			if err := txStore.OrderRepo.UpdateStatuses(ctx, []int64{orderID}, newStatus); err != nil {
+			orderIDs := make([]int64, len(updates))
+			for i, u := range updates {
+				orderIDs[i] = u.OrderID
+			}
+			current, err := txStore.OrderRepo.LockStatuses(ctx, orderIDs)
+			if err != nil {
 				return err
 			}
-			if newStatus == "completed" && s.cloneEnabled && s.supplyTarget > 0 {
-				shippingCount, err := txStore.OrderRepo.CountShipping(ctx)
+			for _, u := range updates {
+				order, ok := current[u.OrderID]
+				if !ok {
+					return fmt.Errorf("%w: %d", ErrOrderNotFound, u.OrderID)
+				}
+				if err := checkAssignment(order, robotID); err != nil {
+					return err
+				}
+				if err := checkTransition(u.OrderID, order.ShippedStatus, u.NewStatus); err != nil {
+					return err
+				}
+			}
+
+			for _, status := range statuses {
+				if err := recordStatusChange(ctx, txStore, byStatus[status], status, robotID); err != nil {
+					return err
+				}
+			}
+			for _, orderID := range byStatus["completed"] {
+				replenished, err := replenish(ctx, txStore, s.supply, orderID)
 				if err != nil {
 					return err
 				}
-				if shippingCount < s.supplyTarget {
-					if err := txStore.OrderRepo.CloneAsShipping(ctx, []int64{orderID}); err != nil {
-						return err
-					}
+				for ownerID, ids := range replenished {
+					cloned[ownerID] = append(cloned[ownerID], ids...)
 				}
 			}
 			return nil
 		})
 	})
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		s.events.Publish(byStatus[status], status)
+	}
+	for ownerID, clonedIDs := range cloned {
+		s.events.PublishCreated(ownerID, clonedIDs)
+	}
+	return nil
 }
 
-type pathNode struct {
-	itemIndex int
-	prevIdx   int
+// checkAssignment は注文を引き当てたロボット以外からの報告ならErrOrderNotAssignedを返す
+// 引き当てたロボットが記録されていない注文（配送待ちや記録を始める前に引き当てた注文）は誰からの報告も受け付ける
+func checkAssignment(order model.Order, robotID string) error {
+	if order.AssignedRobotID.Valid && order.AssignedRobotID.String != robotID {
+		return fmt.Errorf("%w: order %d", ErrOrderNotAssigned, order.OrderID)
+	}
+	return nil
 }
 
-func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID string, robotCapacity int) (model.DeliveryPlan, error) {
+func validateStatusUpdates(updates []model.UpdateOrderStatusRequest) error {
+	if len(updates) == 0 || len(updates) > maxBulkStatusUpdates {
+		return fmt.Errorf("%w: updates must contain 1-%d entries", ErrInvalidStatusUpdate, maxBulkStatusUpdates)
+	}
+	seen := make(map[int64]bool, len(updates))
+	for _, u := range updates {
+		if u.OrderID <= 0 {
+			return fmt.Errorf("%w: order_id must be positive", ErrInvalidStatusUpdate)
+		}
+		if !robotSettableStatuses[u.NewStatus] {
+			return fmt.Errorf("%w: unknown status %q for order %d", ErrInvalidStatusUpdate, u.NewStatus, u.OrderID)
+		}
+		if seen[u.OrderID] {
+			return fmt.Errorf("%w: duplicate order_id %d", ErrInvalidStatusUpdate, u.OrderID)
+		}
+		seen[u.OrderID] = true
+	}
+	return nil
+}
+
+// selectOrdersForDelivery はordersから積載量の範囲で積む注文を選ぶ。ordersは書き換えないため、並行して呼んでも共有してよい
+func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID string, robotCapacity model.Grams, opts planOptions) (model.DeliveryPlan, error) {
 	if robotCapacity <= 0 || len(orders) == 0 {
 		return model.DeliveryPlan{RobotID: robotID, Orders: make([]model.Order, 0)}, nil
 	}
 
-	// フィルタ: 積載量を超える注文は候補外に
-	filtered := orders[:0]
+	// 容積の上限が指定されていれば、重量と容積の両方で選ぶ
+	volumeLimited := opts.volumeCapacity > 0
+
+	// フィルタ: 積載量（容積）を超える注文は候補外に
+	// 呼び出し元の注文は複数の計画で共有されうるため書き換えず、以降はこの関数で確保したスライスだけを並べ替える
+	filtered := make([]model.Order, 0, len(orders))
 	for _, o := range orders {
-		if o.Weight <= robotCapacity {
+		if o.Weight <= robotCapacity && (!volumeLimited || o.Volume <= opts.volumeCapacity) {
 			filtered = append(filtered, o)
 		}
 	}
@@ -120,19 +824,39 @@ func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID
 
 	var zeroWeightOrders []model.Order
 	positiveOrders := orders[:0]
-	totalWeight := 0
+	var (
+		totalWeight model.Grams
+		totalVolume model.CubicCentimeters
+	)
 	for _, o := range orders {
-		if o.Weight == 0 {
+		// 容積を制約する場合、重量0でも容積のある注文は積載枠を使うため選定の対象にする
+		if o.Weight == 0 && (!volumeLimited || o.Volume == 0) {
 			zeroWeightOrders = append(zeroWeightOrders, o)
 			continue
 		}
 		positiveOrders = append(positiveOrders, o)
 		totalWeight += o.Weight
+		totalVolume += o.Volume
+	}
+
+	now := time.Now()
+	explanation := &model.PlanExplanation{ZeroWeightCandidates: len(zeroWeightOrders)}
+	zeroWeightOrders = capZeroWeightOrders(zeroWeightOrders, opts, now)
+	// 重量0の注文だけで計画の注文数の上限を超える場合は、ここで上限まで絞る
+	if opts.maxItems > 0 && len(zeroWeightOrders) > opts.maxItems {
+		zeroWeightOrders = zeroWeightOrders[:opts.maxItems]
+	}
+	explanation.ZeroWeightIncluded = len(zeroWeightOrders)
+	explanation.ZeroWeightDeferred = explanation.ZeroWeightCandidates - explanation.ZeroWeightIncluded
+	if explanation.ZeroWeightDeferred > 0 {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf(
+			"zero-weight orders capped at %d (%s first); %d left in pool",
+			opts.zeroWeightCap, opts.zeroWeightPolicy, explanation.ZeroWeightDeferred))
 	}
 
 	selected := make([]model.Order, 0, len(orders))
 	selected = append(selected, zeroWeightOrders...)
-	totalValue := 0
+	var totalValue model.Points
 	for _, o := range zeroWeightOrders {
 		totalValue += o.Value
 	}
@@ -143,6 +867,7 @@ func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID
 			TotalWeight: 0,
 			TotalValue:  totalValue,
 			Orders:      selected,
+			Explanation: explanation,
 		}, nil
 	}
 
@@ -150,67 +875,32 @@ func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID
 	if totalWeight < effectiveCap {
 		effectiveCap = totalWeight
 	}
-	if effectiveCap <= 0 {
+	effectiveVolume := opts.volumeCapacity
+	if totalVolume < effectiveVolume {
+		effectiveVolume = totalVolume
+	}
+	if effectiveCap <= 0 && effectiveVolume <= 0 {
 		return model.DeliveryPlan{
 			RobotID:     robotID,
 			TotalWeight: 0,
 			TotalValue:  totalValue,
 			Orders:      selected,
+			Explanation: explanation,
 		}, nil
 	}
 
-	bestValue := make([]int, effectiveCap+1)
-	bestPathIdx := make([]int, effectiveCap+1)
-	for i := range bestPathIdx {
-		bestPathIdx[i] = -1
-	}
-	paths := make([]pathNode, 0, len(positiveOrders))
-
-	const checkEvery = 4096
-	steps := 0
-
-	for i, order := range positiveOrders {
-		if err := ctx.Err(); err != nil {
-			return model.DeliveryPlan{}, err
-		}
-		w := order.Weight
-		if w > effectiveCap {
-			continue
-		}
-		v := order.Value
-		for currentCap := effectiveCap; currentCap >= w; currentCap-- {
-			candidate := bestValue[currentCap-w] + v
-			if candidate > bestValue[currentCap] {
-				bestValue[currentCap] = candidate
-				prevIdx := bestPathIdx[currentCap-w]
-				pathIdx := len(paths)
-				paths = append(paths, pathNode{itemIndex: i, prevIdx: prevIdx})
-				bestPathIdx[currentCap] = pathIdx
-			}
-			steps++
-			if steps%checkEvery == 0 {
-				select {
-				case <-ctx.Done():
-					return model.DeliveryPlan{}, ctx.Err()
-				default:
-				}
-			}
-		}
+	candidates := deadlineAdjustedOrders(agedOrders(positiveOrders, opts, now), opts, now)
+	candidates = userFairOrders(candidates, opts.userFairness, robotCapacity, explanation)
+	chosen, err := solveByPriority(ctx, candidates, effectiveCap, effectiveVolume, opts, explanation)
+	if err != nil {
+		return model.DeliveryPlan{}, err
 	}
-
-	bestCap := 0
-	maxValue := 0
-	for cap := 0; cap <= effectiveCap; cap++ {
-		if bestValue[cap] > maxValue {
-			maxValue = bestValue[cap]
-			bestCap = cap
+	for i, ok := range chosen {
+		if ok {
+			selected = append(selected, positiveOrders[i])
 		}
 	}
 
-	for idx := bestPathIdx[bestCap]; idx != -1; idx = paths[idx].prevIdx {
-		selected = append(selected, positiveOrders[paths[idx].itemIndex])
-	}
-
 	if len(selected) == len(zeroWeightOrders) {
 		fallbackIdx := -1
 		for i, order := range positiveOrders {
@@ -226,23 +916,228 @@ func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID
 		}
 	}
 
-	if len(selected) > len(zeroWeightOrders) {
-		for i, j := len(zeroWeightOrders), len(selected)-1; i < j; i, j = i+1, j-1 {
-			selected[i], selected[j] = selected[j], selected[i]
-		}
-	}
+	selected = capPlanItems(selected, opts.maxItems, explanation)
 
 	totalWeight = 0
 	totalValue = 0
+	totalVolume = 0
 	for _, o := range selected {
 		totalWeight += o.Weight
 		totalValue += o.Value
+		totalVolume += o.Volume
 	}
 
-	return model.DeliveryPlan{
+	plan := model.DeliveryPlan{
 		RobotID:     robotID,
 		TotalWeight: totalWeight,
 		TotalValue:  totalValue,
 		Orders:      selected,
-	}, nil
+		Explanation: explanation,
+	}
+	if volumeLimited {
+		plan.TotalVolume = totalVolume
+	}
+	return plan, nil
+}
+
+// solveByPriority は優先度の高い層から順に、残りの積載量の範囲で価値が最大になる注文を選ぶ
+// 上の層の注文は下の層の価値によらず先に積むため、優先度は価値より常に優先される
+// volumeCapが正なら重量と容積の両方を、そうでなければ重量のみを制約とする
+func solveByPriority(ctx context.Context, items []model.Order, weightCap model.Grams, volumeCap model.CubicCentimeters, opts planOptions, explanation *model.PlanExplanation) ([]bool, error) {
+	byTier := make(map[int][]int)
+	for i, item := range items {
+		byTier[item.Priority] = append(byTier[item.Priority], i)
+	}
+	tiers := make([]int, 0, len(byTier))
+	for tier := range byTier {
+		tiers = append(tiers, tier)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(tiers)))
+
+	// 登録されていない解法のプロファイルは厳密解で計画する
+	solver := opts.solver
+	if solver == nil {
+		solver = builtinSolvers[opts.algorithm]
+	}
+	if solver == nil {
+		solver = exactSolver{}
+	}
+
+	solveCtx, cancel := anytimeContext(ctx, opts.anytime)
+	defer cancel()
+
+	chosen := make([]bool, len(items))
+	remainingW, remainingV := weightCap, volumeCap
+	approximated := 0
+	cutOff := 0
+	for _, tier := range tiers {
+		idx := byTier[tier]
+		sub := items
+		if len(tiers) > 1 {
+			sub = make([]model.Order, len(idx))
+			for j, i := range idx {
+				sub[j] = items[i]
+			}
+		}
+
+		// 候補に容積のある注文がなければ、容積の上限は計画に影響しない
+		constraints := solverConstraints(remainingW, remainingV, volumeCap > 0, opts)
+		start := time.Now()
+		result, err := solver.Solve(solveCtx, sub, constraints)
+		if err != nil && solveCtx.Err() != nil && ctx.Err() == nil {
+			// 打ち切りの期限を過ぎた層は、期限内に必ず求まる貪欲解で計画する
+			result, err = greedySolver{}.Solve(ctx, sub, constraints)
+			cutOff++
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := validateSolverPlan(sub, constraints, result); err != nil {
+			return nil, err
+		}
+		if result.Strategy == "" {
+			result.Strategy = opts.algorithm
+		}
+		if !slices.Contains(explanation.Strategies, result.Strategy) {
+			explanation.Strategies = append(explanation.Strategies, result.Strategy)
+		}
+		if result.Approximate && volumeCap > 0 {
+			approximated += len(sub)
+		}
+		picked := result.Chosen
+		if opts.solverStats != nil {
+			recordSolve(opts.solverStats, result.Strategy, sub, picked, remainingW, remainingV, volumeCap > 0, time.Since(start))
+		}
+		for j, ok := range picked {
+			if !ok {
+				continue
+			}
+			i := j
+			if len(tiers) > 1 {
+				i = idx[j]
+			}
+			chosen[i] = true
+			remainingW -= items[i].Weight
+			remainingV -= items[i].Volume
+		}
+	}
+	if approximated > 0 {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf(
+			"weight and volume constrained plan approximated greedily over %d orders", approximated))
+	}
+	if cutOff > 0 {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf(
+			"solver stopped at %.0f%% of the request deadline; %d tiers planned greedily", opts.anytime*100, cutOff))
+	}
+	if len(tiers) > 1 {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf(
+			"orders planned in %d priority tiers, highest first", len(tiers)))
+	}
+	return chosen, nil
+}
+
+// capPlanItems は計画の注文数をmaxItemsまでに抑える。どの解法で選んだ計画にも適用する
+// 優先度の高い順、同じ優先度なら価値の高い順に残し、残した注文の並びは変えない
+func capPlanItems(selected []model.Order, maxItems int, explanation *model.PlanExplanation) []model.Order {
+	if maxItems <= 0 || len(selected) <= maxItems {
+		return selected
+	}
+	rank := make([]int, len(selected))
+	for i := range rank {
+		rank[i] = i
+	}
+	sort.SliceStable(rank, func(a, b int) bool {
+		x, y := selected[rank[a]], selected[rank[b]]
+		if x.Priority != y.Priority {
+			return x.Priority > y.Priority
+		}
+		return x.Value > y.Value
+	})
+	keep := make([]bool, len(selected))
+	for _, i := range rank[:maxItems] {
+		keep[i] = true
+	}
+	capped := make([]model.Order, 0, maxItems)
+	for i, o := range selected {
+		if keep[i] {
+			capped = append(capped, o)
+		}
+	}
+	explanation.Notes = append(explanation.Notes, fmt.Sprintf(
+		"plan capped at %d orders; %d selected orders left in pool", maxItems, len(selected)-maxItems))
+	return capped
+}
+
+// 重量0の注文を上限件数までに絞り込む。上限を超えた分は計画に含めず次回以降に残す
+// 優先度の高い注文から残す
+func capZeroWeightOrders(orders []model.Order, opts planOptions, now time.Time) []model.Order {
+	if opts.zeroWeightCap <= 0 || len(orders) <= opts.zeroWeightCap {
+		return orders
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if pa, pb := opts.deadline.priority(a, now), opts.deadline.priority(b, now); pa != pb {
+			return pa > pb
+		}
+		if opts.zeroWeightPolicy == zeroWeightValueFirst && a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.OrderID < b.OrderID
+	})
+	return orders[:opts.zeroWeightCap]
+}
+
+// 公平性モードがagingのとき、待ち時間に応じて価値を上乗せした選定用の注文を返す
+// 返す注文はordersと同じ並び。元の注文は書き換えない
+func agedOrders(orders []model.Order, opts planOptions, now time.Time) []model.Order {
+	if opts.fairnessMode != fairnessAging || opts.agingBoost <= 0 {
+		return orders
+	}
+	aged := make([]model.Order, len(orders))
+	for i, o := range orders {
+		aged[i] = o
+		if hours := int(now.Sub(o.CreatedAt).Hours()); hours > 0 {
+			aged[i].Value += model.Points(hours) * opts.agingBoost
+		}
+	}
+	return aged
+}
+
+// priority は配送期限を考慮した選定上の優先度を返す
+func (p deadlinePolicy) priority(o model.Order, now time.Time) int {
+	if p.forceWithin > 0 && o.DeliverBy.Valid && o.DeliverBy.Time.Sub(now) <= p.forceWithin {
+		return deadlineForcedPriority
+	}
+	return o.Priority
+}
+
+// 配送期限が近い注文の価値を上乗せし、期限切れ間近の注文を最優先にした選定用の注文を返す
+// 返す注文はordersと同じ並び。元の注文は書き換えない
+func deadlineAdjustedOrders(orders []model.Order, opts planOptions, now time.Time) []model.Order {
+	p := opts.deadline
+	var adjusted []model.Order
+	for i, o := range orders {
+		if !o.DeliverBy.Valid {
+			continue
+		}
+		if adjusted == nil {
+			adjusted = append([]model.Order(nil), orders...)
+		}
+		adjusted[i].Priority = p.priority(o, now)
+		remaining := o.DeliverBy.Time.Sub(now)
+		if p.boost > 0 && p.window > 0 && remaining < p.window {
+			urgency := 1 - float64(remaining)/float64(p.window)
+			if urgency > 1 {
+				urgency = 1
+			}
+			adjusted[i].Value += model.Points(math.Round(float64(p.boost) * urgency))
+		}
+	}
+	if adjusted == nil {
+		return orders
+	}
+	return adjusted
 }
@@ -2,9 +2,23 @@ package service
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/telemetry"
+
+	"github.com/jmoiron/sqlx"
 )
 
 func TestSelectOrdersForDeliveryBasic(t *testing.T) {
@@ -14,7 +28,7 @@ func TestSelectOrdersForDeliveryBasic(t *testing.T) {
 		{OrderID: 3, Weight: 6, Value: 30},
 	}
 
-	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 9)
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 9, planOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -36,7 +50,7 @@ func TestSelectOrdersForDeliveryBasic(t *testing.T) {
 }
 
 func TestSelectOrdersForDeliveryNoOrders(t *testing.T) {
-	plan, err := selectOrdersForDelivery(context.Background(), nil, "robot", 10)
+	plan, err := selectOrdersForDelivery(context.Background(), nil, "robot", 10, planOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -45,7 +59,7 @@ func TestSelectOrdersForDeliveryNoOrders(t *testing.T) {
 		t.Fatalf("expected empty slice, got nil")
 	}
 
-	plan, err = selectOrdersForDelivery(context.Background(), nil, "robot", 0)
+	plan, err = selectOrdersForDelivery(context.Background(), nil, "robot", 0, planOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -61,7 +75,7 @@ func TestSelectOrdersForDeliveryZeroWeight(t *testing.T) {
 		{OrderID: 3, Weight: 3, Value: 8},
 	}
 
-	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 2)
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 2, planOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -88,7 +102,7 @@ func TestSelectOrdersForDeliveryZeroValue(t *testing.T) {
 		{OrderID: 2, Weight: 4, Value: 0},
 	}
 
-	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 5)
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 5, planOptions{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -101,6 +115,48 @@ func TestSelectOrdersForDeliveryZeroValue(t *testing.T) {
 	}
 }
 
+func TestSelectOrdersForDeliveryLeavesInputUntouched(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 9, Value: 90},
+		{OrderID: 2, Weight: 0, Value: 5},
+		{OrderID: 3, Weight: 3, Value: 30},
+		{OrderID: 4, Weight: 2, Value: 25},
+	}
+	want := append([]model.Order(nil), orders...)
+
+	// 同じ候補を複数の計画で並行して使っても、互いの選定や候補を書き換えない（go test -raceで確認する）
+	const workers = 8
+	plans := make([]model.DeliveryPlan, workers)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			plans[i], err = selectOrdersForDelivery(context.Background(), orders, "robot", 5, planOptions{algorithm: plannerExact})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for i := range orders {
+		if !reflect.DeepEqual(orders[i], want[i]) {
+			t.Fatalf("input was modified at %d: %+v", i, orders)
+		}
+	}
+	for _, plan := range plans {
+		if plan.TotalValue != 60 || len(plan.Orders) != 3 {
+			t.Fatalf("unexpected plan: %+v", plan)
+		}
+	}
+}
+
 func TestSelectOrdersForDeliveryContextCanceled(t *testing.T) {
 	orders := make([]model.Order, 5)
 	for i := range orders {
@@ -110,8 +166,1298 @@ func TestSelectOrdersForDeliveryContextCanceled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	_, err := selectOrdersForDelivery(ctx, orders, "robot", 3)
+	_, err := selectOrdersForDelivery(ctx, orders, "robot", 3, planOptions{})
 	if err == nil {
 		t.Fatalf("expected error due to context cancellation")
 	}
 }
+
+func TestSelectOrdersForDeliveryZeroWeightCap(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 0, Value: 1},
+		{OrderID: 2, Weight: 0, Value: 9},
+		{OrderID: 3, Weight: 0, Value: 5},
+		{OrderID: 4, Weight: 2, Value: 3},
+	}
+
+	plan, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", 5,
+		planOptions{zeroWeightCap: 2, zeroWeightPolicy: zeroWeightOldestFirst})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Orders) != 3 || plan.Orders[0].OrderID != 1 || plan.Orders[1].OrderID != 2 {
+		t.Fatalf("expected oldest zero-weight orders 1 and 2, got %+v", plan.Orders)
+	}
+	if plan.Explanation == nil || plan.Explanation.ZeroWeightDeferred != 1 || len(plan.Explanation.Notes) == 0 {
+		t.Fatalf("expected truncation to be reported, got %+v", plan.Explanation)
+	}
+
+	plan, err = selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", 5,
+		planOptions{zeroWeightCap: 2, zeroWeightPolicy: zeroWeightValueFirst})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Orders[0].OrderID != 2 || plan.Orders[1].OrderID != 3 {
+		t.Fatalf("expected highest-value zero-weight orders 2 and 3, got %+v", plan.Orders)
+	}
+	if plan.TotalValue != 17 {
+		t.Fatalf("expected total value 17, got %d", plan.TotalValue)
+	}
+}
+
+func TestSelectOrdersForDeliveryMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 500; iter++ {
+		n := 1 + rng.Intn(12)
+		orders := make([]model.Order, n)
+		for i := range orders {
+			orders[i] = model.Order{OrderID: int64(i + 1), Weight: model.Grams(1 + rng.Intn(20)), Value: model.Points(rng.Intn(50))}
+		}
+		capacity := model.Grams(1 + rng.Intn(60))
+
+		var want model.Points
+		for mask := 0; mask < 1<<n; mask++ {
+			var (
+				w model.Grams
+				v model.Points
+			)
+			for i := 0; i < n; i++ {
+				if mask&(1<<i) != 0 {
+					w += orders[i].Weight
+					v += orders[i].Value
+				}
+			}
+			if w <= capacity && v > want {
+				want = v
+			}
+		}
+
+		plan, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", capacity, planOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plan.TotalWeight > capacity {
+			t.Fatalf("iteration %d: plan exceeds capacity %d: %+v", iter, capacity, plan)
+		}
+		if plan.TotalValue != want {
+			t.Fatalf("iteration %d: expected optimal value %d, got %d (capacity %d, orders %+v)", iter, want, plan.TotalValue, capacity, orders)
+		}
+	}
+}
+
+func TestPlanEpsilonConfiguration(t *testing.T) {
+	t.Setenv("ROBOT_PLAN_EPSILON", "0.2")
+	if eps := NewRobotService(nil, nil).planner.defaults.epsilon; eps != 0.2 {
+		t.Fatalf("expected epsilon from env, got %v", eps)
+	}
+	if eps := NewRobotService(nil, nil, WithPlanEpsilon(0.05)).planner.defaults.epsilon; eps != 0.05 {
+		t.Fatalf("expected the option to override env, got %v", eps)
+	}
+
+	for _, invalid := range []float64{-0.01, 1, 1.5, math.NaN(), math.Inf(1)} {
+		if err := validatePlanEpsilon(invalid); err == nil {
+			t.Fatalf("expected epsilon %v to be rejected", invalid)
+		}
+		if eps := NewRobotService(nil, nil, WithPlanEpsilon(invalid)).planner.defaults.epsilon; eps != 0.2 {
+			t.Fatalf("expected invalid epsilon %v to be ignored, got %v", invalid, eps)
+		}
+	}
+
+	t.Setenv("ROBOT_PLAN_EPSILON", "1")
+	if eps := NewRobotService(nil, nil).planner.defaults.epsilon; eps != 0 {
+		t.Fatalf("expected out-of-range env to fall back to exact, got %v", eps)
+	}
+}
+
+func TestPlanEpsilonExtremes(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for iter := 0; iter < 200; iter++ {
+		orders := make([]model.Order, 1+rng.Intn(10))
+		for i := range orders {
+			orders[i] = model.Order{OrderID: int64(i + 1), Weight: model.Grams(1 + rng.Intn(20)), Value: model.Points(1 + rng.Intn(50))}
+		}
+		capacity := model.Grams(1 + rng.Intn(60))
+
+		exact, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", capacity, planOptions{algorithm: plannerExact})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// 0.999は貪欲解をほぼ無条件に採用する上限。それでも積載量は守り、最適値の半分を下回らない
+		for _, eps := range []float64{0, 1e-9, 0.5, 0.999} {
+			plan, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", capacity, planOptions{algorithm: plannerExact, epsilon: eps})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if plan.TotalWeight > capacity {
+				t.Fatalf("iteration %d eps %v: plan exceeds capacity %d: %+v", iter, eps, capacity, plan)
+			}
+			if eps == 0 && plan.TotalValue != exact.TotalValue {
+				t.Fatalf("iteration %d: epsilon 0 must be exact, got %d want %d", iter, plan.TotalValue, exact.TotalValue)
+			}
+			if float64(plan.TotalValue) < (1-eps)*float64(exact.TotalValue) || 2*plan.TotalValue < exact.TotalValue {
+				t.Fatalf("iteration %d eps %v: value %d is outside the tolerance of %d", iter, eps, plan.TotalValue, exact.TotalValue)
+			}
+		}
+	}
+}
+
+func TestPlanBatchNeverAssignsAnOrderTwice(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 4, Value: 40},
+		{OrderID: 2, Weight: 4, Value: 30},
+		{OrderID: 3, Weight: 4, Value: 20},
+		{OrderID: 4, Weight: 0, Value: 5},
+	}
+	specs := []model.RobotSpec{{RobotID: "a", Capacity: 4}, {RobotID: "b", Capacity: 4}, {RobotID: "c", Capacity: 8}}
+
+	plans, _, err := planBatch(context.Background(), orders, specs, make([]planOptions, len(specs)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	seen := map[int64]string{}
+	for _, plan := range plans {
+		if plan.TotalWeight > 8 {
+			t.Fatalf("plan exceeds capacity: %+v", plan)
+		}
+		for _, o := range plan.Orders {
+			if prev, ok := seen[o.OrderID]; ok {
+				t.Fatalf("order %d assigned to both %s and %s", o.OrderID, prev, plan.RobotID)
+			}
+			seen[o.OrderID] = plan.RobotID
+		}
+	}
+	if seen[1] != "a" || seen[4] != "a" || seen[2] != "b" || seen[3] != "c" {
+		t.Fatalf("expected robots to claim orders in spec order, got %v", seen)
+	}
+	if orders[0].OrderID != 1 || orders[3].OrderID != 4 {
+		t.Fatalf("planBatch must not reorder the caller's orders: %+v", orders)
+	}
+}
+
+func TestValidateRobotSpecs(t *testing.T) {
+	cases := [][]model.RobotSpec{
+		nil,
+		{{RobotID: "", Capacity: 10}},
+		{{RobotID: "a", Capacity: 10}, {RobotID: "a", Capacity: 5}},
+		make([]model.RobotSpec, maxBatchRobots+1),
+	}
+	for i, specs := range cases {
+		if err := validateRobotSpecs(specs); !errors.Is(err, ErrInvalidRobotBatch) {
+			t.Fatalf("case %d: expected ErrInvalidRobotBatch, got %v", i, err)
+		}
+	}
+	if err := validateRobotSpecs([]model.RobotSpec{{RobotID: "a"}, {RobotID: "b"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSelectOrdersForDeliveryVolumeMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for iter := 0; iter < 300; iter++ {
+		n := 1 + rng.Intn(10)
+		orders := make([]model.Order, n)
+		for i := range orders {
+			orders[i] = model.Order{
+				OrderID: int64(i + 1),
+				Weight:  model.Grams(rng.Intn(15)),
+				Value:   model.Points(1 + rng.Intn(50)),
+				Volume:  model.CubicCentimeters(rng.Intn(15)),
+			}
+		}
+		capacity := model.Grams(1 + rng.Intn(40))
+		volume := model.CubicCentimeters(1 + rng.Intn(40))
+
+		var want model.Points
+		for mask := 0; mask < 1<<n; mask++ {
+			var (
+				w model.Grams
+				c model.CubicCentimeters
+				v model.Points
+			)
+			for i := 0; i < n; i++ {
+				if mask&(1<<i) != 0 {
+					w += orders[i].Weight
+					c += orders[i].Volume
+					v += orders[i].Value
+				}
+			}
+			if w <= capacity && c <= volume && v > want {
+				want = v
+			}
+		}
+
+		plan, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", capacity, planOptions{volumeCapacity: volume})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plan.TotalWeight > capacity || plan.TotalVolume > volume {
+			t.Fatalf("iteration %d: plan exceeds capacity %d/%d: %+v", iter, capacity, volume, plan)
+		}
+		if plan.TotalValue != want {
+			t.Fatalf("iteration %d: expected optimal value %d, got %d (capacity %d, volume %d, orders %+v)", iter, want, plan.TotalValue, capacity, volume, orders)
+		}
+	}
+}
+
+func TestSolveVolumePlanFallsBackToGreedyForLargeInputs(t *testing.T) {
+	orders := make([]model.Order, 100)
+	for i := range orders {
+		orders[i] = model.Order{OrderID: int64(i + 1), Weight: model.Grams(100 + i), Value: model.Points(10 + i%7), Volume: model.CubicCentimeters(200 + i)}
+	}
+	capacity, volume := model.Grams(5000), model.CubicCentimeters(8000)
+
+	chosen, strategy, err := solveVolumePlan(context.Background(), orders, capacity, volume, planOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strategy != strategyVolumeGreedy {
+		t.Fatalf("expected the DP budget to be exceeded, got %s", strategy)
+	}
+	var (
+		w model.Grams
+		c model.CubicCentimeters
+	)
+	for i, ok := range chosen {
+		if ok {
+			w += orders[i].Weight
+			c += orders[i].Volume
+		}
+	}
+	if w == 0 || w > capacity || c > volume {
+		t.Fatalf("unexpected greedy selection: weight %d volume %d", w, c)
+	}
+}
+
+func TestSelectOrdersForDeliveryPriorityFirst(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 6, Value: 100},
+		{OrderID: 2, Weight: 5, Value: 1, Priority: model.OrderPriorityHigh},
+		{OrderID: 3, Weight: 4, Value: 2, Priority: model.OrderPriorityUrgent},
+		{OrderID: 4, Weight: 1, Value: 10},
+	}
+
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 10, planOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := map[int64]bool{}
+	for _, o := range plan.Orders {
+		got[o.OrderID] = true
+	}
+	// 価値だけなら1と4を選ぶが、優先度の高い3と2を先に積み、残り1で4を選ぶ
+	if len(got) != 3 || !got[3] || !got[2] || !got[4] {
+		t.Fatalf("expected high-priority orders first, got %+v", plan.Orders)
+	}
+	if plan.TotalWeight != 10 || plan.TotalValue != 13 {
+		t.Fatalf("unexpected totals: weight %d value %d", plan.TotalWeight, plan.TotalValue)
+	}
+}
+
+func TestCapZeroWeightOrdersKeepsHigherPriority(t *testing.T) {
+	now := time.Now()
+	orders := []model.Order{
+		{OrderID: 1, CreatedAt: now.Add(-2 * time.Hour)},
+		{OrderID: 2, CreatedAt: now, Priority: model.OrderPriorityHigh},
+		{OrderID: 3, CreatedAt: now.Add(-time.Hour)},
+	}
+	kept := capZeroWeightOrders(orders, planOptions{zeroWeightCap: 2, zeroWeightPolicy: zeroWeightOldestFirst}, now)
+	if len(kept) != 2 || kept[0].OrderID != 2 || kept[1].OrderID != 1 {
+		t.Fatalf("unexpected orders kept: %+v", kept)
+	}
+}
+
+func TestSelectOrdersForDeliveryDeadlines(t *testing.T) {
+	now := time.Now()
+	due := func(d time.Duration) sql.NullTime { return sql.NullTime{Time: now.Add(d), Valid: true} }
+	policy := deadlinePolicy{boost: 50, window: 6 * time.Hour, forceWithin: 30 * time.Minute}
+	pick := func(orders []model.Order, opts planOptions) map[int64]bool {
+		t.Helper()
+		plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 5, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got := map[int64]bool{}
+		for _, o := range plan.Orders {
+			got[o.OrderID] = true
+		}
+		return got
+	}
+
+	// 期限切れ間近の注文は優先度の高い注文よりも先に積む
+	expiring := []model.Order{
+		{OrderID: 1, Weight: 5, Value: 100, Priority: model.OrderPriorityUrgent},
+		{OrderID: 2, Weight: 5, Value: 1, DeliverBy: due(10 * time.Minute)},
+	}
+	if got := pick(expiring, planOptions{deadline: policy}); !got[2] || got[1] {
+		t.Fatalf("expected the expiring order to be forced in, got %v", got)
+	}
+	if got := pick(expiring, planOptions{}); !got[1] {
+		t.Fatalf("expected deadlines to be ignored without a policy, got %v", got)
+	}
+
+	// 期限が近づくほど上乗せが大きくなり、価値の差を逆転できる
+	nearing := []model.Order{
+		{OrderID: 1, Weight: 5, Value: 60},
+		{OrderID: 2, Weight: 5, Value: 30, DeliverBy: due(time.Hour)},
+	}
+	if got := pick(nearing, planOptions{deadline: policy}); !got[2] {
+		t.Fatalf("expected the boosted order to win, got %v", got)
+	}
+	gentle := policy
+	gentle.boost = 10
+	if got := pick(nearing, planOptions{deadline: gentle}); !got[1] {
+		t.Fatalf("expected a gentle boost to keep the higher-value order, got %v", got)
+	}
+}
+
+func TestDeadlineAdjustedOrdersKeepsOriginals(t *testing.T) {
+	now := time.Now()
+	orders := []model.Order{{OrderID: 1, Value: 10, DeliverBy: sql.NullTime{Time: now.Add(-time.Minute), Valid: true}}}
+	adjusted := deadlineAdjustedOrders(orders, planOptions{deadline: deadlinePolicy{boost: 20, window: time.Hour, forceWithin: time.Minute}}, now)
+	if adjusted[0].Value != 30 || adjusted[0].Priority != deadlineForcedPriority {
+		t.Fatalf("unexpected adjustment for an overdue order: %+v", adjusted[0])
+	}
+	if orders[0].Value != 10 || orders[0].Priority != 0 {
+		t.Fatalf("original order must not be modified: %+v", orders[0])
+	}
+}
+
+func TestSolveBranchAndBoundMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	for iter := 0; iter < 300; iter++ {
+		n := 1 + rng.Intn(12)
+		orders := make([]model.Order, n)
+		for i := range orders {
+			orders[i] = model.Order{
+				OrderID: int64(i + 1),
+				Weight:  model.Grams(rng.Intn(20)),
+				Value:   model.Points(rng.Intn(50)),
+				Volume:  model.CubicCentimeters(rng.Intn(20)),
+			}
+		}
+		capacity := model.Grams(rng.Intn(60))
+		// 負の容積は容積を制約しない
+		volume := model.CubicCentimeters(rng.Intn(60) - 10)
+
+		var want model.Points
+		for mask := 0; mask < 1<<n; mask++ {
+			var (
+				w model.Grams
+				c model.CubicCentimeters
+				v model.Points
+			)
+			for i := 0; i < n; i++ {
+				if mask&(1<<i) != 0 {
+					w += orders[i].Weight
+					c += orders[i].Volume
+					v += orders[i].Value
+				}
+			}
+			if w <= capacity && (volume < 0 || c <= volume) && v > want {
+				want = v
+			}
+		}
+
+		chosen, exact, err := solveBranchAndBound(context.Background(), orders, capacity, volume, make([]bool, n), time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exact {
+			t.Fatalf("iteration %d: expected the search to finish within the budget", iter)
+		}
+		var (
+			w model.Grams
+			c model.CubicCentimeters
+			v model.Points
+		)
+		for i, ok := range chosen {
+			if ok {
+				w += orders[i].Weight
+				c += orders[i].Volume
+				v += orders[i].Value
+			}
+		}
+		if w > capacity || (volume >= 0 && c > volume) {
+			t.Fatalf("iteration %d: selection exceeds capacity %d/%d: weight %d volume %d", iter, capacity, volume, w, c)
+		}
+		if v != want {
+			t.Fatalf("iteration %d: expected optimal value %d, got %d (capacity %d, volume %d, orders %+v)", iter, want, v, capacity, volume, orders)
+		}
+	}
+}
+
+func TestSolveBranchAndBoundWithoutBudgetKeepsInitial(t *testing.T) {
+	orders := []model.Order{{OrderID: 1, Weight: 5, Value: 10}, {OrderID: 2, Weight: 5, Value: 30}}
+	initial := []bool{true, false}
+	chosen, exact, err := solveBranchAndBound(context.Background(), orders, 5, -1, initial, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exact || !chosen[0] || chosen[1] {
+		t.Fatalf("expected the initial selection without a budget, got %v (exact %v)", chosen, exact)
+	}
+}
+
+func TestPlanEpsilonImprovedByExactBudget(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	for iter := 0; iter < 200; iter++ {
+		orders := make([]model.Order, 1+rng.Intn(10))
+		for i := range orders {
+			orders[i] = model.Order{OrderID: int64(i + 1), Weight: model.Grams(1 + rng.Intn(20)), Value: model.Points(1 + rng.Intn(50))}
+		}
+		capacity := model.Grams(1 + rng.Intn(60))
+
+		exact, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", capacity, planOptions{algorithm: plannerExact})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		plan, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", capacity, planOptions{algorithm: plannerExact, epsilon: 0.5, exactBudget: time.Second})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plan.TotalWeight > capacity || plan.TotalValue != exact.TotalValue {
+			t.Fatalf("iteration %d: expected the budget to reach the optimum %d, got %+v", iter, exact.TotalValue, plan)
+		}
+	}
+}
+
+func TestExactBudgetConfiguration(t *testing.T) {
+	if budget := NewRobotService(nil, nil).exactBudget; budget != 50*time.Millisecond {
+		t.Fatalf("expected default budget, got %v", budget)
+	}
+	t.Setenv("ROBOT_EXACT_BUDGET", "0")
+	if budget := NewRobotService(nil, nil).exactBudget; budget != 0 {
+		t.Fatalf("expected env to disable the search, got %v", budget)
+	}
+	if budget := NewRobotService(nil, nil, WithExactBudget(10*time.Millisecond)).exactBudget; budget != 10*time.Millisecond {
+		t.Fatalf("expected the option to override env, got %v", budget)
+	}
+}
+
+func TestParallelKnapsackDPMatchesSequential(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	for iter := 0; iter < 5; iter++ {
+		items := make([]model.Order, parallelDPMinItems+rng.Intn(16))
+		free := make([]int, len(items))
+		for i := range items {
+			items[i] = model.Order{OrderID: int64(i + 1), Weight: model.Grams(1000 + rng.Intn(9000)), Value: model.Points(1 + rng.Intn(1000))}
+			free[i] = i
+		}
+		capacity := model.Grams(parallelDPMinCapacity + rng.Intn(parallelDPMinCapacity))
+
+		sequential, _, err := solveKnapsack(context.Background(), items, capacity, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		parallel := make([]bool, len(items))
+		if err := parallelKnapsackDP(context.Background(), items, free, capacity, 4, parallel); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		total := func(chosen []bool) (model.Grams, model.Points) {
+			var (
+				w model.Grams
+				v model.Points
+			)
+			for i, ok := range chosen {
+				if ok {
+					w += items[i].Weight
+					v += items[i].Value
+				}
+			}
+			return w, v
+		}
+		sw, sv := total(sequential)
+		pw, pv := total(parallel)
+		if pw > capacity || pv != sv {
+			t.Fatalf("iteration %d: parallel plan %d/%d differs from sequential %d/%d", iter, pw, pv, sw, sv)
+		}
+	}
+}
+
+func TestParallelDPWorkers(t *testing.T) {
+	cases := []struct {
+		parallelism, items int
+		capacity           model.Grams
+		want               int
+	}{
+		{1, 100, 1 << 20, 1},
+		{8, parallelDPMinItems - 1, 1 << 20, 1},
+		{8, 100, parallelDPMinCapacity - 1, 1},
+		{8, 100, 1 << 20, 8},
+		{8, 100, parallelDPMinCapacity, parallelDPMinCapacity / parallelDPMinChunk},
+		{8, maxParallelDPBits, 1 << 20, 1},
+	}
+	for i, c := range cases {
+		if got := parallelDPWorkers(c.parallelism, c.items, c.capacity); got != c.want {
+			t.Fatalf("case %d: expected %d workers, got %d", i, c.want, got)
+		}
+	}
+
+	t.Setenv("ROBOT_PLAN_PARALLELISM", "3")
+	if n := NewRobotService(nil, nil).parallelism; n != 3 {
+		t.Fatalf("expected parallelism from env, got %d", n)
+	}
+}
+
+func BenchmarkSolveKnapsack(b *testing.B) {
+	rng := rand.New(rand.NewSource(7))
+	// 価値密度を揃え、事前の採否の確定でDPが小さくならないようにする
+	items := make([]model.Order, 200)
+	for i := range items {
+		w := 1000 + rng.Intn(9000)
+		items[i] = model.Order{OrderID: int64(i + 1), Weight: model.Grams(w), Value: model.Points(w/10 + rng.Intn(20))}
+	}
+	capacity := model.Grams(200000)
+
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := solveKnapsack(context.Background(), items, capacity, parallelism); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// readOnlyDB は配送待ちの注文と登録済みのロボットを返し、書き込みのクエリを数えるDB
+// robotsがnilなら"robot"を稼働中として扱う
+type readOnlyDB struct {
+	orders []model.Order
+	robots map[string]model.Robot
+	writes int32
+	// 注文を読んだクエリ
+	orderQueries []string
+	// 引き当てる注文にロックを取ったクエリ
+	lockQueries []string
+}
+
+func (db *readOnlyDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if robot, ok := dest.(*model.Robot); ok {
+		robots := db.robots
+		if robots == nil {
+			robots = map[string]model.Robot{"robot": {RobotID: "robot", MaxCapacity: 1000, Status: model.RobotStatusActive}}
+		}
+		found, ok := robots[args[0].(string)]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*robot = found
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (db *readOnlyDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if orders, ok := dest.(*[]model.Order); ok {
+		*orders = append([]model.Order(nil), db.orders...)
+		db.orderQueries = append(db.orderQueries, query)
+	}
+	// 引き当てる注文はすべてロックできる
+	if ids, ok := dest.(*[]int64); ok && strings.Contains(query, "FOR UPDATE") {
+		for _, arg := range args {
+			*ids = append(*ids, arg.(int64))
+		}
+		db.lockQueries = append(db.lockQueries, query)
+	}
+	return nil
+}
+
+func (db *readOnlyDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	atomic.AddInt32(&db.writes, 1)
+	return nil, errors.New("unexpected query")
+}
+
+func (db *readOnlyDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	atomic.AddInt32(&db.writes, 1)
+	return nil, errors.New("unexpected write")
+}
+
+func (db *readOnlyDB) Rebind(query string) string { return query }
+
+func TestPreviewDeliveryPlanDoesNotWrite(t *testing.T) {
+	db := &readOnlyDB{orders: []model.Order{
+		{OrderID: 1, Weight: 3, Value: 30},
+		{OrderID: 2, Weight: 4, Value: 10},
+		{OrderID: 3, Weight: 2, Value: 20},
+	}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+
+	plan, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: "robot", Capacity: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.TotalValue != 50 || len(plan.Orders) != 2 || plan.Profile != defaultPlannerProfile {
+		t.Fatalf("unexpected preview: %+v", plan)
+	}
+	if writes := atomic.LoadInt32(&db.writes); writes != 0 {
+		t.Fatalf("expected the preview to skip the write path, got %d writes", writes)
+	}
+}
+
+func TestPreviewDeliveryPlanServedFromCache(t *testing.T) {
+	db := &readOnlyDB{orders: []model.Order{{OrderID: 1, Weight: 3, Value: 30}}}
+	events := NewOrderEventBus()
+	svc := NewRobotService(repository.NewStore(db), events)
+	svc.planner.loadedAt = time.Now()
+	spec := model.RobotSpec{RobotID: "robot", Capacity: 5}
+
+	if _, err := svc.PreviewDeliveryPlan(context.Background(), spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 注文の集合が同じなら、商品の重さが変わっても選定し直さない
+	db.orders[0].Weight = 10
+	plan, err := svc.PreviewDeliveryPlan(context.Background(), spec)
+	if err != nil || len(plan.Orders) != 1 {
+		t.Fatalf("expected the cached plan, got %+v %v", plan, err)
+	}
+
+	events.Publish([]int64{1}, "shipping")
+	plan, err = svc.PreviewDeliveryPlan(context.Background(), spec)
+	if err != nil || len(plan.Orders) != 0 {
+		t.Fatalf("expected a status change to force a new selection, got %+v %v", plan, err)
+	}
+}
+
+func TestSolvePlanStrategies(t *testing.T) {
+	// 貪欲解(1,2: 価値9)より厳密解(2,3: 価値10)が良い問題
+	items := []model.Order{
+		{OrderID: 1, Weight: 1, Value: 3},
+		{OrderID: 2, Weight: 3, Value: 6},
+		{OrderID: 3, Weight: 2, Value: 4},
+	}
+	cases := []struct {
+		opts planOptions
+		want string
+	}{
+		{planOptions{algorithm: plannerGreedy}, strategyGreedy},
+		{planOptions{algorithm: plannerExact}, strategyDP},
+		{planOptions{algorithm: plannerExact, epsilon: 0.5}, strategyEpsilonGreedy},
+		{planOptions{algorithm: plannerExact, epsilon: 0.5, exactBudget: time.Second}, strategyBranchAndBound},
+	}
+	for _, c := range cases {
+		_, strategy, err := solvePlan(context.Background(), items, 5, c.opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strategy != c.want {
+			t.Fatalf("expected %s for %+v, got %s", c.want, c.opts, strategy)
+		}
+	}
+
+	stats := telemetry.NewSolverStats()
+	if _, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), items...), "robot", 5, planOptions{algorithm: plannerExact, solverStats: stats}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var b strings.Builder
+	stats.WritePrometheus(&b)
+	if !strings.Contains(b.String(), `planner_solves_total{strategy="dp"} 1`) {
+		t.Fatalf("expected the solve to be recorded, got:\n%s", b.String())
+	}
+}
+
+// firstFitSolver は並び順に入るだけ詰める、テスト用の解法
+type firstFitSolver struct {
+	calls int
+}
+
+func (s *firstFitSolver) Solve(ctx context.Context, orders []model.Order, c SolverConstraints) (SolverPlan, error) {
+	s.calls++
+	chosen := make([]bool, len(orders))
+	remaining := c.WeightCapacity
+	for i, o := range orders {
+		if o.Weight <= remaining {
+			chosen[i] = true
+			remaining -= o.Weight
+		}
+	}
+	return SolverPlan{Chosen: chosen}, nil
+}
+
+func TestRegisteredSolverIsUsedByProfile(t *testing.T) {
+	svc := NewRobotService(nil, nil)
+	custom := &firstFitSolver{}
+	if err := svc.RegisterSolver("first-fit", custom); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := svc.RegisterSolver("", custom); !errors.Is(err, ErrInvalidSolver) {
+		t.Fatalf("expected an empty name to be rejected, got %v", err)
+	}
+	profile := model.PlannerProfile{Name: "ff", Algorithm: "first-fit", FairnessMode: fairnessNone, ZeroWeightPolicy: zeroWeightOldestFirst}
+	if err := validatePlannerProfile(profile, svc.planner.solvers); err != nil {
+		t.Fatalf("expected the registered solver to be a valid algorithm: %v", err)
+	}
+	profile.Algorithm = "unknown"
+	if err := validatePlannerProfile(profile, svc.planner.solvers); !errors.Is(err, ErrInvalidPlannerProfile) {
+		t.Fatalf("expected an unknown algorithm to be rejected, got %v", err)
+	}
+
+	svc.planner.loadedAt = time.Now()
+	svc.planner.profiles = map[string]model.PlannerProfile{"ff": {Name: "ff", Algorithm: "first-fit", FairnessMode: fairnessNone}}
+	svc.planner.assignments = map[string]string{"robot": "ff"}
+	_, opts := svc.resolvePlan(model.RobotSpec{RobotID: "robot", Capacity: 5})
+
+	orders := []model.Order{{OrderID: 1, Weight: 3, Value: 1}, {OrderID: 2, Weight: 4, Value: 50}}
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 5, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if custom.calls != 1 || len(plan.Orders) != 1 || plan.Orders[0].OrderID != 1 {
+		t.Fatalf("expected the first-fit selection, got %+v after %d calls", plan.Orders, custom.calls)
+	}
+}
+
+// overfillSolver は制約を無視してすべての注文を選ぶ
+type overfillSolver struct{}
+
+func (overfillSolver) Solve(ctx context.Context, orders []model.Order, c SolverConstraints) (SolverPlan, error) {
+	chosen := make([]bool, len(orders))
+	for i := range chosen {
+		chosen[i] = true
+	}
+	return SolverPlan{Chosen: chosen}, nil
+}
+
+func TestInvalidSolverPlanIsRejected(t *testing.T) {
+	orders := []model.Order{{OrderID: 1, Weight: 3, Value: 1}, {OrderID: 2, Weight: 4, Value: 50}}
+	_, err := selectOrdersForDelivery(context.Background(), orders, "robot", 5, planOptions{solver: overfillSolver{}})
+	if !errors.Is(err, ErrInvalidSolverPlan) {
+		t.Fatalf("expected an over-capacity selection to be rejected, got %v", err)
+	}
+}
+
+func TestBuiltinSolversMatchBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	for iter := 0; iter < 200; iter++ {
+		n := 1 + rng.Intn(10)
+		orders := make([]model.Order, n)
+		for i := range orders {
+			orders[i] = model.Order{OrderID: int64(i + 1), Weight: model.Grams(1 + rng.Intn(20)), Value: model.Points(rng.Intn(50)), Volume: model.CubicCentimeters(rng.Intn(20))}
+		}
+		c := SolverConstraints{WeightCapacity: model.Grams(rng.Intn(60)), Epsilon: 0.5, ExactBudget: time.Second}
+		if iter%2 == 0 {
+			c.LimitVolume, c.VolumeCapacity = true, model.CubicCentimeters(rng.Intn(60))
+		}
+
+		var want model.Points
+		for mask := 0; mask < 1<<n; mask++ {
+			var (
+				w   model.Grams
+				vol model.CubicCentimeters
+				v   model.Points
+			)
+			for i := 0; i < n; i++ {
+				if mask&(1<<i) != 0 {
+					w += orders[i].Weight
+					vol += orders[i].Volume
+					v += orders[i].Value
+				}
+			}
+			if w <= c.WeightCapacity && (!c.LimitVolume || vol <= c.VolumeCapacity) && v > want {
+				want = v
+			}
+		}
+
+		for _, name := range []string{plannerExact, solverDP, solverBranchAndBound} {
+			plan, err := builtinSolvers[name].Solve(context.Background(), orders, c)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := validateSolverPlan(orders, c, plan); err != nil {
+				t.Fatalf("iteration %d %s: %v", iter, name, err)
+			}
+			var got model.Points
+			for i, ok := range plan.Chosen {
+				if ok {
+					got += orders[i].Value
+				}
+			}
+			if got != want || plan.Approximate {
+				t.Fatalf("iteration %d %s: expected optimal value %d, got %d (approximate %v)", iter, name, want, got, plan.Approximate)
+			}
+		}
+	}
+}
+
+func TestAdmitRobot(t *testing.T) {
+	db := &readOnlyDB{
+		orders: []model.Order{{OrderID: 1, Weight: 3, Value: 30}, {OrderID: 2, Weight: 4, Value: 10}},
+		robots: map[string]model.Robot{
+			"small":  {RobotID: "small", MaxCapacity: 3, Status: model.RobotStatusActive},
+			"parked": {RobotID: "parked", MaxCapacity: 100, Status: model.RobotStatusInactive},
+		},
+	}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+
+	plan, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: "small", Capacity: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.TotalWeight != 3 || len(plan.Orders) != 1 {
+		t.Fatalf("expected the capacity to be clamped to the registered maximum, got %+v", plan)
+	}
+	if _, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: "unknown", Capacity: 10}); !errors.Is(err, ErrRobotNotFound) {
+		t.Fatalf("expected ErrRobotNotFound, got %v", err)
+	}
+	if _, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: "parked", Capacity: 10}); !errors.Is(err, ErrRobotInactive) {
+		t.Fatalf("expected ErrRobotInactive, got %v", err)
+	}
+	specs := []model.RobotSpec{{RobotID: "small", Capacity: 100}, {RobotID: "unknown", Capacity: 10}}
+	if _, err := svc.GenerateDeliveryPlans(context.Background(), specs); !errors.Is(err, ErrRobotNotFound) {
+		t.Fatalf("expected the batch to reject unknown robots, got %v", err)
+	}
+	if specs[0].Capacity != 100 {
+		t.Fatalf("GenerateDeliveryPlans must not modify the caller's specs: %+v", specs)
+	}
+	if writes := atomic.LoadInt32(&db.writes); writes != 0 {
+		t.Fatalf("expected no writes, got %d", writes)
+	}
+}
+
+func TestAdmitRobotRejectsSilentRobot(t *testing.T) {
+	db := &readOnlyDB{
+		orders: []model.Order{{OrderID: 1, Weight: 3, Value: 30}},
+		robots: map[string]model.Robot{
+			"alive":  {RobotID: "alive", MaxCapacity: 10, Status: model.RobotStatusActive, LastHeartbeatAt: sql.NullTime{Time: time.Now(), Valid: true}},
+			"silent": {RobotID: "silent", MaxCapacity: 10, Status: model.RobotStatusActive, LastHeartbeatAt: sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}},
+			// 生存を通知したことのないロボットは監視しない
+			"legacy": {RobotID: "legacy", MaxCapacity: 10, Status: model.RobotStatusActive},
+		},
+	}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+	svc.heartbeatTimeout = time.Minute
+
+	for _, robotID := range []string{"alive", "legacy"} {
+		if _, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: robotID, Capacity: 10}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", robotID, err)
+		}
+	}
+	if _, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: "silent", Capacity: 10}); !errors.Is(err, ErrRobotSilent) {
+		t.Fatalf("expected ErrRobotSilent, got %v", err)
+	}
+
+	svc.heartbeatTimeout = 0
+	if _, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: "silent", Capacity: 10}); err != nil {
+		t.Fatalf("expected heartbeats to be ignored when the timeout is disabled, got %v", err)
+	}
+}
+
+type fixedCostModel time.Duration
+
+func (m fixedCostModel) Estimate(orders []model.Order) time.Duration {
+	return time.Duration(len(orders)) * time.Duration(m)
+}
+
+func TestDeliveryPlanEstimatedDuration(t *testing.T) {
+	m := linearCostModel{perItem: 30 * time.Second, perKilogram: 10 * time.Second}
+	orders := []model.Order{{OrderID: 1, Weight: 1500}, {OrderID: 2, Weight: 500}}
+	if got, want := m.Estimate(orders), 80*time.Second; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if got := m.Estimate(nil); got != 0 {
+		t.Fatalf("expected an empty plan to take no time, got %s", got)
+	}
+
+	db := &readOnlyDB{orders: []model.Order{{OrderID: 1, Weight: 3, Value: 30}, {OrderID: 2, Weight: 4, Value: 10}}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+	svc.eta = fixedCostModel(time.Minute)
+	plan, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: "robot", Capacity: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := int64(len(plan.Orders)) * time.Minute.Milliseconds(); plan.EstimatedDuration != want {
+		t.Fatalf("expected the plugged cost model to be used (%dms), got %dms", want, plan.EstimatedDuration)
+	}
+}
+
+func TestGenerateDeliveryPlanLocksOnlyClaimedOrders(t *testing.T) {
+	db := &amendDB{readOnlyDB: readOnlyDB{orders: []model.Order{
+		{OrderID: 1, Weight: 3, Value: 30},
+		{OrderID: 2, Weight: 30, Value: 30},
+	}}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+	spec := model.RobotSpec{RobotID: "robot", Capacity: 5}
+
+	if _, err := svc.PreviewDeliveryPlan(context.Background(), spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.lockQueries) != 0 {
+		t.Fatalf("preview must not lock orders: %v", db.lockQueries)
+	}
+	plan, err := svc.GenerateDeliveryPlan(context.Background(), spec)
+	if err != nil || len(plan.Orders) != 1 {
+		t.Fatalf("unexpected plan: %+v, %v", plan, err)
+	}
+	// 候補は行ロックを取らずに読み、選んだ注文にだけロックを取る
+	for _, query := range db.orderQueries {
+		if strings.Contains(query, "FOR UPDATE") {
+			t.Fatalf("candidates must be read without locks: %s", query)
+		}
+	}
+	if len(db.lockQueries) != 1 || !strings.Contains(db.lockQueries[0], "shipped_status = 'shipping' FOR UPDATE SKIP LOCKED") {
+		t.Fatalf("expected the claim to lock the chosen orders, got %v", db.lockQueries)
+	}
+	if got := db.orderArgs[len(db.orderArgs)-1]; len(got) != 1 || got[0] != int64(1) {
+		t.Fatalf("expected only the chosen order to be locked, got %v", got)
+	}
+}
+
+// claimRaceDB は複数のロボットが同時に計画する状況を模す
+// 注文の読み込みはreadersの数だけ呼び出し元がそろうまで待ち、全員が同じ候補を読んでから引き当てに進む
+// ロックを取った注文はその場で引き当て済みとし、以降の読み込みやロックからは外す
+type claimRaceDB struct {
+	readOnlyDB
+	mx      sync.Mutex
+	claimed map[int64]bool
+	reads   sync.WaitGroup
+	waited  atomic.Int32
+	readers int32
+}
+
+func (db *claimRaceDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	switch dest := dest.(type) {
+	case *[]model.Order:
+		db.mx.Lock()
+		for _, o := range db.orders {
+			if !db.claimed[o.OrderID] {
+				*dest = append(*dest, o)
+			}
+		}
+		db.mx.Unlock()
+		if db.waited.Add(1) <= db.readers {
+			db.reads.Done()
+			db.reads.Wait()
+		}
+	case *[]int64:
+		db.mx.Lock()
+		defer db.mx.Unlock()
+		for _, arg := range args {
+			if id := arg.(int64); !db.claimed[id] {
+				db.claimed[id] = true
+				*dest = append(*dest, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (db *claimRaceDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return insertResult(1), nil
+}
+
+func TestConcurrentPlansClaimDisjointOrders(t *testing.T) {
+	db := &claimRaceDB{
+		readOnlyDB: readOnlyDB{
+			orders: []model.Order{
+				{OrderID: 1, Weight: 3, Value: 30},
+				{OrderID: 2, Weight: 4, Value: 10},
+				{OrderID: 3, Weight: 2, Value: 20},
+			},
+			robots: map[string]model.Robot{
+				"robot-a": {RobotID: "robot-a", MaxCapacity: 1000, Status: model.RobotStatusActive},
+				"robot-b": {RobotID: "robot-b", MaxCapacity: 1000, Status: model.RobotStatusActive},
+			},
+		},
+		claimed: make(map[int64]bool),
+		readers: 2,
+	}
+	db.reads.Add(2)
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+
+	// 2台とも同じ候補から同じ注文を選ぶが、先に引き当てられた方は残りの注文で選び直す
+	plans := make([]*model.DeliveryPlan, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, robotID := range []string{"robot-a", "robot-b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			plans[i], errs[i] = svc.GenerateDeliveryPlan(context.Background(), model.RobotSpec{RobotID: robotID, Capacity: 5})
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int64]string)
+	for i, plan := range plans {
+		if errs[i] != nil {
+			t.Fatalf("unexpected error: %v", errs[i])
+		}
+		if len(plan.Orders) == 0 {
+			t.Fatalf("expected both robots to get orders, %s got an empty plan", plan.RobotID)
+		}
+		for _, o := range plan.Orders {
+			if other, ok := seen[o.OrderID]; ok {
+				t.Fatalf("order %d claimed by both %s and %s", o.OrderID, other, plan.RobotID)
+			}
+			seen[o.OrderID] = plan.RobotID
+		}
+	}
+}
+
+func TestPlanExplanationListsStrategies(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 1, Value: 3, Priority: model.OrderPriorityHigh},
+		{OrderID: 2, Weight: 3, Value: 6},
+		{OrderID: 3, Weight: 2, Value: 4},
+	}
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 5, planOptions{algorithm: plannerExact})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := plan.Explanation.Strategies
+	if len(got) != 2 || got[0] != strategyGreedyOptimal || got[1] != strategyDP {
+		t.Fatalf("expected one entry per distinct strategy in tier order, got %v", got)
+	}
+}
+
+func TestShippingOrderWindowConfiguration(t *testing.T) {
+	t.Setenv("ROBOT_PLAN_WINDOW", "500")
+	db := &readOnlyDB{orders: []model.Order{{OrderID: 1, Weight: 3, Value: 30}}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.planner.loadedAt = time.Now()
+	if svc.window != 500 {
+		t.Fatalf("expected ROBOT_PLAN_WINDOW to set the window, got %d", svc.window)
+	}
+
+	if _, err := svc.PreviewDeliveryPlan(context.Background(), model.RobotSpec{RobotID: "robot", Capacity: 5}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	query := db.orderQueries[0]
+	if !strings.Contains(query, "COALESCE(o.value, p.value) / COALESCE(o.product_weight, p.weight) DESC") || !strings.Contains(query, "LIMIT ?") || strings.Contains(query, "FOR UPDATE") {
+		t.Fatalf("expected an unlocked read of the densest orders: %s", query)
+	}
+}
+
+// stalledSolver はcontextが終わるまで選定を返さない
+type stalledSolver struct{}
+
+func (stalledSolver) Solve(ctx context.Context, orders []model.Order, c SolverConstraints) (SolverPlan, error) {
+	<-ctx.Done()
+	return SolverPlan{}, ctx.Err()
+}
+
+func TestAnytimeSolverReturnsGreedyPlanBeforeDeadline(t *testing.T) {
+	orders := []model.Order{{OrderID: 1, Weight: 3, Value: 30}, {OrderID: 2, Weight: 4, Value: 10}}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	opts := planOptions{algorithm: "stalled", solver: stalledSolver{}, anytime: 0.5}
+	plan, err := selectOrdersForDelivery(ctx, orders, "robot", 5, opts)
+	if err != nil {
+		t.Fatalf("expected the greedy plan instead of an error, got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("expected the plan to be returned before the request deadline")
+	}
+	if len(plan.Orders) != 1 || plan.Orders[0].OrderID != 1 || len(plan.Explanation.Notes) == 0 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	// anytimeでなければ期限切れのエラーになる
+	opts.anytime = 0
+	if _, err := selectOrdersForDelivery(ctx, orders, "robot", 5, opts); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded without the anytime mode, got %v", err)
+	}
+}
+
+func TestAnytimeConfiguration(t *testing.T) {
+	t.Setenv("ROBOT_PLAN_ANYTIME", "0.8")
+	if got := NewRobotService(nil, nil).anytime; got != 0.8 {
+		t.Fatalf("expected ROBOT_PLAN_ANYTIME to be applied, got %v", got)
+	}
+	t.Setenv("ROBOT_PLAN_ANYTIME", "1.5")
+	if got := NewRobotService(nil, nil).anytime; got != 0 {
+		t.Fatalf("expected an out-of-range fraction to be ignored, got %v", got)
+	}
+}
+
+func TestSelectOrdersForDeliveryMaxItems(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 0, Value: 1},
+		{OrderID: 2, Weight: 1, Value: 50},
+		{OrderID: 3, Weight: 1, Value: 40},
+		{OrderID: 4, Weight: 1, Value: 5, Priority: model.OrderPriorityHigh},
+	}
+	for _, algorithm := range []string{plannerExact, plannerGreedy, solverBranchAndBound} {
+		opts := planOptions{algorithm: algorithm, zeroWeightCap: 100, maxItems: 2, exactBudget: time.Second}
+		plan, err := selectOrdersForDelivery(context.Background(), append([]model.Order(nil), orders...), "robot", 10, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", algorithm, err)
+		}
+		// 優先度の高い注文4と、残りで最も価値の高い注文2を残す
+		if len(plan.Orders) != 2 || plan.Orders[0].OrderID != 2 || plan.Orders[1].OrderID != 4 {
+			t.Fatalf("%s: expected the plan to be capped to orders 2 and 4, got %+v", algorithm, plan.Orders)
+		}
+		if plan.TotalValue != 55 || plan.TotalWeight != 2 {
+			t.Fatalf("%s: totals must reflect the capped plan, got %+v", algorithm, plan)
+		}
+	}
+}
+
+func TestUpdateOrderStatuses(t *testing.T) {
+	db := &orderDB{orders: map[int64]model.Order{
+		1: {OrderID: 1, ShippedStatus: "delivering"},
+		2: {OrderID: 2, ShippedStatus: "shipping"},
+		3: {OrderID: 3, ShippedStatus: "delivering"},
+	}}
+	events := NewOrderEventBus()
+	svc := NewRobotService(repository.NewStore(db), events)
+	svc.supply = noSupply{}
+
+	changes, stop, err := events.Subscribe(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	updates := []model.UpdateOrderStatusRequest{
+		{OrderID: 1, NewStatus: "completed"},
+		{OrderID: 2, NewStatus: "delivering"},
+		{OrderID: 3, NewStatus: "completed"},
+	}
+	if err := svc.UpdateOrderStatuses(context.Background(), "robot", updates); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// ステータスごとにイベントの追記と射影を1回ずつ
+	if len(db.writes) != 4 {
+		t.Fatalf("expected updates to be grouped by status, got %v", db.writes)
+	}
+	select {
+	case ev := <-changes:
+		if ev.Status != "completed" {
+			t.Fatalf("expected a completed event, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the update to be published")
+	}
+
+	db.writes = nil
+	invalid := [][]model.UpdateOrderStatusRequest{
+		nil,
+		{{OrderID: 1, NewStatus: "completed"}, {OrderID: 1, NewStatus: "shipping"}},
+		{{OrderID: 1, NewStatus: "completed"}, {OrderID: 2, NewStatus: "cancelled"}},
+		{{OrderID: 0, NewStatus: "completed"}},
+	}
+	for _, updates := range invalid {
+		if err := svc.UpdateOrderStatuses(context.Background(), "robot", updates); !errors.Is(err, ErrInvalidStatusUpdate) {
+			t.Fatalf("%+v: expected ErrInvalidStatusUpdate, got %v", updates, err)
+		}
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected invalid batches not to write, got %v", db.writes)
+	}
+}
+
+func TestUpdateOrderStatusRejectsInvalidTransitions(t *testing.T) {
+	db := &orderDB{orders: map[int64]model.Order{
+		1: {OrderID: 1, ShippedStatus: "completed"},
+		2: {OrderID: 2, ShippedStatus: "shipping"},
+		3: {OrderID: 3, ShippedStatus: "cancelled"},
+		4: {OrderID: 4, ShippedStatus: "delivering"},
+	}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.supply = noSupply{}
+
+	for _, tt := range []struct {
+		orderID int64
+		status  string
+	}{
+		{1, "shipping"},
+		{1, "completed"},
+		{2, "completed"},
+		{3, "delivering"},
+	} {
+		var transition *StatusTransitionError
+		if err := svc.UpdateOrderStatus(context.Background(), "robot", tt.orderID, tt.status); !errors.As(err, &transition) {
+			t.Fatalf("order %d to %s: expected a StatusTransitionError, got %v", tt.orderID, tt.status, err)
+		}
+	}
+	// 1件でも許されない遷移があれば、まとめて更新する他の注文も更新しない
+	updates := []model.UpdateOrderStatusRequest{{OrderID: 4, NewStatus: "completed"}, {OrderID: 1, NewStatus: "shipping"}}
+	var transition *StatusTransitionError
+	if err := svc.UpdateOrderStatuses(context.Background(), "robot", updates); !errors.As(err, &transition) || transition.OrderID != 1 {
+		t.Fatalf("expected the batch to be rejected on order 1, got %v", err)
+	}
+	if err := svc.UpdateOrderStatus(context.Background(), "robot", 5, "completed"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected rejected updates not to write, got %v", db.writes)
+	}
+
+	if err := svc.UpdateOrderStatus(context.Background(), "robot", 4, "shipping"); err != nil {
+		t.Fatalf("expected a delivering order to be released back to shipping, got %v", err)
+	}
+}
+
+func TestFailDelivery(t *testing.T) {
+	db := &orderDB{orders: map[int64]model.Order{
+		1: {OrderID: 1, ShippedStatus: "delivering"},
+		2: {OrderID: 2, ShippedStatus: "delivering", RetryCount: 3},
+		3: {OrderID: 3, ShippedStatus: "shipping"},
+	}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+	svc.maxDeliveryRetries = 3
+
+	resp, err := svc.FailDelivery(context.Background(), "robot", 1, "recipient_absent")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ShippedStatus != "shipping" || resp.RetryCount != 1 {
+		t.Fatalf("expected the order to return to shipping after 1 failure, got %+v", resp)
+	}
+	resp, err = svc.FailDelivery(context.Background(), "robot", 2, "damaged")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ShippedStatus != "failed" || resp.RetryCount != 4 {
+		t.Fatalf("expected the order to fail after exceeding the retry limit, got %+v", resp)
+	}
+
+	db.writes = nil
+	var transition *StatusTransitionError
+	if _, err := svc.FailDelivery(context.Background(), "robot", 3, "other"); !errors.As(err, &transition) {
+		t.Fatalf("expected a transition error for a shipping order, got %v", err)
+	}
+	if _, err := svc.FailDelivery(context.Background(), "robot", 1, "lost"); !errors.Is(err, ErrInvalidStatusUpdate) {
+		t.Fatalf("expected ErrInvalidStatusUpdate for an unknown reason, got %v", err)
+	}
+	if _, err := svc.FailDelivery(context.Background(), "robot", 9, "other"); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected rejected failures not to write, got %v", db.writes)
+	}
+}
+
+func TestUpdateOrderStatusRejectsOtherRobots(t *testing.T) {
+	assigned := sql.NullString{String: "robot-a", Valid: true}
+	db := &orderDB{orders: map[int64]model.Order{
+		1: {OrderID: 1, ShippedStatus: "delivering", AssignedRobotID: assigned},
+		2: {OrderID: 2, ShippedStatus: "delivering"},
+	}}
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+
+	if err := svc.UpdateOrderStatus(context.Background(), "robot-b", 1, "completed"); !errors.Is(err, ErrOrderNotAssigned) {
+		t.Fatalf("expected ErrOrderNotAssigned, got %v", err)
+	}
+	if _, err := svc.FailDelivery(context.Background(), "robot-b", 1, "other"); !errors.Is(err, ErrOrderNotAssigned) {
+		t.Fatalf("expected ErrOrderNotAssigned for a failure report, got %v", err)
+	}
+	if len(db.writes) != 0 {
+		t.Fatalf("expected rejected reports not to write, got %v", db.writes)
+	}
+
+	if err := svc.UpdateOrderStatus(context.Background(), "robot-a", 1, "shipping"); err != nil {
+		t.Fatalf("expected the assigned robot to update the order, got %v", err)
+	}
+	// 引き当てたロボットが記録されていない注文はどのロボットからも受け付ける
+	if err := svc.UpdateOrderStatus(context.Background(), "robot-b", 2, "shipping"); err != nil {
+		t.Fatalf("expected an unassigned order to be updated, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// OrderScheduler は配送開始日時を過ぎた予約中（scheduled）の注文を定期的に配送待ち（shipping）にする
+// ステータスの変更はイベントログを通して記録し、ロボットへの割り当ての対象にする
+type OrderScheduler struct {
+	store  *repository.Store
+	events *OrderEventBus
+	every  time.Duration
+	// 1回のトランザクションで各注文テーブルから配送待ちにする件数の上限
+	batch int
+	now   func() time.Time
+}
+
+func NewOrderScheduler(store *repository.Store, events *OrderEventBus) *OrderScheduler {
+	return &OrderScheduler{
+		store:  store,
+		events: events,
+		every:  parseDurationEnv("ORDER_SCHEDULE_INTERVAL", 10*time.Second),
+		batch:  parseIntEnv("ORDER_SCHEDULE_BATCH", 500),
+		now:    time.Now,
+	}
+}
+
+// Start はevery間隔で予約中の注文を配送待ちにするジョブを開始する
+func (s *OrderScheduler) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.every):
+			}
+
+			released, err := s.Release(ctx)
+			if err != nil {
+				log.Printf("Failed to release scheduled orders after %d: %v", released, err)
+			} else if released > 0 {
+				log.Printf("Released %d scheduled orders for shipping", released)
+			}
+		}
+	}()
+}
+
+// Release は配送開始日時を過ぎた予約中の注文をすべて配送待ちにし、その件数を返す
+// ロックを長く持たないよう、batch件ずつ別のトランザクションで変更する
+func (s *OrderScheduler) Release(ctx context.Context) (int, error) {
+	due := s.now()
+	total := 0
+	for {
+		var ids []int64
+		err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			ids, err = txStore.OrderRepo.LockDueScheduled(ctx, due, s.batch)
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+			return recordStatusChange(ctx, txStore, ids, "shipping", model.OrderEventActorScheduler)
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		s.events.Publish(ids, "shipping")
+		total += len(ids)
+	}
+}
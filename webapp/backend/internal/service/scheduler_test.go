@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/repository"
+)
+
+func TestSchedulerReleasesDueOrdersInBatches(t *testing.T) {
+	db := &archiveDB{batches: [][]int64{{1, 2}, {3}}}
+	events := NewOrderEventBus()
+	svc := NewOrderScheduler(repository.NewStore(db), events)
+	svc.batch = 2
+
+	changes, stop, err := events.Subscribe(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	released, err := svc.Release(context.Background())
+	if err != nil || released != 3 {
+		t.Fatalf("expected 3 orders to be released, got %d %v", released, err)
+	}
+	// 各バッチでイベントを記録してから注文へ反映する
+	want := []string{"INSERT order_events", "UPDATE o", "INSERT order_events", "UPDATE o"}
+	if strings.Join(db.execs, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected each batch to be recorded then projected, got %v", db.execs)
+	}
+	select {
+	case ev := <-changes:
+		if ev.Status != "shipping" {
+			t.Fatalf("expected a shipping event, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the release to be published")
+	}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/internal/repository"
+	"backend/internal/telemetry"
+)
+
+// SessionJanitor は期限切れのセッションを定期的に削除する
+// 1回のDELETEはbatch件までにし、user_sessionsのロックを長く持たないようにする
+type SessionJanitor struct {
+	store *repository.Store
+	every time.Duration
+	batch int
+	stats *telemetry.SessionGCStats
+}
+
+func NewSessionJanitor(store *repository.Store) *SessionJanitor {
+	return &SessionJanitor{
+		store: store,
+		every: parseDurationEnv("SESSION_GC_INTERVAL", 10*time.Minute),
+		batch: parseIntEnv("SESSION_GC_BATCH", 1000),
+		stats: telemetry.NewSessionGCStats(),
+	}
+}
+
+// Stats は削除した行数などの集計を返す
+func (j *SessionJanitor) Stats() *telemetry.SessionGCStats {
+	return j.stats
+}
+
+// Start はevery間隔で期限切れのセッションを削除するジョブを開始する。ctxが終わると止まる
+// 返したチャネルはジョブが止まると閉じる
+func (j *SessionJanitor) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(j.every):
+			}
+
+			removed, err := j.Sweep(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to delete expired sessions after %d: %v", removed, err)
+			}
+		}
+	}()
+	return done
+}
+
+// Sweep は期限切れのセッションをbatch件ずつ、なくなるまで削除し、削除した数を返す
+func (j *SessionJanitor) Sweep(ctx context.Context) (int64, error) {
+	now := time.Now()
+	var removed int64
+	for ctx.Err() == nil {
+		n, err := j.store.SessionRepo.DeleteExpired(ctx, now, j.batch)
+		removed += n
+		if err != nil {
+			j.stats.Record(removed, err, now)
+			return removed, err
+		}
+		if n < int64(j.batch) {
+			break
+		}
+	}
+	j.stats.Record(removed, ctx.Err(), now)
+	return removed, ctx.Err()
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"backend/internal/repository"
+	"backend/internal/telemetry"
+)
+
+// expiredSessionDB はexpired件の期限切れセッションを、LIMITの件数ずつ削除する
+type expiredSessionDB struct {
+	orderDB
+	expired int
+	deletes int
+}
+
+func (db *expiredSessionDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	n := min(db.expired, args[1].(int))
+	db.expired -= n
+	db.deletes++
+	return driver.RowsAffected(n), nil
+}
+
+func TestSessionJanitorSweepDeletesInBatches(t *testing.T) {
+	db := &expiredSessionDB{expired: 25}
+	j := &SessionJanitor{store: repository.NewStore(db), batch: 10, stats: telemetry.NewSessionGCStats()}
+
+	removed, err := j.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed != 25 || db.deletes != 3 {
+		t.Fatalf("expected 25 rows in 3 deletes, got %d rows in %d deletes", removed, db.deletes)
+	}
+	if j.stats.Removed() != 25 {
+		t.Fatalf("expected stats to record 25 rows, got %d", j.stats.Removed())
+	}
+
+	// ちょうどbatchの倍数のときは、空のDELETEを1回して止まる
+	db.expired, db.deletes = 20, 0
+	if removed, _ := j.Sweep(context.Background()); removed != 20 || db.deletes != 3 {
+		t.Fatalf("expected 20 rows in 3 deletes, got %d rows in %d deletes", removed, db.deletes)
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/service/utils"
+)
+
+// 1ページで返す配送待ちの注文の上限
+const maxShippingFeedPageSize = 1000
+
+var ErrInvalidFeedRequest = errors.New("invalid shipping feed request")
+
+// ShippingFeed は外部の倉庫管理システムが配送待ちの注文を写し取るためのフィードを1ページ返す
+// 作成日時・注文IDの順に並べ、cursorの続きからlimit件返す。sinceより前に作成された注文は含めない
+// 配送待ちでなくなった注文は以後のページに現れないため、利用側は写した注文のステータスを別途確かめる
+func (s *OrderService) ShippingFeed(ctx context.Context, since time.Time, cursor string, limit int) (*model.ShippingFeedPage, error) {
+	if limit <= 0 || limit > maxShippingFeedPageSize {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFeedRequest, maxShippingFeedPageSize)
+	}
+	var after model.ShippingFeedPosition
+	if cursor != "" {
+		var err error
+		if after, err = decodeShippingFeedCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	var orders []model.Order
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		orders, err = s.store.OrderRepo.ListShippingFeed(ctx, since, after, limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := &model.ShippingFeedPage{Orders: orders}
+	if page.Orders == nil {
+		page.Orders = []model.Order{}
+	}
+	if len(orders) == limit {
+		last := orders[len(orders)-1]
+		page.NextCursor = encodeShippingFeedCursor(model.ShippingFeedPosition{CreatedAt: last.CreatedAt, OrderID: last.OrderID})
+	}
+	return page, nil
+}
+
+func encodeShippingFeedCursor(pos model.ShippingFeedPosition) string {
+	raw := "s:" + strconv.FormatInt(pos.CreatedAt.UnixNano(), 10) + ":" + strconv.FormatInt(pos.OrderID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeShippingFeedCursor(cursor string) (model.ShippingFeedPosition, error) {
+	invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidFeedRequest)
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return model.ShippingFeedPosition{}, invalid
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 || parts[0] != "s" {
+		return model.ShippingFeedPosition{}, invalid
+	}
+	nanos, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return model.ShippingFeedPosition{}, invalid
+	}
+	orderID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || orderID <= 0 {
+		return model.ShippingFeedPosition{}, invalid
+	}
+	return model.ShippingFeedPosition{CreatedAt: time.Unix(0, nanos), OrderID: orderID}, nil
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// feedDB はフィードの読み込みにordersを返し、渡された引数を記録する
+type feedDB struct {
+	orderDB
+	feed []model.Order
+	args []interface{}
+}
+
+func (db *feedDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db.args = args
+	*dest.(*[]model.Order) = db.feed
+	return nil
+}
+
+func TestShippingFeedPagesWithCursor(t *testing.T) {
+	created := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+	db := &feedDB{feed: []model.Order{
+		{OrderID: 7, CreatedAt: created},
+		{OrderID: 3, CreatedAt: created.Add(time.Second)},
+	}}
+	svc := NewOrderService(repository.NewStore(db), NewOrderEventBus())
+
+	page, err := svc.ShippingFeed(context.Background(), time.Time{}, "", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.NextCursor == "" {
+		t.Fatal("expected a cursor when the page is full")
+	}
+
+	// 続きは最後の注文の作成日時と注文IDより後から読む
+	db.feed = db.feed[:1]
+	page, err = svc.ShippingFeed(context.Background(), time.Time{}, page.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if from := db.args[0].(time.Time); !from.Equal(created.Add(time.Second)) || db.args[2] != int64(3) {
+		t.Fatalf("expected the next page to start after order 3, got %v", db.args)
+	}
+	if page.NextCursor != "" {
+		t.Fatalf("expected no cursor on the last page, got %q", page.NextCursor)
+	}
+
+	for _, cursor := range []string{"not-base64!", encodeShippingFeedCursor(model.ShippingFeedPosition{})} {
+		if _, err := svc.ShippingFeed(context.Background(), time.Time{}, cursor, 2); !errors.Is(err, ErrInvalidFeedRequest) {
+			t.Fatalf("%q: expected ErrInvalidFeedRequest, got %v", cursor, err)
+		}
+	}
+	if _, err := svc.ShippingFeed(context.Background(), time.Time{}, "", maxShippingFeedPageSize+1); !errors.Is(err, ErrInvalidFeedRequest) {
+		t.Fatalf("expected ErrInvalidFeedRequest for a large limit, got %v", err)
+	}
+}
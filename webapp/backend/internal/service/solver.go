@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/internal/model"
+)
+
+// Solver は配送待ちの注文から、制約の範囲で積む注文を選ぶ
+// プランナープロファイルのalgorithmに登録名を指定すると、そのプロファイルの計画に使われる
+// 優先度の層ごとに呼ばれるため、ordersには同じ層の重量が正の注文だけが渡される
+type Solver interface {
+	Solve(ctx context.Context, orders []model.Order, constraints SolverConstraints) (SolverPlan, error)
+}
+
+// SolverConstraints は1回の選定の制約と、プロファイル・サービスの設定
+type SolverConstraints struct {
+	WeightCapacity model.Grams
+	// LimitVolumeがtrueの場合のみ容積を制約とする（上限が0でも容積のある注文は積めない）
+	LimitVolume    bool
+	VolumeCapacity model.CubicCentimeters
+	// 近似の許容誤差、厳密解の探索に使える時間、DPの並列度。使うかどうかは解法による
+	Epsilon     float64
+	ExactBudget time.Duration
+	Parallelism int
+}
+
+// SolverPlan は選定結果
+type SolverPlan struct {
+	// ordersと同じ並びで各注文を選ぶかどうか
+	Chosen []bool
+	// 解法ごとの集計に使う名前。空なら登録名を使う
+	Strategy string
+	// 厳密解でない場合はtrue。計画の説明に近似した旨を記録する
+	Approximate bool
+}
+
+var (
+	ErrInvalidSolver     = errors.New("invalid solver")
+	ErrInvalidSolverPlan = errors.New("solver returned an invalid plan")
+)
+
+// 組み込みの解法の登録名
+const (
+	solverDP             = "dp"
+	solverBranchAndBound = "branch_and_bound"
+)
+
+// プロファイルのalgorithm列の長さ
+const maxSolverNameLength = 32
+
+var builtinSolvers = map[string]Solver{
+	plannerExact:         exactSolver{},
+	plannerGreedy:        greedySolver{},
+	solverDP:             dpSolver{},
+	solverBranchAndBound: branchAndBoundSolver{},
+}
+
+// solverRegistry は登録名から解法を引く。組み込みの解法は最初から登録されている
+type solverRegistry struct {
+	mx      sync.RWMutex
+	solvers map[string]Solver
+}
+
+func newSolverRegistry() *solverRegistry {
+	solvers := make(map[string]Solver, len(builtinSolvers))
+	for name, solver := range builtinSolvers {
+		solvers[name] = solver
+	}
+	return &solverRegistry{solvers: solvers}
+}
+
+// register は解法を登録する。登録済みの名前なら置き換える
+func (r *solverRegistry) register(name string, solver Solver) error {
+	if name == "" || len(name) > maxSolverNameLength || solver == nil {
+		return fmt.Errorf("%w: name must be 1-%d characters and solver must not be nil", ErrInvalidSolver, maxSolverNameLength)
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.solvers[name] = solver
+	return nil
+}
+
+func (r *solverRegistry) lookup(name string) (Solver, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	solver, ok := r.solvers[name]
+	return solver, ok
+}
+
+// names は登録名を名前順に返す
+func (r *solverRegistry) names() []string {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	names := make([]string, 0, len(r.solvers))
+	for name := range r.solvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *solverRegistry) describe() string {
+	return strings.Join(r.names(), ", ")
+}
+
+// solverConstraints はプロファイルの設定から選定の制約を作る
+func solverConstraints(weightCap model.Grams, volumeCap model.CubicCentimeters, limitVolume bool, opts planOptions) SolverConstraints {
+	return SolverConstraints{
+		WeightCapacity: weightCap,
+		LimitVolume:    limitVolume,
+		VolumeCapacity: volumeCap,
+		Epsilon:        opts.epsilon,
+		ExactBudget:    opts.exactBudget,
+		Parallelism:    opts.parallelism,
+	}
+}
+
+// planOptionsFor は組み込みの解法に渡す設定を制約から作り直す
+func planOptionsFor(algorithm string, c SolverConstraints) planOptions {
+	return planOptions{algorithm: algorithm, epsilon: c.Epsilon, exactBudget: c.ExactBudget, parallelism: c.Parallelism}
+}
+
+func solverPlan(chosen []bool, strategy string) SolverPlan {
+	return SolverPlan{Chosen: chosen, Strategy: strategy, Approximate: !isExactStrategy(strategy)}
+}
+
+// exactSolver は厳密解を求める。epsilonの許容範囲に収まる貪欲解があればそれを使い、時間予算の範囲で改善する
+type exactSolver struct{}
+
+func (exactSolver) Solve(ctx context.Context, orders []model.Order, c SolverConstraints) (SolverPlan, error) {
+	var (
+		chosen   []bool
+		strategy string
+		err      error
+	)
+	if c.LimitVolume {
+		chosen, strategy, err = solveVolumePlan(ctx, orders, c.WeightCapacity, c.VolumeCapacity, planOptionsFor(plannerExact, c))
+	} else {
+		chosen, strategy, err = solvePlan(ctx, orders, c.WeightCapacity, planOptionsFor(plannerExact, c))
+	}
+	return solverPlan(chosen, strategy), err
+}
+
+// greedySolver は価値密度順の貪欲解を返す
+type greedySolver struct{}
+
+func (greedySolver) Solve(ctx context.Context, orders []model.Order, c SolverConstraints) (SolverPlan, error) {
+	var (
+		chosen   []bool
+		strategy string
+		err      error
+	)
+	if c.LimitVolume {
+		chosen, strategy, err = solveVolumePlan(ctx, orders, c.WeightCapacity, c.VolumeCapacity, planOptionsFor(plannerGreedy, c))
+	} else {
+		chosen, strategy, err = solvePlan(ctx, orders, c.WeightCapacity, planOptionsFor(plannerGreedy, c))
+	}
+	return solverPlan(chosen, strategy), err
+}
+
+// dpSolver はepsilonによらず常にDPで厳密解を求める
+// 容積も制約する場合、2次元DPの計算量が上限を超えれば分枝限定法で改善した貪欲解になる
+type dpSolver struct{}
+
+func (dpSolver) Solve(ctx context.Context, orders []model.Order, c SolverConstraints) (SolverPlan, error) {
+	c.Epsilon = 0
+	return exactSolver{}.Solve(ctx, orders, c)
+}
+
+// branchAndBoundSolver は貪欲解を初期解として、時間予算の範囲で分枝限定法により厳密解を探す
+type branchAndBoundSolver struct{}
+
+func (branchAndBoundSolver) Solve(ctx context.Context, orders []model.Order, c SolverConstraints) (SolverPlan, error) {
+	if err := ctx.Err(); err != nil {
+		return SolverPlan{}, err
+	}
+	volumeCap := model.CubicCentimeters(-1)
+	var greedy []bool
+	if c.LimitVolume {
+		volumeCap = c.VolumeCapacity
+		greedy = greedyVolumePlan(orders, c.WeightCapacity, volumeCap)
+	} else {
+		_, greedy = newFractionalBounds(orders).greedy(c.WeightCapacity)
+	}
+	chosen, complete, err := solveBranchAndBound(ctx, orders, c.WeightCapacity, volumeCap, greedy, c.ExactBudget)
+	if !complete {
+		return SolverPlan{Chosen: chosen, Strategy: strategyBranchAndBoundPartial, Approximate: true}, err
+	}
+	return SolverPlan{Chosen: chosen, Strategy: strategyBranchAndBound}, err
+}
+
+// validateSolverPlan は解法の選定結果が制約を守っているか確かめる
+func validateSolverPlan(orders []model.Order, c SolverConstraints, plan SolverPlan) error {
+	if len(plan.Chosen) != len(orders) {
+		return fmt.Errorf("%w: %d selections for %d orders", ErrInvalidSolverPlan, len(plan.Chosen), len(orders))
+	}
+	var (
+		weight model.Grams
+		volume model.CubicCentimeters
+	)
+	for i, ok := range plan.Chosen {
+		if ok {
+			weight += orders[i].Weight
+			volume += orders[i].Volume
+		}
+	}
+	if weight > c.WeightCapacity || (c.LimitVolume && volume > c.VolumeCapacity) {
+		return fmt.Errorf("%w: selection exceeds capacity", ErrInvalidSolverPlan)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// StockAlertService は在庫が閾値（low_stock_threshold）を下回った商品を定期的に探して通知する
+// 通知はログに出し、LOW_STOCK_WEBHOOK_URL があればそこへJSONでPOSTする
+// 同じ商品は在庫が閾値以上に戻るまで1度しか通知しない
+type StockAlertService struct {
+	store      *repository.Store
+	every      time.Duration
+	webhookURL string
+	client     *http.Client
+	// 在庫を数えない設定では在庫が減らないので何もしない
+	enabled bool
+	// 通知済みの商品ID。Checkは1つのgoroutineからしか呼ばない
+	alerted map[int]bool
+}
+
+func NewStockAlertService(store *repository.Store) *StockAlertService {
+	return &StockAlertService{
+		store:      store,
+		every:      parseDurationEnv("LOW_STOCK_CHECK_INTERVAL", time.Minute),
+		webhookURL: os.Getenv("LOW_STOCK_WEBHOOK_URL"),
+		client:     &http.Client{Timeout: parseDurationEnv("LOW_STOCK_WEBHOOK_TIMEOUT", 5*time.Second)},
+		enabled:    !parseBoolEnv("INVENTORY_UNLIMITED", true),
+		alerted:    make(map[int]bool),
+	}
+}
+
+// Start は起動直後とevery間隔で在庫を確かめるジョブを開始する
+func (s *StockAlertService) Start(ctx context.Context) {
+	if !s.enabled {
+		return
+	}
+	go func() {
+		for {
+			if n, err := s.Check(ctx); err != nil {
+				log.Printf("Failed to check low stock after %d alerts: %v", n, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.every):
+			}
+		}
+	}()
+}
+
+// Check は新たに在庫が閾値を下回った商品を通知し、通知した商品の数を返す
+// Webhookに送れなかった商品は通知済みにせず、次の確認で送り直す
+func (s *StockAlertService) Check(ctx context.Context) (int, error) {
+	products, err := s.store.ProductRepo.LowStock(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	low := make(map[int]bool, len(products))
+	fresh := make([]model.LowStockProduct, 0)
+	for _, p := range products {
+		low[p.ProductID] = true
+		if !s.alerted[p.ProductID] {
+			fresh = append(fresh, p)
+		}
+	}
+	// 在庫が戻った商品は、また下回ったときに通知する
+	for productID := range s.alerted {
+		if !low[productID] {
+			delete(s.alerted, productID)
+		}
+	}
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+
+	for _, p := range fresh {
+		log.Printf("Low stock: product %d (%s) has %d left, threshold %d", p.ProductID, p.Name, p.Stock, p.Threshold)
+	}
+	if err := s.notify(ctx, fresh); err != nil {
+		return 0, err
+	}
+	for _, p := range fresh {
+		s.alerted[p.ProductID] = true
+	}
+	return len(fresh), nil
+}
+
+// notify は在庫が閾値を下回った商品をまとめてWebhookへ送る
+func (s *StockAlertService) notify(ctx context.Context, products []model.LowStockProduct) error {
+	if s.webhookURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{"low_stock": products})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("low stock webhook returned %s", resp.Status)
+	}
+	return nil
+}
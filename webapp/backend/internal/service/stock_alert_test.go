@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// lowStockDB は在庫が閾値を下回った商品としてlowを返す
+type lowStockDB struct {
+	orderDB
+	low []model.LowStockProduct
+}
+
+func (db *lowStockDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	*dest.(*[]model.LowStockProduct) = append([]model.LowStockProduct(nil), db.low...)
+	return nil
+}
+
+func TestStockAlertCheck(t *testing.T) {
+	var received [][]int
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var body struct {
+			LowStock []model.LowStockProduct `json:"low_stock"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		ids := []int{}
+		for _, p := range body.LowStock {
+			ids = append(ids, p.ProductID)
+		}
+		received = append(received, ids)
+	}))
+	defer server.Close()
+
+	db := &lowStockDB{low: []model.LowStockProduct{{ProductID: 1, Stock: 2, Threshold: 5}}}
+	svc := &StockAlertService{
+		store:      repository.NewStore(db),
+		webhookURL: server.URL,
+		client:     server.Client(),
+		alerted:    make(map[int]bool),
+	}
+	ctx := context.Background()
+
+	// Webhookが失敗した商品は通知済みにしない
+	fail = true
+	if _, err := svc.Check(ctx); err == nil {
+		t.Fatal("expected webhook error")
+	}
+	fail = false
+	if n, err := svc.Check(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 alert, got %d, %v", n, err)
+	}
+	// 通知済みの商品は在庫が戻るまで通知しない
+	db.low = append(db.low, model.LowStockProduct{ProductID: 2, Stock: 0, Threshold: 1})
+	if n, err := svc.Check(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 alert, got %d, %v", n, err)
+	}
+	db.low = db.low[1:]
+	if n, _ := svc.Check(ctx); n != 0 {
+		t.Fatalf("expected no alerts, got %d", n)
+	}
+	db.low = append(db.low, model.LowStockProduct{ProductID: 1, Stock: 1, Threshold: 5})
+	if n, _ := svc.Check(ctx); n != 1 {
+		t.Fatalf("expected product 1 to alert again, got %d", n)
+	}
+
+	want := [][]int{{1}, {2}, {1}}
+	if len(received) != len(want) {
+		t.Fatalf("expected %v, got %v", want, received)
+	}
+	for i := range want {
+		if len(received[i]) != 1 || received[i][0] != want[i][0] {
+			t.Fatalf("expected %v, got %v", want, received)
+		}
+	}
+}
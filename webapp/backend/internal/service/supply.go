@@ -0,0 +1,140 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository"
+	"context"
+	"log"
+	"os"
+	"strconv"
+)
+
+// SupplyPolicy は配送完了をきっかけに、負荷生成のために配送待ちの注文を補充する方針
+// 配送待ちの注文がTargetを下回っていれば、Sourcesが返した注文を複製して配送待ちに戻す
+type SupplyPolicy interface {
+	// Target は維持したい配送待ちの注文数。0以下なら補充しない
+	Target() int
+	// Sources は配送完了した注文completedIDを受けて、複製する元の注文IDを最大shortfall件返す
+	// 同じIDを複数回返すと、その回数だけ複製する
+	Sources(ctx context.Context, store *repository.Store, completedID int64, shortfall int) ([]int64, error)
+}
+
+// 補充の方針
+// none: 補充しない, clone_completed: 完了した注文を複製する,
+// recent_completed: 直近に完了した注文（商品が偏らないよう複数件）を複製する
+const (
+	supplyNone            = "none"
+	supplyCloneCompleted  = "clone_completed"
+	supplyRecentCompleted = "recent_completed"
+)
+
+// supplyLimits は補充の目標件数と、1回の配送完了で複製する上限
+type supplyLimits struct {
+	target int
+	batch  int
+}
+
+func (l supplyLimits) Target() int { return l.target }
+
+func (l supplyLimits) count(shortfall int) int {
+	return min(l.batch, shortfall)
+}
+
+type noSupply struct{}
+
+func (noSupply) Target() int { return 0 }
+
+func (noSupply) Sources(ctx context.Context, store *repository.Store, completedID int64, shortfall int) ([]int64, error) {
+	return nil, nil
+}
+
+// cloneCompletedSupply は完了した注文をbatch件まで複製する
+type cloneCompletedSupply struct{ supplyLimits }
+
+func (p cloneCompletedSupply) Sources(ctx context.Context, store *repository.Store, completedID int64, shortfall int) ([]int64, error) {
+	ids := make([]int64, p.count(shortfall))
+	for i := range ids {
+		ids[i] = completedID
+	}
+	return ids, nil
+}
+
+// recentCompletedSupply は直近に完了した注文を新しい順にbatch件まで1件ずつ複製する
+type recentCompletedSupply struct{ supplyLimits }
+
+func (p recentCompletedSupply) Sources(ctx context.Context, store *repository.Store, completedID int64, shortfall int) ([]int64, error) {
+	return store.OrderEventRepo.RecentlyCompleted(ctx, p.count(shortfall))
+}
+
+// newSupplyPolicyFromEnv は環境変数から補充の方針を作る
+// ROBOT_SHIPPING_CLONE_ENABLED=falseは、ROBOT_SUPPLY_POLICYによらず補充を止める
+func newSupplyPolicyFromEnv() SupplyPolicy {
+	if v := os.Getenv("ROBOT_SHIPPING_CLONE_ENABLED"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil && !b {
+			return noSupply{}
+		}
+	}
+
+	limits := supplyLimits{target: 500, batch: 1}
+	if v := os.Getenv("ROBOT_SHIPPING_SUPPLY_TARGET"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limits.target = max(n, 0)
+		}
+	}
+	limits.batch = parseIntEnv("ROBOT_SUPPLY_BATCH", limits.batch)
+
+	switch v := os.Getenv("ROBOT_SUPPLY_POLICY"); v {
+	case supplyNone:
+		return noSupply{}
+	case supplyRecentCompleted:
+		return recentCompletedSupply{limits}
+	case supplyCloneCompleted, "":
+	default:
+		log.Printf("Unknown ROBOT_SUPPLY_POLICY %q, using %q", v, supplyCloneCompleted)
+	}
+	return cloneCompletedSupply{limits}
+}
+
+// replenish は配送待ちの注文が目標を下回っていれば、方針に従って注文を複製する
+// 複製した注文IDを、通知に使うため元の注文のユーザーごとに返す
+func replenish(ctx context.Context, txStore *repository.Store, policy SupplyPolicy, completedID int64) (map[int][]int64, error) {
+	target := policy.Target()
+	if target <= 0 {
+		return nil, nil
+	}
+	shippingCount, err := txStore.OrderRepo.CountShipping(ctx)
+	if err != nil || shippingCount >= target {
+		return nil, err
+	}
+	sources, err := policy.Sources(ctx, txStore, completedID, target-shippingCount)
+	if err != nil || len(sources) == 0 {
+		return nil, err
+	}
+
+	cloned := make(map[int][]int64)
+	owners := make(map[int64]int)
+	for _, source := range sources {
+		ids, err := txStore.OrderRepo.CloneAsShipping(ctx, []int64{source})
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		// 複製した注文は元の注文と同じユーザーのものとして通知する
+		owner, ok := owners[source]
+		if !ok {
+			if owner, err = txStore.OrderRepo.GetUserID(ctx, source); err != nil {
+				return nil, err
+			}
+			owners[source] = owner
+		}
+		cloned[owner] = append(cloned[owner], ids...)
+	}
+	for _, ids := range cloned {
+		if err := txStore.OrderEventRepo.Append(ctx, ids, model.OrderEventCreated, "shipping", model.OrderEventActorSupplyClone); err != nil {
+			return nil, err
+		}
+	}
+	return cloned, nil
+}
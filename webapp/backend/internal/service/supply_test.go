@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"backend/internal/repository"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// supplyDB は配送待ちの注文数と注文の持ち主を返し、複製のたびに新しい注文IDを払い出すDBを模す
+type supplyDB struct {
+	shipping int
+	// 注文IDごとの持ち主
+	owners map[int64]int
+	recent []int64
+	nextID int64
+	clones []interface{}
+}
+
+func (db *supplyDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	n := dest.(*int)
+	if strings.Contains(query, "COUNT(*)") {
+		*n = db.shipping
+		return nil
+	}
+	owner, ok := db.owners[args[0].(int64)]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*n = owner
+	return nil
+}
+
+func (db *supplyDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ids := dest.(*[]int64)
+	*ids = append(*ids, db.recent[:min(args[1].(int), len(db.recent))]...)
+	return nil
+}
+
+func (db *supplyDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (db *supplyDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if strings.HasPrefix(query, "INSERT INTO orders") {
+		db.clones = append(db.clones, args[0])
+		db.nextID++
+		return insertResult(db.nextID), nil
+	}
+	return insertResult(0), nil
+}
+
+func (db *supplyDB) Rebind(query string) string { return query }
+
+func TestReplenishClonesCompletedOrderUpToBatch(t *testing.T) {
+	db := &supplyDB{shipping: 8, owners: map[int64]int{5: 42}, nextID: 100}
+	store := repository.NewStore(db)
+	policy := cloneCompletedSupply{supplyLimits{target: 10, batch: 3}}
+
+	cloned, err := replenish(context.Background(), store, policy, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 不足は2件なので、バッチの上限3件より少ない2件だけ複製する
+	if len(db.clones) != 2 || len(cloned[42]) != 2 {
+		t.Fatalf("expected two clones of order 5 owned by user 42, got %v %v", db.clones, cloned)
+	}
+
+	db.shipping = 10
+	if cloned, err := replenish(context.Background(), store, policy, 5); err != nil || cloned != nil {
+		t.Fatalf("expected no replenishment at the target, got %v %v", cloned, err)
+	}
+}
+
+func TestReplenishRecentCompletedSpreadsAcrossOrders(t *testing.T) {
+	db := &supplyDB{owners: map[int64]int{7: 1, 6: 2, 5: 1}, recent: []int64{7, 6, 5}}
+	policy := recentCompletedSupply{supplyLimits{target: 10, batch: 2}}
+
+	cloned, err := replenish(context.Background(), repository.NewStore(db), policy, 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.clones) != 2 || db.clones[0] != int64(7) || db.clones[1] != int64(6) {
+		t.Fatalf("expected the two most recently completed orders to be cloned, got %v", db.clones)
+	}
+	if len(cloned[1]) != 1 || len(cloned[2]) != 1 {
+		t.Fatalf("expected clones to be grouped by owner, got %v", cloned)
+	}
+}
+
+func TestSupplyPolicyConfiguration(t *testing.T) {
+	t.Setenv("ROBOT_SUPPLY_POLICY", "recent_completed")
+	t.Setenv("ROBOT_SUPPLY_BATCH", "4")
+	t.Setenv("ROBOT_SHIPPING_SUPPLY_TARGET", "20")
+	p, ok := newSupplyPolicyFromEnv().(recentCompletedSupply)
+	if !ok || p.target != 20 || p.batch != 4 {
+		t.Fatalf("unexpected policy: %#v", p)
+	}
+
+	t.Setenv("ROBOT_SHIPPING_CLONE_ENABLED", "false")
+	if p := newSupplyPolicyFromEnv(); p.Target() != 0 {
+		t.Fatalf("expected cloning to be disabled, got %#v", p)
+	}
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrThumbnailNotFound = errors.New("thumbnail not found")
+
+// ThumbnailService は商品画像のサムネイルを事前生成し、生成済みのバイト列を返す
+type ThumbnailService struct {
+	imageDir string
+	outDir   string
+	sizes    []int
+	interval time.Duration
+
+	mx    sync.RWMutex
+	cache map[string][]byte
+
+	// 生成に失敗した画像。再試行されるまでバックフィルの対象から外す
+	failedMx  sync.Mutex
+	failed    map[string]bool
+	onFailure func(imagePath string, err error)
+}
+
+func NewThumbnailService() *ThumbnailService {
+	imageDir := os.Getenv("IMAGE_DIR")
+	if imageDir == "" {
+		imageDir = "/app/images"
+	}
+	outDir := os.Getenv("THUMBNAIL_DIR")
+	if outDir == "" {
+		outDir = filepath.Join(os.TempDir(), "thumbnails")
+	}
+	return &ThumbnailService{
+		imageDir: imageDir,
+		outDir:   outDir,
+		sizes:    parseSizesEnv("THUMBNAIL_SIZES", []int{128, 256}),
+		interval: parseDurationEnv("THUMBNAIL_BACKFILL_INTERVAL", 10*time.Minute),
+		cache:    make(map[string][]byte),
+		failed:   make(map[string]bool),
+	}
+}
+
+// SetFailureHandler はサムネイル生成に失敗した画像の通知先を設定する
+func (s *ThumbnailService) SetFailureHandler(fn func(imagePath string, err error)) {
+	s.onFailure = fn
+}
+
+// Regenerate は1枚の画像のサムネイルを生成し直す
+func (s *ThumbnailService) Regenerate(imagePath string) error {
+	if _, err := s.generate(imagePath); err != nil {
+		return err
+	}
+	s.failedMx.Lock()
+	delete(s.failed, imagePath)
+	s.failedMx.Unlock()
+	return nil
+}
+
+// Sizes は設定されたサムネイルサイズ（長辺px）を返す
+func (s *ThumbnailService) Sizes() []int {
+	return append([]int(nil), s.sizes...)
+}
+
+// HasSize は指定サイズが設定に含まれるかを返す
+func (s *ThumbnailService) HasSize(size int) bool {
+	for _, v := range s.sizes {
+		if v == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Start はバックグラウンドで定期的にバックフィルを実行する
+func (s *ThumbnailService) Start(ctx context.Context) {
+	go func() {
+		for {
+			generated, err := s.Backfill(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Thumbnail backfill failed: %v", err)
+			} else if generated > 0 {
+				log.Printf("Generated %d thumbnails", generated)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.interval):
+			}
+		}
+	}()
+}
+
+// Backfill は画像ディレクトリを走査し、未生成のサムネイルをすべて生成する
+func (s *ThumbnailService) Backfill(ctx context.Context) (int, error) {
+	generated := 0
+	err := filepath.WalkDir(s.imageDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || !isThumbnailSource(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.imageDir, path)
+		if err != nil {
+			return err
+		}
+		s.failedMx.Lock()
+		skip := s.failed[rel]
+		s.failedMx.Unlock()
+		if skip {
+			return nil
+		}
+		n, err := s.generate(rel)
+		if err != nil {
+			log.Printf("Failed to generate thumbnail for %s: %v", rel, err)
+			if s.onFailure != nil {
+				s.failedMx.Lock()
+				s.failed[rel] = true
+				s.failedMx.Unlock()
+				s.onFailure(rel, err)
+			}
+			return nil
+		}
+		generated += n
+		return nil
+	})
+	return generated, err
+}
+
+// Get は生成済みサムネイルのバイト列とContent-Typeを返す
+func (s *ThumbnailService) Get(imagePath string, size int) ([]byte, string, error) {
+	if !s.HasSize(size) {
+		return nil, "", ErrThumbnailNotFound
+	}
+	key := s.thumbnailPath(imagePath, size)
+	s.mx.RLock()
+	data, ok := s.cache[key]
+	s.mx.RUnlock()
+	if !ok {
+		var err error
+		data, err = os.ReadFile(key)
+		if err != nil {
+			return nil, "", ErrThumbnailNotFound
+		}
+		s.mx.Lock()
+		s.cache[key] = data
+		s.mx.Unlock()
+	}
+	return data, thumbnailContentType(imagePath), nil
+}
+
+func (s *ThumbnailService) generate(rel string) (int, error) {
+	var pending []int
+	for _, size := range s.sizes {
+		if _, err := os.Stat(s.thumbnailPath(rel, size)); errors.Is(err, fs.ErrNotExist) {
+			pending = append(pending, size)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	f, err := os.Open(filepath.Join(s.imageDir, rel))
+	if err != nil {
+		return 0, err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, size := range pending {
+		dst := s.thumbnailPath(rel, size)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return 0, err
+		}
+		if err := writeThumbnail(dst, resizeImage(src, size)); err != nil {
+			return 0, err
+		}
+	}
+	return len(pending), nil
+}
+
+func (s *ThumbnailService) thumbnailPath(rel string, size int) string {
+	return filepath.Join(s.outDir, strconv.Itoa(size), rel)
+}
+
+// 一時ファイルに書き出してからリネームし、書きかけのファイルを配信しないようにする
+func writeThumbnail(dst string, img image.Image) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = encodeImage(tmp, img, dst)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// encodeImage はpathの拡張子に合わせた形式でimgを書き出す
+func encodeImage(w io.Writer, img image.Image, path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case ".gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return png.Encode(w, img)
+	}
+}
+
+// resizeImage は長辺がmaxSideになるよう縮小する（拡大はしない）
+func resizeImage(src image.Image, maxSide int) image.Image {
+	return fitImage(src, maxSide, maxSide)
+}
+
+// fitImage は縦横比を保ったままmaxW×maxHに収まるよう縮小する（拡大はしない）
+// 縮小先の各画素に対応する元画像の領域を平均して求める
+func fitImage(src image.Image, maxW, maxH int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxW && h <= maxH {
+		return src
+	}
+	dw, dh := maxW, maxH
+	if w*maxH >= h*maxW {
+		dh = h * maxW / w
+	} else {
+		dw = w * maxH / h
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0 := b.Min.Y + y*h/dh
+		sy1 := b.Min.Y + (y+1)*h/dh
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			sx0 := b.Min.X + x*w/dw
+			sx1 := b.Min.X + (x+1)*w/dw
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+func isThumbnailSource(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+func thumbnailContentType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".gif":
+		return "image/gif"
+	default:
+		return "image/png"
+	}
+}
+
+func parseSizesEnv(key string, fallback []int) []int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	var sizes []int
+	for _, part := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			log.Printf("Ignoring invalid %s entry %q", key, part)
+			continue
+		}
+		sizes = append(sizes, n)
+	}
+	if len(sizes) == 0 {
+		return fallback
+	}
+	sort.Ints(sizes)
+	return sizes
+}
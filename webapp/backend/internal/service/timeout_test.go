@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// stallingDB はすべてのクエリで止まり続けるDB。releaseが閉じられるまでctxも無視する
+type stallingDB struct {
+	release chan struct{}
+	calls   int32
+}
+
+func newStallingDB(t *testing.T) *stallingDB {
+	db := &stallingDB{release: make(chan struct{})}
+	t.Cleanup(func() { close(db.release) })
+	return db
+}
+
+func (db *stallingDB) stall() error {
+	atomic.AddInt32(&db.calls, 1)
+	<-db.release
+	return errors.New("released")
+}
+
+func (db *stallingDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return db.stall()
+}
+
+func (db *stallingDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return db.stall()
+}
+
+func (db *stallingDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return nil, db.stall()
+}
+
+func (db *stallingDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, db.stall()
+}
+
+func (db *stallingDB) Rebind(query string) string { return query }
+
+func shortDeadline(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func assertTimedOut(t *testing.T, err error, start time.Time) {
+	t.Helper()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up at the deadline, took %s", elapsed)
+	}
+}
+
+func TestLoginTimesOutOnStalledDB(t *testing.T) {
+	db := newStallingDB(t)
+	svc := NewAuthService(repository.NewStore(db))
+
+	start := time.Now()
+	_, _, err := svc.Login(shortDeadline(t), "user", "password", model.ClientInfo{})
+	assertTimedOut(t, err, start)
+}
+
+func TestFetchOrdersTimesOutOnStalledDB(t *testing.T) {
+	db := newStallingDB(t)
+	svc := NewOrderService(repository.NewStore(db), NewOrderEventBus())
+
+	start := time.Now()
+	_, _, _, err := svc.FetchOrders(shortDeadline(t), 1, model.ListRequest{PageSize: 20, SortField: "o.order_id", SortOrder: "DESC"})
+	assertTimedOut(t, err, start)
+}
+
+func TestGenerateDeliveryPlanTimesOutOnStalledDB(t *testing.T) {
+	db := newStallingDB(t)
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+
+	start := time.Now()
+	_, err := svc.GenerateDeliveryPlan(shortDeadline(t), model.RobotSpec{RobotID: "robot", Capacity: 100})
+	assertTimedOut(t, err, start)
+}
+
+func TestUpdateOrderStatusTimesOutOnStalledDB(t *testing.T) {
+	db := newStallingDB(t)
+	svc := NewRobotService(repository.NewStore(db), NewOrderEventBus())
+
+	start := time.Now()
+	err := svc.UpdateOrderStatus(shortDeadline(t), "robot", 1, "completed")
+	assertTimedOut(t, err, start)
+}
+
+// cancelOnCheck は指定回数目のErr()呼び出しの直後に自身をキャンセルするコンテキスト
+// その回のErr()はnilを返すため、次のキャンセル確認箇所まで処理が進む
+type cancelOnCheck struct {
+	context.Context
+	cancel func()
+	checks int32
+	at     int32
+}
+
+func (c *cancelOnCheck) Err() error {
+	err := c.Context.Err()
+	if atomic.AddInt32(&c.checks, 1) == c.at {
+		c.cancel()
+	}
+	return err
+}
+
+func TestSolveKnapsackCanceledInsideDPLoop(t *testing.T) {
+	// 貪欲解が最適でなく、どの注文も事前に採否が確定しない大容量の問題
+	items := []model.Order{
+		{OrderID: 1, Weight: 2000001, Value: 2000002},
+		{OrderID: 2, Weight: 1500000, Value: 1500000},
+		{OrderID: 3, Weight: 1500000, Value: 1500000},
+	}
+	inner, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 1回目は関数冒頭、2回目は最初の注文の処理前。その直後にキャンセルし、DP内部の定期確認で止まることを確かめる
+	ctx := &cancelOnCheck{Context: inner, cancel: cancel, at: 2}
+
+	_, _, err := solveKnapsack(ctx, items, 3000000, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation from the DP loop, got %v", err)
+	}
+	if ctx.checks != 3 {
+		t.Fatalf("expected cancellation to be observed by the periodic check, got %d Err() calls", ctx.checks)
+	}
+}
+
+func TestSolveKnapsackOptimalOnLargeInstance(t *testing.T) {
+	items := []model.Order{
+		{OrderID: 1, Weight: 2000001, Value: 2000002},
+		{OrderID: 2, Weight: 1500000, Value: 1500000},
+		{OrderID: 3, Weight: 1500000, Value: 1500000},
+	}
+	chosen, _, err := solveKnapsack(context.Background(), items, 3000000, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if chosen[0] || !chosen[1] || !chosen[2] {
+		t.Fatalf("expected orders 2 and 3 to be chosen over the greedy pick, got %v", chosen)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"backend/internal/model"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// ユーザー間の公平性の扱い
+// share_cap: 1ユーザーの注文が計画に占める重量を積載量のshareまでに抑える
+// diminishing: 同じユーザーの注文は2件目以降、価値をdecay倍ずつ割り引いて選定する（計画の合計価値は元の価値）
+const (
+	userFairnessNone        = "none"
+	userFairnessShareCap    = "share_cap"
+	userFairnessDiminishing = "diminishing"
+)
+
+// 1人のユーザーが多くの注文を抱えていても、その注文だけで計画が埋まらないようにする
+type userFairnessPolicy struct {
+	mode  string
+	share float64
+	decay float64
+}
+
+func newUserFairnessPolicy() userFairnessPolicy {
+	p := userFairnessPolicy{mode: userFairnessNone, share: 0.5, decay: 0.5}
+	switch v := os.Getenv("ROBOT_USER_FAIRNESS"); v {
+	case userFairnessNone, userFairnessShareCap, userFairnessDiminishing:
+		p.mode = v
+	case "":
+	default:
+		log.Printf("Unknown ROBOT_USER_FAIRNESS %q, using %q", v, userFairnessNone)
+	}
+	if v := os.Getenv("ROBOT_USER_SHARE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 && f <= 1 {
+			p.share = f
+		} else {
+			log.Printf("Invalid ROBOT_USER_SHARE %q, using %v", v, p.share)
+		}
+	}
+	if v := os.Getenv("ROBOT_USER_DECAY"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 && f <= 1 {
+			p.decay = f
+		} else {
+			log.Printf("Invalid ROBOT_USER_DECAY %q, using %v", v, p.decay)
+		}
+	}
+	return p
+}
+
+// userFairOrders はユーザーごとの注文を価値密度の高い順に並べ、公平性の設定に応じて選定上の価値を調整する
+// share_capで上限を超えた注文は価値を0にして候補から外す。ただし各ユーザーの最初の1件は上限を超えても残す
+// ordersと同じ並びのスライスを返し、調整がなければordersをそのまま返す
+func userFairOrders(orders []model.Order, p userFairnessPolicy, capacity model.Grams, explanation *model.PlanExplanation) []model.Order {
+	if p.mode != userFairnessShareCap && p.mode != userFairnessDiminishing {
+		return orders
+	}
+	byUser := make(map[int][]int)
+	for i, o := range orders {
+		byUser[o.UserID] = append(byUser[o.UserID], i)
+	}
+	if len(byUser) < 2 && p.mode == userFairnessShareCap {
+		return orders
+	}
+
+	adjusted := append([]model.Order(nil), orders...)
+	limit := model.Grams(float64(capacity) * p.share)
+	held := 0
+	for _, idx := range byUser {
+		sort.SliceStable(idx, func(a, b int) bool {
+			x, y := orders[idx[a]], orders[idx[b]]
+			return int(x.Value)*int(y.Weight) > int(y.Value)*int(x.Weight)
+		})
+		var used model.Grams
+		for k, i := range idx {
+			switch p.mode {
+			case userFairnessShareCap:
+				if k > 0 && used+orders[i].Weight > limit {
+					adjusted[i].Value = 0
+					held++
+					continue
+				}
+				used += orders[i].Weight
+			case userFairnessDiminishing:
+				if k == 0 || orders[i].Value <= 0 {
+					continue
+				}
+				v := model.Points(math.Round(float64(orders[i].Value) * math.Pow(p.decay, float64(k))))
+				adjusted[i].Value = max(v, 1)
+			}
+		}
+	}
+	if held > 0 {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf(
+			"per-user share capped at %.0f%% of capacity; %d orders held back", p.share*100, held))
+	}
+	return adjusted
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestUserFairnessShareCapLimitsHeavyUser(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, UserID: 1, Weight: 3, Value: 30},
+		{OrderID: 2, UserID: 1, Weight: 3, Value: 30},
+		{OrderID: 3, UserID: 1, Weight: 3, Value: 30},
+		{OrderID: 4, UserID: 2, Weight: 3, Value: 10},
+		{OrderID: 5, UserID: 3, Weight: 3, Value: 10},
+	}
+	opts := planOptions{algorithm: plannerExact, userFairness: userFairnessPolicy{mode: userFairnessShareCap, share: 0.5}}
+
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 12, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	perUser := map[int]model.Grams{}
+	for _, o := range plan.Orders {
+		perUser[o.UserID] += o.Weight
+	}
+	if perUser[1] > 6 || perUser[2] == 0 || perUser[3] == 0 {
+		t.Fatalf("expected user 1 to be capped at half the capacity, got %+v", plan.Orders)
+	}
+	// 計画の合計価値は元の価値で数える
+	if plan.TotalValue != 80 {
+		t.Fatalf("expected the original values to be reported, got %d", plan.TotalValue)
+	}
+}
+
+func TestUserFairnessShareCapKeepsFirstOrder(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, UserID: 1, Weight: 8, Value: 30},
+		{OrderID: 2, UserID: 2, Weight: 1, Value: 1},
+	}
+	got := userFairOrders(orders, userFairnessPolicy{mode: userFairnessShareCap, share: 0.5}, 10, &model.PlanExplanation{})
+	if got[0].Value != 30 {
+		t.Fatalf("a user's first order must stay a candidate even above the share, got %+v", got)
+	}
+}
+
+func TestUserFairnessDiminishingSpreadsAcrossUsers(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, UserID: 1, Weight: 1, Value: 10},
+		{OrderID: 2, UserID: 1, Weight: 1, Value: 9},
+		{OrderID: 3, UserID: 2, Weight: 1, Value: 6},
+	}
+	opts := planOptions{algorithm: plannerExact, userFairness: userFairnessPolicy{mode: userFairnessDiminishing, decay: 0.5}}
+
+	plan, err := selectOrdersForDelivery(context.Background(), orders, "robot", 2, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids := map[int64]bool{}
+	for _, o := range plan.Orders {
+		ids[o.OrderID] = true
+	}
+	if len(ids) != 2 || !ids[1] || !ids[3] {
+		t.Fatalf("expected the second user's order over user 1's second order, got %+v", plan.Orders)
+	}
+}
+
+func TestUserFairnessConfiguration(t *testing.T) {
+	t.Setenv("ROBOT_USER_FAIRNESS", "diminishing")
+	t.Setenv("ROBOT_USER_DECAY", "0.25")
+	t.Setenv("ROBOT_USER_SHARE", "2")
+	p := newUserFairnessPolicy()
+	if p.mode != userFairnessDiminishing || p.decay != 0.25 || p.share != 0.5 {
+		t.Fatalf("unexpected policy: %+v", p)
+	}
+
+	orders := []model.Order{{OrderID: 1, UserID: 1, Weight: 1, Value: 10}}
+	if got := userFairOrders(orders, userFairnessPolicy{mode: userFairnessNone}, 10, &model.PlanExplanation{}); &got[0] != &orders[0] {
+		t.Fatalf("expected orders to be returned unchanged without fairness")
+	}
+}
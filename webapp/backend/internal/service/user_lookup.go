@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"backend/internal/model"
+)
+
+// 同時の検索をまとめたクエリの制限時間
+// 最初の呼び出し元が切断しても他の呼び出し元に結果を返せるよう、呼び出し元のctxとは切り離して実行する
+const userLookupTimeout = 10 * time.Second
+
+// userLookupGroup は同じユーザー名の同時の検索を1回のクエリにまとめる
+// キャッシュが切れた直後にログインが集中してもDBへの問い合わせは1回で済む
+type userLookupGroup struct {
+	mx    sync.Mutex
+	calls map[string]*userLookupCall
+	// 呼び出し元が検索に加わるたびに呼ぶ。テストで呼び出し元がそろうのを待つために使う
+	joined func()
+}
+
+type userLookupCall struct {
+	done chan struct{}
+	user *model.User
+	err  error
+}
+
+// do はuserNameの検索が進行中ならその結果を待ち、なければfnで検索を始めて待つ
+// fnには呼び出し元のキャンセルを引き継がないctxを渡す。呼び出し元は自分のctxが切れたら待つのをやめる
+func (g *userLookupGroup) do(ctx context.Context, userName string, fn func(ctx context.Context) (*model.User, error)) (*model.User, error) {
+	g.mx.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*userLookupCall)
+	}
+	call, ok := g.calls[userName]
+	if !ok {
+		call = &userLookupCall{done: make(chan struct{})}
+		g.calls[userName] = call
+		go g.run(ctx, userName, call, fn)
+	}
+	joined := g.joined
+	g.mx.Unlock()
+	if joined != nil {
+		joined()
+	}
+
+	select {
+	case <-call.done:
+		return copyUser(call.user), call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (g *userLookupGroup) run(ctx context.Context, userName string, call *userLookupCall, fn func(ctx context.Context) (*model.User, error)) {
+	lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), userLookupTimeout)
+	defer cancel()
+	call.user, call.err = fn(lookupCtx)
+
+	g.mx.Lock()
+	delete(g.calls, userName)
+	g.mx.Unlock()
+	close(call.done)
+}
+
+// copyUser は呼び出し元ごとに書き換えてもよいようユーザーを複製する
+func copyUser(user *model.User) *model.User {
+	if user == nil {
+		return nil
+	}
+	userCopy := *user
+	return &userCopy
+}
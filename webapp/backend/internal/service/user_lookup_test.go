@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// userLookupDB はusersのユーザーを返し、検索した回数を数える。releaseが閉じるまで検索を止める
+type userLookupDB struct {
+	orderDB
+	users   map[string]model.User
+	release chan struct{}
+	queries atomic.Int32
+}
+
+func (db *userLookupDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db.queries.Add(1)
+	if db.release != nil {
+		<-db.release
+	}
+	user, ok := db.users[args[0].(string)]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*dest.(*model.User) = user
+	return nil
+}
+
+func TestGetUserCachesMisses(t *testing.T) {
+	db := &userLookupDB{users: map[string]model.User{"alice": {UserID: 1, UserName: "alice"}}}
+	cache := newUserCache(time.Minute, 16)
+	cache.missTTL = time.Minute
+	svc := &AuthService{store: repository.NewStore(db), userCache: cache}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := svc.getUser(ctx, "bob"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("expected sql.ErrNoRows, got %v", err)
+		}
+	}
+	if n := db.queries.Load(); n != 1 {
+		t.Fatalf("expected unknown user to be looked up once, got %d", n)
+	}
+
+	// 登録したらいなかったことのキャッシュは消える
+	cache.delete("bob")
+	db.users["bob"] = model.User{UserID: 2, UserName: "bob"}
+	user, err := svc.getUser(ctx, "bob")
+	if err != nil || user.UserID != 2 {
+		t.Fatalf("unexpected result: %+v, %v", user, err)
+	}
+}
+
+func TestUserCacheDropsResultsReadBeforeDelete(t *testing.T) {
+	cache := newUserCache(time.Minute, 16)
+	cache.missTTL = time.Minute
+
+	// 登録の前に始めた検索の「いない」という結果は、登録の後に保存しない
+	generation := cache.begin()
+	cache.delete("bob")
+	cache.setMissing("bob", generation)
+	if _, ok := cache.get("bob"); ok {
+		t.Fatal("expected a miss read before the delete not to be cached")
+	}
+
+	generation = cache.begin()
+	cache.setMissing("bob", generation)
+	if user, ok := cache.get("bob"); !ok || user != nil {
+		t.Fatalf("expected a cached miss, got %+v, %v", user, ok)
+	}
+}
+
+func TestGetUserCollapsesConcurrentLookups(t *testing.T) {
+	db := &userLookupDB{
+		users:   map[string]model.User{"alice": {UserID: 1, UserName: "alice"}},
+		release: make(chan struct{}),
+	}
+	svc := &AuthService{store: repository.NewStore(db)}
+
+	const callers = 10
+	joined := make(chan struct{}, callers)
+	svc.userLookups.joined = func() { joined <- struct{}{} }
+
+	// 検索を始めた呼び出し元が切断しても、他の呼び出し元には結果を返す
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := svc.getUser(leaderCtx, "alice")
+		leaderErr <- err
+	}()
+	<-joined
+
+	errs := make(chan error, callers-1)
+	for i := 1; i < callers; i++ {
+		go func() {
+			user, err := svc.getUser(context.Background(), "alice")
+			if err == nil && user.UserID != 1 {
+				err = errors.New("unexpected user")
+			}
+			errs <- err
+		}()
+	}
+	for i := 1; i < callers; i++ {
+		<-joined
+	}
+	cancelLeader()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the canceled caller to give up, got %v", err)
+	}
+	close(db.release)
+
+	for i := 1; i < callers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := db.queries.Load(); n != 1 {
+		t.Fatalf("expected concurrent lookups to collapse into one query, got %d", n)
+	}
+}
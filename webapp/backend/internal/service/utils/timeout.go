@@ -8,11 +8,14 @@ import (
 
 var defaultTimeout = 120 * time.Second
 
+// 現在時刻の取得元。テストで差し替えられるようにしている
+var now = time.Now
+
 // 終わらない処理などによる無限ループを防ぐため、タイムアウト付きで処理を実行する
 func WithTimeout(parent context.Context, fn func(ctx context.Context) error) error {
 	timeout := defaultTimeout
 	if dl, ok := parent.Deadline(); ok {
-		if rem := time.Until(dl); rem > 0 && rem < timeout {
+		if rem := dl.Sub(now()); rem > 0 && rem < timeout {
 			timeout = rem
 		}
 	}
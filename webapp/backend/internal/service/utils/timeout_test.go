@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithTimeoutReturnsFnError(t *testing.T) {
+	want := errors.New("boom")
+	err := WithTimeout(context.Background(), func(ctx context.Context) error {
+		return want
+	})
+	if !errors.Is(err, want) {
+		t.Fatalf("expected %v, got %v", want, err)
+	}
+}
+
+func TestWithTimeoutAbandonsStalledFn(t *testing.T) {
+	orig := defaultTimeout
+	defaultTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { defaultTimeout = orig })
+
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	err := WithTimeout(context.Background(), func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up quickly, took %s", elapsed)
+	}
+}
+
+func TestWithTimeoutParentCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := WithTimeout(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+}
+
+func TestWithTimeoutClampsToParentDeadline(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	// 親の期限まで残り50msであるかのように見せかける
+	origNow := now
+	now = func() time.Time { return deadline.Add(-50 * time.Millisecond) }
+	t.Cleanup(func() { now = origNow })
+
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	err := WithTimeout(ctx, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected timeout clamped to parent's remaining time, took %s", elapsed)
+	}
+}
@@ -0,0 +1,144 @@
+package telemetry
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Phase はリクエストの処理がいまどの段階にあるか
+type Phase string
+
+const (
+	PhaseAuth     Phase = "auth"
+	PhaseHandler  Phase = "handler"
+	PhasePlanning Phase = "planning"
+	// DBのクエリを実行中。クエリが終われば直前の段階に戻る
+	PhaseQuery Phase = "query"
+)
+
+// InflightRegistry は処理中のリクエストを保持する
+// p99が跳ねたときに、どのリクエストがどこで止まっているかを確認するために使う
+type InflightRegistry struct {
+	mx     sync.Mutex
+	nextID uint64
+	reqs   map[uint64]*InflightRequest
+	now    func() time.Time
+}
+
+// InflightRequest は処理中のリクエスト1件。処理中に書き換わる項目はatomicで持つ
+type InflightRequest struct {
+	id      uint64
+	method  string
+	path    string
+	started time.Time
+
+	userID        atomic.Int64
+	phase         atomic.Value // Phase
+	queries       atomic.Int64
+	activeQueries atomic.Int32
+}
+
+// InflightSnapshot は処理中のリクエストのある時点の状態
+type InflightSnapshot struct {
+	ID        uint64  `json:"id"`
+	Method    string  `json:"method"`
+	Route     string  `json:"route"`
+	UserID    int     `json:"user_id,omitempty"`
+	ElapsedMs float64 `json:"elapsed_ms"`
+	Phase     Phase   `json:"phase"`
+	Queries   int64   `json:"queries"`
+}
+
+func NewInflightRegistry() *InflightRegistry {
+	return &InflightRegistry{reqs: make(map[uint64]*InflightRequest), now: time.Now}
+}
+
+// Begin はリクエストの処理開始を登録する。処理が終わったら必ずEndを呼ぶ
+func (r *InflightRegistry) Begin(method, path string) *InflightRequest {
+	req := &InflightRequest{method: method, path: path, started: r.now()}
+	req.phase.Store(PhaseHandler)
+	r.mx.Lock()
+	r.nextID++
+	req.id = r.nextID
+	r.reqs[req.id] = req
+	r.mx.Unlock()
+	return req
+}
+
+// End はリクエストを登録から外す
+func (r *InflightRegistry) End(req *InflightRequest) {
+	r.mx.Lock()
+	delete(r.reqs, req.id)
+	r.mx.Unlock()
+}
+
+// Snapshot は処理中のリクエストを経過時間の長い順に返す
+func (r *InflightRegistry) Snapshot() []InflightSnapshot {
+	now := r.now()
+	r.mx.Lock()
+	out := make([]InflightSnapshot, 0, len(r.reqs))
+	for _, req := range r.reqs {
+		phase := req.phase.Load().(Phase)
+		if req.activeQueries.Load() > 0 {
+			phase = PhaseQuery
+		}
+		out = append(out, InflightSnapshot{
+			ID:        req.id,
+			Method:    req.method,
+			Route:     req.path,
+			UserID:    int(req.userID.Load()),
+			ElapsedMs: float64(now.Sub(req.started).Microseconds()) / 1000,
+			Phase:     phase,
+			Queries:   req.queries.Load(),
+		})
+	}
+	r.mx.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ElapsedMs != out[j].ElapsedMs {
+			return out[i].ElapsedMs > out[j].ElapsedMs
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+type inflightKey struct{}
+
+// WithInflight はリクエストの処理状況をcontextに入れる
+func WithInflight(ctx context.Context, req *InflightRequest) context.Context {
+	return context.WithValue(ctx, inflightKey{}, req)
+}
+
+func inflightFromContext(ctx context.Context) *InflightRequest {
+	req, _ := ctx.Value(inflightKey{}).(*InflightRequest)
+	return req
+}
+
+// SetPhase はリクエストの処理段階を記録する。登録されていないcontextでは何もしない
+func SetPhase(ctx context.Context, phase Phase) {
+	if req := inflightFromContext(ctx); req != nil {
+		req.phase.Store(phase)
+	}
+}
+
+// SetUser はリクエストのユーザーを記録する
+func SetUser(ctx context.Context, userID int) {
+	if req := inflightFromContext(ctx); req != nil {
+		req.userID.Store(int64(userID))
+	}
+}
+
+// StartQuery はDBのクエリ1件の発行を記録し、クエリが終わったときに呼ぶ関数を返す
+// 並行して発行されたクエリも数えられるよう、実行中の件数で段階を判定する
+func StartQuery(ctx context.Context) func() {
+	req := inflightFromContext(ctx)
+	if req == nil {
+		return func() {}
+	}
+	req.queries.Add(1)
+	req.activeQueries.Add(1)
+	return func() { req.activeQueries.Add(-1) }
+}
@@ -0,0 +1,49 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInflightRegistryTracksPhaseAndQueries(t *testing.T) {
+	reg := NewInflightRegistry()
+	now := time.Unix(1000, 0)
+	reg.now = func() time.Time { return now }
+
+	older := reg.Begin("GET", "/api/robot/delivery-plan")
+	now = now.Add(time.Second)
+	req := reg.Begin("POST", "/api/v1/orders")
+	ctx := WithInflight(context.Background(), req)
+	SetUser(ctx, 42)
+	done := StartQuery(ctx)
+	now = now.Add(time.Second)
+
+	snap := reg.Snapshot()
+	if len(snap) != 2 || snap[0].ID != older.id {
+		t.Fatalf("expected the oldest request first: %+v", snap)
+	}
+	got := snap[1]
+	if got.UserID != 42 || got.Phase != PhaseQuery || got.Queries != 1 || got.ElapsedMs != 1000 {
+		t.Fatalf("unexpected snapshot: %+v", got)
+	}
+
+	done()
+	SetPhase(ctx, PhasePlanning)
+	if got := reg.Snapshot()[1]; got.Phase != PhasePlanning || got.Queries != 1 {
+		t.Fatalf("expected planning phase after the query finished: %+v", got)
+	}
+
+	reg.End(req)
+	reg.End(older)
+	if n := len(reg.Snapshot()); n != 0 {
+		t.Fatalf("expected no requests after End, got %d", n)
+	}
+}
+
+func TestInflightHelpersIgnoreUnregisteredContext(t *testing.T) {
+	ctx := context.Background()
+	SetPhase(ctx, PhaseAuth)
+	SetUser(ctx, 1)
+	StartQuery(ctx)()
+}
@@ -0,0 +1,88 @@
+package telemetry
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// RequestStats は直近のリクエストの処理時間とステータスをリングバッファに保持し、
+// ヘルススコアなどの計算に使う集計値を返す
+type RequestStats struct {
+	mx      sync.Mutex
+	samples []requestSample
+	next    int
+	full    bool
+	now     func() time.Time
+}
+
+type requestSample struct {
+	at       time.Time
+	duration time.Duration
+	failed   bool
+	// ロングポーリングなど、待つことが仕様のリクエストはレイテンシの集計から除く
+	skipLatency bool
+}
+
+// RequestSnapshot はある期間のリクエストの集計値
+type RequestSnapshot struct {
+	Requests  int
+	Errors    int
+	ErrorRate float64
+	P99       time.Duration
+}
+
+// NewRequestStats は最大capacity件のサンプルを保持するRequestStatsを作る
+func NewRequestStats(capacity int) *RequestStats {
+	return &RequestStats{samples: make([]requestSample, capacity), now: time.Now}
+}
+
+// Record はリクエスト1件の結果を記録する。failedはサーバー側のエラー(5xx)かどうか
+func (s *RequestStats) Record(duration time.Duration, failed, skipLatency bool) {
+	at := s.now()
+	s.mx.Lock()
+	s.samples[s.next] = requestSample{at: at, duration: duration, failed: failed, skipLatency: skipLatency}
+	s.next++
+	if s.next == len(s.samples) {
+		s.next = 0
+		s.full = true
+	}
+	s.mx.Unlock()
+}
+
+// Snapshot は直近windowのリクエストを集計する
+func (s *RequestStats) Snapshot(window time.Duration) RequestSnapshot {
+	since := s.now().Add(-window)
+
+	s.mx.Lock()
+	n := s.next
+	if s.full {
+		n = len(s.samples)
+	}
+	var snap RequestSnapshot
+	durations := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		sample := s.samples[i]
+		if sample.at.Before(since) {
+			continue
+		}
+		snap.Requests++
+		if sample.failed {
+			snap.Errors++
+		}
+		if !sample.skipLatency {
+			durations = append(durations, sample.duration)
+		}
+	}
+	s.mx.Unlock()
+
+	if snap.Requests > 0 {
+		snap.ErrorRate = float64(snap.Errors) / float64(snap.Requests)
+	}
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		idx := (len(durations)*99+99)/100 - 1
+		snap.P99 = durations[idx]
+	}
+	return snap
+}
@@ -0,0 +1,47 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// SessionGCStats は期限切れのセッションを削除するジョブの実行回数と削除した行数を集計する
+type SessionGCStats struct {
+	runs    atomic.Int64
+	failed  atomic.Int64
+	removed atomic.Int64
+	// 最後に成功した実行の時刻（Unix秒）
+	lastSuccess atomic.Int64
+}
+
+func NewSessionGCStats() *SessionGCStats {
+	return &SessionGCStats{}
+}
+
+// Record は1回の実行の結果を記録する
+func (s *SessionGCStats) Record(removed int64, err error, at time.Time) {
+	s.runs.Add(1)
+	s.removed.Add(removed)
+	if err != nil {
+		s.failed.Add(1)
+		return
+	}
+	s.lastSuccess.Store(at.Unix())
+}
+
+// Removed はこれまでに削除した行数を返す
+func (s *SessionGCStats) Removed() int64 {
+	return s.removed.Load()
+}
+
+// WritePrometheus は集計値をPrometheusのテキスト形式で書き出す
+func (s *SessionGCStats) WritePrometheus(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP session_gc_runs_total Number of expired session cleanup runs.\n# TYPE session_gc_runs_total counter\nsession_gc_runs_total %d\n"+
+		"# HELP session_gc_failures_total Number of expired session cleanup runs that failed.\n# TYPE session_gc_failures_total counter\nsession_gc_failures_total %d\n"+
+		"# HELP session_gc_removed_total Number of expired sessions deleted.\n# TYPE session_gc_removed_total counter\nsession_gc_removed_total %d\n"+
+		"# HELP session_gc_last_success_timestamp_seconds Time of the last successful cleanup run.\n# TYPE session_gc_last_success_timestamp_seconds gauge\nsession_gc_last_success_timestamp_seconds %d\n",
+		s.runs.Load(), s.failed.Load(), s.removed.Load(), s.lastSuccess.Load())
+	return err
+}
@@ -0,0 +1,127 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SolverStats は配送計画の選定に使った解法ごとに、問題の大きさ・処理時間・解の質を集計する
+// 解法を切り替える大きさの閾値を実際の負荷で調整するために使う
+type SolverStats struct {
+	mx         sync.Mutex
+	byStrategy map[string]*solverSeries
+}
+
+type solverSeries struct {
+	items    histogram
+	capacity histogram
+	duration histogram
+	// 選んだ注文の価値と、分数緩和による上界の比（1に近いほど最適に近い）
+	quality histogram
+}
+
+var (
+	solverItemBuckets     = []float64{10, 100, 1000, 10000, 100000}
+	solverCapacityBuckets = []float64{1e3, 1e4, 1e5, 1e6, 1e7}
+	solverDurationBuckets = []float64{0.0001, 0.001, 0.01, 0.05, 0.1, 0.5, 1}
+	solverQualityBuckets  = []float64{0.5, 0.8, 0.9, 0.95, 0.99, 1}
+)
+
+// histogram は上限ごとの累積でない度数を持つ。書き出すときに累積にする
+type histogram struct {
+	bounds []float64
+	counts []int64 // 最後の要素は+Inf
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func NewSolverStats() *SolverStats {
+	return &SolverStats{byStrategy: make(map[string]*solverSeries)}
+}
+
+// Record は1回の選定を記録する。upperBoundが0なら解の質は1とみなす
+func (s *SolverStats) Record(strategy string, items int, capacity int64, duration time.Duration, value, upperBound int64) {
+	quality := 1.0
+	if upperBound > 0 {
+		quality = float64(value) / float64(upperBound)
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	series, ok := s.byStrategy[strategy]
+	if !ok {
+		series = &solverSeries{
+			items:    newHistogram(solverItemBuckets),
+			capacity: newHistogram(solverCapacityBuckets),
+			duration: newHistogram(solverDurationBuckets),
+			quality:  newHistogram(solverQualityBuckets),
+		}
+		s.byStrategy[strategy] = series
+	}
+	series.items.observe(float64(items))
+	series.capacity.observe(float64(capacity))
+	series.duration.observe(duration.Seconds())
+	series.quality.observe(quality)
+}
+
+// WritePrometheus は集計値をPrometheusのテキスト形式で書き出す
+func (s *SolverStats) WritePrometheus(w io.Writer) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	strategies := make([]string, 0, len(s.byStrategy))
+	for strategy := range s.byStrategy {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+
+	metrics := []struct {
+		name, help string
+		get        func(*solverSeries) *histogram
+	}{
+		{"planner_solve_items", "Number of candidate orders per solve.", func(s *solverSeries) *histogram { return &s.items }},
+		{"planner_solve_capacity_grams", "Remaining weight capacity per solve.", func(s *solverSeries) *histogram { return &s.capacity }},
+		{"planner_solve_duration_seconds", "Time spent per solve.", func(s *solverSeries) *histogram { return &s.duration }},
+		{"planner_solve_value_ratio", "Achieved value divided by the fractional upper bound.", func(s *solverSeries) *histogram { return &s.quality }},
+	}
+	if _, err := fmt.Fprintf(w, "# HELP planner_solves_total Number of solves per strategy.\n# TYPE planner_solves_total counter\n"); err != nil {
+		return err
+	}
+	for _, strategy := range strategies {
+		if _, err := fmt.Fprintf(w, "planner_solves_total{strategy=%q} %d\n", strategy, s.byStrategy[strategy].items.count); err != nil {
+			return err
+		}
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", m.name, m.help, m.name); err != nil {
+			return err
+		}
+		for _, strategy := range strategies {
+			h := m.get(s.byStrategy[strategy])
+			var cumulative int64
+			for i, bound := range h.bounds {
+				cumulative += h.counts[i]
+				if _, err := fmt.Fprintf(w, "%s_bucket{strategy=%q,le=\"%g\"} %d\n", m.name, strategy, bound, cumulative); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintf(w, "%s_bucket{strategy=%q,le=\"+Inf\"} %d\n%s_sum{strategy=%q} %g\n%s_count{strategy=%q} %d\n",
+				m.name, strategy, h.count, m.name, strategy, h.sum, m.name, strategy, h.count); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package telemetry
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSolverStatsPrometheus(t *testing.T) {
+	s := NewSolverStats()
+	s.Record("dp", 50, 20000, 3*time.Millisecond, 90, 100)
+	s.Record("dp", 500, 20000, 20*time.Millisecond, 100, 100)
+	s.Record("greedy", 5, 100, time.Microsecond, 0, 0)
+
+	var b strings.Builder
+	if err := s.WritePrometheus(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		`planner_solves_total{strategy="dp"} 2`,
+		`planner_solves_total{strategy="greedy"} 1`,
+		`planner_solve_items_bucket{strategy="dp",le="100"} 1`,
+		`planner_solve_items_bucket{strategy="dp",le="1000"} 2`,
+		`planner_solve_duration_seconds_bucket{strategy="dp",le="0.01"} 1`,
+		`planner_solve_value_ratio_bucket{strategy="dp",le="0.9"} 1`,
+		`planner_solve_value_ratio_sum{strategy="greedy"} 1`,
+		`planner_solve_capacity_grams_bucket{strategy="greedy",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
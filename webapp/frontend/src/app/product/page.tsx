@@ -159,9 +159,10 @@ export default function ProductListPage() {
     setSortModel(model);
   };
 
-  const getImageUrl = (imagePath: string) => {
-    if (!imagePath) return "/default-product.png";
-    return `/api/v1/image?path=${encodeURIComponent(imagePath)}`;
+  // 一覧では60px角で表示するため、高解像度ディスプレイ向けに倍のサイズで取得する
+  const getImageUrl = (product: Product) => {
+    if (!product.image) return "/default-product.png";
+    return `/api/products/${product.product_id}/image?w=120&h=120`;
   };
 
   const columns: GridColDef[] = [
@@ -177,7 +178,7 @@ export default function ProductListPage() {
       renderCell: (params: GridRenderCellParams) => (
         <Box
           component="img"
-          src={getImageUrl(params.row.image)}
+          src={getImageUrl(params.row)}
           alt={params.row.name}
           sx={{
             width: 60,
@@ -0,0 +1,12 @@
+-- 配送ロボットの登録。配送計画の要求で指定された積載量はmax_capacity（グラム）までに切り詰める
+-- 登録のないロボットIDからの計画の要求は404で拒否する
+CREATE TABLE IF NOT EXISTS robots (
+    robot_id VARCHAR(64) PRIMARY KEY,
+    max_capacity INT UNSIGNED NOT NULL,
+    -- active: 稼働中, inactive: 停止中（計画を生成しない）
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- X-ROBOT-IDを送らないロボットが使うID
+INSERT IGNORE INTO robots (robot_id, max_capacity, status) VALUES ('robot-001', 1000000, 'active');
@@ -0,0 +1,16 @@
+-- 生成した配送計画の履歴。どの解法がどの注文をどのロボットに割り当てたかの監査に使う
+CREATE TABLE IF NOT EXISTS delivery_plans (
+    plan_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    robot_id VARCHAR(64) NOT NULL,
+    profile VARCHAR(64) NOT NULL,
+    -- 優先度の層ごとに使った解法（カンマ区切り）
+    algorithm VARCHAR(255) NOT NULL,
+    order_ids JSON NOT NULL,
+    total_weight BIGINT NOT NULL,
+    total_value BIGINT NOT NULL,
+    total_volume BIGINT NOT NULL DEFAULT 0,
+    -- 選定にかかった時間（マイクロ秒）。キャッシュした結果を使った場合は0
+    duration_us BIGINT NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_delivery_plans_robot (robot_id, plan_id)
+);
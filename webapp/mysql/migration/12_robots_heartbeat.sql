@@ -0,0 +1,3 @@
+-- ロボットが最後に生存を通知した時刻。一度も通知していないロボットはNULL（生存を監視しない）
+ALTER TABLE robots
+    ADD COLUMN last_heartbeat_at DATETIME NULL;
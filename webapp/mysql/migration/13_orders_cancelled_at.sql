@@ -0,0 +1,4 @@
+-- 利用者が注文を取り消した日時。取り消していない注文はNULL
+-- cmd/shardorders で作成済みのシャードテーブルにも同じ列を追加すること
+ALTER TABLE orders
+    ADD COLUMN cancelled_at DATETIME NULL;
@@ -0,0 +1,3 @@
+-- 注文履歴をステータスで絞り込むためのインデックス。作成日時での絞り込み・並べ替えにもそのまま使える
+-- cmd/shardorders で作成済みのシャードテーブルにも同じインデックスを追加すること
+CREATE INDEX idx_orders_user_id_status_created_at ON orders (user_id, shipped_status, created_at);
@@ -0,0 +1,3 @@
+-- 変更前のステータス。作成のイベントと、この列を追加する前のイベントはNULL
+ALTER TABLE order_events
+    ADD COLUMN previous_status VARCHAR(50) NULL;
@@ -0,0 +1,28 @@
+-- 配送完了から一定日数が過ぎた注文の退避先。シャードテーブルの注文もこのテーブルに退避する
+-- 注文テーブルと同じ列に、配送完了日時と退避日時を加える
+CREATE TABLE IF NOT EXISTS orders_archive (
+    order_id BIGINT UNSIGNED PRIMARY KEY,
+    user_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    shipped_status VARCHAR(50) NOT NULL,
+    priority TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    arrived_at DATETIME NULL,
+    deliver_by DATETIME NULL,
+    cancelled_at DATETIME NULL,
+    completed_at DATETIME(6) NOT NULL,
+    archived_at DATETIME NOT NULL,
+    INDEX idx_orders_archive_user_id_created_at (user_id, created_at)
+);
+
+-- 退避した注文のイベント履歴を残すため、order_eventsからordersへの外部キー（削除の連鎖）を外す
+-- cmd/shardorders を実行済みなら外部キーは既に外れている
+SET @fk := (
+    SELECT CONSTRAINT_NAME FROM information_schema.REFERENTIAL_CONSTRAINTS
+    WHERE CONSTRAINT_SCHEMA = DATABASE() AND TABLE_NAME = 'order_events' AND REFERENCED_TABLE_NAME = 'orders'
+    LIMIT 1
+);
+SET @ddl := IF(@fk IS NULL, 'DO 0', CONCAT('ALTER TABLE order_events DROP FOREIGN KEY `', @fk, '`'));
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
@@ -0,0 +1,11 @@
+-- ロボットが配送に失敗して配送待ちへ戻した回数。上限を超えた注文はfailedになり、配送計画の対象から外れる
+-- cmd/shardorders で作成済みのシャードテーブルにも同じ列を追加すること
+ALTER TABLE orders
+    ADD COLUMN retry_count INT UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE orders_archive
+    ADD COLUMN retry_count INT UNSIGNED NOT NULL DEFAULT 0;
+
+-- 配送に失敗した理由のコード。配送失敗のイベント以外はNULL
+ALTER TABLE order_events
+    ADD COLUMN reason VARCHAR(32) NULL;
@@ -0,0 +1,7 @@
+-- 注文作成時にフロントエンドが付ける任意のJSONオブジェクト（ギフト指定や配送時の指示など）。未指定ならNULL
+-- cmd/shardorders で作成済みのシャードテーブルにも同じ列を追加すること
+ALTER TABLE orders
+    ADD COLUMN metadata JSON NULL;
+
+ALTER TABLE orders_archive
+    ADD COLUMN metadata JSON NULL;
@@ -0,0 +1,7 @@
+-- 注文履歴を商品の価値・重さの範囲で絞り込むためのインデックス
+-- 範囲に合う商品が少なければ商品側から引き、(user_id, product_id)でユーザーの注文を探す
+-- cmd/shardorders で作成済みのシャードテーブルにも同じインデックスを追加すること
+CREATE INDEX idx_products_value_weight ON products (value, weight);
+CREATE INDEX idx_products_weight_value ON products (weight, value);
+CREATE INDEX idx_orders_user_id_product ON orders (user_id, product_id);
+CREATE INDEX idx_orders_archive_user_id_product ON orders_archive (user_id, product_id);
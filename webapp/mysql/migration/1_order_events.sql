@@ -0,0 +1,14 @@
+-- 注文ステータスの変更履歴（追記のみ）。orders.shipped_status はこのテーブルからの射影として更新する
+CREATE TABLE IF NOT EXISTS order_events (
+    event_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    order_id INT UNSIGNED NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    occurred_at DATETIME(6) NOT NULL,
+    INDEX idx_order_events_order_id (order_id, event_id),
+    FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE
+);
+
+-- 既存の注文の現在状態を初期イベントとして取り込む
+INSERT INTO order_events (order_id, event_type, status, occurred_at)
+SELECT order_id, 'imported', shipped_status, created_at FROM orders;
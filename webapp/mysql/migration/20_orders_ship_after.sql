@@ -0,0 +1,9 @@
+-- 配送を始めてよい日時。指定した注文はその日時までscheduledのままで、配送計画の対象にならない
+-- 日時を過ぎたscheduledの注文は定期的にshippingへ移す
+-- cmd/shardorders で作成済みのシャードテーブルにも同じ列とインデックスを追加すること
+ALTER TABLE orders
+    ADD COLUMN ship_after DATETIME NULL,
+    ADD INDEX idx_orders_status_ship_after (shipped_status, ship_after);
+
+ALTER TABLE orders_archive
+    ADD COLUMN ship_after DATETIME NULL;
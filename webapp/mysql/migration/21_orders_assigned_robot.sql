@@ -0,0 +1,8 @@
+-- 配送計画で注文を引き当てたロボット。配送待ちへ戻すとNULLに戻し、完了・失敗した注文には最後のロボットが残る
+-- 引き当てたロボット以外からのステータスの報告を拒否するために使う
+-- cmd/shardorders で作成済みのシャードテーブルにも同じ列を追加すること
+ALTER TABLE orders
+    ADD COLUMN assigned_robot_id VARCHAR(64) NULL;
+
+ALTER TABLE orders_archive
+    ADD COLUMN assigned_robot_id VARCHAR(64) NULL;
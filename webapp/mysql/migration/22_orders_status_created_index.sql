@@ -0,0 +1,3 @@
+-- 配送待ちの注文を作成日時の順に読むためのインデックス。外部の倉庫管理システム向けのフィードのキーセットページングに使う
+-- cmd/shardorders で作成済みのシャードテーブルにも同じインデックスを追加すること
+CREATE INDEX idx_orders_status_created_at ON orders (shipped_status, created_at);
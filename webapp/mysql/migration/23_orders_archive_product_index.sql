@@ -0,0 +1,3 @@
+-- 商品を削除する前に、退避した注文からも参照されていないか確かめるためのインデックス
+-- 注文テーブルは商品への外部キーのインデックスで足りる
+CREATE INDEX idx_orders_archive_product ON orders_archive (product_id);
@@ -0,0 +1,3 @@
+-- 商品名と説明の全文検索用のインデックス。一覧でtype=fulltextを指定した検索に使う
+-- 日本語を単語に区切らずに検索できるようngramパーサーを使う。語の長さはconf.dのngram_token_sizeに従う
+CREATE FULLTEXT INDEX idx_products_name_description ON products (name, description) WITH PARSER ngram;
@@ -0,0 +1,18 @@
+-- 商品のカテゴリ。parent_idで階層にし、最上位のカテゴリはNULL
+CREATE TABLE IF NOT EXISTS categories (
+    category_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    parent_id INT UNSIGNED NULL,
+    name VARCHAR(255) NOT NULL,
+    INDEX idx_categories_parent (parent_id),
+    FOREIGN KEY (parent_id) REFERENCES categories(category_id) ON DELETE CASCADE
+);
+
+-- 商品とカテゴリの対応。1つの商品を複数のカテゴリに入れられる
+CREATE TABLE IF NOT EXISTS product_categories (
+    product_id INT UNSIGNED NOT NULL,
+    category_id INT UNSIGNED NOT NULL,
+    PRIMARY KEY (product_id, category_id),
+    INDEX idx_product_categories_category (category_id, product_id),
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories(category_id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- 商品の在庫数。注文の作成で減らし、取り消しで戻す
+-- INVENTORY_UNLIMITEDが有効（既定）な間は在庫を数えず、この列によらず注文を受け付ける
+ALTER TABLE products
+    ADD COLUMN stock INT UNSIGNED NOT NULL DEFAULT 0;
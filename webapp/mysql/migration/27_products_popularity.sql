@@ -0,0 +1,4 @@
+-- 商品ごとの注文数（取り消しを除く）。商品一覧の人気順の並び替えに使い、バックグラウンドのジョブが定期的に集計し直す
+ALTER TABLE products
+    ADD COLUMN popularity INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD INDEX idx_products_popularity (popularity, product_id);
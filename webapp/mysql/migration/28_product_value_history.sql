@@ -0,0 +1,23 @@
+-- 商品の価値の履歴。価値が変わるたびに、それまでの行のeffective_toを閉じて新しい行を加える
+-- effective_toがNULLの行が現在の価値
+CREATE TABLE IF NOT EXISTS product_value_history (
+    history_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    product_id INT UNSIGNED NOT NULL,
+    value INT UNSIGNED NOT NULL,
+    effective_from DATETIME(6) NOT NULL,
+    effective_to DATETIME(6) NULL,
+    INDEX idx_product_value_history_product (product_id, effective_from)
+);
+
+-- 記録を始める時点の価値を最初の履歴にする
+INSERT INTO product_value_history (product_id, value, effective_from)
+SELECT product_id, value, NOW(6) FROM products;
+
+-- 注文時点の商品の価値。後から商品の価値を変えても、配送計画や集計に使う注文の価値は変わらない
+-- NULLの注文（この列を加える前の注文）は現在の商品の価値を使う
+-- cmd/shardorders で作成済みのシャードテーブルにも同じ列を追加すること
+ALTER TABLE orders
+    ADD COLUMN value INT UNSIGNED NULL;
+
+ALTER TABLE orders_archive
+    ADD COLUMN value INT UNSIGNED NULL;
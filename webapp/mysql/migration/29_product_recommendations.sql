@@ -0,0 +1,8 @@
+-- 一緒に注文されることの多い商品。バックグラウンドのジョブが注文から定期的に集計し直し、全件を置き換える
+-- scoreは両方の商品を注文した利用者の数
+CREATE TABLE IF NOT EXISTS product_recommendations (
+    product_id INT UNSIGNED NOT NULL,
+    recommended_product_id INT UNSIGNED NOT NULL,
+    score INT UNSIGNED NOT NULL,
+    PRIMARY KEY (product_id, recommended_product_id)
+);
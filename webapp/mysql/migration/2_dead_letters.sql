@@ -0,0 +1,11 @@
+-- 再試行しても失敗し続けた非同期処理を保存するデッドレターキュー
+CREATE TABLE IF NOT EXISTS dead_letters (
+    dead_letter_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(64) NOT NULL,
+    payload TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    attempts INT UNSIGNED NOT NULL DEFAULT 1,
+    created_at DATETIME NOT NULL,
+    last_failed_at DATETIME NOT NULL,
+    INDEX idx_dead_letters_kind (kind, dead_letter_id)
+);
@@ -0,0 +1,4 @@
+-- 商品一覧をキーセット（並び替えの列, product_id）で続きから読むためのインデックス
+-- 名前順はidx_products_name（InnoDBのセカンダリインデックスは末尾に主キーを持つ）、人気順はidx_products_popularityを使う
+CREATE INDEX idx_products_value_id ON products (value, product_id);
+CREATE INDEX idx_products_weight_id ON products (weight, product_id);
@@ -0,0 +1,4 @@
+-- 商品の論理削除。削除した商品も過去の注文から参照するため行は残し、削除した日時を記録する
+-- 削除した商品は一覧や新しい注文の対象から外し、管理APIで元に戻せる
+ALTER TABLE products
+    ADD COLUMN deleted_at DATETIME NULL DEFAULT NULL;
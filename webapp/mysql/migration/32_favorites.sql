@@ -0,0 +1,7 @@
+-- 利用者ごとのお気に入りの商品。商品一覧は利用者のお気に入りをLEFT JOINして、お気に入りかどうかを返す
+CREATE TABLE IF NOT EXISTS favorites (
+    user_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, product_id)
+);
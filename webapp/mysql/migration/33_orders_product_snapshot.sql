@@ -0,0 +1,21 @@
+-- 注文一覧で商品をJOINせずに済むよう、注文時点の商品名・重さ・容積を注文に記録する（価値は28_product_value_historyで記録済み）
+-- 記録を始める前の注文は現在の商品の値で埋める。埋めた後に記録のない注文は、一覧で商品から補う
+ALTER TABLE orders
+    ADD COLUMN product_name VARCHAR(255) NULL,
+    ADD COLUMN product_weight INT UNSIGNED NULL,
+    ADD COLUMN product_volume INT UNSIGNED NULL;
+
+ALTER TABLE orders_archive
+    ADD COLUMN product_name VARCHAR(255) NULL,
+    ADD COLUMN product_weight INT UNSIGNED NULL,
+    ADD COLUMN product_volume INT UNSIGNED NULL;
+
+UPDATE orders o
+JOIN products p ON o.product_id = p.product_id
+SET o.product_name = p.name, o.product_weight = p.weight, o.product_volume = p.volume, o.value = COALESCE(o.value, p.value)
+WHERE o.product_name IS NULL;
+
+UPDATE orders_archive o
+JOIN products p ON o.product_id = p.product_id
+SET o.product_name = p.name, o.product_weight = p.weight, o.product_volume = p.volume, o.value = COALESCE(o.value, p.value)
+WHERE o.product_name IS NULL;
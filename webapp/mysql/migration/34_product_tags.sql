@@ -0,0 +1,16 @@
+-- 商品に付けるタグ（「fragile」「heavy」など）。名前は小文字にそろえて登録する
+CREATE TABLE IF NOT EXISTS tags (
+    tag_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    UNIQUE INDEX idx_tags_name (name)
+);
+
+-- 商品とタグの対応。1つの商品に複数のタグを付けられる
+CREATE TABLE IF NOT EXISTS product_tags (
+    product_id INT UNSIGNED NOT NULL,
+    tag_id INT UNSIGNED NOT NULL,
+    PRIMARY KEY (product_id, tag_id),
+    INDEX idx_product_tags_tag (tag_id, product_id),
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(tag_id) ON DELETE CASCADE
+);
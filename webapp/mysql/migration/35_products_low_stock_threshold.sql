@@ -0,0 +1,3 @@
+-- 在庫が少ないと通知する閾値。在庫がこれを下回った商品をバックグラウンドのジョブが通知する。0なら通知しない
+ALTER TABLE products
+    ADD COLUMN low_stock_threshold INT UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- 期限切れのセッションをまとめて削除するジョブのためのインデックス
+ALTER TABLE user_sessions
+    ADD INDEX idx_user_sessions_expires_at (expires_at);
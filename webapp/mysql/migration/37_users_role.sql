@@ -0,0 +1,12 @@
+-- ユーザーの役割。user: 購入者, admin: 管理者, robot: 配送ロボット
+-- セッションにも役割を複製し、認証のたびにusersを引かないようにする
+ALTER TABLE users
+    ADD COLUMN role ENUM('user', 'admin', 'robot') NOT NULL DEFAULT 'user';
+
+ALTER TABLE user_sessions
+    ADD COLUMN role ENUM('user', 'admin', 'robot') NOT NULL DEFAULT 'user';
+
+UPDATE user_sessions s
+JOIN users u ON u.user_id = s.user_id
+SET s.role = u.role
+WHERE u.role <> 'user';
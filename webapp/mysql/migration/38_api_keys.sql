@@ -0,0 +1,16 @@
+-- ロボットがユーザーのセッションを使わずに認証するためのAPIキー
+-- キーそのものは保存せず、SHA-256のハッシュだけを持つ。key_prefixは一覧でキーを見分けるためのもの
+-- robot_idがあれば、そのキーはそのロボットとしてだけ使える
+-- ローテーションした古いキーはexpires_atまで使える
+CREATE TABLE api_keys (
+    api_key_id BIGINT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    robot_id VARCHAR(64) NULL,
+    key_hash CHAR(64) NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    PRIMARY KEY (api_key_id),
+    UNIQUE KEY idx_api_keys_key_hash (key_hash)
+);
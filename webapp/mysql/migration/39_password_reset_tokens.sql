@@ -0,0 +1,14 @@
+-- パスワード再設定のトークン。トークンそのものは保存せず、SHA-256のハッシュだけを持つ
+-- 使ったトークンはused_atを入れ、同じトークンで二度再設定できないようにする
+CREATE TABLE password_reset_tokens (
+    token_id BIGINT NOT NULL AUTO_INCREMENT,
+    user_id INT UNSIGNED NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    PRIMARY KEY (token_id),
+    UNIQUE KEY idx_password_reset_tokens_token_hash (token_hash),
+    KEY idx_password_reset_tokens_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,7 @@
+-- ユーザー名をアプリケーション層で暗号化して保存するためのカラム
+-- user_name_enc: 暗号化したユーザー名 / user_name_bidx: 検索用のブラインドインデックス
+-- 既存行は cmd/reencryptusers で暗号化する。暗号化前の行は従来どおり user_name で検索される
+ALTER TABLE users
+    ADD COLUMN user_name_enc TEXT NULL,
+    ADD COLUMN user_name_bidx CHAR(64) NULL,
+    ADD UNIQUE INDEX idx_users_user_name_bidx (user_name_bidx);
@@ -0,0 +1,8 @@
+-- セッションを作ったクライアントと最終利用時刻。利用者が自分のセッションを確認・取り消すために使う
+-- 既存のセッションは値がないままにする
+ALTER TABLE user_sessions
+    ADD COLUMN user_agent VARCHAR(255) NULL,
+    ADD COLUMN ip VARCHAR(45) NULL,
+    ADD COLUMN created_at DATETIME NULL,
+    ADD COLUMN last_seen_at DATETIME NULL,
+    ADD INDEX idx_user_sessions_user_id_expires_at (user_id, expires_at);
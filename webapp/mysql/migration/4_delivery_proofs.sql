@@ -0,0 +1,8 @@
+-- 配送完了時にロボットが添付する配達証明（写真・署名）。本体はオブジェクトストレージに保存する
+CREATE TABLE IF NOT EXISTS delivery_proofs (
+    order_id BIGINT UNSIGNED PRIMARY KEY,
+    object_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(64) NOT NULL,
+    size_bytes INT UNSIGNED NOT NULL,
+    uploaded_at DATETIME NOT NULL
+);
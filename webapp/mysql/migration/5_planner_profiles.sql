@@ -0,0 +1,20 @@
+-- 配送計画の選定設定（プランナープロファイル）。ロボット単位・コホート単位で割り当て、A/Bテストに使う
+CREATE TABLE IF NOT EXISTS planner_profiles (
+    name VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(32) NOT NULL,
+    epsilon DOUBLE NOT NULL DEFAULT 0,
+    fairness_mode VARCHAR(32) NOT NULL,
+    aging_boost INT NOT NULL DEFAULT 0,
+    zero_weight_cap INT NOT NULL DEFAULT 0,
+    zero_weight_policy VARCHAR(32) NOT NULL,
+    -- 個別に割り当てのないロボットのうち、このプロファイルを使う割合(%)
+    rollout_percent INT NOT NULL DEFAULT 0,
+    updated_at DATETIME NOT NULL
+);
+
+-- ロボットごとのプロファイルの割り当て（コホートより優先）
+CREATE TABLE IF NOT EXISTS planner_assignments (
+    robot_id VARCHAR(64) PRIMARY KEY,
+    profile_name VARCHAR(64) NOT NULL,
+    updated_at DATETIME NOT NULL
+);
@@ -0,0 +1,4 @@
+-- イベントを発生させた主体（配送を引き受けたロボットID、補充のための複製など）。日次の突合レポートで使う
+ALTER TABLE order_events
+    ADD COLUMN actor VARCHAR(64) NOT NULL DEFAULT '',
+    ADD INDEX idx_order_events_status_occurred_at (status, occurred_at);
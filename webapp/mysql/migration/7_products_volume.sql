@@ -0,0 +1,3 @@
+-- 商品の容積（立方センチメートル）。0は未登録で、配送計画では容積の制約を受けない
+ALTER TABLE products
+    ADD COLUMN volume INT UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+-- 注文の配送優先度（0: 通常, 1: 高, 2: 至急）。配送計画では優先度の高い注文から積む
+-- cmd/shardorders で作成済みのシャードテーブルにも同じ列を追加すること
+ALTER TABLE orders
+    ADD COLUMN priority TINYINT UNSIGNED NOT NULL DEFAULT 0;
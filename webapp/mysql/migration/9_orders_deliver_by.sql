@@ -0,0 +1,4 @@
+-- 注文の配送期限。期限が近い注文は配送計画で優先して積む
+-- cmd/shardorders で作成済みのシャードテーブルにも同じ列を追加すること
+ALTER TABLE orders
+    ADD COLUMN deliver_by DATETIME NULL;